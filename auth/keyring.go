@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jobullo/go-api-example/config"
+)
+
+// DefaultLifetime is how long tokens are valid for when the configuration doesn't say
+const DefaultLifetime = 30 * time.Minute
+
+// id given to the key built from the legacy jwt.secret setting
+const legacyKeyID = "default"
+
+// Key is one signing key, only keys with a private part (or an HMAC secret) can sign
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// Keyring signs tokens with the current key and verifies tokens signed with any configured key,
+// so a secret can be rotated by adding the new key, signing with it and dropping the old one
+// once the tokens it signed have expired.
+type Keyring struct {
+	keys     map[string]*Key
+	signing  *Key
+	lifetime time.Duration
+}
+
+// NewKeyring builds the keyring from the jwt section of the configuration
+func NewKeyring(cfg *config.JWT) (*Keyring, error) {
+	if cfg == nil {
+		return nil, errors.New("jwt configuration is missing")
+	}
+
+	keyCfgs := cfg.Keys
+	if len(keyCfgs) == 0 && cfg.Secret != "" {
+		keyCfgs = []*config.JWTKey{{ID: legacyKeyID, Secret: cfg.Secret}}
+	}
+	if len(keyCfgs) == 0 {
+		return nil, errors.New("jwt configuration needs a secret or at least one key")
+	}
+
+	keyring := &Keyring{keys: map[string]*Key{}, lifetime: DefaultLifetime}
+	if cfg.Duration > 0 {
+		keyring.lifetime = time.Duration(cfg.Duration) * time.Minute
+	}
+
+	for _, keyCfg := range keyCfgs {
+		key, err := loadKey(keyCfg)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := keyring.keys[key.ID]; ok {
+			return nil, fmt.Errorf("jwt key %q is listed twice", key.ID)
+		}
+		keyring.keys[key.ID] = key
+	}
+
+	signingID := cfg.SigningKeyID
+	if signingID == "" {
+		signingID = keyCfgs[0].ID
+		if signingID == "" {
+			signingID = legacyKeyID
+		}
+	}
+	keyring.signing = keyring.keys[signingID]
+	if keyring.signing == nil {
+		return nil, fmt.Errorf("jwt signing key %q is not configured", signingID)
+	}
+	if keyring.signing.signKey == nil {
+		return nil, fmt.Errorf("jwt signing key %q has no private key", signingID)
+	}
+
+	return keyring, nil
+}
+
+// Lifetime is how long tokens issued by the keyring are valid for
+func (k *Keyring) Lifetime() time.Duration {
+	return k.lifetime
+}
+
+// Sign issues a token with the given claims plus iat, exp and a unique jti, signed with the current key
+func (k *Keyring) Sign(claims jwt.MapClaims) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(k.lifetime)
+
+	signed := jwt.MapClaims{}
+	for name, value := range claims {
+		signed[name] = value
+	}
+	signed["iat"] = now.Unix()
+	signed["exp"] = expiresAt.Unix()
+
+	//the token id is what goes on the revocation list when the user logs out
+	jti, err := newTokenID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	signed["jti"] = jti
+
+	token := jwt.NewWithClaims(k.signing.Method, signed)
+	token.Header["kid"] = k.signing.ID
+
+	tokenString, err := token.SignedString(k.signing.signKey)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return tokenString, expiresAt, nil
+}
+
+// Parse verifies a token against the key named by its kid header and checks that it hasn't expired.
+// Tokens from before keys had ids are checked against the legacy secret key.
+func (k *Keyring) Parse(tokenString string) (*jwt.Token, error) {
+	token, err := jwt.Parse(tokenString, k.lookup)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("token is not valid")
+	}
+
+	//a token without an expiry would be valid forever
+	if claims, ok := token.Claims.(jwt.MapClaims); !ok || !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, errors.New("token has no expiry or has expired")
+	}
+
+	return token, nil
+}
+
+func (k *Keyring) lookup(token *jwt.Token) (interface{}, error) {
+	id, _ := token.Header["kid"].(string)
+	if id == "" {
+		id = legacyKeyID
+	}
+
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", id)
+	}
+
+	// the algorithm has to match the key, otherwise e.g. a public key could be used as an HMAC secret
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.verifyKey, nil
+}
+
+func loadKey(cfg *config.JWTKey) (*Key, error) {
+	id := cfg.ID
+	if id == "" {
+		id = legacyKeyID
+	}
+
+	algorithm := strings.ToUpper(cfg.Algorithm)
+	if algorithm == "" {
+		algorithm = jwt.SigningMethodHS256.Alg()
+	}
+
+	key := &Key{ID: id}
+	switch algorithm {
+	case jwt.SigningMethodHS256.Alg():
+		if cfg.Secret == "" {
+			return nil, fmt.Errorf("jwt key %q needs a secret", id)
+		}
+		key.Method = jwt.SigningMethodHS256
+		key.signKey = []byte(cfg.Secret)
+		key.verifyKey = key.signKey
+
+	case jwt.SigningMethodRS256.Alg():
+		key.Method = jwt.SigningMethodRS256
+		if cfg.PrivateKeyFile != "" {
+			data, err := readKeyFile(id, cfg.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, fmt.Errorf("jwt key %q: %w", id, err)
+			}
+			key.signKey = private
+			key.verifyKey = &private.PublicKey
+		}
+		if cfg.PublicKeyFile != "" {
+			data, err := readKeyFile(id, cfg.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			public, err := jwt.ParseRSAPublicKeyFromPEM(data)
+			if err != nil {
+				return nil, fmt.Errorf("jwt key %q: %w", id, err)
+			}
+			key.verifyKey = public
+		}
+
+	case jwt.SigningMethodES256.Alg():
+		key.Method = jwt.SigningMethodES256
+		if cfg.PrivateKeyFile != "" {
+			data, err := readKeyFile(id, cfg.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			private, err := jwt.ParseECPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, fmt.Errorf("jwt key %q: %w", id, err)
+			}
+			key.signKey = private
+			key.verifyKey = &private.PublicKey
+		}
+		if cfg.PublicKeyFile != "" {
+			data, err := readKeyFile(id, cfg.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			public, err := jwt.ParseECPublicKeyFromPEM(data)
+			if err != nil {
+				return nil, fmt.Errorf("jwt key %q: %w", id, err)
+			}
+			key.verifyKey = public
+		}
+
+	default:
+		return nil, fmt.Errorf("jwt key %q has unsupported algorithm %q", id, cfg.Algorithm)
+	}
+
+	if key.verifyKey == nil {
+		return nil, fmt.Errorf("jwt key %q needs a private_key_file or public_key_file", id)
+	}
+
+	return key, nil
+}
+
+func readKeyFile(id string, path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("jwt key %q: %w", id, err)
+	}
+	return data, nil
+}
+
+func newTokenID() (string, error) {
+	data := make([]byte, 16)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jobullo/go-api-example/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyring_LegacySecret(t *testing.T) {
+	keyring, err := NewKeyring(&config.JWT{Secret: "secret", Duration: 15})
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, keyring.Lifetime())
+
+	tokenString, expiresAt, err := keyring.Sign(jwt.MapClaims{"sub": "1"})
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), expiresAt, time.Second)
+
+	token, err := keyring.Parse(tokenString)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "default", token.Header["kid"])
+		assert.Equal(t, "1", token.Claims.(jwt.MapClaims)["sub"])
+	}
+}
+
+func TestKeyring_DefaultLifetime(t *testing.T) {
+	keyring, err := NewKeyring(&config.JWT{Secret: "secret"})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultLifetime, keyring.Lifetime())
+}
+
+func TestKeyring_TokenWithoutKid(t *testing.T) {
+	// tokens issued before keys had ids are checked against the legacy secret
+	keyring, err := NewKeyring(&config.JWT{Secret: "secret"})
+	require.NoError(t, err)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"exp": time.Now().Add(time.Minute).Unix()})
+	tokenString, err := token.SignedString([]byte("secret"))
+	require.NoError(t, err)
+
+	_, err = keyring.Parse(tokenString)
+	assert.NoError(t, err)
+}
+
+func TestKeyring_Rotation(t *testing.T) {
+	old, err := NewKeyring(&config.JWT{Keys: []*config.JWTKey{{ID: "2024", Secret: "old secret"}}})
+	require.NoError(t, err)
+	oldToken, _, err := old.Sign(jwt.MapClaims{"sub": "1"})
+	require.NoError(t, err)
+
+	// the new key signs, the old one is kept so live tokens still verify
+	rotated, err := NewKeyring(&config.JWT{
+		SigningKeyID: "2025",
+		Keys: []*config.JWTKey{
+			{ID: "2024", Secret: "old secret"},
+			{ID: "2025", Secret: "new secret"},
+		},
+	})
+	require.NoError(t, err)
+
+	newToken, _, err := rotated.Sign(jwt.MapClaims{"sub": "1"})
+	require.NoError(t, err)
+
+	parsed, err := rotated.Parse(newToken)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "2025", parsed.Header["kid"])
+	}
+	_, err = rotated.Parse(oldToken)
+	assert.NoError(t, err)
+
+	// once the old key is dropped its tokens are rejected
+	retired, err := NewKeyring(&config.JWT{Keys: []*config.JWTKey{{ID: "2025", Secret: "new secret"}}})
+	require.NoError(t, err)
+	_, err = retired.Parse(oldToken)
+	assert.Error(t, err)
+	_, err = retired.Parse(newToken)
+	assert.NoError(t, err)
+}
+
+func TestKeyring_RejectsInvalidTokens(t *testing.T) {
+	keyring, err := NewKeyring(&config.JWT{Keys: []*config.JWTKey{{ID: "a", Secret: "secret"}}})
+	require.NoError(t, err)
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		tokenString, err := token.SignedString(key)
+		require.NoError(t, err)
+		return tokenString
+	}
+	expiry := jwt.MapClaims{"exp": time.Now().Add(time.Minute).Unix()}
+
+	for name, tokenString := range map[string]string{
+		"unknown kid":    sign(jwt.SigningMethodHS256, "b", []byte("secret"), expiry),
+		"wrong secret":   sign(jwt.SigningMethodHS256, "a", []byte("other"), expiry),
+		"wrong method":   sign(jwt.SigningMethodHS512, "a", []byte("secret"), expiry),
+		"none method":    sign(jwt.SigningMethodNone, "a", jwt.UnsafeAllowNoneSignatureType, expiry),
+		"expired":        sign(jwt.SigningMethodHS256, "a", []byte("secret"), jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}),
+		"without expiry": sign(jwt.SigningMethodHS256, "a", []byte("secret"), jwt.MapClaims{"sub": "1"}),
+	} {
+		_, err := keyring.Parse(tokenString)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestKeyring_RS256(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	publicDER, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	privateFile := writePEM(t, dir, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(private))
+	publicFile := writePEM(t, dir, "rsa.pub", "PUBLIC KEY", publicDER)
+
+	signer, err := NewKeyring(&config.JWT{Keys: []*config.JWTKey{{ID: "rsa", Algorithm: "RS256", PrivateKeyFile: privateFile}}})
+	require.NoError(t, err)
+	tokenString, _, err := signer.Sign(jwt.MapClaims{"sub": "1"})
+	require.NoError(t, err)
+
+	// a service holding only the public key can verify but not sign
+	verifier, err := NewKeyring(&config.JWT{
+		SigningKeyID: "hmac",
+		Keys: []*config.JWTKey{
+			{ID: "hmac", Secret: "secret"},
+			{ID: "rsa", Algorithm: "RS256", PublicKeyFile: publicFile},
+		},
+	})
+	require.NoError(t, err)
+	_, err = verifier.Parse(tokenString)
+	assert.NoError(t, err)
+
+	_, err = NewKeyring(&config.JWT{Keys: []*config.JWTKey{{ID: "rsa", Algorithm: "RS256", PublicKeyFile: publicFile}}})
+	assert.Error(t, err)
+
+	// the public key can't be used as an HMAC secret to forge a token
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"exp": time.Now().Add(time.Minute).Unix()})
+	forged.Header["kid"] = "rsa"
+	forgedString, err := forged.SignedString(publicDER)
+	require.NoError(t, err)
+	_, err = verifier.Parse(forgedString)
+	assert.Error(t, err)
+}
+
+func TestKeyring_ES256(t *testing.T) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	privateDER, err := x509.MarshalECPrivateKey(private)
+	require.NoError(t, err)
+
+	privateFile := writePEM(t, t.TempDir(), "ec.pem", "EC PRIVATE KEY", privateDER)
+
+	keyring, err := NewKeyring(&config.JWT{Keys: []*config.JWTKey{{ID: "ec", Algorithm: "es256", PrivateKeyFile: privateFile}}})
+	require.NoError(t, err)
+
+	tokenString, _, err := keyring.Sign(jwt.MapClaims{"sub": "1"})
+	require.NoError(t, err)
+
+	token, err := keyring.Parse(tokenString)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "ES256", token.Method.Alg())
+	}
+}
+
+func TestNewKeyring_Invalid(t *testing.T) {
+	for name, cfg := range map[string]*config.JWT{
+		"missing":           nil,
+		"no keys":           {},
+		"no secret":         {Keys: []*config.JWTKey{{ID: "a"}}},
+		"duplicate id":      {Keys: []*config.JWTKey{{ID: "a", Secret: "x"}, {ID: "a", Secret: "y"}}},
+		"unknown signer":    {SigningKeyID: "b", Keys: []*config.JWTKey{{ID: "a", Secret: "x"}}},
+		"unknown algorithm": {Keys: []*config.JWTKey{{ID: "a", Algorithm: "PS256", Secret: "x"}}},
+		"missing file":      {Keys: []*config.JWTKey{{ID: "a", Algorithm: "RS256", PrivateKeyFile: "does-not-exist.pem"}}},
+	} {
+		_, err := NewKeyring(cfg)
+		assert.Error(t, err, name)
+	}
+}
+
+// writes a PEM block to a file in dir and returns its path
+func writePEM(t *testing.T, dir string, name string, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+	return path
+}
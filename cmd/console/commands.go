@@ -2,19 +2,27 @@ package console
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	gorm "github.com/jinzhu/gorm"
 
 	database "github.com/jobullo/go-api-example/database"
+	export "github.com/jobullo/go-api-example/export"
+	ledger "github.com/jobullo/go-api-example/ledger"
 	service "github.com/jobullo/go-api-example/service"
 )
 
-func handleAccountOperations(command string, argMap map[string]string, db *gorm.DB) {
+// dates are given to commands as days
+const dateLayout = "2006-01-02"
 
-	newAccountService := service.NewAccountService(db)
+func handleAccountOperations(command string, argMap map[string]string, store database.Store, rules service.Rules) {
+
+	newAccountService := service.NewAccountService(store)
 
 	switch command {
 	case "list":
@@ -24,7 +32,7 @@ func handleAccountOperations(command string, argMap map[string]string, db *gorm.
 			return
 		}
 		for _, account := range *accounts {
-			fmt.Printf("Account #: %d, Owner: %v, Balance: %v\n", account.ID, account.AccountHolder, account.Balance)
+			fmt.Printf("Account #: %d, Owner: %v, Balance: %v, Status: %s\n", account.ID, account.AccountHolder, account.Balance, account.Status)
 		}
 	case "read":
 		idString := argMap["id"]
@@ -39,7 +47,7 @@ func handleAccountOperations(command string, argMap map[string]string, db *gorm.
 			fmt.Println("  Error fetching account:", err)
 			return
 		}
-		fmt.Printf("Account #: %d, Owner: %v, Balance: %v\n", account.Model.ID, account.AccountHolder, account.Balance)
+		fmt.Printf("Account #: %d, Owner: %v, Balance: %v, Status: %s\n", account.Model.ID, account.AccountHolder, account.Balance, account.Status)
 	case "delete":
 		idString := argMap["id"]
 		id, err := strconv.ParseUint(idString, 10, 32)
@@ -47,23 +55,51 @@ func handleAccountOperations(command string, argMap map[string]string, db *gorm.
 			fmt.Println("  Invalid ID:", idString)
 			return
 		}
-		newAccountService.Delete(uint(id))
-		fmt.Println("  Deleted account with ID:", id)
-	case "update":
+		if err := newAccountService.Delete(uint(id)); err != nil {
+			fmt.Println("  Error closing account:", err)
+			return
+		}
+		fmt.Println("  Closed account with ID:", id)
+	case "freeze", "unfreeze", "close":
 		idString := argMap["id"]
 		id, err := strconv.ParseUint(idString, 10, 32)
 		if err != nil {
 			fmt.Println("  Invalid ID:", idString)
 			return
 		}
-		ownerString := argMap["owner"]
-		account, err := newAccountService.FetchById(uint(id))
+		change := map[string]func(uint, uint) (*database.Account, error){
+			"freeze":   newAccountService.Freeze,
+			"unfreeze": newAccountService.Unfreeze,
+			"close":    newAccountService.Close,
+		}[command]
+		account, err := change(uint(id), 0)
 		if err != nil {
-			fmt.Println("  Error fetching account:", err)
+			fmt.Println("  Error changing account status:", err)
+			return
+		}
+		fmt.Printf("  Account with ID %d is now %s\n", account.ID, account.Status)
+	case "dormant":
+		daysString := argMap["days"]
+		days, err := strconv.Atoi(daysString)
+		if err != nil || days <= 0 {
+			fmt.Println("  Invalid number of days:", daysString)
+			return
+		}
+		count, err := newAccountService.MarkDormant(time.Now().AddDate(0, 0, -days))
+		if err != nil {
+			fmt.Println("  Error marking accounts dormant:", err)
+			return
+		}
+		fmt.Printf("  Marked %d accounts without activity in %d days dormant\n", count, days)
+	case "update":
+		idString := argMap["id"]
+		id, err := strconv.ParseUint(idString, 10, 32)
+		if err != nil {
+			fmt.Println("  Invalid ID:", idString)
 			return
 		}
-		account.AccountHolder = ownerString
-		if err := newAccountService.Update(account); err != nil {
+		ownerString := argMap["owner"]
+		if _, err := newAccountService.UpdateHolder(uint(id), ownerString, 0); err != nil {
 			fmt.Println("  Error updating account:", err)
 			return
 		}
@@ -71,26 +107,100 @@ func handleAccountOperations(command string, argMap map[string]string, db *gorm.
 	case "insert":
 		ownerString := argMap["owner"]
 		typeString := argMap["type"]
+		if !rules.AllowsAccountType(typeString) {
+			fmt.Println("  Invalid account type, expected one of", strings.Join(rules.AccountTypeNames(), ", ")+":", typeString)
+			return
+		}
+		currency := argMap["currency"]
+		if err := rules.CheckCurrency(typeString, currency); err != nil {
+			fmt.Println("  Invalid currency:", err)
+			return
+		}
+		if currency == "" {
+			currency = rules.Currency(typeString)
+		}
 		balanceString := argMap["balance"]
-		balance, err := strconv.ParseFloat(balanceString, 64)
+		balance, err := database.ParseMoney(balanceString, currency)
 		if err != nil {
 			fmt.Println("  Invalid balance:", balanceString)
 			return
 		}
 		account := database.Account{AccountHolder: ownerString, AccountType: typeString, Balance: balance}
+		if userString := argMap["ownerID"]; userString != "" {
+			userID, err := strconv.ParseUint(userString, 10, 32)
+			if err != nil {
+				fmt.Println("  Invalid User ID:", userString)
+				return
+			}
+			ownerID := uint(userID)
+			account.OwnerID = &ownerID
+		}
 		if err := newAccountService.Create(&account); err != nil {
 			fmt.Println("  Error creating account:", err)
 			return
 		}
 		fmt.Println("  Inserted new account with ID:", account.Model.ID)
+	case "export":
+		idString := argMap["id"]
+		id, err := strconv.ParseUint(idString, 10, 32)
+		if err != nil {
+			fmt.Println("  Invalid Account ID:", idString)
+			return
+		}
+		from, err := time.Parse(dateLayout, argMap["from"])
+		if err != nil {
+			fmt.Println("  Invalid from date, expected e.g. 2024-01-01:", argMap["from"])
+			return
+		}
+		//the to date is included, it defaults to today
+		to := time.Now()
+		if toString := argMap["to"]; toString != "" {
+			day, err := time.Parse(dateLayout, toString)
+			if err != nil {
+				fmt.Println("  Invalid to date, expected e.g. 2024-01-31:", toString)
+				return
+			}
+			to = day.AddDate(0, 0, 1)
+		}
+		format := argMap["format"]
+		if format == "" {
+			format = export.CSV
+		}
+
+		newTransactionService := service.NewTransactionService(store, rules)
+		statement, err := newTransactionService.Statement(uint(id), from, to)
+		if err != nil {
+			fmt.Println("  Error building statement:", err)
+			return
+		}
+
+		//write to the given file, or to the console
+		out := io.Writer(os.Stdout)
+		if fileName := argMap["file"]; fileName != "" {
+			file, err := os.Create(fileName)
+			if err != nil {
+				fmt.Println("  Error creating file:", err)
+				return
+			}
+			defer file.Close()
+			out = file
+		}
+		if err := export.Write(out, format, statement); err != nil {
+			fmt.Println("  Error exporting statement:", err)
+			return
+		}
+		if argMap["file"] != "" {
+			fmt.Println("  Exported statement to:", argMap["file"])
+		}
+	case "import":
+		importCSV(argMap, service.NewImportService(store, rules).ImportAccounts)
 	default:
 		fmt.Println("  Unknown command.")
 	}
 }
 
-func handleTransactionOperations(command string, argMap map[string]string, db *gorm.DB) {
-	newAccountService := service.NewAccountService(db)
-	newTransactionService := service.NewTransactionService(db, *newAccountService)
+func handleTransactionOperations(command string, argMap map[string]string, store database.Store, rules service.Rules) {
+	newTransactionService := service.NewTransactionService(store, rules)
 	switch command {
 	case "list":
 		var transactions *[]database.Transaction
@@ -116,8 +226,11 @@ func handleTransactionOperations(command string, argMap map[string]string, db *g
 			return
 		}
 
-		var transaction database.Transaction
-		db.First(&transaction, id)
+		transaction, err := newTransactionService.FetchById(uint(id))
+		if err != nil {
+			fmt.Println("  Error fetching transaction:", err)
+			return
+		}
 		fmt.Printf("  ID: %d, Account: %d, Amount: %v, Type: %s\n", transaction.ID, transaction.AccountID, transaction.Amount, transaction.Type)
 	case "delete":
 		idString := argMap["id"]
@@ -127,8 +240,10 @@ func handleTransactionOperations(command string, argMap map[string]string, db *g
 			return
 		}
 
-		newTransactionService.Delete(uint(id))
-		db.Delete(&database.Transaction{}, id)
+		if err := newTransactionService.Delete(uint(id)); err != nil {
+			fmt.Println("  Error deleting transaction:", err)
+			return
+		}
 		fmt.Println("  Deleted transaction with ID:", id)
 	case "update":
 		idString := argMap["id"]
@@ -139,7 +254,7 @@ func handleTransactionOperations(command string, argMap map[string]string, db *g
 		}
 
 		amountString := argMap["amount"]
-		amount, err := strconv.ParseFloat(amountString, 64)
+		amount, err := database.ParseMoney(amountString, argMap["currency"])
 		if err != nil {
 			fmt.Println("  Invalid amount:", amountString)
 			return
@@ -147,7 +262,10 @@ func handleTransactionOperations(command string, argMap map[string]string, db *g
 
 		model := gorm.Model{ID: uint(id)}
 
-		newTransactionService.Update(&database.Transaction{Model: model, Amount: amount})
+		if err := newTransactionService.Update(&database.Transaction{Model: model, Amount: amount}); err != nil {
+			fmt.Println("  Error updating transaction:", err)
+			return
+		}
 		fmt.Println("  Updated transaction with ID:", id)
 	case "insert":
 		accountString := argMap["account"]
@@ -158,7 +276,7 @@ func handleTransactionOperations(command string, argMap map[string]string, db *g
 		}
 
 		amountString := argMap["amount"]
-		amount, err := strconv.ParseFloat(amountString, 64)
+		amount, err := database.ParseMoney(amountString, argMap["currency"])
 		if err != nil {
 			fmt.Println("  Invalid amount:", amountString)
 			return
@@ -172,16 +290,277 @@ func handleTransactionOperations(command string, argMap map[string]string, db *g
 			return
 		}
 		fmt.Println("  Inserted new transaction with ID:", transaction.Model.ID)
+	case "reverse":
+		idString := argMap["id"]
+		id, err := strconv.ParseUint(idString, 10, 32)
+		if err != nil {
+			fmt.Println("  Invalid ID:", idString)
+			return
+		}
+
+		reversal, err := newTransactionService.Reverse(uint(id))
+		if err != nil {
+			fmt.Println("  Error reversing transaction:", err)
+			return
+		}
+		fmt.Println("  Inserted reversal with ID:", reversal.Model.ID)
+
+	case "import":
+		importCSV(argMap, service.NewImportService(store, rules).ImportTransactions)
+	default:
+		fmt.Println("  Unknown command.")
+	}
+}
+
+func handleTransferOperations(command string, argMap map[string]string, store database.Store, rules service.Rules) {
+	newTransferService := service.NewTransferService(store, rules)
+	switch command {
+	case "read":
+		idString := argMap["id"]
+		id, err := strconv.ParseUint(idString, 10, 32)
+		if err != nil {
+			fmt.Println("  Invalid ID:", idString)
+			return
+		}
 
+		transfer, err := newTransferService.FetchById(uint(id))
+		if err != nil {
+			fmt.Println("  Error fetching transfer:", err)
+			return
+		}
+		fmt.Printf("  ID: %d, From: %d, To: %d, Amount: %v\n", transfer.ID, transfer.FromAccountID, transfer.ToAccountID, transfer.Amount)
+		for _, transaction := range transfer.Transactions {
+			fmt.Printf("    Transaction ID: %d, Account: %d, Amount: %v, Type: %s\n", transaction.ID, transaction.AccountID, transaction.Amount, transaction.Type)
+		}
+	case "insert":
+		fromString := argMap["from"]
+		from, err := strconv.ParseUint(fromString, 10, 32)
+		if err != nil {
+			fmt.Println("  Invalid Account ID:", fromString)
+			return
+		}
+
+		toString := argMap["to"]
+		to, err := strconv.ParseUint(toString, 10, 32)
+		if err != nil {
+			fmt.Println("  Invalid Account ID:", toString)
+			return
+		}
+
+		amountString := argMap["amount"]
+		amount, err := database.ParseMoney(amountString, argMap["currency"])
+		if err != nil {
+			fmt.Println("  Invalid amount:", amountString)
+			return
+		}
+
+		transfer := database.Transfer{FromAccountID: uint(from), ToAccountID: uint(to), Amount: amount}
+		if err := newTransferService.Create(&transfer); err != nil {
+			fmt.Println("  Error creating transfer:", err)
+			return
+		}
+		fmt.Println("  Inserted new transfer with ID:", transfer.Model.ID)
 	default:
 		fmt.Println("  Unknown command.")
 	}
 }
 
-func HandleCommands(cmd string, db *gorm.DB) {
+func handleLedgerOperations(command string, argMap map[string]string, store database.Store) {
+	newLedger := ledger.New(store.Journal())
+	switch command {
+	case "read":
+		idString := argMap["account"]
+		id, err := strconv.ParseUint(idString, 10, 32)
+		if err != nil {
+			fmt.Println("  Invalid Account ID:", idString)
+			return
+		}
+
+		account, err := service.NewAccountService(store).FetchById(uint(id))
+		if err != nil {
+			fmt.Println("  Error fetching account:", err)
+			return
+		}
+
+		balance, err := newLedger.Balance(account.ID, account.Balance.Currency)
+		if err != nil {
+			fmt.Println("  Error computing ledger balance:", err)
+			return
+		}
+		fmt.Printf("  Account #: %d, Balance: %v, Ledger Balance: %v\n", account.ID, account.Balance, balance)
+	case "verify":
+		if err := newLedger.Verify(); err != nil {
+			fmt.Println("  Ledger does not balance:", err)
+			return
+		}
+		fmt.Println("  Ledger balances, all postings sum to zero.")
+	default:
+		fmt.Println("  Unknown command.")
+	}
+}
+
+func handleInterestOperations(command string, argMap map[string]string, store database.Store, rules service.Rules) {
+	newInterestService := service.NewInterestService(store, rules)
+	switch command {
+	case "read":
+		idString := argMap["account"]
+		id, err := strconv.ParseUint(idString, 10, 32)
+		if err != nil {
+			fmt.Println("  Invalid Account ID:", idString)
+			return
+		}
+		from, to, err := service.ParsePeriod(argMap["period"])
+		if err != nil {
+			fmt.Println("  Invalid period:", err)
+			return
+		}
+
+		account, err := service.NewAccountService(store).FetchById(uint(id))
+		if err != nil {
+			fmt.Println("  Error fetching account:", err)
+			return
+		}
+
+		interest, err := newInterestService.Accrue(account, from, to)
+		if err != nil {
+			fmt.Println("  Error accruing interest:", err)
+			return
+		}
+		fmt.Printf("  Account #: %d, Period: %s, Interest: %v\n", account.ID, argMap["period"], interest)
+	case "post":
+		run, err := newInterestService.Post(argMap["period"])
+		if err != nil {
+			fmt.Println("  Error posting interest:", err)
+			if run == nil {
+				return
+			}
+		}
+		for _, posting := range run.Posted {
+			fmt.Printf("  Account #: %d, Interest: %v\n", posting.AccountID, posting.Amount)
+		}
+		fmt.Printf("  Posted interest for %s to %d accounts, %d were already paid\n", run.Period, len(run.Posted), run.Skipped)
+	default:
+		fmt.Println("  Unknown command.")
+	}
+}
+
+func handleFeeOperations(command string, argMap map[string]string, store database.Store, rules service.Rules) {
+	switch command {
+	case "post":
+		run, err := service.NewFeeService(store, rules).ChargeMaintenance(argMap["period"])
+		if err != nil {
+			fmt.Println("  Error charging maintenance fees:", err)
+			if run == nil {
+				return
+			}
+		}
+		for _, fee := range run.Charged {
+			fmt.Printf("  Account #: %d, Fee: %v, Waived: %t\n", fee.AccountID, fee.Amount, fee.Waived)
+		}
+		fmt.Printf("  Charged maintenance fees for %s to %d accounts, %d were skipped\n", run.Period, len(run.Charged), run.Skipped)
+	default:
+		fmt.Println("  Unknown command.")
+	}
+}
+
+func handleUserOperations(command string, argMap map[string]string, db *gorm.DB) {
+	newUserService := service.NewUserService(db, service.DefaultMinPasswordStrength)
+	switch command {
+	case "insert":
+		user, err := newUserService.Register(argMap["username"], argMap["password"], argMap["role"])
+		if err != nil {
+			fmt.Println("  Error creating user:", err)
+			return
+		}
+		fmt.Println("  Inserted new user with ID:", user.Model.ID)
+	default:
+		fmt.Println("  Unknown command.")
+	}
+}
+
+func handleSchemaOperations(command string, db *database.DB) {
+	migrator, err := db.Migrator()
+	if err != nil {
+		fmt.Println("  Error loading migrations:", err)
+		return
+	}
+
+	switch command {
+	case "migrate":
+		applied, err := migrator.Up()
+		for _, migration := range applied {
+			fmt.Printf("  Applied migration %03d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Println("  Error migrating:", err)
+			return
+		}
+		if len(applied) == 0 {
+			fmt.Println("  The schema is up to date.")
+		}
+	case "rollback":
+		migration, err := migrator.Down()
+		if err != nil {
+			fmt.Println("  Error rolling back:", err)
+			return
+		}
+		fmt.Printf("  Rolled back migration %03d_%s\n", migration.Version, migration.Name)
+	case "status":
+		status, err := migrator.Status()
+		if err != nil {
+			fmt.Println("  Error reading migrations:", err)
+			return
+		}
+		for _, s := range status {
+			if s.AppliedAt != nil {
+				fmt.Printf("  %03d_%s, applied %s\n", s.Version, s.Name, s.AppliedAt.Format(time.RFC3339))
+			} else {
+				fmt.Printf("  %03d_%s, pending\n", s.Version, s.Name)
+			}
+		}
+	default:
+		fmt.Println("  Unknown command.")
+	}
+}
+
+// importCSV loads the file named by -file, with -dryRun true every row is checked but nothing is saved
+func importCSV(argMap map[string]string, importFile func(r io.Reader, dryRun bool) (*database.ImportResult, error)) {
+	file, err := os.Open(argMap["file"])
+	if err != nil {
+		fmt.Println("  Error opening file:", err)
+		return
+	}
+	defer file.Close()
+
+	dryRun := argMap["dryRun"] == "true"
+	result, err := importFile(file, dryRun)
+	if err != nil {
+		fmt.Println("  Error importing file:", err)
+		return
+	}
+
+	for _, rowError := range result.Errors {
+		if rowError.Column != "" {
+			fmt.Printf("  Line %d, %s: %s\n", rowError.Line, rowError.Column, rowError.Message)
+		} else {
+			fmt.Printf("  Line %d: %s\n", rowError.Line, rowError.Message)
+		}
+	}
+
+	switch {
+	case len(result.Errors) > 0:
+		fmt.Printf("  %d of %d rows are invalid, nothing was imported.\n", len(result.Errors), result.Rows)
+	case dryRun:
+		fmt.Printf("  All %d rows are valid, nothing was imported because this is a dry run.\n", result.Rows)
+	default:
+		fmt.Printf("  Imported %d rows.\n", result.Imported)
+	}
+}
+
+func HandleCommands(cmd string, db *database.DB, rules service.Rules) {
 
-	// Regex pattern to capture key-value pairs
-	pattern := `-(\w+)\s+([^-\s]+(?:\s+[^-\s]+)*)?`
+	// Regex pattern to capture key-value pairs, values can contain dashes, e.g. dates, but not start with one
+	pattern := `-(\w+)\s+([^-\s]\S*(?:\s+[^-\s]\S*)*)?`
 
 	re := regexp.MustCompile(pattern)
 
@@ -196,14 +575,27 @@ func HandleCommands(cmd string, db *gorm.DB) {
 	command := strings.Fields(cmd)[0]
 	entity := argMap["entity"]
 
-	if entity != "Account" && entity != "Transaction" {
+	if entity != "Account" && entity != "Transaction" && entity != "Transfer" && entity != "Ledger" && entity != "User" && entity != "Schema" && entity != "Interest" && entity != "Fee" {
 		fmt.Println("Invalid entity specified.")
 		return
 	}
 
+	store := db.Store()
 	if entity == "Account" {
-		handleAccountOperations(command, argMap, db)
+		handleAccountOperations(command, argMap, store, rules)
 	} else if entity == "Transaction" {
-		handleTransactionOperations(command, argMap, db)
+		handleTransactionOperations(command, argMap, store, rules)
+	} else if entity == "Transfer" {
+		handleTransferOperations(command, argMap, store, rules)
+	} else if entity == "Ledger" {
+		handleLedgerOperations(command, argMap, store)
+	} else if entity == "User" {
+		handleUserOperations(command, argMap, db.DB)
+	} else if entity == "Schema" {
+		handleSchemaOperations(command, db)
+	} else if entity == "Interest" {
+		handleInterestOperations(command, argMap, store, rules)
+	} else if entity == "Fee" {
+		handleFeeOperations(command, argMap, store, rules)
 	}
 }
@@ -2,13 +2,16 @@ package console
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
-	"strconv"
 	"strings"
 
+	"github.com/jobullo/go-api-example/config"
 	"github.com/jobullo/go-api-example/database"
+	"github.com/jobullo/go-api-example/service"
 )
 
 func main() {
@@ -23,24 +26,24 @@ func Execute() {
 	printYellow(">> Loading console settings ...")
 	LoadDotEnv()
 
+	printYellow(">> Loading business rules ...")
+	rules := loadRules()
+
 	printYellow(">> Connecting to database ...")
-	portString := os.Getenv("DB_PORT")
-	port, err := strconv.Atoi(portString)
+	cfg, err := config.DatabaseFromEnvironment()
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	conn, err := database.New(cfg)
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
-		port = 5432
 	}
-	database.Init(
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_HOST"),
-		port,
-		os.Getenv("DB_NAME"),
-	)
-	db := database.GetDatabase()
+	defer conn.Close()
 
-	printYellow(">> Setting up database ...")
-	database.BuildDatabase()
+	printYellow(">> Migrating database ...")
+	if _, err := conn.Migrate(); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
 
 	fmt.Println("-----------------------------")
 	fmt.Println("Example Commands for usage...")
@@ -53,11 +56,47 @@ func Execute() {
 	printBlue("$ read -entity <entity name> —id 1")
 	printGray("     Will read the record with the id of 1 in the <entity name> table")
 	printBlue("$ delete -entity <entity name> —id 1 ")
-	printGray("     Will delete the record with the id of 1 in the <entity name> table.")
-	printBlue("$ insert -entity Account -owner \"John Doe\" -type savings -balance 1000")
-	printGray("     Will create a record in Account table with owner John Does with a $1000 balance in a savings account.")
+	printGray("     Will delete the record with the id of 1 in the <entity name> table, accounts are closed rather than deleted.")
+	printBlue("$ insert -entity Account -owner \"John Doe\" -type savings -balance 1000.00 -currency USD")
+	printGray("     Will create a record in Account table with owner John Does with a $1000 balance in a savings account. The currency defaults to USD.")
+	printBlue("$ insert -entity Account -owner \"John Doe\" -type checking -balance 0 -ownerID 1")
+	printGray("     Will create an account that belongs to user 1, customers can only see accounts that belong to them.")
+	printBlue("$ export -entity Account -id 1 -from 2024-01-01 -to 2024-01-31 -format csv -file statement.csv")
+	printGray("     Will export the statement of account 1 for January as csv, ofx or text. Without -file it is printed.")
+	printBlue("$ import -entity Account -file accounts.csv -dryRun true")
+	printGray("     Will check every row of a csv file of accounts or transactions, drop -dryRun to import them. One invalid row stops the whole file.")
 	printBlue("$ insert -entity Transaction -account 1 -amount 1000 -type <deposit/withdrawal>")
 	printGray("     Will create a transaction record that corresponds to account 1 for a deposit or withdrawal in the amount of $1000.")
+	printBlue("$ reverse -entity Transaction -id 1")
+	printGray("     Will post a transaction that cancels out the transaction with id 1.")
+	printBlue("$ insert -entity Transfer -from 1 -to 2 -amount 250.00")
+	printGray("     Will move $250 from account 1 to account 2 as a single atomic transfer.")
+	printBlue("$ read -entity Transfer -id 1")
+	printGray("     Will read the transfer with id 1 along with its withdrawal and deposit.")
+	printBlue("$ verify -entity Ledger")
+	printGray("     Will check that every journal entry in the double-entry ledger sums to zero.")
+	printBlue("$ read -entity Ledger -account 1")
+	printGray("     Will compare the balance of account 1 with its balance in the ledger.")
+	printBlue("$ read -entity Interest -account 1 -period 2024-01")
+	printGray("     Will work out the interest account 1 earned in January 2024 without paying it.")
+	printBlue("$ post -entity Interest -period 2024-01")
+	printGray("     Will pay every open account the interest it earned in January 2024, accounts that were already paid are skipped.")
+	printBlue("$ post -entity Fee -period 2024-01")
+	printGray("     Will charge every open account the maintenance fee of its account type for January 2024, unless its balance waives it.")
+	printBlue("$ insert -entity User -username jdoe -password <password>")
+	printGray("     Will create a user that can log in to the API, the password must be strong, e.g. 12 characters mixing upper case, lower case and digits.")
+	printBlue("$ insert -entity User -username admin -password <password> -role admin")
+	printGray("     Will create a user with the customer, teller or admin role. The role defaults to customer.")
+	printBlue("$ status -entity Schema")
+	printGray("     Will list the schema migrations and when they were applied, pending ones are applied when the console starts.")
+	printBlue("$ migrate -entity Schema")
+	printGray("     Will apply the pending schema migrations, e.g. to redo one that was rolled back.")
+	printBlue("$ rollback -entity Schema")
+	printGray("     Will roll back the latest schema migration.")
+	printBlue("$ freeze -entity Account -id 1")
+	printGray("     Will stop all transactions on account 1, unfreeze reopens it and close closes it for good once its balance is zero.")
+	printBlue("$ dormant -entity Account -days 365")
+	printGray("     Will mark open accounts without activity in the last 365 days dormant, unfreeze reopens them.")
 	printBlue("$ update -entity Account -id 1 -owner \"John Doe\"")
 	printGray("     Will update the owner of the account with id 1 to John Doe.")
 	printBlue("$ update -entity Transaction -id 1 -account 1 -amount 1000")
@@ -73,6 +112,25 @@ func Execute() {
 			printRed("Exiting the application...")
 			break
 		}
-		HandleCommands(cmd, db)
+		HandleCommands(cmd, conn, rules)
+	}
+}
+
+// loadRules reads the account types, their overdraft limits, fees and interest rates, from the file at CONFIG_PATH,
+// config.yaml by default, so the console applies the same rules as the API. Without the file the default rules apply.
+func loadRules() service.Rules {
+	path := config.GetEnvironmentVariable("CONFIG_PATH", "config.yaml")
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		log.Printf("RULES: No %s found, applying the default rules.", path)
+		return service.DefaultRules()
+	}
+	cfg, err := config.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to load business rules: %v", err)
+	}
+	rules, err := service.RulesFromConfig(*cfg)
+	if err != nil {
+		log.Fatalf("Failed to load business rules: %v", err)
 	}
+	return rules
 }
@@ -2,12 +2,11 @@ package http
 
 import (
 	"fmt"
+	"log"
 
 	routes "github.com/jobullo/go-api-example/cmd/http/routes"
 	config "github.com/jobullo/go-api-example/config"
 	database "github.com/jobullo/go-api-example/database"
-
-	"github.com/gin-contrib/cors"
 )
 
 // @title bank-example API
@@ -24,19 +23,19 @@ func Execute() {
 	cfg := config.LoadConfigFromPath("config.yaml")
 
 	fmt.Println(">> Connecting to database ...")
-	database.Init(cfg.DB.User, cfg.DB.Password, cfg.DB.Host, cfg.DB.Port, cfg.DB.Database)
-
-	fmt.Println(">> Setting up database ...")
-	database.BuildDatabase()
-
-	fmt.Println(">> Loading routes ...")
-	router := routes.SetupRouter(cfg)
-
-	fmt.Println(">> Loading middleware ...")
-	router.Use(AuthMiddleware(cfg))
-
-	fmt.Println(">> Loading api settings ...")
-	router.Use(cors.Default())
+	db, err := database.New(cfg.DB)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	fmt.Println(">> Migrating database ...")
+	if _, err := db.Migrate(); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+
+	fmt.Println(">> Loading routes and middleware ...")
+	router := routes.NewRouter(cfg, db)
 
 	fmt.Println(">> Starting service ...")
 	router.Run(cfg.Server.Port)
@@ -5,19 +5,23 @@ import (
 	"fmt"
 	http "net/http"
 	strconv "strconv"
+	"strings"
 
 	service "github.com/jobullo/go-api-example/service"
 
 	gin "github.com/gin-gonic/gin"
 	database "github.com/jobullo/go-api-example/database"
+	"github.com/jobullo/go-api-example/validation"
 )
 
 type AccountController struct {
 	service *service.AccountService
+	rules   service.Rules
 }
 
-func NewAccountController(service *service.AccountService) *AccountController {
-	return &AccountController{service: service}
+// accounts can only be opened with the account types of the given rules
+func NewAccountController(service *service.AccountService, rules service.Rules) *AccountController {
+	return &AccountController{service: service, rules: rules}
 }
 
 // @Summary create an account record
@@ -26,55 +30,63 @@ func NewAccountController(service *service.AccountService) *AccountController {
 // @Security ApiKeyAuth
 // @Accept  json
 // @Produce  json
-// @Param account body database.Account true "create account"
-// @Success 200 {object} database.Account
-// @Failure 400 {object} error
-// @Failure 500 {object} error
+// @Param account body CreateAccountRequest true "create account"
+// @Param Idempotency-Key header string false "makes the request safe to retry, the first response is replayed"
+// @Success 200 {object} AccountResponse
+// @Failure 400 {object} Problem
+// @Failure 409 {object} Problem
+// @Failure 422 {object} Problem
+// @Failure 500 {object} Problem
 // @Router /accounts [post]
 func (ac *AccountController) Create(ctx *gin.Context) {
 
-	var account database.Account
-	if err := ctx.BindJSON(&account); err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, NewError(err.Error()))
+	principal := CurrentPrincipal(ctx)
+	request := CreateAccountRequest{rules: ac.rules, staff: principal.IsStaff()}
+	if !bindRequest(ctx, &request) {
 		return
 	}
+	account := request.account()
+
+	//customers open accounts for themselves, staff can open them on behalf of any user
+	if !principal.IsStaff() {
+		account.OwnerID = &principal.UserID
+	}
 
 	if err := ac.service.Create(&account); err != nil {
-		ctx.AbortWithStatusJSON(http.StatusInternalServerError, NewError(err.Error()))
+		abortWithError(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, account)
+	setETag(ctx, &account)
+	ctx.JSON(http.StatusOK, newAccountResponse(&account))
 }
 
 // @Summary delete an account record
-// @Description deletes an account record from the DB
+// @Description closes the account like POST /accounts/{id}/close, accounts and their history are never removed.
+// @Description Only admins can delete accounts.
 // @Tags Accounts
 // @Security ApiKeyAuth
 // @Accept  json
 // @Produce json
 // @Param id path int true "account ID"
 // @Success 204
-// @Failure 400 {object} error
-// @Failure 404 {object} error
-// @Failure 500 {object} error
+// @Failure 400 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 409 {object} Problem
+// @Failure 500 {object} Problem
+// @Failure 403 {object} Problem
 // @Router /accounts/{id} [delete]
 func (ac *AccountController) Delete(ctx *gin.Context) {
 
-	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	id, err := pathID(ctx)
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, NewError(err.Error()))
+		abortWithError(ctx, err)
 		return
 	}
 
-	if err := ac.service.Delete(uint(id)); err != nil {
-
-		if errors.Is(err, database.ErrNotFound) {
-			ctx.AbortWithStatusJSON(http.StatusNotFound, NewError(fmt.Sprintf("Account with ID %d not found", id)))
-			return
-		}
+	if err := ac.service.Delete(id); err != nil {
 
-		ctx.AbortWithStatusJSON(http.StatusInternalServerError, NewError(err.Error()))
+		abortWithError(ctx, notFound(err, "Account", id))
 		return
 	}
 
@@ -88,92 +100,296 @@ func (ac *AccountController) Delete(ctx *gin.Context) {
 // @Accept  json
 // @Produce  json
 // @Param id path int true "account ID"
-// @Success 200 {object} database.Account
-// @Failure 400 {object} error
-// @Failure 404 {object} error
-// @Failure 500 {object} error
+// @Success 200 {object} AccountResponse
+// @Failure 400 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 500 {object} Problem
 // @Router /accounts/{id} [get]
 func (accountController *AccountController) FetchById(ctx *gin.Context) {
 
-	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	id, err := pathID(ctx)
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, NewError(err.Error()))
+		abortWithError(ctx, err)
 		return
 	}
 
-	account, err := accountController.service.FetchById(uint(id))
+	account, err := accountController.service.FetchById(id)
 
-	if err != nil {
-		if errors.Is(err, database.ErrNotFound) {
-			ctx.AbortWithStatusJSON(http.StatusNotFound, NewError(fmt.Sprintf("Account with ID %d not found", id)))
-			return
-		}
+	//someone else's account looks the same as one that doesn't exist
+	if err == nil && !CurrentPrincipal(ctx).CanAccess(account) {
+		err = database.ErrNotFound
+	}
 
-		ctx.AbortWithStatusJSON(http.StatusInternalServerError, NewError(err.Error()))
+	if err != nil {
+		abortWithError(ctx, notFound(err, "Account", id))
 		return
 	}
 
-	ctx.JSON(http.StatusOK, account)
+	setETag(ctx, account)
+	ctx.JSON(http.StatusOK, newAccountResponse(account))
 }
 
-// @Summary list all account records
-// @Description lists all account records in the DB
+// @Summary list account records
+// @Description lists one page of the account records in the DB, customers only get their own accounts.
+// @Description Pass next_cursor from the response as cursor to get the next page.
 // @Tags Accounts
 // @Security ApiKeyAuth
 // @Accept  json
 // @Produce  json
-// @Success 200 {array} database.Account
-// @Failure 500 {object} error
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param limit query int false "records per page, 50 by default and at most 200"
+// @Param sort query string false "id, created_at, holder or balance" default(id)
+// @Param order query string false "asc or desc" default(asc)
+// @Param type query string false "account type"
+// @Param status query string false "open, frozen, dormant or closed"
+// @Param holder query string false "part of the account holder, case insensitive"
+// @Param from query string false "created on or after this date or timestamp"
+// @Param to query string false "created before this timestamp, or on or before this date"
+// @Param min_balance query string false "smallest balance"
+// @Param max_balance query string false "largest balance"
+// @Success 200 {object} database.Page[AccountResponse]
+// @Failure 400 {object} Problem
+// @Failure 500 {object} Problem
 // @Router /accounts [get]
 func (ac *AccountController) List(ctx *gin.Context) {
-	accounts, err := ac.service.List()
+	page, err := parsePageRequest(ctx)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
 
+	filter, err := accountFilter(ctx)
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusInternalServerError, NewError(err.Error()))
+		abortWithError(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, accounts)
+	//customers only see their own accounts
+	if principal := CurrentPrincipal(ctx); !principal.IsStaff() {
+		filter.OwnerID = &principal.UserID
+	}
+
+	accounts, err := ac.service.ListPage(filter, page)
+
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, newPageResponse(accounts, newAccountResponse))
+}
+
+// accountFilter reads the filters of the account list from the query string
+func accountFilter(ctx *gin.Context) (filter database.AccountFilter, err error) {
+	filter.AccountType = ctx.Query("type")
+	filter.Holder = ctx.Query("holder")
+
+	if filter.Status = ctx.Query("status"); filter.Status != "" {
+		var v validation.Validator
+		v.OneOf("status", filter.Status, database.AccountStatuses)
+		if err := v.Err(); err != nil {
+			return filter, err
+		}
+	}
+
+	if filter.CreatedFrom, err = queryTime(ctx, "from", false); err != nil {
+		return filter, err
+	}
+	if filter.CreatedTo, err = queryTime(ctx, "to", true); err != nil {
+		return filter, err
+	}
+	if filter.MinBalance, err = queryMinor(ctx, "min_balance"); err != nil {
+		return filter, err
+	}
+	if filter.MaxBalance, err = queryMinor(ctx, "max_balance"); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
 }
 
 // @Summary update an account record
-// @Description updates an account record in the DB
+// @Description updates the holder of an account record in the DB, the balance only changes through transactions.
+// @Description Send the ETag from a previous read in If-Match (or the version in the body) and the update fails
+// @Description if the account has changed since.
 // @Tags Accounts
 // @Security ApiKeyAuth
 // @Accept  json
 // @Produce  json
 // @Param id path int true "account ID"
-// @Success 200 {object} database.Account
-// @Failure 400 {object} error
-// @Failure 404 {object} error
-// @Failure 500 {object} error
+// @Param account body UpdateAccountRequest true "update account"
+// @Param If-Match header string false "ETag of the account version being updated"
+// @Success 200 {object} AccountResponse
+// @Failure 400 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 409 {object} Problem
+// @Failure 412 {object} Problem
+// @Failure 500 {object} Problem
 // @Router /accounts/{id} [put]
 func (ac *AccountController) Update(ctx *gin.Context) {
 
-	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	id, err := pathID(ctx)
+
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	var request UpdateAccountRequest
+	if !bindRequest(ctx, &request) {
+		return
+	}
+
+	account, err := ac.service.FetchById(id)
+
+	//someone else's account looks the same as one that doesn't exist
+	if err == nil && !CurrentPrincipal(ctx).CanAccess(account) {
+		err = database.ErrNotFound
+	}
+
+	if err != nil {
+		abortWithError(ctx, notFound(err, "Account", id))
+		return
+	}
+
+	//only the holder is taken from the request, the balance only changes through transactions.
+	//If-Match takes precedence over a version in the body, without either the latest version is updated
+	version := request.Version
+
+	ifMatch := ctx.GetHeader("If-Match")
+	if ifMatch != "" {
+		if version, err = parseETag(ifMatch); err != nil {
+			abortWithError(ctx, err)
+			return
+		}
+	}
 
+	account, err = ac.service.UpdateHolder(id, request.AccountHolder, version)
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, NewError(err.Error()))
+		//the client asked for the update to be conditional on the version
+		if errors.Is(err, database.ErrConflict) && ifMatch != "" {
+			err = ErrPreconditionFailed
+		}
+
+		abortWithError(ctx, notFound(err, "Account", id))
 		return
 	}
 
-	var account database.Account
-	if err := ctx.BindJSON(&account); err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, NewError(err.Error()))
+	setETag(ctx, account)
+	ctx.JSON(http.StatusOK, newAccountResponse(account))
+}
+
+// @Summary freeze an account
+// @Description stops all transactions on an open or dormant account, only staff can freeze accounts
+// @Tags Accounts
+// @Security ApiKeyAuth
+// @Produce  json
+// @Param id path int true "account ID"
+// @Param If-Match header string false "ETag of the account version being frozen"
+// @Success 200 {object} AccountResponse
+// @Failure 400 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 409 {object} Problem
+// @Failure 412 {object} Problem
+// @Failure 500 {object} Problem
+// @Router /accounts/{id}/freeze [post]
+func (ac *AccountController) Freeze(ctx *gin.Context) {
+	ac.changeStatus(ctx, ac.service.Freeze)
+}
+
+// @Summary unfreeze an account
+// @Description reopens a frozen or dormant account, only staff can unfreeze accounts
+// @Tags Accounts
+// @Security ApiKeyAuth
+// @Produce  json
+// @Param id path int true "account ID"
+// @Param If-Match header string false "ETag of the account version being unfrozen"
+// @Success 200 {object} AccountResponse
+// @Failure 400 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 409 {object} Problem
+// @Failure 412 {object} Problem
+// @Failure 500 {object} Problem
+// @Router /accounts/{id}/unfreeze [post]
+func (ac *AccountController) Unfreeze(ctx *gin.Context) {
+	ac.changeStatus(ctx, ac.service.Unfreeze)
+}
+
+// @Summary close an account
+// @Description closes an account with a zero balance for good. The account and its transactions can still be read.
+// @Tags Accounts
+// @Security ApiKeyAuth
+// @Produce  json
+// @Param id path int true "account ID"
+// @Param If-Match header string false "ETag of the account version being closed"
+// @Success 200 {object} AccountResponse
+// @Failure 400 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 409 {object} Problem
+// @Failure 412 {object} Problem
+// @Failure 500 {object} Problem
+// @Router /accounts/{id}/close [post]
+func (ac *AccountController) Close(ctx *gin.Context) {
+	ac.changeStatus(ctx, ac.service.Close)
+}
+
+// changeStatus applies one of the status changes of the account service to the account in the path
+func (ac *AccountController) changeStatus(ctx *gin.Context, change func(id uint, version uint) (*database.Account, error)) {
+	id, err := pathID(ctx)
+	if err != nil {
+		abortWithError(ctx, err)
 		return
 	}
 
-	account.ID = uint(id)
+	account, err := ac.service.FetchById(id)
+
+	//someone else's account looks the same as one that doesn't exist
+	if err == nil && !CurrentPrincipal(ctx).CanAccess(account) {
+		err = database.ErrNotFound
+	}
+
+	if err != nil {
+		abortWithError(ctx, notFound(err, "Account", id))
+		return
+	}
 
-	if err := ac.service.Update(&account); err != nil {
-		if errors.Is(err, database.ErrNotFound) {
-			ctx.AbortWithStatusJSON(http.StatusNotFound, NewError(fmt.Sprintf("Account with ID %d not found", id)))
+	//without If-Match the status of the latest version is changed
+	var version uint
+	ifMatch := ctx.GetHeader("If-Match")
+	if ifMatch != "" {
+		if version, err = parseETag(ifMatch); err != nil {
+			abortWithError(ctx, err)
 			return
 		}
+	}
+
+	account, err = change(id, version)
+	if err != nil {
+		if errors.Is(err, database.ErrConflict) && ifMatch != "" {
+			err = ErrPreconditionFailed
+		}
 
-		ctx.AbortWithStatusJSON(http.StatusInternalServerError, NewError(err.Error()))
+		abortWithError(ctx, notFound(err, "Account", id))
 		return
 	}
 
-	ctx.JSON(http.StatusOK, account)
+	setETag(ctx, account)
+	ctx.JSON(http.StatusOK, newAccountResponse(account))
+}
+
+// setETag exposes the account version so clients can send it back in If-Match
+func setETag(ctx *gin.Context, account *database.Account) {
+	ctx.Header("ETag", fmt.Sprintf("\"%d\"", account.Version))
+}
+
+// parseETag reads the account version from an If-Match header value such as "3" or W/"3"
+func parseETag(value string) (uint, error) {
+	tag := strings.Trim(strings.TrimPrefix(strings.TrimSpace(value), "W/"), "\"")
+	version, err := strconv.ParseUint(tag, 10, 32)
+	if err != nil || version == 0 {
+		return 0, withDetail(ErrBadRequest, "invalid If-Match header %q", value)
+	}
+	return uint(version), nil
 }
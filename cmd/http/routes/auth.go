@@ -1,52 +1,211 @@
 package routes
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	"github.com/jobullo/go-api-example/auth"
+	"github.com/jobullo/go-api-example/database"
+	"github.com/jobullo/go-api-example/service"
+	"github.com/jobullo/go-api-example/validation"
 )
 
-type AuthController struct{}
+type AuthController struct {
+	service *service.UserService
+	tokens  *service.TokenService
+	keyring *auth.Keyring
+}
+
+func NewAuthController(service *service.UserService, tokens *service.TokenService, keyring *auth.Keyring) *AuthController {
+	return &AuthController{service: service, tokens: tokens, keyring: keyring}
+}
+
+// Register creates a user that can log in
+// @Summary Register creates a user
+// @Description Creates a user, the password must meet the configured minimum strength
+// @Tags Authentication
+// @Accept  json
+// @Produce  json
+// @Param credentials body Credentials true "username and password"
+// @Success 200 {object} UserResponse
+// @Failure 400 {object} Problem
+// @Failure 409 {object} Problem
+// @Failure 500 {object} Problem
+// @Router /auth/register [post]
+func (h *AuthController) Register(c *gin.Context) {
+	var credentials Credentials
+	if !bindRequest(c, &credentials) {
+		return
+	}
+
+	//anyone can sign up as a customer, staff roles are given out from the console
+	user, err := h.service.Register(credentials.Username, credentials.Password, database.RoleCustomer)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newUserResponse(user))
+}
 
 // Login generates a JWT token
 // @Summary Login generates a JWT token
-// @Description Generates a JWT Token for use with Authorized endpoints
+// @Description Checks a username and password and generates a JWT Token for use with Authorized endpoints
 // @Tags Authentication
 // @Accept  json
 // @Produce  json
+// @Param credentials body Credentials true "username and password"
 // @Success 200 {object} AuthToken
-// @Failure 401
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 500 {object} Problem
 // @Router /auth/login [post]
-func (h AuthController) Login(c *gin.Context) {
+func (h *AuthController) Login(c *gin.Context) {
+	var credentials Credentials
+	if !bindRequest(c, &credentials) {
+		return
+	}
+
+	user, err := h.service.Authenticate(credentials.Username, credentials.Password)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	h.respondWithTokens(c, user, "")
+}
+
+// Refresh exchanges a refresh token for a new access token and refresh token
+// @Summary Refresh exchanges a refresh token for new tokens
+// @Description Each refresh token can be used once. Using one a second time revokes every token from the same login.
+// @Tags Authentication
+// @Accept  json
+// @Produce  json
+// @Param refresh body RefreshRequest true "refresh token"
+// @Success 200 {object} AuthToken
+// @Failure 400 {object} Problem
+// @Failure 401 {object} Problem
+// @Failure 500 {object} Problem
+// @Router /auth/refresh [post]
+func (h *AuthController) Refresh(c *gin.Context) {
+	var request RefreshRequest
+	if !bindRequest(c, &request) {
+		return
+	}
+
+	userID, refreshToken, err := h.tokens.Rotate(request.RefreshToken)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	user, err := h.service.FetchById(userID)
+	//the user was deleted after logging in
+	if errors.Is(err, database.ErrNotFound) {
+		err = database.ErrInvalidToken
+	}
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	h.respondWithTokens(c, user, refreshToken)
+}
 
-	// Establish a 30 minute expiration
-	expiresAt := time.Now().Add(time.Minute * 30).Unix()
+// Logout revokes the caller's access token and, when given, their refresh token
+// @Summary Logout revokes the caller's tokens
+// @Description Revokes the bearer token of the request and every refresh token from the same login
+// @Tags Authentication
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce  json
+// @Param refresh body RefreshRequest false "refresh token"
+// @Success 204
+// @Failure 401
+// @Failure 500 {object} Problem
+// @Router /auth/logout [post]
+func (h *AuthController) Logout(c *gin.Context) {
+	var request RefreshRequest
+	if c.Request.ContentLength != 0 && !bindRequest(c, &request) {
+		return
+	}
 
-	token := jwt.New(jwt.SigningMethodHS256)
+	claims := c.MustGet(ClaimsKey).(jwt.MapClaims)
+	if jti, ok := claims["jti"].(string); ok {
+		expiresAt, _ := claims["exp"].(float64)
+		if err := h.tokens.RevokeAccessToken(jti, time.Unix(int64(expiresAt), 0)); err != nil {
+			abortWithError(c, err)
+			return
+		}
+	}
 
-	token.Claims = jwt.MapClaims{
-		"exp": expiresAt,
-		"iat": time.Now().Unix(),
+	//an unknown refresh token is already as good as revoked
+	if request.RefreshToken != "" {
+		if err := h.tokens.Revoke(request.RefreshToken); err != nil && !errors.Is(err, database.ErrInvalidToken) {
+			abortWithError(c, err)
+			return
+		}
 	}
 
-	// TODO: Load from config
-	tokenString, err := token.SignedString([]byte("A14E45A7-D02B-4ADA-94BC-66DCBFD3181E"))
+	c.Status(http.StatusNoContent)
+}
+
+// signs an access token for the user and responds with it and a refresh token, a new login gets a new refresh token
+func (h *AuthController) respondWithTokens(c *gin.Context, user *database.User, refreshToken string) {
+	// the lifetime and signing key come from the jwt section of config.yaml
+	tokenString, expiresAt, err := h.keyring.Sign(jwt.MapClaims{
+		"sub":      strconv.FormatUint(uint64(user.ID), 10),
+		"username": user.Username,
+		"role":     user.Role,
+	})
 	if err != nil {
-		panic(err.Error())
+		abortWithError(c, err)
+		return
+	}
+
+	if refreshToken == "" {
+		if refreshToken, err = h.tokens.Issue(user.ID); err != nil {
+			abortWithError(c, err)
+			return
+		}
 	}
 
 	c.JSON(http.StatusOK, AuthToken{
-		Token:     tokenString,
-		TokenType: "Bearer",
-		ExpiresIn: expiresAt,
+		Token:        tokenString,
+		TokenType:    "Bearer",
+		ExpiresIn:    expiresAt.Unix(),
+		RefreshToken: refreshToken,
 	})
 }
 
+/** Refresh token posted to refresh and log out */
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (r *RefreshRequest) Validate(v *validation.Validator) {
+	v.Required("refresh_token", r.RefreshToken)
+}
+
+/** Credentials posted to register and log in */
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (r *Credentials) Validate(v *validation.Validator) {
+	v.Required("username", r.Username)
+	v.Check(r.Password != "", "password", "is required")
+}
+
 /** Auth struct for token management */
 type AuthToken struct {
-	TokenType string `json:"token_type"`
-	Token     string `json:"access_token"`
-	ExpiresIn int64  `json:"expires_in"`
+	TokenType    string `json:"token_type"`
+	Token        string `json:"access_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
 }
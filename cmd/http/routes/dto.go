@@ -0,0 +1,357 @@
+package routes
+
+import (
+	"time"
+
+	gin "github.com/gin-gonic/gin"
+	database "github.com/jobullo/go-api-example/database"
+	service "github.com/jobullo/go-api-example/service"
+	"github.com/jobullo/go-api-example/validation"
+)
+
+// the longest account holder name accepted
+const maxHolderLength = 200
+
+// request is a json request body that checks its own fields
+type request interface {
+	Validate(v *validation.Validator)
+}
+
+// bindRequest decodes the json body into req and validates it, otherwise the request is aborted
+// with the invalid fields and false is returned
+func bindRequest(ctx *gin.Context, req request) bool {
+	err := validation.DecodeJSON(ctx.Request.Body, req)
+	if err == nil {
+		var v validation.Validator
+		req.Validate(&v)
+		err = v.Err()
+	}
+
+	if err != nil {
+		abortWithError(ctx, err)
+		return false
+	}
+	return true
+}
+
+// checkCurrency adds a field error when an amount is in a currency accounts can't be held in and reports
+// whether it is supported, an amount without a currency is in the account's
+func checkCurrency(v *validation.Validator, field string, amount database.Money) bool {
+	ok := amount.Currency == "" || database.ValidCurrency(amount.Currency)
+	v.Check(ok, field, "has an unsupported currency")
+	return ok
+}
+
+/** Body posted to open an account */
+type CreateAccountRequest struct {
+	AccountHolder string         `json:"accountHolder"`
+	AccountType   string         `json:"accountType"`
+	Balance       database.Money `json:"balance"`           //only staff can open an account with money in it, customers only pick the currency
+	OwnerID       *uint          `json:"ownerID,omitempty"` //only staff can open accounts for someone else
+
+	rules service.Rules //the configured account types and their currencies
+	staff bool          //whether the caller is a teller or an admin
+}
+
+func (r *CreateAccountRequest) Validate(v *validation.Validator) {
+	if v.Required("accountHolder", r.AccountHolder) {
+		v.MaxLength("accountHolder", r.AccountHolder, maxHolderLength)
+	}
+	if v.Required("accountType", r.AccountType) {
+		v.OneOf("accountType", r.AccountType, r.rules.AccountTypeNames())
+	}
+	if r.Balance.IsNegative() {
+		v.Add("balance", "must not be negative")
+	} else {
+		v.Check(r.staff || r.Balance.IsZero(), "balance", "can only be set by staff")
+	}
+	if checkCurrency(v, "balance", r.Balance) && r.rules.AllowsAccountType(r.AccountType) {
+		//the account is held in the currency of its type
+		currency := r.rules.Currency(r.AccountType)
+		if r.rules.CheckCurrency(r.AccountType, r.Balance.Currency) != nil {
+			v.Add("balance", "must be in "+currency+" for "+r.AccountType+" accounts")
+		} else if _, err := r.Balance.In(currency); err != nil {
+			v.Add("balance", "has more decimal places than "+currency+" has")
+		}
+	}
+}
+
+// account returns the account to open, a balance without a currency is in the account type's, see Validate
+func (r *CreateAccountRequest) account() database.Account {
+	balance, _ := r.Balance.In(r.rules.Currency(r.AccountType))
+	return database.Account{
+		AccountHolder: r.AccountHolder,
+		AccountType:   r.AccountType,
+		Balance:       balance,
+		OwnerID:       r.OwnerID,
+	}
+}
+
+/** Body put to update an account, only the holder can be changed */
+type UpdateAccountRequest struct {
+	AccountHolder string `json:"accountHolder"`
+	Version       uint   `json:"version,omitempty"` //the version being updated when If-Match isn't sent
+}
+
+func (r *UpdateAccountRequest) Validate(v *validation.Validator) {
+	if v.Required("accountHolder", r.AccountHolder) {
+		v.MaxLength("accountHolder", r.AccountHolder, maxHolderLength)
+	}
+}
+
+/** Body posted to create a transaction */
+type CreateTransactionRequest struct {
+	AccountID uint           `json:"accountID"`
+	Type      string         `json:"transactionType"`
+	Amount    database.Money `json:"transactionAmount"`
+}
+
+func (r *CreateTransactionRequest) Validate(v *validation.Validator) {
+	v.Check(r.AccountID != 0, "accountID", "is required")
+	if v.Required("transactionType", r.Type) {
+		v.OneOf("transactionType", r.Type, database.TransactionTypes)
+	}
+	v.Check(r.Amount.IsPositive(), "transactionAmount", "must be greater than zero")
+	checkCurrency(v, "transactionAmount", r.Amount)
+}
+
+func (r *CreateTransactionRequest) transaction() database.Transaction {
+	return database.Transaction{AccountID: r.AccountID, Type: r.Type, Amount: r.Amount}
+}
+
+/** Body put to change the amount of a transaction */
+type UpdateTransactionRequest struct {
+	Amount database.Money `json:"transactionAmount"`
+}
+
+func (r *UpdateTransactionRequest) Validate(v *validation.Validator) {
+	v.Check(r.Amount.IsPositive(), "transactionAmount", "must be greater than zero")
+	checkCurrency(v, "transactionAmount", r.Amount)
+}
+
+/** Body posted to transfer funds */
+type CreateTransferRequest struct {
+	FromAccountID uint           `json:"fromAccountID"`
+	ToAccountID   uint           `json:"toAccountID"`
+	Amount        database.Money `json:"amount"`
+}
+
+func (r *CreateTransferRequest) Validate(v *validation.Validator) {
+	v.Check(r.FromAccountID != 0, "fromAccountID", "is required")
+	v.Check(r.ToAccountID != 0, "toAccountID", "is required")
+	v.Check(r.FromAccountID == 0 || r.FromAccountID != r.ToAccountID, "toAccountID", "must be a different account")
+	v.Check(r.Amount.IsPositive(), "amount", "must be greater than zero")
+	checkCurrency(v, "amount", r.Amount)
+}
+
+func (r *CreateTransferRequest) transfer() database.Transfer {
+	return database.Transfer{FromAccountID: r.FromAccountID, ToAccountID: r.ToAccountID, Amount: r.Amount}
+}
+
+/** Body posted to pay the interest or charge the maintenance fees of a month */
+type PeriodRequest struct {
+	Period string `json:"period"` //e.g. 2024-01
+}
+
+func (r *PeriodRequest) Validate(v *validation.Validator) {
+	if v.Required("period", r.Period) {
+		_, _, err := service.ParsePeriod(r.Period)
+		v.Check(err == nil, "period", "must be a month such as 2024-01")
+	}
+}
+
+/** Account as returned by the api */
+type AccountResponse struct {
+	ID            uint           `json:"id"`
+	AccountHolder string         `json:"accountHolder"`
+	AccountType   string         `json:"accountType"`
+	Balance       database.Money `json:"balance"`
+	Version       uint           `json:"version"`
+	OwnerID       *uint          `json:"ownerID,omitempty"`
+	Status        string         `json:"status"`
+	CreatedAt     time.Time      `json:"createdAt"`
+	UpdatedAt     time.Time      `json:"updatedAt"`
+}
+
+func newAccountResponse(account *database.Account) AccountResponse {
+	return AccountResponse{
+		ID:            account.ID,
+		AccountHolder: account.AccountHolder,
+		AccountType:   account.AccountType,
+		Balance:       account.Balance,
+		Version:       account.Version,
+		OwnerID:       account.OwnerID,
+		Status:        account.Status,
+		CreatedAt:     account.CreatedAt,
+		UpdatedAt:     account.UpdatedAt,
+	}
+}
+
+/** Transaction as returned by the api */
+type TransactionResponse struct {
+	ID           uint                  `json:"id"`
+	AccountID    uint                  `json:"accountID"`
+	Type         string                `json:"transactionType"`
+	Amount       database.Money        `json:"transactionAmount"`
+	TransferID   *uint                 `json:"transferID,omitempty"`
+	ReversalOfID *uint                 `json:"reversalOfID,omitempty"`
+	FeeForID     *uint                 `json:"feeForID,omitempty"` //set on a fee, the transaction that triggered it
+	Fees         []TransactionResponse `json:"fees,omitempty"`     //the fees a newly created transaction triggered
+	CreatedAt    time.Time             `json:"createdAt"`
+	UpdatedAt    time.Time             `json:"updatedAt"`
+}
+
+func newTransactionResponse(transaction *database.Transaction) TransactionResponse {
+	response := TransactionResponse{
+		ID:           transaction.ID,
+		AccountID:    transaction.AccountID,
+		Type:         transaction.Type,
+		Amount:       transaction.Amount,
+		TransferID:   transaction.TransferID,
+		ReversalOfID: transaction.ReversalOfID,
+		FeeForID:     transaction.FeeForID,
+		CreatedAt:    transaction.CreatedAt,
+		UpdatedAt:    transaction.UpdatedAt,
+	}
+	for i := range transaction.Fees {
+		response.Fees = append(response.Fees, newTransactionResponse(&transaction.Fees[i]))
+	}
+	return response
+}
+
+/** Transfer as returned by the api, with the withdrawal and deposit it was posted as */
+type TransferResponse struct {
+	ID            uint                  `json:"id"`
+	FromAccountID uint                  `json:"fromAccountID"`
+	ToAccountID   uint                  `json:"toAccountID"`
+	Amount        database.Money        `json:"amount"`
+	Transactions  []TransactionResponse `json:"transactions,omitempty"`
+	CreatedAt     time.Time             `json:"createdAt"`
+}
+
+func newTransferResponse(transfer *database.Transfer) TransferResponse {
+	response := TransferResponse{
+		ID:            transfer.ID,
+		FromAccountID: transfer.FromAccountID,
+		ToAccountID:   transfer.ToAccountID,
+		Amount:        transfer.Amount,
+		CreatedAt:     transfer.CreatedAt,
+	}
+	for i := range transfer.Transactions {
+		response.Transactions = append(response.Transactions, newTransactionResponse(&transfer.Transactions[i]))
+	}
+	return response
+}
+
+/** User as returned by the api */
+type UserResponse struct {
+	ID        uint      `json:"id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func newUserResponse(user *database.User) UserResponse {
+	return UserResponse{ID: user.ID, Username: user.Username, Role: user.Role, CreatedAt: user.CreatedAt}
+}
+
+/** Account statement as returned by the api */
+type StatementResponse struct {
+	AccountID      uint                    `json:"accountID"`
+	Account        AccountResponse         `json:"account"`
+	From           time.Time               `json:"from"`
+	To             time.Time               `json:"to"`
+	OpeningBalance database.Money          `json:"openingBalance"`
+	Lines          []StatementLineResponse `json:"transactions"`
+	ClosingBalance database.Money          `json:"closingBalance"`
+}
+
+/** Transaction on a statement with the account balance right after it */
+type StatementLineResponse struct {
+	Transaction    TransactionResponse `json:"transaction"`
+	RunningBalance database.Money      `json:"runningBalance"`
+}
+
+func newStatementResponse(statement *database.Statement) StatementResponse {
+	response := StatementResponse{
+		AccountID:      statement.AccountID,
+		From:           statement.From,
+		To:             statement.To,
+		OpeningBalance: statement.OpeningBalance,
+		Lines:          make([]StatementLineResponse, 0, len(statement.Lines)),
+		ClosingBalance: statement.ClosingBalance,
+	}
+	if statement.Account != nil {
+		response.Account = newAccountResponse(statement.Account)
+	}
+	for i := range statement.Lines {
+		response.Lines = append(response.Lines, StatementLineResponse{
+			Transaction:    newTransactionResponse(&statement.Lines[i].Transaction),
+			RunningBalance: statement.Lines[i].RunningBalance,
+		})
+	}
+	return response
+}
+
+/** Interest paid to one account */
+type InterestPostingResponse struct {
+	AccountID     uint           `json:"accountID"`
+	Amount        database.Money `json:"amount"`
+	TransactionID *uint          `json:"transactionID,omitempty"` //not set when nothing was earned
+}
+
+/** Result of posting the interest of a month */
+type InterestRunResponse struct {
+	Period  string                    `json:"period"`
+	Posted  []InterestPostingResponse `json:"posted"`
+	Skipped int                       `json:"skipped"` //accounts paid by an earlier run
+}
+
+func newInterestRunResponse(run *database.InterestRun) InterestRunResponse {
+	response := InterestRunResponse{Period: run.Period, Posted: make([]InterestPostingResponse, 0, len(run.Posted)), Skipped: run.Skipped}
+	for _, posting := range run.Posted {
+		response.Posted = append(response.Posted, InterestPostingResponse{
+			AccountID:     posting.AccountID,
+			Amount:        posting.Amount,
+			TransactionID: posting.TransactionID,
+		})
+	}
+	return response
+}
+
+/** Maintenance fee of one account */
+type MaintenanceFeeResponse struct {
+	AccountID     uint           `json:"accountID"`
+	Amount        database.Money `json:"amount"`
+	Waived        bool           `json:"waived"`
+	TransactionID *uint          `json:"transactionID,omitempty"` //not set when the fee was waived
+}
+
+/** Result of charging the maintenance fees of a month */
+type FeeRunResponse struct {
+	Period  string                   `json:"period"`
+	Charged []MaintenanceFeeResponse `json:"charged"`
+	Skipped int                      `json:"skipped"` //accounts charged by an earlier run or no longer open
+}
+
+func newFeeRunResponse(run *database.FeeRun) FeeRunResponse {
+	response := FeeRunResponse{Period: run.Period, Charged: make([]MaintenanceFeeResponse, 0, len(run.Charged)), Skipped: run.Skipped}
+	for _, fee := range run.Charged {
+		response.Charged = append(response.Charged, MaintenanceFeeResponse{
+			AccountID:     fee.AccountID,
+			Amount:        fee.Amount,
+			Waived:        fee.Waived,
+			TransactionID: fee.TransactionID,
+		})
+	}
+	return response
+}
+
+// newPageResponse converts the records of a page with the given function, keeping the cursor
+func newPageResponse[M any, R any](page *database.Page[M], convert func(*M) R) database.Page[R] {
+	response := database.Page[R]{Items: make([]R, 0, len(page.Items)), NextCursor: page.NextCursor}
+	for i := range page.Items {
+		response.Items = append(response.Items, convert(&page.Items[i]))
+	}
+	return response
+}
@@ -1,11 +1,157 @@
 package routes
 
 import (
+	"errors"
+	"fmt"
+	http "net/http"
+
 	"github.com/gin-gonic/gin"
+	"github.com/jobullo/go-api-example/database"
+	"github.com/jobullo/go-api-example/validation"
 )
 
-func NewError(error string) gin.H {
-	return gin.H{
-		"error": error,
+// ProblemContentType is the media type of error responses, see RFC 7807
+const ProblemContentType = "application/problem+json"
+
+// Problem is the body of every error response. Code is stable and meant for programs,
+// Detail is a message for people and may change.
+type Problem struct {
+	Type     string            `json:"type"`
+	Title    string            `json:"title"`
+	Status   int               `json:"status"`
+	Detail   string            `json:"detail,omitempty"`
+	Instance string            `json:"instance,omitempty"`
+	Code     string            `json:"code"`
+	Fields   validation.Errors `json:"fields,omitempty"` //what is wrong with each field of an invalid request
+}
+
+// errors raised by the api itself rather than by the services
+var (
+	ErrBadRequest           = errors.New("bad request")
+	ErrUnauthorized         = errors.New("a valid bearer token is required")
+	ErrForbidden            = errors.New("your role is not allowed to perform this operation")
+	ErrPreconditionFailed   = errors.New("the record has changed since the version in If-Match")
+	ErrIdempotencyKeyReused = errors.New("Idempotency-Key has already been used with a different request body")
+	ErrIdempotencyKeyInUse  = errors.New("a request with this Idempotency-Key is still being processed")
+	ErrRequestTooLarge      = errors.New("the request body is too large")
+)
+
+// problemType is the status and code an error is reported with
+type problemType struct {
+	err    error
+	status int
+	code   string
+}
+
+// problemTypes are tried in order with errors.Is. Anything else is a 500 whose message isn't shown,
+// it may be a database error with SQL in it.
+var problemTypes = []problemType{
+	{ErrBadRequest, http.StatusBadRequest, "bad_request"},
+	{ErrUnauthorized, http.StatusUnauthorized, "unauthorized"},
+	{ErrForbidden, http.StatusForbidden, "forbidden"},
+	{ErrPreconditionFailed, http.StatusPreconditionFailed, "precondition_failed"},
+	{ErrIdempotencyKeyReused, http.StatusUnprocessableEntity, "idempotency_key_reused"},
+	{ErrIdempotencyKeyInUse, http.StatusConflict, "idempotency_key_in_use"},
+	{ErrRequestTooLarge, http.StatusRequestEntityTooLarge, "request_too_large"},
+	{database.ErrNotFound, http.StatusNotFound, "not_found"},
+	{database.ErrParentNotFound, http.StatusConflict, "parent_not_found"},
+	{database.ErrInvalidType, http.StatusBadRequest, "invalid_transaction_type"},
+	{database.ErrInvalidAccountType, http.StatusBadRequest, "invalid_account_type"},
+	{database.ErrInvalidHolder, http.StatusBadRequest, "invalid_account_holder"},
+	{database.ErrInvalidAmount, http.StatusBadRequest, "invalid_amount"},
+	{database.ErrCurrencyMismatch, http.StatusBadRequest, "currency_mismatch"},
+	{database.ErrInvalidCurrency, http.StatusBadRequest, "invalid_currency"},
+	{database.ErrSameAccount, http.StatusBadRequest, "same_account"},
+	{database.ErrInsufficientFunds, http.StatusUnprocessableEntity, "insufficient_funds"},
+	{database.ErrAccountNotOpen, http.StatusUnprocessableEntity, "account_not_open"},
+	{database.ErrInvalidTransition, http.StatusConflict, "invalid_status_transition"},
+	{database.ErrBalanceNotZero, http.StatusConflict, "balance_not_zero"},
+	{database.ErrImmutable, http.StatusConflict, "immutable_transaction"},
+	{database.ErrAlreadyReversed, http.StatusConflict, "already_reversed"},
+	{database.ErrReversal, http.StatusConflict, "reversal_not_reversible"},
+	{database.ErrReversalFinal, http.StatusConflict, "reversal_final"},
+	{database.ErrTransferLeg, http.StatusConflict, "transfer_leg"},
+	{database.ErrConflict, http.StatusConflict, "version_conflict"},
+	{database.ErrInvalidUsername, http.StatusBadRequest, "invalid_username"},
+	{database.ErrInvalidRole, http.StatusBadRequest, "invalid_role"},
+	{database.ErrWeakPassword, http.StatusBadRequest, "weak_password"},
+	{database.ErrUsernameTaken, http.StatusConflict, "username_taken"},
+	{database.ErrInvalidCredentials, http.StatusUnauthorized, "invalid_credentials"},
+	{database.ErrInvalidToken, http.StatusUnauthorized, "invalid_token"},
+	{database.ErrTokenReused, http.StatusUnauthorized, "refresh_token_reused"},
+	{database.ErrInvalidCursor, http.StatusBadRequest, "invalid_cursor"},
+	{database.ErrInvalidSort, http.StatusBadRequest, "invalid_sort"},
+	{database.ErrInvalidPeriod, http.StatusBadRequest, "invalid_period"},
+}
+
+// NewProblem describes an error for the client
+func NewProblem(err error) Problem {
+	var fields validation.Errors
+	if errors.As(err, &fields) {
+		problem := newProblem(http.StatusBadRequest, "invalid_request", "the request has invalid fields")
+		problem.Fields = fields
+		return problem
+	}
+
+	for _, pt := range problemTypes {
+		if errors.Is(err, pt.err) {
+			return newProblem(pt.status, pt.code, err.Error())
+		}
 	}
+
+	return newProblem(http.StatusInternalServerError, "internal_error", "an unexpected error occurred")
+}
+
+func newProblem(status int, code string, detail string) Problem {
+	return Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail, Code: code}
+}
+
+// detailedError gives one of the mapped errors a message of its own, errors.Is still finds the mapped error
+type detailedError struct {
+	err     error
+	message string
+}
+
+func (e *detailedError) Error() string { return e.message }
+func (e *detailedError) Unwrap() error { return e.err }
+
+// withDetail returns err with the formatted message, e.g. which record wasn't found
+func withDetail(err error, format string, args ...interface{}) error {
+	return &detailedError{err: err, message: fmt.Sprintf(format, args...)}
+}
+
+// notFound names the missing record in the detail of a not found error, other errors are returned as they are
+func notFound(err error, record string, id uint) error {
+	if errors.Is(err, database.ErrNotFound) {
+		return withDetail(err, "%s with ID %d not found", record, id)
+	}
+	return err
+}
+
+// abortWithError stops the request, Problems writes the response
+func abortWithError(ctx *gin.Context, err error) {
+	ctx.Error(err)
+	ctx.Abort()
+}
+
+// Problems writes the last error a handler passed to abortWithError as an application/problem+json response
+func Problems() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Next()
+		writeProblem(ctx)
+	}
+}
+
+// writeProblem responds with the last error of the request, unless a response has been written already
+func writeProblem(ctx *gin.Context) {
+	if len(ctx.Errors) == 0 || ctx.Writer.Written() {
+		return
+	}
+
+	//the error itself is in the request log written by gin.Logger
+	problem := NewProblem(ctx.Errors.Last().Err)
+	problem.Instance = ctx.Request.URL.Path
+
+	ctx.Header("Content-Type", ProblemContentType)
+	ctx.JSON(problem.Status, problem)
 }
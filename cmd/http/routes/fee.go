@@ -0,0 +1,46 @@
+package routes
+
+import (
+	http "net/http"
+
+	service "github.com/jobullo/go-api-example/service"
+
+	gin "github.com/gin-gonic/gin"
+)
+
+type FeeController struct {
+	service *service.FeeService
+}
+
+func NewFeeController(service *service.FeeService) *FeeController {
+	return &FeeController{service: service}
+}
+
+// @Summary charge the maintenance fees of a month
+// @Description charges every open account the maintenance fee of its account type for a month that is over,
+// @Description unless its end-of-day balance never fell below the waiver balance. Accounts that were already
+// @Description charged for the month are skipped, so the request can safely be repeated. Only admins can charge fees.
+// @Tags Fees
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param run body PeriodRequest true "month to charge"
+// @Success 200 {object} FeeRunResponse
+// @Failure 400 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 500 {object} Problem
+// @Router /fees/maintenance [post]
+func (fc *FeeController) Maintenance(ctx *gin.Context) {
+	var request PeriodRequest
+	if !bindRequest(ctx, &request) {
+		return
+	}
+
+	run, err := fc.service.ChargeMaintenance(request.Period)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, newFeeRunResponse(run))
+}
@@ -0,0 +1,117 @@
+package routes
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jobullo/go-api-example/database"
+	"github.com/jobullo/go-api-example/service"
+)
+
+// IdempotencyKeyHeader is the request header clients set to make a create request safe to retry
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// MaxIdempotentBodySize is the largest body in bytes a request with an Idempotency-Key may have, the whole
+// body is read to hash it
+const MaxIdempotentBodySize = 1 << 20
+
+// Idempotency stores the response to requests made with an Idempotency-Key header and replays it when the
+// request is retried with the same key. Reusing a key with a different body is rejected with a 422 and a
+// retry that arrives while the first request is still running gets a 409.
+func Idempotency(idempotencyService *service.IdempotencyService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := ctx.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			ctx.Next()
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, MaxIdempotentBodySize))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				abortWithError(ctx, ErrRequestTooLarge)
+				return
+			}
+			abortWithError(ctx, withDetail(ErrBadRequest, "the request body could not be read"))
+			return
+		}
+		//put the body back for the handler
+		ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.Sum256(body)
+		record := &database.IdempotencyKey{
+			Key:         key,
+			Method:      ctx.Request.Method,
+			Path:        ctx.Request.URL.Path,
+			UserID:      CurrentPrincipal(ctx).UserID,
+			RequestHash: hex.EncodeToString(hash[:]),
+		}
+
+		existing, err := idempotencyService.Reserve(record)
+		if err != nil {
+			abortWithError(ctx, err)
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case existing.RequestHash != record.RequestHash:
+				abortWithError(ctx, ErrIdempotencyKeyReused)
+			case existing.StatusCode == 0:
+				abortWithError(ctx, ErrIdempotencyKeyInUse)
+			default:
+				ctx.Header("Idempotent-Replayed", "true")
+				ctx.Data(existing.StatusCode, existing.ContentType, existing.ResponseBody)
+				ctx.Abort()
+			}
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: ctx.Writer}
+		ctx.Writer = recorder
+
+		ctx.Next()
+
+		//write the error of a failed request now so the problem is recorded like any other response
+		writeProblem(ctx)
+
+		//server errors and conflicts aren't stored so the client can retry them with the same key
+		//the response has been sent by now, a failure to store it only leaves the key reserved until it expires
+		if recorder.Status() >= http.StatusInternalServerError || recorder.Status() == http.StatusConflict {
+			if err := idempotencyService.Release(record); err != nil {
+				log.Printf("Failed to release Idempotency-Key %q: %v", record.Key, err)
+			}
+			return
+		}
+
+		record.StatusCode = recorder.Status()
+		record.ContentType = recorder.Header().Get("Content-Type")
+		record.ResponseBody = recorder.body.Bytes()
+		if err := idempotencyService.Complete(record); err != nil {
+			log.Printf("Failed to store the response for Idempotency-Key %q: %v", record.Key, err)
+		}
+	}
+}
+
+// responseRecorder keeps a copy of the response body as it is written
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(data string) (int, error) {
+	r.body.WriteString(data)
+	return r.ResponseWriter.WriteString(data)
+}
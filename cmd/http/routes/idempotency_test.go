@@ -0,0 +1,237 @@
+package routes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/jobullo/go-api-example/database"
+	"github.com/jobullo/go-api-example/mock"
+	"github.com/jobullo/go-api-example/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type IdempotencySuite struct {
+	suite.Suite
+	assert  *assert.Assertions
+	sqlmock sqlmock.Sqlmock
+	router  *gin.Engine
+	calls   int
+	status  int
+	err     error //aborts the request instead of responding with status
+	userID  string
+}
+
+func TestIdempotencySuite(t *testing.T) {
+	suite.Run(t, new(IdempotencySuite))
+}
+
+func (is *IdempotencySuite) SetupTest() {
+	t := is.T()
+
+	db, sql, err := mock.DB()
+	require.NoError(t, err)
+
+	is.assert = assert.New(t)
+	is.sqlmock = sql
+	is.calls = 0
+	is.status = http.StatusOK
+	is.err = nil
+	is.userID = "7"
+
+	gin.SetMode(gin.TestMode)
+	is.router = gin.New()
+	is.router.Use(Problems())
+	// stands in for AuthMiddleware
+	is.router.Use(func(ctx *gin.Context) {
+		ctx.Set(ClaimsKey, jwt.MapClaims{"sub": is.userID, "role": database.RoleCustomer})
+	})
+	is.router.POST("/accounts/", Idempotency(service.NewIdempotencyService(db)), func(ctx *gin.Context) {
+		is.calls++
+		if is.err != nil {
+			abortWithError(ctx, is.err)
+			return
+		}
+		ctx.JSON(is.status, gin.H{"id": is.calls})
+	})
+}
+
+func (is *IdempotencySuite) TestWithoutKey() {
+	response := is.post("", `{"accountHolder":"Foo Bar"}`)
+
+	is.assert.Equal(http.StatusOK, response.Code)
+	is.assert.Equal(1, is.calls)
+	is.assert.NoError(is.sqlmock.ExpectationsWereMet())
+}
+
+func (is *IdempotencySuite) TestFirstRequestIsStored() {
+	is.expectLookup()
+	is.sqlmock.ExpectBegin()
+	is.sqlmock.ExpectExec(`^DELETE FROM "idempotency_keys"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	is.sqlmock.ExpectCommit()
+	is.sqlmock.ExpectBegin()
+	is.sqlmock.ExpectQuery(`^INSERT INTO "idempotency_keys"`).
+		WithArgs(mock.Any{}, mock.Any{}, "abc", "POST", "/accounts/", 7, hash(`{"accountHolder":"Foo Bar"}`), 0, "", mock.Any{}).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	is.sqlmock.ExpectCommit()
+	is.sqlmock.ExpectBegin()
+	is.sqlmock.ExpectExec(`^UPDATE "idempotency_keys"`).
+		WithArgs(mock.Any{}, mock.Any{}, "abc", "POST", "/accounts/", 7, mock.Any{}, http.StatusOK, "application/json; charset=utf-8", []byte(`{"id":1}`), 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	is.sqlmock.ExpectCommit()
+
+	response := is.post("abc", `{"accountHolder":"Foo Bar"}`)
+
+	is.assert.Equal(http.StatusOK, response.Code)
+	is.assert.Equal(1, is.calls)
+	is.assert.NoError(is.sqlmock.ExpectationsWereMet())
+}
+
+func (is *IdempotencySuite) TestProblemIsStored() {
+	is.err = database.ErrInsufficientFunds
+	is.expectLookup()
+	is.sqlmock.ExpectBegin()
+	is.sqlmock.ExpectExec(`^DELETE FROM "idempotency_keys"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	is.sqlmock.ExpectCommit()
+	is.sqlmock.ExpectBegin()
+	is.sqlmock.ExpectQuery(`^INSERT INTO "idempotency_keys"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	is.sqlmock.ExpectCommit()
+	// the problem is written before the response is recorded, so a retry gets the same problem
+	is.sqlmock.ExpectBegin()
+	is.sqlmock.ExpectExec(`^UPDATE "idempotency_keys"`).
+		WithArgs(mock.Any{}, mock.Any{}, "abc", "POST", "/accounts/", 7, mock.Any{}, http.StatusUnprocessableEntity, ProblemContentType, mock.Any{}, 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	is.sqlmock.ExpectCommit()
+
+	response := is.post("abc", `{"accountHolder":"Foo Bar"}`)
+
+	is.assert.Equal(http.StatusUnprocessableEntity, response.Code)
+	is.assert.Contains(response.Body.String(), `"code":"insufficient_funds"`)
+	is.assert.NoError(is.sqlmock.ExpectationsWereMet())
+}
+
+func (is *IdempotencySuite) TestServerErrorReleasesKey() {
+	is.status = http.StatusInternalServerError
+	is.expectLookup()
+	is.sqlmock.ExpectBegin()
+	is.sqlmock.ExpectExec(`^DELETE FROM "idempotency_keys"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	is.sqlmock.ExpectCommit()
+	is.sqlmock.ExpectBegin()
+	is.sqlmock.ExpectQuery(`^INSERT INTO "idempotency_keys"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	is.sqlmock.ExpectCommit()
+	is.sqlmock.ExpectBegin()
+	is.sqlmock.ExpectExec(`^DELETE FROM "idempotency_keys" WHERE "idempotency_keys"."id" = \$1`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	is.sqlmock.ExpectCommit()
+
+	response := is.post("abc", `{"accountHolder":"Foo Bar"}`)
+
+	is.assert.Equal(http.StatusInternalServerError, response.Code)
+	is.assert.NoError(is.sqlmock.ExpectationsWereMet())
+}
+
+func (is *IdempotencySuite) TestRetryIsReplayed() {
+	is.expectLookup().AddRow(1, time.Now(), time.Now(), "abc", "POST", "/accounts/", 7, hash(`{"accountHolder":"Foo Bar"}`), http.StatusOK, "application/json", []byte(`{"id":41}`))
+
+	response := is.post("abc", `{"accountHolder":"Foo Bar"}`)
+
+	is.assert.Equal(http.StatusOK, response.Code)
+	is.assert.Equal(`{"id":41}`, response.Body.String())
+	is.assert.Equal("true", response.Header().Get("Idempotent-Replayed"))
+	is.assert.Equal(0, is.calls)
+	is.assert.NoError(is.sqlmock.ExpectationsWereMet())
+}
+
+func (is *IdempotencySuite) TestReuseWithDifferentBody() {
+	is.expectLookup().AddRow(1, time.Now(), time.Now(), "abc", "POST", "/accounts/", 7, hash(`{"accountHolder":"Foo Bar"}`), http.StatusOK, "application/json", []byte(`{"id":41}`))
+
+	response := is.post("abc", `{"accountHolder":"Someone Else"}`)
+
+	is.assert.Equal(http.StatusUnprocessableEntity, response.Code)
+	is.assert.Equal(0, is.calls)
+	is.assert.NoError(is.sqlmock.ExpectationsWereMet())
+}
+
+func (is *IdempotencySuite) TestRetryWhileInFlight() {
+	is.expectLookup().AddRow(1, time.Now(), time.Now(), "abc", "POST", "/accounts/", 7, hash(`{"accountHolder":"Foo Bar"}`), 0, "", nil)
+
+	response := is.post("abc", `{"accountHolder":"Foo Bar"}`)
+
+	is.assert.Equal(http.StatusConflict, response.Code)
+	is.assert.Equal(0, is.calls)
+	is.assert.NoError(is.sqlmock.ExpectationsWereMet())
+}
+
+func (is *IdempotencySuite) TestKeyIsScopedToTheUser() {
+	// another user's response under the same key isn't found, so the request runs
+	is.userID = "8"
+	is.sqlmock.ExpectQuery(`^SELECT \* FROM "idempotency_keys"`).
+		WithArgs("abc", "POST", "/accounts/", 8, mock.AnyTime{}).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	is.sqlmock.ExpectBegin()
+	is.sqlmock.ExpectExec(`^DELETE FROM "idempotency_keys"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	is.sqlmock.ExpectCommit()
+	is.sqlmock.ExpectBegin()
+	is.sqlmock.ExpectQuery(`^INSERT INTO "idempotency_keys"`).
+		WithArgs(mock.Any{}, mock.Any{}, "abc", "POST", "/accounts/", 8, mock.Any{}, 0, "", mock.Any{}).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	is.sqlmock.ExpectCommit()
+	is.sqlmock.ExpectBegin()
+	is.sqlmock.ExpectExec(`^UPDATE "idempotency_keys"`).WillReturnResult(sqlmock.NewResult(1, 1))
+	is.sqlmock.ExpectCommit()
+
+	response := is.post("abc", `{"accountHolder":"Foo Bar"}`)
+
+	is.assert.Equal(http.StatusOK, response.Code)
+	is.assert.Empty(response.Header().Get("Idempotent-Replayed"))
+	is.assert.Equal(1, is.calls)
+	is.assert.NoError(is.sqlmock.ExpectationsWereMet())
+}
+
+func (is *IdempotencySuite) TestBodyTooLarge() {
+	// nothing is reserved for a body that is too large to hash
+	response := is.post("abc", `{"accountHolder":"`+strings.Repeat("a", MaxIdempotentBodySize)+`"}`)
+
+	is.assert.Equal(http.StatusRequestEntityTooLarge, response.Code)
+	is.assert.Contains(response.Body.String(), `"code":"request_too_large"`)
+	is.assert.Equal(0, is.calls)
+	is.assert.NoError(is.sqlmock.ExpectationsWereMet())
+}
+
+// sets the expectation that the key is looked up and returns the rows so a stored record can be added
+func (is *IdempotencySuite) expectLookup() *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "created_at", "updated_at", "key", "method", "path", "user_id", "request_hash", "status_code", "content_type", "response_body"})
+	is.sqlmock.ExpectQuery(`^SELECT \* FROM "idempotency_keys"`).
+		WithArgs("abc", "POST", "/accounts/", 7, mock.AnyTime{}).
+		WillReturnRows(rows)
+	return rows
+}
+
+// sends a create request with an optional idempotency key
+func (is *IdempotencySuite) post(key string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/accounts/", strings.NewReader(body))
+	if key != "" {
+		request.Header.Set(IdempotencyKeyHeader, key)
+	}
+
+	response := httptest.NewRecorder()
+	is.router.ServeHTTP(response, request)
+	return response
+}
+
+func hash(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
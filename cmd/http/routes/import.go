@@ -0,0 +1,83 @@
+package routes
+
+import (
+	"io"
+	http "net/http"
+	strconv "strconv"
+
+	service "github.com/jobullo/go-api-example/service"
+
+	gin "github.com/gin-gonic/gin"
+	database "github.com/jobullo/go-api-example/database"
+)
+
+type ImportController struct {
+	service *service.ImportService
+}
+
+func NewImportController(service *service.ImportService) *ImportController {
+	return &ImportController{service: service}
+}
+
+// @Summary import accounts from a CSV file
+// @Description creates an account for every row of a CSV file with the columns account_holder, account_type
+// @Description and optionally balance, currency and owner_id. Nothing is saved unless every row is valid.
+// @Tags Import
+// @Security ApiKeyAuth
+// @Accept  text/csv
+// @Produce json
+// @Param file body string true "CSV file"
+// @Param dry_run query bool false "check every row without saving anything"
+// @Success 200 {object} database.ImportResult
+// @Failure 400 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 422 {object} database.ImportResult
+// @Failure 500 {object} Problem
+// @Router /import/accounts [post]
+func (ic *ImportController) Accounts(ctx *gin.Context) {
+	ic.run(ctx, ic.service.ImportAccounts)
+}
+
+// @Summary import transactions from a CSV file
+// @Description posts a transaction for every row of a CSV file with the columns account_id, type, amount
+// @Description and optionally currency and created_at. Rows are posted in order, no fees are charged and nothing is saved unless every row is valid.
+// @Tags Import
+// @Security ApiKeyAuth
+// @Accept  text/csv
+// @Produce json
+// @Param file body string true "CSV file"
+// @Param dry_run query bool false "check every row without saving anything"
+// @Success 200 {object} database.ImportResult
+// @Failure 400 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 422 {object} database.ImportResult
+// @Failure 500 {object} Problem
+// @Router /import/transactions [post]
+func (ic *ImportController) Transactions(ctx *gin.Context) {
+	ic.run(ctx, ic.service.ImportTransactions)
+}
+
+// run imports the request body, invalid rows are reported with a 422
+func (ic *ImportController) run(ctx *gin.Context, importFile func(r io.Reader, dryRun bool) (*database.ImportResult, error)) {
+	dryRun := false
+	if value := ctx.Query("dry_run"); value != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			abortWithError(ctx, withDetail(ErrBadRequest, "dry_run must be true or false"))
+			return
+		}
+	}
+
+	result, err := importFile(ctx.Request.Body, dryRun)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	if len(result.Errors) > 0 {
+		ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, result)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, result)
+}
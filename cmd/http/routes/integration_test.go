@@ -0,0 +1,263 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jobullo/go-api-example/config"
+	"github.com/jobullo/go-api-example/database"
+	"github.com/jobullo/go-api-example/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// IntegrationSuite runs requests through the whole service against a memory database, an in-memory
+// SQLite database, so the queries are really executed rather than matched against expected SQL
+type IntegrationSuite struct {
+	suite.Suite
+	assert *assert.Assertions
+	db     *database.DB
+	router *gin.Engine
+}
+
+func TestIntegrationSuite(t *testing.T) {
+	suite.Run(t, new(IntegrationSuite))
+}
+
+func (is *IntegrationSuite) SetupTest() {
+	t := is.T()
+
+	db, err := database.New(&config.Database{Driver: database.DriverMemory})
+	require.NoError(t, err)
+	_, err = db.Migrate()
+	require.NoError(t, err)
+
+	is.assert = assert.New(t)
+	is.db = db
+
+	gin.SetMode(gin.TestMode)
+	is.router = NewRouter(config.Configuration{JWT: &config.JWT{Secret: testSecret, Duration: 15}}, db)
+}
+
+func (is *IntegrationSuite) TearDownTest() {
+	is.db.Close()
+}
+
+func (is *IntegrationSuite) TestDepositAndWithdraw() {
+	token := is.login("alice")
+
+	account := is.createAccount(token, "Alice", "savings")
+	is.send("POST", "/transactions/", token, fmt.Sprintf(`{"accountID":%d,"transactionType":"deposit","transactionAmount":"100.00"}`, account), http.StatusOK)
+	is.send("POST", "/transactions/", token, fmt.Sprintf(`{"accountID":%d,"transactionType":"withdrawal","transactionAmount":"30.50"}`, account), http.StatusOK)
+
+	var fetched AccountResponse
+	is.decode(is.send("GET", fmt.Sprintf("/accounts/%d", account), token, "", http.StatusOK), &fetched)
+	is.assert.Equal("69.50", fetched.Balance.Decimal())
+
+	// savings accounts can't be overdrawn, and the failed withdrawal is rolled back
+	is.send("POST", "/transactions/", token, fmt.Sprintf(`{"accountID":%d,"transactionType":"withdrawal","transactionAmount":"70.00"}`, account), http.StatusUnprocessableEntity)
+
+	var page database.Page[TransactionResponse]
+	is.decode(is.send("GET", fmt.Sprintf("/accounts/%d/transactions", account), token, "", http.StatusOK), &page)
+	is.assert.Len(page.Items, 2)
+}
+
+func (is *IntegrationSuite) TestCustomersOnlySeeTheirOwnAccounts() {
+	alice := is.login("alice")
+	bob := is.login("bob")
+
+	account := is.createAccount(alice, "Alice", "savings")
+
+	is.send("GET", fmt.Sprintf("/accounts/%d", account), bob, "", http.StatusNotFound)
+
+	var page database.Page[AccountResponse]
+	is.decode(is.send("GET", "/accounts/", bob, "", http.StatusOK), &page)
+	is.assert.Empty(page.Items)
+}
+
+func (is *IntegrationSuite) TestPagesThroughAccounts() {
+	token := is.login("alice")
+	for _, holder := range []string{"Carol", "Alice", "Bob"} {
+		is.createAccount(token, holder, "checking")
+	}
+
+	// the cursor of a time sort key is compared as a time, not as text
+	holders := []string{}
+	path := "/accounts/?limit=2&sort=created_at"
+	for path != "" {
+		var page database.Page[AccountResponse]
+		is.decode(is.send("GET", path, token, "", http.StatusOK), &page)
+		for _, account := range page.Items {
+			holders = append(holders, account.AccountHolder)
+		}
+
+		path = ""
+		if page.NextCursor != "" {
+			path = "/accounts/?limit=2&sort=created_at&cursor=" + page.NextCursor
+		}
+	}
+
+	is.assert.Equal([]string{"Carol", "Alice", "Bob"}, holders)
+
+	var matches database.Page[AccountResponse]
+	is.decode(is.send("GET", "/accounts/?holder=LI", token, "", http.StatusOK), &matches)
+	if is.assert.Len(matches.Items, 1) {
+		is.assert.Equal("Alice", matches.Items[0].AccountHolder)
+	}
+}
+
+func (is *IntegrationSuite) TestUpdateOnlyChangesTheHolder() {
+	token := is.login("alice")
+	account := is.createAccount(token, "Alice", "savings")
+	is.send("POST", "/transactions/", token, fmt.Sprintf(`{"accountID":%d,"transactionType":"deposit","transactionAmount":"100.00"}`, account), http.StatusOK)
+
+	// fields that can't be updated are ignored rather than written
+	var updated AccountResponse
+	body := `{"accountHolder":"Alice Smith","accountType":"checking","balance":"1000000.00","id":99}`
+	is.decode(is.send("PUT", fmt.Sprintf("/accounts/%d", account), token, body, http.StatusOK), &updated)
+
+	is.assert.Equal(account, updated.ID)
+	is.assert.Equal("Alice Smith", updated.AccountHolder)
+	is.assert.Equal("savings", updated.AccountType)
+	is.assert.Equal("100.00", updated.Balance.Decimal())
+	is.assert.Equal(uint(3), updated.Version)
+
+	// a version that a deposit has moved past is rejected, the deposit isn't undone
+	is.send("POST", "/transactions/", token, fmt.Sprintf(`{"accountID":%d,"transactionType":"deposit","transactionAmount":"50.00"}`, account), http.StatusOK)
+	is.send("PUT", fmt.Sprintf("/accounts/%d", account), token, `{"accountHolder":"Alice Jones","version":3}`, http.StatusConflict)
+
+	is.decode(is.send("GET", fmt.Sprintf("/accounts/%d", account), token, "", http.StatusOK), &updated)
+	is.assert.Equal("Alice Smith", updated.AccountHolder)
+	is.assert.Equal("150.00", updated.Balance.Decimal())
+}
+
+func (is *IntegrationSuite) TestAccountLifecycle() {
+	alice := is.login("alice")
+	teller := is.loginAs("terry", database.RoleTeller)
+
+	account := is.createAccount(alice, "Alice", "savings")
+	deposit := fmt.Sprintf(`{"accountID":%d,"transactionType":"deposit","transactionAmount":"100.00"}`, account)
+	is.send("POST", "/transactions/", alice, deposit, http.StatusOK)
+
+	// only staff freeze accounts, and nothing posts to a frozen account
+	is.send("POST", fmt.Sprintf("/accounts/%d/freeze", account), alice, "", http.StatusForbidden)
+	var frozen AccountResponse
+	is.decode(is.send("POST", fmt.Sprintf("/accounts/%d/freeze", account), teller, "", http.StatusOK), &frozen)
+	is.assert.Equal(database.AccountFrozen, frozen.Status)
+	is.assert.Equal("account_not_open", is.problem(is.send("POST", "/transactions/", alice, deposit, http.StatusUnprocessableEntity)).Code)
+
+	// an account with money in it can't be closed
+	is.send("POST", fmt.Sprintf("/accounts/%d/unfreeze", account), teller, "", http.StatusOK)
+	is.assert.Equal("balance_not_zero", is.problem(is.send("POST", fmt.Sprintf("/accounts/%d/close", account), alice, "", http.StatusConflict)).Code)
+
+	withdrawal := fmt.Sprintf(`{"accountID":%d,"transactionType":"withdrawal","transactionAmount":"100.00"}`, account)
+	is.send("POST", "/transactions/", alice, withdrawal, http.StatusOK)
+	is.send("POST", fmt.Sprintf("/accounts/%d/close", account), alice, "", http.StatusOK)
+
+	// closing is final, but the account and its history can still be read
+	is.assert.Equal("invalid_status_transition", is.problem(is.send("POST", fmt.Sprintf("/accounts/%d/unfreeze", account), teller, "", http.StatusConflict)).Code)
+	is.send("POST", "/transactions/", alice, deposit, http.StatusUnprocessableEntity)
+
+	var closed database.Page[AccountResponse]
+	is.decode(is.send("GET", "/accounts/?status=closed", alice, "", http.StatusOK), &closed)
+	if is.assert.Len(closed.Items, 1) {
+		is.assert.Equal(account, closed.Items[0].ID)
+	}
+
+	var transactions database.Page[TransactionResponse]
+	is.decode(is.send("GET", fmt.Sprintf("/accounts/%d/transactions", account), alice, "", http.StatusOK), &transactions)
+	is.assert.Len(transactions.Items, 2)
+}
+
+func (is *IntegrationSuite) TestDeleteClosesTheAccount() {
+	alice := is.login("alice")
+	admin := is.loginAs("ada", database.RoleAdmin)
+
+	account := is.createAccount(alice, "Alice", "checking")
+	is.send("DELETE", fmt.Sprintf("/accounts/%d", account), admin, "", http.StatusNoContent)
+
+	var fetched AccountResponse
+	is.decode(is.send("GET", fmt.Sprintf("/accounts/%d", account), alice, "", http.StatusOK), &fetched)
+	is.assert.Equal(database.AccountClosed, fetched.Status)
+}
+
+func (is *IntegrationSuite) TestPostInterest() {
+	alice := is.login("alice")
+	admin := is.loginAs("ada", database.RoleAdmin)
+	now := time.Now().UTC()
+	lastMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+	body := fmt.Sprintf(`{"period":%q}`, lastMonth.Format("2006-01"))
+
+	is.send("POST", "/interest/postings", alice, body, http.StatusForbidden)
+	is.assert.Equal("invalid_request", is.problem(is.send("POST", "/interest/postings", admin, `{"period":"January"}`, http.StatusBadRequest)).Code)
+
+	// accounts opened this month haven't earned anything last month
+	is.createAccount(alice, "Alice", "savings")
+	var run InterestRunResponse
+	is.decode(is.send("POST", "/interest/postings", admin, body, http.StatusOK), &run)
+	is.assert.Empty(run.Posted)
+}
+
+// login registers a customer and returns the authorization header for them
+func (is *IntegrationSuite) login(username string) string {
+	credentials := fmt.Sprintf(`{"username":%q,"password":"Correct-Horse-Battery-9"}`, username)
+	is.send("POST", "/auth/register", "", credentials, http.StatusOK)
+	return is.authorize(credentials)
+}
+
+// loginAs creates a user with the given role, which can't be done through the api, and logs them in
+func (is *IntegrationSuite) loginAs(username string, role string) string {
+	_, err := service.NewUserService(is.db.DB, 0).Register(username, "Correct-Horse-Battery-9", role)
+	require.NoError(is.T(), err)
+	return is.authorize(fmt.Sprintf(`{"username":%q,"password":"Correct-Horse-Battery-9"}`, username))
+}
+
+// authorize logs in and returns the authorization header
+func (is *IntegrationSuite) authorize(credentials string) string {
+	var token AuthToken
+	is.decode(is.send("POST", "/auth/login", "", credentials, http.StatusOK), &token)
+	return "Bearer " + token.Token
+}
+
+func (is *IntegrationSuite) createAccount(authorization string, holder string, accountType string) uint {
+	var account AccountResponse
+	body := fmt.Sprintf(`{"accountHolder":%q,"accountType":%q}`, holder, accountType)
+	is.decode(is.send("POST", "/accounts/", authorization, body, http.StatusOK), &account)
+	return account.ID
+}
+
+// send makes a request that must succeed with the given status
+func (is *IntegrationSuite) send(method string, path string, authorization string, body string, status int) *httptest.ResponseRecorder {
+	response := is.request(method, path, authorization, body)
+	require.Equal(is.T(), status, response.Code, "%s %s: %s", method, path, response.Body.String())
+	return response
+}
+
+func (is *IntegrationSuite) request(method string, path string, authorization string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+
+	response := httptest.NewRecorder()
+	is.router.ServeHTTP(response, request)
+	return response
+}
+
+func (is *IntegrationSuite) problem(response *httptest.ResponseRecorder) Problem {
+	var problem Problem
+	is.decode(response, &problem)
+	return problem
+}
+
+func (is *IntegrationSuite) decode(response *httptest.ResponseRecorder, v interface{}) {
+	require.NoError(is.T(), json.Unmarshal(response.Body.Bytes(), v), response.Body.String())
+}
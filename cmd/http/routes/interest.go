@@ -0,0 +1,46 @@
+package routes
+
+import (
+	http "net/http"
+
+	service "github.com/jobullo/go-api-example/service"
+
+	gin "github.com/gin-gonic/gin"
+)
+
+type InterestController struct {
+	service *service.InterestService
+}
+
+func NewInterestController(service *service.InterestService) *InterestController {
+	return &InterestController{service: service}
+}
+
+// @Summary post the interest of a month
+// @Description pays every open account the interest it earned in a month that is over, at the yearly rate of
+// @Description its account type on its end-of-day balances. Accounts that were already paid for the month
+// @Description are skipped, so the request can safely be repeated. Only admins can post interest.
+// @Tags Interest
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param run body PeriodRequest true "month to post"
+// @Success 200 {object} InterestRunResponse
+// @Failure 400 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 500 {object} Problem
+// @Router /interest/postings [post]
+func (ic *InterestController) Post(ctx *gin.Context) {
+	var request PeriodRequest
+	if !bindRequest(ctx, &request) {
+		return
+	}
+
+	run, err := ic.service.Post(request.Period)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, newInterestRunResponse(run))
+}
@@ -0,0 +1,60 @@
+package routes
+
+import (
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/jobullo/go-api-example/auth"
+	"github.com/jobullo/go-api-example/service"
+)
+
+// ClaimsKey is the gin context key AuthMiddleware stores the claims of the caller's token under
+const ClaimsKey = "claims"
+
+// AuthMiddleware validates that you have a valid JWT token that hasn't been revoked.
+// It has to be added to a group before the group's routes are registered, gin copies a group's
+// handlers into each route when the route is added.
+func AuthMiddleware(keyring *auth.Keyring, tokenService *service.TokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		authHeader := c.GetHeader("Authorization")
+
+		// Ensure we have a bearer token in the header
+		if !strings.HasPrefix(authHeader, "Bearer ") {
+			unauthorized(c)
+			return
+		}
+
+		// Parse and validate the token
+		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+		token, err := keyring.Parse(tokenString)
+		if err != nil {
+			unauthorized(c)
+			return
+		}
+
+		// Check the revocation list, tokens issued before tokens had ids can't be revoked
+		claims := token.Claims.(jwt.MapClaims)
+		if jti, ok := claims["jti"].(string); ok {
+			revoked, err := tokenService.IsRevoked(jti)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+			if revoked {
+				unauthorized(c)
+				return
+			}
+		}
+
+		c.Set(ClaimsKey, claims)
+		c.Next()
+	}
+}
+
+// unauthorized rejects a request without a valid token, the header tells the client which scheme to use
+func unauthorized(c *gin.Context) {
+	c.Header("WWW-Authenticate", "Bearer")
+	abortWithError(c, ErrUnauthorized)
+}
@@ -0,0 +1,99 @@
+package routes
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jobullo/go-api-example/database"
+)
+
+// dates in query strings are either a full timestamp or a day
+const queryDateLayout = "2006-01-02"
+
+// parsePageRequest reads the cursor, limit, sort and order query parameters
+func parsePageRequest(ctx *gin.Context) (database.PageRequest, error) {
+	page := database.PageRequest{
+		Cursor: ctx.Query("cursor"),
+		Sort:   ctx.Query("sort"),
+	}
+
+	if limit := ctx.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return page, withDetail(ErrBadRequest, "limit must be a positive number, got %q", limit)
+		}
+		page.Limit = n
+	}
+
+	switch order := ctx.Query("order"); order {
+	case "", "asc":
+	case "desc":
+		page.Desc = true
+	default:
+		return page, withDetail(ErrBadRequest, "order must be asc or desc, got %q", order)
+	}
+
+	return page, nil
+}
+
+// queryTime reads a timestamp or a day from the query string. A day given as the end of a range
+// is moved to the start of the next day, so the whole day is included.
+func queryTime(ctx *gin.Context, name string, end bool) (*time.Time, error) {
+	value := ctx.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.Parse(queryDateLayout, value)
+	if err != nil {
+		return nil, withDetail(ErrBadRequest, "%s must be a date such as 2024-01-31 or an RFC 3339 timestamp, got %q", name, value)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+// queryMinor reads an amount such as 12.50 from the query string in minor units
+func queryMinor(ctx *gin.Context, name string) (*int64, error) {
+	value := ctx.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	amount, err := database.ParseMoney(value, "")
+	if err != nil {
+		return nil, withDetail(ErrBadRequest, "%s must be an amount such as 12.50, got %q", name, value)
+	}
+	return &amount.Minor, nil
+}
+
+// pathID reads the record id from the path of the request
+func pathID(ctx *gin.Context) (uint, error) {
+	value := ctx.Param("id")
+	id, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, withDetail(ErrBadRequest, "id must be a positive number, got %q", value)
+	}
+	return uint(id), nil
+}
+
+// queryID reads a record id from the query string
+func queryID(ctx *gin.Context, name string) (*uint, error) {
+	value := ctx.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	id, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return nil, withDetail(ErrBadRequest, "%s must be an id, got %q", name, value)
+	}
+	result := uint(id)
+	return &result, nil
+}
@@ -0,0 +1,83 @@
+package routes
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/jobullo/go-api-example/database"
+	"github.com/jobullo/go-api-example/service"
+)
+
+// Principal is the authenticated caller, read from the token claims stored by AuthMiddleware
+type Principal struct {
+	UserID uint
+	Role   string
+}
+
+// CurrentPrincipal returns the caller of the request, a token without a role is treated as a customer
+func CurrentPrincipal(ctx *gin.Context) Principal {
+	principal := Principal{Role: database.RoleCustomer}
+
+	claims, ok := ctx.Value(ClaimsKey).(jwt.MapClaims)
+	if !ok {
+		return principal
+	}
+
+	if sub, ok := claims["sub"].(string); ok {
+		if id, err := strconv.ParseUint(sub, 10, 32); err == nil {
+			principal.UserID = uint(id)
+		}
+	}
+	if role, ok := claims["role"].(string); ok && database.ValidRole(role) {
+		principal.Role = role
+	}
+
+	return principal
+}
+
+// IsStaff reports whether the caller is a teller or an admin, staff can see every account
+func (p Principal) IsStaff() bool {
+	return p.Role == database.RoleTeller || p.Role == database.RoleAdmin
+}
+
+// CanAccess reports whether the caller may see and act on the account
+func (p Principal) CanAccess(account *database.Account) bool {
+	return p.IsStaff() || account.OwnerID != nil && *account.OwnerID == p.UserID
+}
+
+// RequireRole only lets callers with one of the given roles through, anyone else gets a 403.
+// It has to run after AuthMiddleware.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		principal := CurrentPrincipal(ctx)
+		for _, role := range roles {
+			if principal.Role == role {
+				ctx.Next()
+				return
+			}
+		}
+
+		abortWithError(ctx, ErrForbidden)
+	}
+}
+
+// canAccessAccount reports whether the caller may act on the account with the given id.
+// An account that doesn't exist can't be accessed, so customers can't tell it apart from someone else's.
+func canAccessAccount(ctx *gin.Context, accountService *service.AccountService, accountID uint) (bool, error) {
+	principal := CurrentPrincipal(ctx)
+	if principal.IsStaff() {
+		return true, nil
+	}
+
+	account, err := accountService.FetchById(accountID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return principal.CanAccess(account), nil
+}
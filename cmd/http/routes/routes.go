@@ -2,8 +2,11 @@ package routes
 
 import (
 	"net/http"
+	"time"
 
+	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/jobullo/go-api-example/auth"
 	"github.com/jobullo/go-api-example/config"
 	"github.com/jobullo/go-api-example/database"
 	_ "github.com/jobullo/go-api-example/docs"
@@ -12,14 +15,22 @@ import (
 	"github.com/swaggo/gin-swagger/swaggerFiles"
 )
 
-// SetupRouter creates a router using middleware and controllers
-func SetupRouter(cfg config.Configuration) *gin.Engine {
+// NewRouter creates a router whose services use the given database, the banking services use its store.
+// Middleware is added before the routes it applies to, gin ignores middleware added to a group afterwards.
+func NewRouter(cfg config.Configuration, db *database.DB) *gin.Engine {
 
 	router := gin.New()
 	router.Use(gin.Logger())
 	router.Use(gin.Recovery())
+	router.Use(cors.Default())
+	router.Use(Problems())                          //errors are written as application/problem+json
 	router.SetTrustedProxies([]string{"127.0.0.1"}) //only trust local proxy
 
+	//unknown paths get a problem like any other error
+	router.NoRoute(func(c *gin.Context) {
+		abortWithError(c, withDetail(database.ErrNotFound, "%s not found", c.Request.URL.Path))
+	})
+
 	//set up swagger
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
@@ -36,42 +47,127 @@ func SetupRouter(cfg config.Configuration) *gin.Engine {
 		healthRoutes.GET("/", health.Status)
 	}
 
-	// Authentication endpoint
-	auth := new(AuthController)
+	//passwords must score at least this on service.PasswordStrength
+	minPasswordStrength := 0
+	if cfg.App != nil {
+		minPasswordStrength = cfg.App.MinPasswordStr
+	}
+
+	//signs and verifies tokens with the keys from the jwt section of the configuration
+	keyring, err := auth.NewKeyring(cfg.JWT)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	//refresh tokens and the revocation list of access tokens
+	tokenService := service.NewTokenService(db.DB, time.Duration(cfg.JWT.RefreshDuration)*time.Minute)
+	authenticated := AuthMiddleware(keyring, tokenService)
+
+	// Authentication endpoints
+	authController := NewAuthController(service.NewUserService(db.DB, minPasswordStrength), tokenService, keyring)
 	authRoutes := router.Group("/auth")
 	{
-		authRoutes.POST("/login", auth.Login)
+		authRoutes.POST("/register", authController.Register)
+		authRoutes.POST("/login", authController.Login)
+		authRoutes.POST("/refresh", authController.Refresh)
+		authRoutes.POST("/logout", authenticated, authController.Logout)
 	}
 
-	//get database instance pointer
-	db := database.GetDatabase()
+	//business rules such as overdraft limits by account type
+	rules, err := service.RulesFromConfig(cfg)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	//stores responses so create requests can be retried with an Idempotency-Key header
+	idempotent := Idempotency(service.NewIdempotencyService(db.DB))
+
+	//everything below requires a valid bearer token
+	protected := router.Group("/")
+	protected.Use(authenticated)
+
+	//deleting records rewrites history, so it is kept to admins
+	adminOnly := RequireRole(database.RoleAdmin)
+	staffOnly := RequireRole(database.RoleTeller, database.RoleAdmin)
 
 	//initialize account service and controller
-	accountService := service.NewAccountService(db)
-	accountController := NewAccountController(accountService)
+	store := db.Store()
+	accountService := service.NewAccountService(store)
+	accountController := NewAccountController(accountService, rules)
 
 	// Account endpoints
-	accountRoutes := router.Group("/accounts")
+	accountRoutes := protected.Group("/accounts")
 	{
 		accountRoutes.GET("/", accountController.List)
 		accountRoutes.GET("/:id", accountController.FetchById)
-		accountRoutes.POST("/", accountController.Create)
+		accountRoutes.POST("/", idempotent, accountController.Create)
 		accountRoutes.PUT("/:id", accountController.Update)
-		accountRoutes.DELETE("/:id", accountController.Delete)
+		accountRoutes.DELETE("/:id", adminOnly, accountController.Delete)
+		accountRoutes.POST("/:id/freeze", staffOnly, accountController.Freeze)
+		accountRoutes.POST("/:id/unfreeze", staffOnly, accountController.Unfreeze)
+		accountRoutes.POST("/:id/close", accountController.Close)
 	}
 
 	//initialize transaction service and controller
-	transactionService := service.NewTransactionService(db, *accountService)
-	transactionController := NewTransactionController(transactionService)
+	transactionService := service.NewTransactionService(store, rules)
+	transactionController := NewTransactionController(transactionService, accountService)
 
 	// Transaction endpoints
-	transactionRoutes := router.Group("/transactions")
+	transactionRoutes := protected.Group("/transactions")
 	{
 		transactionRoutes.GET("/", transactionController.List)
 		transactionRoutes.GET("/:id", transactionController.FetchById)
-		transactionRoutes.POST("/", transactionController.Create)
-		transactionRoutes.PUT("/:id", transactionController.Update)
-		transactionRoutes.DELETE("/:id", transactionController.Delete)
+		transactionRoutes.POST("/", idempotent, transactionController.Create)
+		//corrections rewrite posted history, customers can't undo their own withdrawals or fees
+		transactionRoutes.PUT("/:id", staffOnly, transactionController.Update)
+		transactionRoutes.DELETE("/:id", adminOnly, transactionController.Delete)
+		transactionRoutes.POST("/:id/reverse", staffOnly, transactionController.Reverse)
+	}
+
+	// Account transaction endpoints
+	accountRoutes.GET("/:id/transactions", transactionController.ListByAccount)
+	accountRoutes.GET("/:id/statement", transactionController.Statement)
+
+	//initialize transfer service and controller
+	transferService := service.NewTransferService(store, rules)
+	transferController := NewTransferController(transferService, accountService)
+
+	// Transfer endpoints
+	transferRoutes := protected.Group("/transfers")
+	{
+		transferRoutes.GET("/:id", transferController.FetchById)
+		transferRoutes.POST("/", idempotent, transferController.Create)
+	}
+
+	//initialize import service and controller
+	importController := NewImportController(service.NewImportService(store, rules))
+
+	// Import endpoints, loading data in bulk is kept to admins
+	importRoutes := protected.Group("/import")
+	importRoutes.Use(adminOnly)
+	{
+		importRoutes.POST("/accounts", importController.Accounts)
+		importRoutes.POST("/transactions", importController.Transactions)
+	}
+
+	//initialize interest service and controller
+	interestController := NewInterestController(service.NewInterestService(store, rules))
+
+	// Interest endpoints, paying interest is kept to admins
+	interestRoutes := protected.Group("/interest")
+	interestRoutes.Use(adminOnly)
+	{
+		interestRoutes.POST("/postings", interestController.Post)
+	}
+
+	//initialize fee controller, the fees of transactions are charged by the transaction service
+	feeController := NewFeeController(service.NewFeeService(store, rules))
+
+	// Fee endpoints, charging fees in bulk is kept to admins
+	feeRoutes := protected.Group("/fees")
+	feeRoutes.Use(adminOnly)
+	{
+		feeRoutes.POST("/maintenance", feeController.Maintenance)
 	}
 
 	return router
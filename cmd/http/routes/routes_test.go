@@ -0,0 +1,443 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/jobullo/go-api-example/config"
+	"github.com/jobullo/go-api-example/database"
+	"github.com/jobullo/go-api-example/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// the jwt secret of the router under test
+const testSecret = "A14E45A7-D02B-4ADA-94BC-66DCBFD3181E"
+
+type RouterSuite struct {
+	suite.Suite
+	assert  *assert.Assertions
+	sqlmock sqlmock.Sqlmock
+	router  *gin.Engine
+}
+
+func TestRouterSuite(t *testing.T) {
+	suite.Run(t, new(RouterSuite))
+}
+
+func (rs *RouterSuite) SetupTest() {
+	t := rs.T()
+
+	db, sql, err := mock.DB()
+	require.NoError(t, err)
+
+	rs.assert = assert.New(t)
+	rs.sqlmock = sql
+
+	gin.SetMode(gin.TestMode)
+	rs.router = NewRouter(config.Configuration{JWT: &config.JWT{Secret: testSecret}}, &database.DB{DB: db})
+}
+
+func (rs *RouterSuite) TestPublicRoutes() {
+	rs.assert.Equal(http.StatusOK, rs.request("GET", "/health/", "", "").Code)
+	rs.assert.Equal(http.StatusOK, rs.request("GET", "/swagger/index.html", "", "").Code)
+
+	// the login and refresh handlers are reached and reject the empty body
+	rs.assert.Equal(http.StatusBadRequest, rs.request("POST", "/auth/login", "", "").Code)
+	rs.assert.Equal(http.StatusBadRequest, rs.request("POST", "/auth/refresh", "", "").Code)
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestProtectedRoutes_WithoutToken() {
+	routes := []struct{ method, path string }{
+		{"GET", "/accounts/"},
+		{"GET", "/accounts/1"},
+		{"POST", "/accounts/"},
+		{"PUT", "/accounts/1"},
+		{"DELETE", "/accounts/1"},
+		{"GET", "/transactions/"},
+		{"POST", "/transactions/"},
+		{"POST", "/transactions/1/reverse"},
+		{"GET", "/transfers/1"},
+		{"POST", "/transfers/"},
+		{"POST", "/auth/logout"},
+	}
+
+	// no query is made before the token is checked
+	for _, route := range routes {
+		response := rs.request(route.method, route.path, "", `{}`)
+		rs.assert.Equal(http.StatusUnauthorized, response.Code, "%s %s", route.method, route.path)
+	}
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestProtectedRoutes_InvalidToken() {
+	expired := rs.token(jwt.SigningMethodHS256, testSecret, time.Now().Add(-time.Minute))
+	wrongKey := rs.token(jwt.SigningMethodHS256, "not the secret", time.Now().Add(time.Minute))
+	unsigned := rs.token(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, time.Now().Add(time.Minute))
+
+	for name, header := range map[string]string{
+		"expired":   "Bearer " + expired,
+		"wrong key": "Bearer " + wrongKey,
+		"unsigned":  "Bearer " + unsigned,
+		"no scheme": rs.token(jwt.SigningMethodHS256, testSecret, time.Now().Add(time.Minute)),
+		"garbage":   "Bearer abc.def.ghi",
+	} {
+		response := rs.request("GET", "/accounts/", header, "")
+		rs.assert.Equal(http.StatusUnauthorized, response.Code, name)
+	}
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestProtectedRoutes_ValidToken() {
+	rs.sqlmock.ExpectQuery(`^SELECT (.+) FROM "accounts"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	header := "Bearer " + rs.token(jwt.SigningMethodHS256, testSecret, time.Now().Add(time.Minute))
+	response := rs.request("GET", "/accounts/", header, "")
+
+	rs.assert.Equal(http.StatusOK, response.Code)
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestProtectedRoutes_RevokedToken() {
+	rs.sqlmock.ExpectQuery(`^SELECT count\(\*\) FROM "revoked_tokens"`).
+		WithArgs("abc").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	header := "Bearer " + rs.signedToken(jwt.MapClaims{"jti": "abc", "exp": time.Now().Add(time.Minute).Unix()})
+	response := rs.request("GET", "/accounts/", header, "")
+
+	rs.assert.Equal(http.StatusUnauthorized, response.Code)
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestLogout_RevokesToken() {
+	expiresAt := time.Now().Add(time.Minute).Truncate(time.Second)
+	rs.sqlmock.ExpectQuery(`^SELECT count\(\*\) FROM "revoked_tokens"`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	rs.sqlmock.ExpectBegin()
+	rs.sqlmock.ExpectExec(`^DELETE FROM "revoked_tokens" WHERE \(expires_at <= \$1\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	rs.sqlmock.ExpectCommit()
+	rs.sqlmock.ExpectQuery(`^SELECT (.+) FROM "revoked_tokens"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	rs.sqlmock.ExpectBegin()
+	rs.sqlmock.ExpectQuery(`^INSERT INTO "revoked_tokens"`).
+		WithArgs(mock.Any{}, "abc", expiresAt).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	rs.sqlmock.ExpectCommit()
+
+	header := "Bearer " + rs.signedToken(jwt.MapClaims{"jti": "abc", "exp": expiresAt.Unix()})
+	response := rs.request("POST", "/auth/logout", header, "")
+
+	rs.assert.Equal(http.StatusNoContent, response.Code)
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestAccounts_CustomerListsOwnAccounts() {
+	rs.sqlmock.ExpectQuery(`^SELECT (.+) FROM "accounts" WHERE (.+)owner_id = \$1`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	response := rs.request("GET", "/accounts/", rs.roleToken(7, "customer"), "")
+
+	rs.assert.Equal(http.StatusOK, response.Code)
+	rs.assert.JSONEq(`{"items": []}`, response.Body.String())
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestList_InvalidQuery() {
+	for _, path := range []string{
+		"/accounts/?limit=0",
+		"/accounts/?order=sideways",
+		"/accounts/?sort=password",
+		"/accounts/?cursor=abc",
+		"/accounts/?from=yesterday",
+		"/accounts/?min_balance=lots",
+		"/transactions/?account_id=-1",
+		"/transactions/?max_amount=1.234",
+	} {
+		response := rs.request("GET", path, rs.roleToken(7, "teller"), "")
+		rs.assert.Equal(http.StatusBadRequest, response.Code, path)
+	}
+
+	// nothing is queried for a request that can't be understood
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestAccounts_CustomerCannotSeeOtherAccounts() {
+	rs.expectAccount(1, 8)
+
+	response := rs.request("GET", "/accounts/1", rs.roleToken(7, "customer"), "")
+
+	// the same response as an account that doesn't exist
+	rs.assert.Equal(http.StatusNotFound, response.Code)
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestAccounts_CustomerSeesOwnAccount() {
+	rs.expectAccount(1, 7)
+
+	response := rs.request("GET", "/accounts/1", rs.roleToken(7, "customer"), "")
+
+	rs.assert.Equal(http.StatusOK, response.Code)
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestAccounts_TellerSeesAnyAccount() {
+	rs.expectAccount(1, 8)
+
+	response := rs.request("GET", "/accounts/1", rs.roleToken(7, "teller"), "")
+
+	rs.assert.Equal(http.StatusOK, response.Code)
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestTransactions_CustomerCannotPostToOtherAccounts() {
+	rs.expectAccount(1, 8)
+
+	response := rs.request("POST", "/transactions/", rs.roleToken(7, "customer"), `{"accountID": 1, "transactionType": "deposit", "transactionAmount": 10}`)
+
+	rs.assert.Equal(http.StatusConflict, response.Code)
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestCreate_InvalidBody() {
+	for _, tc := range []struct{ path, body, expected string }{
+		{"/accounts/", `{"accountHolder": " ", "accountType": "brokerage", "balance": "-1.00"}`, `[
+			{"field": "accountHolder", "message": "is required"},
+			{"field": "accountType", "message": "must be one of checking, savings"},
+			{"field": "balance", "message": "must not be negative"}]`},
+		{"/accounts/", `{"accountHolder": 7}`, `[{"field": "accountHolder", "message": "must be a string"}]`},
+		{"/transactions/", `{"transactionType": "gift", "transactionAmount": "0"}`, `[
+			{"field": "accountID", "message": "is required"},
+			{"field": "transactionType", "message": "must be one of deposit, withdrawal"},
+			{"field": "transactionAmount", "message": "must be greater than zero"}]`},
+		{"/transfers/", `{"fromAccountID": 1, "toAccountID": 1, "amount": "5.00"}`, `[
+			{"field": "toAccountID", "message": "must be a different account"}]`},
+		{"/transfers/", ``, `[{"message": "the request body is empty"}]`},
+		{"/accounts/", `{"accountHolder": "Foo Bar", "accountType": "checking", "balance": {"amount": "0", "currency": "XYZ"}}`, `[
+			{"field": "balance", "message": "has an unsupported currency"}]`},
+		{"/accounts/", `{"accountHolder": "Foo Bar", "accountType": "checking", "balance": {"amount": "0", "currency": "EUR"}}`, `[
+			{"field": "balance", "message": "must be in USD for checking accounts"}]`},
+		{"/transactions/", `{"accountID": 1, "transactionType": "deposit", "transactionAmount": {"amount": "5.00", "currency": "usdollars"}}`, `[
+			{"field": "transactionAmount", "message": "has an unsupported currency"}]`},
+		{"/transfers/", `{"fromAccountID": 1, "toAccountID": 2, "amount": {"amount": "5.00", "currency": "XYZ"}}`, `[
+			{"field": "amount", "message": "has an unsupported currency"}]`},
+	} {
+		response := rs.request("POST", tc.path, rs.roleToken(7, "teller"), tc.body)
+
+		rs.assert.Equal(http.StatusBadRequest, response.Code, tc.body)
+		rs.assert.JSONEq(`{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "the request has invalid fields",
+			"instance": "`+tc.path+`", "code": "invalid_request", "fields": `+tc.expected+`}`, response.Body.String(), tc.body)
+	}
+
+	// nothing is queried for an invalid request
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestCreateAccount_CustomerCannotSetBalance() {
+	// a customer opening an account with money in it would be creating money
+	response := rs.request("POST", "/accounts/", rs.roleToken(7, "customer"), `{"accountHolder": "Foo Bar", "accountType": "checking", "balance": "1000000.00"}`)
+
+	rs.assert.Equal(http.StatusBadRequest, response.Code)
+	rs.assert.JSONEq(`{"type": "about:blank", "title": "Bad Request", "status": 400, "detail": "the request has invalid fields",
+		"instance": "/accounts/", "code": "invalid_request", "fields": [{"field": "balance", "message": "can only be set by staff"}]}`, response.Body.String())
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestProblem_NotFound() {
+	rs.expectAccount(1, 8)
+
+	response := rs.request("GET", "/accounts/1", rs.roleToken(7, "customer"), "")
+
+	rs.assert.Equal(http.StatusNotFound, response.Code)
+	rs.assert.Equal(ProblemContentType, response.Header().Get("Content-Type"))
+	rs.assert.JSONEq(`{"type": "about:blank", "title": "Not Found", "status": 404, "detail": "Account with ID 1 not found",
+		"instance": "/accounts/1", "code": "not_found"}`, response.Body.String())
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestProblem_HidesDatabaseErrors() {
+	rs.sqlmock.ExpectQuery(`^SELECT (.+) FROM "accounts"`).
+		WillReturnError(errors.New(`pq: relation "accounts" does not exist`))
+
+	response := rs.request("GET", "/accounts/1", rs.roleToken(7, "teller"), "")
+
+	rs.assert.Equal(http.StatusInternalServerError, response.Code)
+	rs.assert.JSONEq(`{"type": "about:blank", "title": "Internal Server Error", "status": 500, "detail": "an unexpected error occurred",
+		"instance": "/accounts/1", "code": "internal_error"}`, response.Body.String())
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestProblem_Unauthorized() {
+	for _, path := range []string{"/accounts/", "/accounts/1"} {
+		response := rs.request("GET", path, "", "")
+
+		rs.assert.Equal(http.StatusUnauthorized, response.Code)
+		rs.assert.Equal("Bearer", response.Header().Get("WWW-Authenticate"))
+		rs.assert.Contains(response.Body.String(), `"code":"unauthorized"`)
+	}
+
+	// unknown paths are problems too
+	response := rs.request("GET", "/nowhere", "", "")
+	rs.assert.Equal(http.StatusNotFound, response.Code)
+	rs.assert.Equal(ProblemContentType, response.Header().Get("Content-Type"))
+}
+
+func (rs *RouterSuite) TestAccountTransactions_CustomerCannotSeeOtherAccounts() {
+	for _, path := range []string{"/accounts/1/transactions", "/accounts/1/statement?from=2024-01-01"} {
+		rs.expectAccount(1, 8)
+
+		response := rs.request("GET", path, rs.roleToken(7, "customer"), "")
+		rs.assert.Equal(http.StatusNotFound, response.Code, path)
+	}
+
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestAccountTransactions_ListsOneAccount() {
+	rs.expectAccount(1, 7)
+	rs.sqlmock.ExpectQuery(`^SELECT (.+) FROM "transactions" WHERE (.+)account_id = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	response := rs.request("GET", "/accounts/1/transactions", rs.roleToken(7, "customer"), "")
+
+	rs.assert.Equal(http.StatusOK, response.Code)
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestStatement_RequiresFrom() {
+	rs.expectAccount(1, 7)
+
+	response := rs.request("GET", "/accounts/1/statement", rs.roleToken(7, "customer"), "")
+
+	rs.assert.Equal(http.StatusBadRequest, response.Code)
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestStatement_ExportsCSV() {
+	rs.expectAccount(1, 7)
+	// the statement reads the account and its transactions from one snapshot, without locking the account
+	rs.sqlmock.ExpectBegin()
+	rs.sqlmock.ExpectQuery(`^SELECT (.+) FROM "accounts" (.+) LIMIT 1$`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "account_type", "balance_minor", "balance_currency", "owner_id", "status"}).
+			AddRow(1, "checking", 10000, "USD", 7, "open"))
+	rs.sqlmock.ExpectQuery(`^SELECT \* FROM "transactions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	rs.sqlmock.ExpectRollback()
+
+	request := httptest.NewRequest("GET", "/accounts/1/statement?from=2024-01-01&to=2024-01-31", nil)
+	request.Header.Set("Authorization", rs.roleToken(7, "customer"))
+	request.Header.Set("Accept", "text/csv")
+	response := httptest.NewRecorder()
+	rs.router.ServeHTTP(response, request)
+
+	rs.assert.Equal(http.StatusOK, response.Code)
+	rs.assert.Equal("text/csv; charset=utf-8", response.Header().Get("Content-Type"))
+	rs.assert.Equal(`attachment; filename="statement-1-2024-01-01.csv"`, response.Header().Get("Content-Disposition"))
+	rs.assert.Equal("date,transaction_id,type,amount,currency,balance\n", response.Body.String())
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestImport_RequiresAdmin() {
+	response := rs.request("POST", "/import/accounts", rs.roleToken(7, "teller"), "account_holder,account_type\n")
+
+	rs.assert.Equal(http.StatusForbidden, response.Code)
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestImport_ReportsInvalidRows() {
+	response := rs.request("POST", "/import/transactions?dry_run=true", rs.roleToken(7, "admin"), "account_id,amount\n1,10.00\n")
+
+	rs.assert.Equal(http.StatusUnprocessableEntity, response.Code)
+	rs.assert.JSONEq(`{"dryRun": true, "rows": 0, "imported": 0, "errors": [{"line": 1, "column": "type", "message": "missing column"}]}`, response.Body.String())
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestDelete_RequiresAdmin() {
+	for _, path := range []string{"/accounts/1", "/transactions/1"} {
+		for _, role := range []string{"customer", "teller"} {
+			response := rs.request("DELETE", path, rs.roleToken(7, role), "")
+			rs.assert.Equal(http.StatusForbidden, response.Code, "%s %s", role, path)
+		}
+	}
+
+	// the handler isn't reached, so nothing is queried
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+func (rs *RouterSuite) TestCorrections_RequireStaff() {
+	// customers can't undo their own withdrawals, interest or fees
+	response := rs.request("PUT", "/transactions/1", rs.roleToken(7, "customer"), `{"transactionAmount": "10.00"}`)
+	rs.assert.Equal(http.StatusForbidden, response.Code)
+
+	response = rs.request("POST", "/transactions/1/reverse", rs.roleToken(7, "customer"), "")
+	rs.assert.Equal(http.StatusForbidden, response.Code)
+
+	// the handler isn't reached, so nothing is queried
+	rs.assert.NoError(rs.sqlmock.ExpectationsWereMet())
+}
+
+// sets the expectation that the account with the given id and owner is fetched
+func (rs *RouterSuite) expectAccount(id uint, ownerID uint) {
+	rs.sqlmock.ExpectQuery(`^SELECT (.+) FROM "accounts" (.+) LIMIT 1$`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "account_holder", "account_type", "balance_minor", "balance_currency", "version", "owner_id", "status"}).
+			AddRow(id, time.Now(), time.Now(), nil, "Foo Bar", "checking", 10000, "USD", 1, ownerID, "open"))
+}
+
+// returns an authorization header for the given user and role
+func (rs *RouterSuite) roleToken(userID uint, role string) string {
+	return "Bearer " + rs.signedToken(jwt.MapClaims{
+		"sub":  strconv.FormatUint(uint64(userID), 10),
+		"role": role,
+		"exp":  time.Now().Add(time.Minute).Unix(),
+	})
+}
+
+// signs a token with the given claims using the router's secret
+func (rs *RouterSuite) signedToken(claims jwt.MapClaims) string {
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testSecret))
+	rs.Require().NoError(err)
+	return signed
+}
+
+// signs a token expiring at the given time
+func (rs *RouterSuite) token(method jwt.SigningMethod, key interface{}, expiresAt time.Time) string {
+	token := jwt.NewWithClaims(method, jwt.MapClaims{
+		"sub": "1",
+		"exp": expiresAt.Unix(),
+		"iat": time.Now().Unix(),
+	})
+	//hmac keys are bytes, the none method takes its own magic constant
+	if s, ok := key.(string); ok {
+		key = []byte(s)
+	}
+
+	signed, err := token.SignedString(key)
+	rs.Require().NoError(err)
+	return signed
+}
+
+func (rs *RouterSuite) request(method string, path string, authorization string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+
+	response := httptest.NewRecorder()
+	rs.router.ServeHTTP(response, request)
+	return response
+}
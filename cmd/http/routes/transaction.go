@@ -1,11 +1,13 @@
 package routes
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	http "net/http"
-	strconv "strconv"
+	"time"
 
+	"github.com/jobullo/go-api-example/export"
 	service "github.com/jobullo/go-api-example/service"
 
 	gin "github.com/gin-gonic/gin"
@@ -13,11 +15,12 @@ import (
 )
 
 type TransactionController struct {
-	service *service.TransactionService
+	service        *service.TransactionService
+	accountService *service.AccountService
 }
 
-func NewTransactionController(service *service.TransactionService) *TransactionController {
-	return &TransactionController{service: service}
+func NewTransactionController(service *service.TransactionService, accountService *service.AccountService) *TransactionController {
+	return &TransactionController{service: service, accountService: accountService}
 }
 
 // @Summary create a transaction record
@@ -26,62 +29,70 @@ func NewTransactionController(service *service.TransactionService) *TransactionC
 // @Security ApiKeyAuth
 // @Accept  json
 // @Produce json
-// @Param transaction body database.Transaction true "Create Transaction"
-// @Success 200 {object} database.Transaction
-// @Failure 400 {object} error
-// @Failure 409 {object} error
-// @Failure 500 {object} error
+// @Param transaction body CreateTransactionRequest true "Create Transaction"
+// @Param Idempotency-Key header string false "makes the request safe to retry, the first response is replayed"
+// @Success 200 {object} TransactionResponse
+// @Failure 400 {object} Problem
+// @Failure 409 {object} Problem
+// @Failure 422 {object} Problem
+// @Failure 500 {object} Problem
 // @Router /transactions [post]
 func (tc *TransactionController) Create(ctx *gin.Context) {
-	var transaction database.Transaction
+	var request CreateTransactionRequest
+	if !bindRequest(ctx, &request) {
+		return
+	}
+	transaction := request.transaction()
 
-	if err := ctx.BindJSON(&transaction); err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, NewError(err.Error()))
+	//posting to someone else's account looks the same as posting to one that doesn't exist
+	if ok, err := canAccessAccount(ctx, tc.accountService, transaction.AccountID); err != nil {
+		abortWithError(ctx, err)
+		return
+	} else if !ok {
+		abortWithError(ctx, withDetail(database.ErrParentNotFound, "Account with ID %d not found", transaction.AccountID))
 		return
 	}
 
 	if err := tc.service.Create(&transaction); err != nil {
-
 		switch {
 		case errors.Is(err, database.ErrParentNotFound):
-			ctx.AbortWithStatusJSON(http.StatusConflict, NewError(fmt.Sprintf("Account with ID %d not found", transaction.AccountID)))
+			err = withDetail(err, "Account with ID %d not found", transaction.AccountID)
 		case errors.Is(err, database.ErrInvalidType):
-			ctx.AbortWithStatusJSON(http.StatusBadRequest, NewError(fmt.Sprintf("Invalid transaction type %s", transaction.Type)))
-		default:
-			ctx.AbortWithStatusJSON(http.StatusInternalServerError, NewError(err.Error()))
+			err = withDetail(err, "Invalid transaction type %s", transaction.Type)
 		}
+
+		abortWithError(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, transaction)
+	ctx.JSON(http.StatusOK, newTransactionResponse(&transaction))
 }
 
 // @Summary delete a transaction record
-// @Description allows a transaction to be deleted from the database
+// @Description allows a transaction to be deleted from the database, its amount and fees are backed out of the account balance. Only admins can delete transactions
 // @Tags Tranasctions
 // @Security ApiKeyAuth
 // @Accept  json
 // @Produce json
 // @Param id path int true "transaction ID"
 // @Success 204
-// @Failure 400 {object} error
-// @Failure 404 {object} error
-// @Failure 500 {object} error
+// @Failure 400 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 409 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 422 {object} Problem
+// @Failure 500 {object} Problem
 // @Router /transactions/{id} [delete]
 func (tc *TransactionController) Delete(ctx *gin.Context) {
-	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	id, err := pathID(ctx)
 
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, NewError(err.Error()))
+		abortWithError(ctx, err)
 		return
 	}
 
-	if err := tc.service.Delete(uint(id)); err != nil {
-		if errors.Is(err, database.ErrNotFound) {
-			ctx.AbortWithStatusJSON(http.StatusNotFound, NewError(fmt.Sprintf("Transaction with ID %d not found", id)))
-		} else {
-			ctx.AbortWithError(http.StatusInternalServerError, err)
-		}
+	if err := tc.service.Delete(id); err != nil {
+		abortWithError(ctx, notFound(err, "Transaction", id))
 		return
 	}
 
@@ -96,99 +107,329 @@ func (tc *TransactionController) Delete(ctx *gin.Context) {
 // @Accept  json
 // @Produce json
 // @Param id path int true "transaction ID"
-// @Success 204 {object} database.Transaction
-// @Failure 400 {object} error
-// @Failure 404 {object} error
-// @Failure 500 {object} error
+// @Success 200 {object} TransactionResponse
+// @Failure 400 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 500 {object} Problem
 // @Router /transactions/{id} [get]
 func (transactionController *TransactionController) FetchById(ctx *gin.Context) {
 
-	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	id, err := pathID(ctx)
 
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, NewError(err.Error()))
+		abortWithError(ctx, err)
 		return
 	}
 
-	transaction, err := transactionController.service.FetchById(int(id))
+	transaction, err := transactionController.service.FetchById(id)
 
-	if err != nil {
-
-		if errors.Is(err, database.ErrNotFound) {
-			ctx.AbortWithStatusJSON(http.StatusNotFound, NewError(fmt.Sprintf("Transaction with ID %d not found", id)))
-			return
+	//someone else's transaction looks the same as one that doesn't exist
+	if err == nil && !CurrentPrincipal(ctx).IsStaff() {
+		var ok bool
+		if ok, err = canAccessAccount(ctx, transactionController.accountService, transaction.AccountID); err == nil && !ok {
+			err = database.ErrNotFound
 		}
+	}
+
+	if err != nil {
 
-		ctx.AbortWithStatusJSON(http.StatusInternalServerError, NewError(err.Error()))
+		abortWithError(ctx, notFound(err, "Transaction", id))
 		return
 	}
 
-	ctx.JSON(http.StatusOK, transaction)
+	ctx.JSON(http.StatusOK, newTransactionResponse(transaction))
 
 }
 
-// @Summary list all transaction records
-// @Description lists all transaction records in the DB
+// @Summary list transaction records
+// @Description lists one page of the transaction records in the DB, customers only get transactions on their own accounts.
+// @Description Pass next_cursor from the response as cursor to get the next page.
 // @Tags Tranasctions
 // @Security ApiKeyAuth
 // @Accept  json
 // @Produce json
-// @Param id path int true "transaction ID"
-// @Success 200 {array} database.Transaction
-// @Failure 500 {object} error
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param limit query int false "records per page, 50 by default and at most 200"
+// @Param sort query string false "id, created_at or amount" default(id)
+// @Param order query string false "asc or desc" default(asc)
+// @Param account_id query int false "account ID"
+// @Param type query string false "transaction type"
+// @Param from query string false "created on or after this date or timestamp"
+// @Param to query string false "created before this timestamp, or on or before this date"
+// @Param min_amount query string false "smallest amount"
+// @Param max_amount query string false "largest amount"
+// @Success 200 {object} database.Page[TransactionResponse]
+// @Failure 400 {object} Problem
+// @Failure 500 {object} Problem
 // @Router /transactions [get]
 func (transactionController *TransactionController) List(ctx *gin.Context) {
 
-	transactions, err := transactionController.service.List()
+	page, err := parsePageRequest(ctx)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	filter, err := transactionFilter(ctx)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	//customers only see transactions on their own accounts
+	if principal := CurrentPrincipal(ctx); !principal.IsStaff() {
+		filter.OwnerID = &principal.UserID
+	}
+
+	transactions, err := transactionController.service.ListPage(filter, page)
 
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusInternalServerError, NewError(err.Error()))
+		abortWithError(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, transactions)
+	ctx.JSON(http.StatusOK, newPageResponse(transactions, newTransactionResponse))
+}
+
+// transactionFilter reads the filters of the transaction list from the query string
+func transactionFilter(ctx *gin.Context) (filter database.TransactionFilter, err error) {
+	filter.Type = ctx.Query("type")
+
+	if filter.AccountID, err = queryID(ctx, "account_id"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedFrom, err = queryTime(ctx, "from", false); err != nil {
+		return filter, err
+	}
+	if filter.CreatedTo, err = queryTime(ctx, "to", true); err != nil {
+		return filter, err
+	}
+	if filter.MinAmount, err = queryMinor(ctx, "min_amount"); err != nil {
+		return filter, err
+	}
+	if filter.MaxAmount, err = queryMinor(ctx, "max_amount"); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
 }
 
 // @Summary update the amount of a transaction record
-// @Description update the amount of a transaction record
+// @Description update the amount of a transaction record, the account balance is adjusted by the difference and the fees are charged again. Only tellers and admins can update transactions
 // @Tags Tranasctions
 // @Security ApiKeyAuth
 // @Accept  json
 // @Produce  json
 // @Param id path int true "transaction ID"
-// @Success 200 {object} database.Transaction
-// @Failure 400 {object} error
-// @Failure 404 {object} error
-// @Failure 500 {object} error
+// @Param transaction body UpdateTransactionRequest true "Update Transaction"
+// @Success 200 {object} TransactionResponse
+// @Failure 400 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 409 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 422 {object} Problem
+// @Failure 500 {object} Problem
 // @Router /transactions/{id} [put]
 func (transactionController *TransactionController) Update(ctx *gin.Context) {
 
-	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	id, err := pathID(ctx)
 
 	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, NewError(err.Error()))
+		abortWithError(ctx, err)
 		return
 	}
 
-	var transaction database.Transaction
-
-	if err := ctx.BindJSON(&transaction); err != nil {
-		ctx.AbortWithStatusJSON(http.StatusBadRequest, NewError(err.Error()))
+	var request UpdateTransactionRequest
+	if !bindRequest(ctx, &request) {
 		return
 	}
 
-	transaction.ID = uint(id)
+	//only the amount of a transaction can be changed
+	transaction := database.Transaction{Amount: request.Amount}
+	transaction.ID = id
 
 	if err := transactionController.service.Update(&transaction); err != nil {
+		abortWithError(ctx, notFound(err, "Transaction", id))
+		return
+	}
 
-		if errors.Is(err, database.ErrNotFound) {
-			ctx.AbortWithStatusJSON(http.StatusNotFound, NewError(fmt.Sprintf("Transaction with ID %d not found", id)))
-			return
-		}
+	ctx.JSON(http.StatusOK, newTransactionResponse(&transaction))
+}
+
+// @Summary reverse a transaction record
+// @Description posts a linked transaction that cancels out the given one, this is how posted history is corrected, the fees it triggered are refunded with it. Only tellers and admins can reverse transactions
+// @Tags Tranasctions
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce  json
+// @Param id path int true "transaction ID"
+// @Success 200 {object} TransactionResponse
+// @Failure 400 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 409 {object} Problem
+// @Failure 403 {object} Problem
+// @Failure 500 {object} Problem
+// @Router /transactions/{id}/reverse [post]
+func (transactionController *TransactionController) Reverse(ctx *gin.Context) {
 
-		ctx.AbortWithStatusJSON(http.StatusInternalServerError, NewError(err.Error()))
+	id, err := pathID(ctx)
+
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	reversal, err := transactionController.service.Reverse(id)
+
+	if err != nil {
+		abortWithError(ctx, notFound(err, "Transaction", id))
 		return
 	}
 
-	ctx.JSON(http.StatusOK, transaction)
+	ctx.JSON(http.StatusOK, newTransactionResponse(reversal))
+}
+
+// @Summary list the transaction records of an account
+// @Description lists one page of the transactions on an account, takes the same query parameters as GET /transactions
+// @Tags Accounts
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param id path int true "account ID"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param limit query int false "records per page, 50 by default and at most 200"
+// @Param sort query string false "id, created_at or amount" default(id)
+// @Param order query string false "asc or desc" default(asc)
+// @Param type query string false "transaction type"
+// @Param from query string false "created on or after this date or timestamp"
+// @Param to query string false "created before this timestamp, or on or before this date"
+// @Success 200 {object} database.Page[TransactionResponse]
+// @Failure 400 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 500 {object} Problem
+// @Router /accounts/{id}/transactions [get]
+func (transactionController *TransactionController) ListByAccount(ctx *gin.Context) {
+
+	id, ok := transactionController.accountParam(ctx)
+	if !ok {
+		return
+	}
+
+	page, err := parsePageRequest(ctx)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	filter, err := transactionFilter(ctx)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+	filter.AccountID = &id
+
+	transactions, err := transactionController.service.ListPage(filter, page)
+
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, newPageResponse(transactions, newTransactionResponse))
+}
+
+// @Summary account statement for a period
+// @Description lists the transactions on an account in a period with the balance after each one,
+// @Description along with the opening and closing balance of the period.
+// @Description Send Accept: text/csv, application/x-ofx or text/plain to download the statement in that format.
+// @Tags Accounts
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json,text/csv,application/x-ofx,text/plain
+// @Param id path int true "account ID"
+// @Param from query string true "start of the period, a date such as 2024-01-01 or a timestamp"
+// @Param to query string false "end of the period, a day is included. Defaults to now"
+// @Success 200 {object} StatementResponse
+// @Failure 400 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 500 {object} Problem
+// @Router /accounts/{id}/statement [get]
+func (transactionController *TransactionController) Statement(ctx *gin.Context) {
+
+	id, ok := transactionController.accountParam(ctx)
+	if !ok {
+		return
+	}
+
+	from, err := queryTime(ctx, "from", false)
+	if err == nil && from == nil {
+		err = withDetail(ErrBadRequest, "from is required")
+	}
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	to, err := queryTime(ctx, "to", true)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+	if to == nil {
+		now := time.Now()
+		to = &now
+	}
+
+	statement, err := transactionController.service.Statement(id, *from, *to)
+
+	if err != nil {
+		abortWithError(ctx, notFound(err, "Account", id))
+		return
+	}
+
+	//the statement is json unless the client asks for one of the export formats in the Accept header
+	offered := []string{gin.MIMEJSON}
+	for _, format := range export.Formats {
+		offered = append(offered, export.ContentType(format))
+	}
+
+	format := export.FormatFor(ctx.NegotiateFormat(offered...))
+	if format == "" {
+		ctx.JSON(http.StatusOK, newStatementResponse(statement))
+		return
+	}
+
+	var body bytes.Buffer
+	if err := export.Write(&body, format, statement); err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, export.FileName(format, statement)))
+	ctx.Data(http.StatusOK, export.ContentType(format)+"; charset=utf-8", body.Bytes())
+}
+
+// accountParam reads the account id from the path of a nested account route and checks the caller may see
+// the account, otherwise the request is aborted and false is returned
+func (transactionController *TransactionController) accountParam(ctx *gin.Context) (uint, bool) {
+	id, err := pathID(ctx)
+
+	if err != nil {
+		abortWithError(ctx, err)
+		return 0, false
+	}
+
+	account, err := transactionController.accountService.FetchById(id)
+
+	//someone else's account looks the same as one that doesn't exist
+	if err == nil && !CurrentPrincipal(ctx).CanAccess(account) {
+		err = database.ErrNotFound
+	}
+
+	if err != nil {
+		abortWithError(ctx, notFound(err, "Account", id))
+		return 0, false
+	}
+
+	return id, true
 }
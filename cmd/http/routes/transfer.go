@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"errors"
+	http "net/http"
+
+	service "github.com/jobullo/go-api-example/service"
+
+	gin "github.com/gin-gonic/gin"
+	database "github.com/jobullo/go-api-example/database"
+)
+
+type TransferController struct {
+	service        *service.TransferService
+	accountService *service.AccountService
+}
+
+func NewTransferController(service *service.TransferService, accountService *service.AccountService) *TransferController {
+	return &TransferController{service: service, accountService: accountService}
+}
+
+// @Summary transfer funds between two accounts
+// @Description debits one account and credits another in a single database transaction
+// @Tags Transfers
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param transfer body CreateTransferRequest true "Create Transfer"
+// @Param Idempotency-Key header string false "makes the request safe to retry, the first response is replayed"
+// @Success 200 {object} TransferResponse
+// @Failure 400 {object} Problem
+// @Failure 409 {object} Problem
+// @Failure 422 {object} Problem
+// @Failure 500 {object} Problem
+// @Router /transfers [post]
+func (tc *TransferController) Create(ctx *gin.Context) {
+	var request CreateTransferRequest
+	if !bindRequest(ctx, &request) {
+		return
+	}
+	transfer := request.transfer()
+
+	//customers can only move money out of their own accounts
+	if ok, err := canAccessAccount(ctx, tc.accountService, transfer.FromAccountID); err != nil {
+		abortWithError(ctx, err)
+		return
+	} else if !ok {
+		abortWithError(ctx, withDetail(database.ErrParentNotFound, "Account with ID %d not found", transfer.FromAccountID))
+		return
+	}
+
+	if err := tc.service.Create(&transfer); err != nil {
+		if errors.Is(err, database.ErrParentNotFound) {
+			err = withDetail(err, "Account with ID %d or %d not found", transfer.FromAccountID, transfer.ToAccountID)
+		}
+
+		abortWithError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, newTransferResponse(&transfer))
+}
+
+// @Summary fetches a transfer record by id
+// @Description fetches a transfer and both of its transactions
+// @Tags Transfers
+// @Security ApiKeyAuth
+// @Accept  json
+// @Produce json
+// @Param id path int true "transfer ID"
+// @Success 200 {object} TransferResponse
+// @Failure 400 {object} Problem
+// @Failure 404 {object} Problem
+// @Failure 500 {object} Problem
+// @Router /transfers/{id} [get]
+func (tc *TransferController) FetchById(ctx *gin.Context) {
+
+	id, err := pathID(ctx)
+	if err != nil {
+		abortWithError(ctx, err)
+		return
+	}
+
+	transfer, err := tc.service.FetchById(id)
+
+	//customers can see transfers into or out of their own accounts
+	if err == nil && !CurrentPrincipal(ctx).IsStaff() {
+		var from, to bool
+		if from, err = canAccessAccount(ctx, tc.accountService, transfer.FromAccountID); err == nil && !from {
+			if to, err = canAccessAccount(ctx, tc.accountService, transfer.ToAccountID); err == nil && !to {
+				err = database.ErrNotFound
+			}
+		}
+	}
+
+	if err != nil {
+		abortWithError(ctx, notFound(err, "Transfer", id))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, newTransferResponse(transfer))
+}
@@ -4,30 +4,24 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os"
-	"strconv"
 
 	lambda "github.com/aws/aws-lambda-go/lambda"
 	gorm "github.com/jinzhu/gorm"
+	config "github.com/jobullo/go-api-example/config"
 	database "github.com/jobullo/go-api-example/database"
 )
 
 func connectToDatabase() *gorm.DB {
 	fmt.Println(">> Connecting to database ...")
-	portString := os.Getenv("DB_PORT")
-	port, err := strconv.Atoi(portString)
+	cfg, err := config.DatabaseFromEnvironment()
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
-		port = 5432
 	}
-	database.Init(
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_HOST"),
-		port,
-		os.Getenv("DB_NAME"),
-	)
-	return database.GetDatabase()
+	db, err := database.New(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	return db.DB
 }
 
 func handler(ctx context.Context) {
@@ -0,0 +1,69 @@
+package migrate
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	config "github.com/jobullo/go-api-example/config"
+	database "github.com/jobullo/go-api-example/database"
+)
+
+// Execute applies or rolls back schema migrations, the action is the first argument:
+// up (the default) applies every pending migration, down rolls back the latest one
+// and status lists them all with when they were applied
+func Execute() {
+	fmt.Println(">> Loading configuration ...")
+	cfg := config.LoadConfigFromPath("config.yaml")
+
+	action := flag.Arg(0)
+	if action == "" {
+		action = "up"
+	}
+
+	fmt.Println(">> Connecting to database ...")
+	db, err := database.New(cfg.DB)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	migrator, err := db.Migrator()
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	switch action {
+	case "up":
+		applied, err := migrator.Up()
+		for _, migration := range applied {
+			fmt.Printf("Applied %03d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatalf("Failed to migrate: %v", err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("The schema is up to date.")
+		}
+	case "down":
+		migration, err := migrator.Down()
+		if err != nil {
+			log.Fatalf("Failed to roll back: %v", err)
+		}
+		fmt.Printf("Rolled back %03d_%s\n", migration.Version, migration.Name)
+	case "status":
+		status, err := migrator.Status()
+		if err != nil {
+			log.Fatalf("Failed to read migrations: %v", err)
+		}
+		for _, s := range status {
+			if s.AppliedAt != nil {
+				fmt.Printf("%03d_%-30s applied %s\n", s.Version, s.Name, s.AppliedAt.Format("2006-01-02 15:04:05"))
+			} else {
+				fmt.Printf("%03d_%-30s pending\n", s.Version, s.Name)
+			}
+		}
+	default:
+		log.Fatalf("Unknown action %q, use up, down or status", action)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 
 	yaml "gopkg.in/yaml.v2"
 )
@@ -39,3 +40,20 @@ func GetEnvironmentVariable(key, fallback string) string {
 	}
 	return fallback
 }
+
+/** DatabaseFromEnvironment reads the database settings from DB_* environment variables, for the console and lambda */
+func DatabaseFromEnvironment() (*Database, error) {
+	port, err := strconv.Atoi(GetEnvironmentVariable("DB_PORT", "5432"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DB_PORT, %v", err)
+	}
+	return &Database{
+		Driver:   os.Getenv("DB_DRIVER"),
+		User:     os.Getenv("DB_USER"),
+		Password: os.Getenv("DB_PASSWORD"),
+		Host:     os.Getenv("DB_HOST"),
+		Port:     port,
+		Database: os.Getenv("DB_NAME"),
+		SSLMode:  os.Getenv("DB_SSLMODE"),
+	}, nil
+}
@@ -6,17 +6,31 @@ type Configuration struct {
 	DB     *Database    `yaml:"database,omitempty"`
 	JWT    *JWT         `yaml:"jwt,omitempty"`
 	App    *Application `yaml:"application,omitempty"`
+	// rules keyed by account type, e.g. "savings" or "checking"
+	AccountTypes map[string]*AccountType `yaml:"account_types,omitempty"`
 }
 
 // Database holds data necessery for database configuration
 type Database struct {
+	// postgres (the default), sqlite to keep the data in the file named by database,
+	// or memory to keep it in the process until it exits
+	Driver     string `yaml:"driver,omitempty"`
 	User       string `yaml:"user,omitempty"`
 	Password   string `yaml:"password,omitempty"`
 	Host       string `yaml:"host,omitempty"`
 	Database   string `yaml:"database,omitempty"`
 	Port       int    `yaml:"port,omitempty"`
 	LogQueries bool   `yaml:"log_queries,omitempty"`
-	Timeout    int    `yaml:"timeout_seconds,omitempty"`
+	// how long one connection attempt may take
+	Timeout int `yaml:"timeout_seconds,omitempty"`
+	// disable (the default), require, verify-ca or verify-full
+	SSLMode string `yaml:"sslmode,omitempty"`
+	// connection pool limits, zero keeps the driver's default
+	MaxOpenConns    int `yaml:"max_open_conns,omitempty"`
+	MaxIdleConns    int `yaml:"max_idle_conns,omitempty"`
+	ConnMaxLifetime int `yaml:"conn_max_lifetime_minutes,omitempty"`
+	// how often to try connecting at startup before giving up, waiting longer after each failure
+	ConnectAttempts int `yaml:"connect_attempts,omitempty"`
 }
 
 // Server holds data necessery for server configuration
@@ -29,11 +43,52 @@ type Server struct {
 
 // JWT holds data necessery for JWT configuration
 type JWT struct {
+	// HMAC secret, used as a single HS256 key when no keys are listed
 	Secret string `yaml:"secret,omitempty"`
+	// how long issued tokens are valid for
+	Duration int `yaml:"duration_minutes,omitempty"`
+	// how long a refresh token can be exchanged for a new access token
+	RefreshDuration int `yaml:"refresh_duration_minutes,omitempty"`
+	// id of the key new tokens are signed with, defaults to the first key
+	SigningKeyID string `yaml:"signing_key_id,omitempty"`
+	// every key a token may be signed with, keep retired keys here until tokens signed with them expire
+	Keys []*JWTKey `yaml:"keys,omitempty"`
+}
+
+// JWTKey holds one key of the JWT keyring, tokens name the key they were signed with in their kid header
+type JWTKey struct {
+	ID string `yaml:"id,omitempty"`
+	// HS256 (the default), RS256 or ES256
+	Algorithm string `yaml:"algorithm,omitempty"`
+	// HMAC secret for HS256 keys
+	Secret string `yaml:"secret,omitempty"`
+	// PEM files for RS256 and ES256 keys, a key with only a public key can verify tokens but not sign them
+	PrivateKeyFile string `yaml:"private_key_file,omitempty"`
+	PublicKeyFile  string `yaml:"public_key_file,omitempty"`
 }
 
 // Application holds application configuration details
 type Application struct {
+	// minimum score from 0 (anything goes) to 4 that passwords need at registration
 	MinPasswordStr int    `yaml:"min_password_strength,omitempty"`
 	SwaggerUIPath  string `yaml:"swagger_ui_path,omitempty"`
+	// when set, posted transactions can't be edited or deleted, only reversed
+	ImmutableLedger bool `yaml:"immutable_ledger,omitempty"`
+}
+
+// AccountType holds the rules applied to accounts of one account type
+type AccountType struct {
+	// ISO 4217 code of the currency accounts of this type are held in, USD by default
+	Currency string `yaml:"currency,omitempty"`
+	// how far below zero a withdrawal may take the balance, as a decimal in the account's currency
+	OverdraftLimit string `yaml:"overdraft_limit,omitempty"`
+	// yearly interest paid on the end-of-day balance, as a percentage, e.g. 2.5
+	InterestRate string `yaml:"interest_rate,omitempty"`
+	// fees as decimals in the account's currency: charged every month, on every withdrawal
+	// and on every withdrawal that leaves the account overdrawn
+	MaintenanceFee string `yaml:"maintenance_fee,omitempty"`
+	WithdrawalFee  string `yaml:"withdrawal_fee,omitempty"`
+	OverdraftFee   string `yaml:"overdraft_fee,omitempty"`
+	// the maintenance and withdrawal fees are waived while the balance stays at or above this
+	FeeWaiverBalance string `yaml:"fee_waiver_balance,omitempty"`
 }
@@ -2,14 +2,35 @@ package database
 
 import "github.com/jinzhu/gorm"
 
+// the lifecycle of an account. Only open accounts take new transactions, frozen and dormant accounts
+// can be reopened and closed accounts stay closed.
+const (
+	AccountOpen    = "open"
+	AccountFrozen  = "frozen"  //stopped by staff, e.g. while fraud is investigated
+	AccountDormant = "dormant" //no activity for a long time
+	AccountClosed  = "closed"
+)
+
+// AccountStatuses lists every account status
+var AccountStatuses = []string{AccountOpen, AccountFrozen, AccountDormant, AccountClosed}
+
 type Account struct {
 	gorm.Model                  //leaving this ananymous field here so gorm:embedded tag isn't necessary
 	AccountHolder string        `json:"accountHolder" binding:"required"`
 	AccountType   string        `json:"accountType" binding:"required"`
-	Balance       float64       `json:"balance" binding:"required"`
+	Balance       Money         `json:"balance" gorm:"embedded;embedded_prefix:balance_"`
+	Version       uint          `json:"version" gorm:"not null;default:1"` //incremented on every update for optimistic concurrency control
+	OwnerID       *uint         `json:"ownerID,omitempty" gorm:"index"`    //the user the account belongs to, customers only see their own accounts
+	Status        string        `json:"status" gorm:"not null;default:'open';index"`
 	Transactions  []Transaction `gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE;"`
 }
 
+// IsOpen reports whether transactions can be posted to the account
+func (a *Account) IsOpen() bool {
+	return a.Status == AccountOpen
+}
+
 type AccountService interface {
 	Service[Account]
+	ListPage(filter AccountFilter, page PageRequest) (*Page[Account], error)
 }
@@ -1,69 +1,212 @@
 package database
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	gorm "github.com/jinzhu/gorm"
+	config "github.com/jobullo/go-api-example/config"
 
 	// Get the guts for postgres
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 	_ "github.com/lib/pq"
+
+	// and for sqlite
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// the storage backends a configuration can choose with driver. Memory keeps the banking data in a MemoryStore
+// and the users, tokens and idempotency keys in an SQLite database that is kept in memory.
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+	DriverMemory   = "memory"
+)
+
+// DefaultConnectAttempts is how often New tries to connect when the configuration doesn't say
+const DefaultConnectAttempts = 5
+
+// the wait after the first failed attempt doubles after every following one, up to maxBackoff
+const (
+	initialBackoff = time.Second
+	maxBackoff     = 30 * time.Second
 )
 
-// Hold on to the database connection for all queries
-var db *gorm.DB
-
-// Hold on to the error
-var err error
-
-// Init creates a connection to a postgres database and returns the DB instance
-func Init(user string, password string, host string, port int, database string) {
-	dbinfo := fmt.Sprintf("user=%s password=%s host=%s port=%s dbname=%s sslmode=disable",
-		user,
-		password,
-		host,
-		strconv.Itoa(port),
-		database,
-	)
-	Connect(dbinfo)
+// open and sleep are replaced in tests
+var (
+	open  = func(dialect string, dsn string) (*gorm.DB, error) { return gorm.Open(dialect, dsn) }
+	sleep = time.Sleep
+)
+
+// every memory database gets its own name so they don't share data
+var memoryDatabases atomic.Int64
+
+// the tables of the schema, for databases that are built from the models instead of the migrations
+var models = []interface{}{
+	&Account{},
+	&Transaction{},
+	&Transfer{},
+	&JournalEntry{},
+	&Posting{},
+	&IdempotencyKey{},
+	&User{},
+	&RefreshToken{},
+	&RevokedToken{},
+	&InterestPosting{},
+	&MaintenanceFee{},
+}
+
+// DB is a pool of connections to the database, the banking services take its Store
+// and the others the *gorm.DB it embeds
+type DB struct {
+	*gorm.DB
+	store Store
 }
 
-// GetDatabase will return the database instance
-func GetDatabase() *gorm.DB {
-	return db
+// Store returns the store of the banking data, which is in the database unless the driver is memory
+func (db *DB) Store() Store {
+	if db.store == nil {
+		return NewGormStore(db.DB)
+	}
+	return db.store
 }
 
-// Connect will connect to the database and return a DB instance
-func Connect(dbinfo string) {
-	db, err = gorm.Open("postgres", dbinfo)
+// New connects to the database described by cfg and sets up the connection pool.
+// The database may still be starting, e.g. next to the api in a container, so a failed
+// attempt is retried with backoff before the error is returned.
+func New(cfg *config.Database) (*DB, error) {
+	if cfg == nil {
+		return nil, errors.New("missing database configuration")
+	}
+
+	dialect, dsn, err := connection(cfg)
 	if err != nil {
-		log.Println("Failed to connect to database.")
-		// panic(err)
+		return nil, err
 	}
+
+	attempts := cfg.ConnectAttempts
+	if attempts <= 0 {
+		attempts = DefaultConnectAttempts
+	}
+
+	var conn *gorm.DB
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		conn, err = open(dialect, dsn)
+		if err == nil {
+			break
+		}
+		if attempt == attempts {
+			return nil, fmt.Errorf("connecting to database after %d attempts: %w", attempts, err)
+		}
+
+		log.Printf("Failed to connect to database, retrying in %v: %v", backoff, err)
+		sleep(backoff)
+		backoff = min(backoff*2, maxBackoff)
+	}
+
+	conn.LogMode(cfg.LogQueries)
+
+	pool := conn.DB()
+	if cfg.MaxOpenConns > 0 {
+		pool.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		pool.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		pool.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime) * time.Minute)
+	}
+	if cfg.Driver == DriverMemory {
+		//a memory database is dropped with its last connection, so one is always kept open
+		pool.SetMaxIdleConns(max(cfg.MaxIdleConns, 1))
+		pool.SetConnMaxLifetime(0)
+	}
+
+	db := &DB{DB: conn}
+	if cfg.Driver == DriverMemory {
+		db.store = NewMemoryStore()
+	}
+
 	log.Println("Database connected")
+	return db, nil
 }
 
-// BuildDatabase sets up the database if there are no tables
-func BuildDatabase() {
-	if !db.HasTable(Account{}) {
-		if err := db.CreateTable(Account{}); err != nil {
-			log.Println("Account Table already exists")
+// connection picks the gorm dialect and data source name for the configured driver
+func connection(cfg *config.Database) (string, string, error) {
+	//writers take the lock when their transaction begins, rather than failing when a reader tries to write
+	const sqliteOptions = "_txlock=immediate&_busy_timeout=5000"
+
+	switch cfg.Driver {
+	case "", DriverPostgres:
+		return "postgres", DSN(cfg), nil
+	case DriverSQLite:
+		if cfg.Database == "" {
+			return "", "", errors.New("the sqlite driver needs the name of the database file")
 		}
+		return "sqlite3", fmt.Sprintf("file:%s?%s", cfg.Database, sqliteOptions), nil
+	case DriverMemory:
+		name := fmt.Sprintf("memory%d", memoryDatabases.Add(1))
+		return "sqlite3", fmt.Sprintf("file:%s?mode=memory&cache=shared&%s", name, sqliteOptions), nil
+	default:
+		return "", "", fmt.Errorf("unknown database driver %q, use postgres, sqlite or memory", cfg.Driver)
 	}
+}
 
-	if !db.HasTable(Transaction{}) {
-		if err := db.CreateTable(Transaction{}); err != nil {
-			log.Println("Transaction Table already exists")
+// DSN builds the postgres connection string for cfg, values are quoted so passwords may contain spaces
+func DSN(cfg *config.Database) string {
+	sslMode := cfg.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+
+	settings := []struct{ key, value string }{
+		{"user", cfg.User},
+		{"password", cfg.Password},
+		{"host", cfg.Host},
+		{"port", strconv.Itoa(cfg.Port)},
+		{"dbname", cfg.Database},
+		{"sslmode", sslMode},
+	}
+	if cfg.Timeout > 0 {
+		settings = append(settings, struct{ key, value string }{"connect_timeout", strconv.Itoa(cfg.Timeout)})
+	}
+
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	parts := make([]string, 0, len(settings))
+	for _, setting := range settings {
+		if setting.value == "" || setting.value == "0" {
+			continue
 		}
+		parts = append(parts, fmt.Sprintf("%s='%s'", setting.key, quote.Replace(setting.value)))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Migrate brings the schema up to date by applying every pending migration.
+// The migrations are written for postgres, sqlite databases are for development and tests
+// and have their tables created from the models instead.
+func (db *DB) Migrate() ([]Migration, error) {
+	if db.Dialect().GetName() != "postgres" {
+		return nil, db.AutoMigrate(models...).Error
 	}
 
-	db.AutoMigrate(Account{})
-	db.AutoMigrate(Transaction{})
+	migrator, err := db.Migrator()
+	if err != nil {
+		return nil, err
+	}
+	return migrator.Up()
 }
 
-// Close closes the database connection
-func Close() {
-	db.Close()
+// Migrator manages the versioned migrations of a postgres database
+func (db *DB) Migrator() (*Migrator, error) {
+	if name := db.Dialect().GetName(); name != "postgres" {
+		return nil, fmt.Errorf("%w, the %s schema is created from the models", ErrMigrationsUnsupported, name)
+	}
+	return NewMigrator(db.DB)
 }
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	gorm "github.com/jinzhu/gorm"
+	config "github.com/jobullo/go-api-example/config"
+	"github.com/jobullo/go-api-example/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDSN(t *testing.T) {
+	dsn := DSN(&config.Database{
+		User:     "postgres",
+		Password: `it's a secret`,
+		Host:     "localhost",
+		Port:     5432,
+		Database: "bankExample",
+		Timeout:  30,
+	})
+
+	assert.Equal(t, `user='postgres' password='it\'s a secret' host='localhost' port='5432' dbname='bankExample' sslmode='disable' connect_timeout='30'`, dsn)
+	assert.Contains(t, DSN(&config.Database{SSLMode: "verify-full"}), "sslmode='verify-full'")
+}
+
+// stubOpen replaces the driver with one that fails the given number of times before connecting,
+// and records the waits between attempts instead of sleeping
+func stubOpen(t *testing.T, failures int) (attempts *int, waits *[]time.Duration) {
+	attempts, waits = new(int), new([]time.Duration)
+
+	originalOpen, originalSleep := open, sleep
+	t.Cleanup(func() { open, sleep = originalOpen, originalSleep })
+
+	open = func(dialect string, dsn string) (*gorm.DB, error) {
+		*attempts++
+		if *attempts <= failures {
+			return nil, mock.Error()
+		}
+		db, _, err := mock.DB()
+		return db, err
+	}
+	sleep = func(d time.Duration) { *waits = append(*waits, d) }
+	return attempts, waits
+}
+
+func TestNew_RetriesWithBackoff(t *testing.T) {
+	attempts, waits := stubOpen(t, 2)
+
+	db, err := New(&config.Database{ConnectAttempts: 3, MaxOpenConns: 4})
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, *attempts)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, *waits)
+	assert.Equal(t, 4, db.DB.DB().Stats().MaxOpenConnections)
+}
+
+func TestNew_GivesUp(t *testing.T) {
+	attempts, _ := stubOpen(t, 10)
+
+	db, err := New(&config.Database{})
+
+	assert.Nil(t, db)
+	assert.ErrorContains(t, err, "after 5 attempts")
+	assert.Equal(t, DefaultConnectAttempts, *attempts)
+}
+
+func TestNew_MissingConfiguration(t *testing.T) {
+	_, err := New(nil)
+
+	assert.Error(t, err)
+}
+
+func TestNew_Drivers(t *testing.T) {
+	db, err := New(&config.Database{Driver: DriverMemory})
+	require.NoError(t, err)
+	defer db.Close()
+
+	// sqlite databases are created from the models, the versioned migrations are postgres only
+	_, err = db.Migrate()
+	require.NoError(t, err)
+	assert.True(t, db.HasTable(&Account{}))
+	_, err = db.Migrator()
+	assert.ErrorIs(t, err, ErrMigrationsUnsupported)
+
+	_, err = New(&config.Database{Driver: DriverSQLite})
+	assert.ErrorContains(t, err, "name of the database file")
+
+	_, err = New(&config.Database{Driver: "oracle"})
+	assert.ErrorContains(t, err, "unknown database driver")
+}
@@ -5,7 +5,36 @@ import (
 )
 
 var (
-	ErrNotFound       = errors.New("not found")
-	ErrParentNotFound = errors.New("parent not found")
-	ErrInvalidType    = errors.New("invalid transaction type")
+	ErrNotFound              = errors.New("not found")
+	ErrParentNotFound        = errors.New("parent not found")
+	ErrInvalidType           = errors.New("invalid transaction type")
+	ErrInvalidAccountType    = errors.New("invalid account type")
+	ErrInvalidHolder         = errors.New("account holder must not be empty")
+	ErrInvalidAmount         = errors.New("invalid amount")
+	ErrCurrencyMismatch      = errors.New("currency mismatch")
+	ErrInvalidCurrency       = errors.New("unsupported currency")
+	ErrSameAccount           = errors.New("cannot transfer to the same account")
+	ErrInsufficientFunds     = errors.New("insufficient funds")
+	ErrImmutable             = errors.New("posted transactions are immutable, post a reversal instead")
+	ErrAlreadyReversed       = errors.New("transaction has already been reversed")
+	ErrReversal              = errors.New("a reversal cannot be reversed")
+	ErrReversalFinal         = errors.New("a reversal and the transaction it reverses can't be changed or deleted")
+	ErrTransferLeg           = errors.New("a transfer leg can't be changed or deleted on its own")
+	ErrUnbalancedEntry       = errors.New("journal entry does not balance")
+	ErrAccountNotOpen        = errors.New("account is not open")
+	ErrInvalidTransition     = errors.New("the account can't change to that status")
+	ErrBalanceNotZero        = errors.New("only accounts with a zero balance can be closed")
+	ErrConflict              = errors.New("record was changed by another request, fetch it and try again")
+	ErrInvalidUsername       = errors.New("username must not be empty")
+	ErrInvalidRole           = errors.New("role must be customer, teller or admin")
+	ErrUsernameTaken         = errors.New("username is already taken")
+	ErrWeakPassword          = errors.New("password is too weak")
+	ErrInvalidCredentials    = errors.New("invalid username or password")
+	ErrInvalidToken          = errors.New("invalid or expired token")
+	ErrInvalidCursor         = errors.New("invalid cursor")
+	ErrInvalidSort           = errors.New("invalid sort field")
+	ErrInvalidPeriod         = errors.New("the end of the period must be after its start")
+	ErrTokenReused           = errors.New("refresh token was already used, every token from this login has been revoked")
+	ErrNoMigration           = errors.New("no migration to roll back")
+	ErrMigrationsUnsupported = errors.New("versioned migrations are only supported on postgres")
 )
@@ -0,0 +1,25 @@
+package database
+
+import (
+	"time"
+)
+
+// MaintenanceFee records that the monthly maintenance fee of a period has been dealt with for an account, so
+// running the fees again for the same period doesn't charge twice. TransactionID is nil when the fee was waived.
+type MaintenanceFee struct {
+	ID            uint `gorm:"primary_key"`
+	CreatedAt     time.Time
+	AccountID     uint   `json:"accountID" gorm:"unique_index:idx_maintenance_fees_period"`
+	Period        string `json:"period" gorm:"unique_index:idx_maintenance_fees_period"` //the month the fee is for, e.g. 2024-01
+	Amount        Money  `json:"amount" gorm:"embedded;embedded_prefix:amount_"`
+	Waived        bool   `json:"waived"`
+	TransactionID *uint  `json:"transactionID,omitempty"`
+}
+
+// FeeRun reports on charging the maintenance fees of a period, accounts that were charged
+// by an earlier run for the same period are counted as skipped
+type FeeRun struct {
+	Period  string           `json:"period"`
+	Charged []MaintenanceFee `json:"charged"`
+	Skipped int              `json:"skipped"`
+}
@@ -0,0 +1,470 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	gorm "github.com/jinzhu/gorm"
+)
+
+// GormStore keeps the banking data in the database gorm is connected to
+type GormStore struct {
+	db *gorm.DB
+}
+
+var _ Store = (*GormStore)(nil)
+
+// create a store on top of a gorm connection, or on top of a database transaction
+func NewGormStore(db *gorm.DB) *GormStore {
+	return &GormStore{db: db}
+}
+
+func (s *GormStore) Accounts() AccountRepository         { return gormAccounts{s.db} }
+func (s *GormStore) Transactions() TransactionRepository { return gormTransactions{s.db} }
+func (s *GormStore) Transfers() TransferRepository       { return gormTransfers{s.db} }
+func (s *GormStore) Journal() JournalRepository          { return gormJournal{s.db} }
+
+func (s *GormStore) InterestPostings() PeriodRepository[InterestPosting] {
+	return gormPeriods[InterestPosting]{s.db}
+}
+
+func (s *GormStore) MaintenanceFees() PeriodRepository[MaintenanceFee] {
+	return gormPeriods[MaintenanceFee]{s.db}
+}
+
+// Atomic runs fn in a database transaction, gorm runs a transaction that is started inside another one as part of it
+func (s *GormStore) Atomic(fn func(store Store) error) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return fn(NewGormStore(tx))
+	})
+}
+
+// Snapshot runs fn in a read-only database transaction. On postgres it is repeatable read, so every query sees
+// the data as it was at the first one. Sqlite transactions are serializable anyway, but the driver begins them all
+// with BEGIN IMMEDIATE, which waits for the writers and then holds them off, so on sqlite a deferred transaction
+// is begun by hand on a connection of its own instead.
+func (s *GormStore) Snapshot(fn func(store Store) error) error {
+	switch s.db.CommonDB().(type) {
+	case *sql.Tx, snapshotConn:
+		return fn(s)
+	}
+
+	if s.db.Dialect().GetName() == "sqlite3" {
+		return s.sqliteSnapshot(fn)
+	}
+
+	tx := s.db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	//nothing was written, so rolling back ends the transaction as well as committing would
+	defer tx.Rollback()
+
+	return fn(NewGormStore(tx))
+}
+
+func (s *GormStore) sqliteSnapshot(fn func(store Store) error) error {
+	ctx := context.Background()
+	conn, err := s.db.DB().Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	//the transaction takes its shared lock at the first read and keeps it, so every read sees the same data
+	if _, err := conn.ExecContext(ctx, "BEGIN DEFERRED"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "ROLLBACK")
+
+	db, err := gorm.Open("sqlite3", snapshotConn{conn})
+	if err != nil {
+		return err
+	}
+
+	return fn(NewGormStore(db))
+}
+
+// snapshotConn runs gorm's queries on the connection a sqlite snapshot was begun on
+type snapshotConn struct {
+	conn *sql.Conn
+}
+
+func (c snapshotConn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.conn.ExecContext(context.Background(), query, args...)
+}
+
+func (c snapshotConn) Prepare(query string) (*sql.Stmt, error) {
+	return c.conn.PrepareContext(context.Background(), query)
+}
+
+func (c snapshotConn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.conn.QueryContext(context.Background(), query, args...)
+}
+
+func (c snapshotConn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.conn.QueryRowContext(context.Background(), query, args...)
+}
+
+// ForUpdate locks the rows a query reads until the database transaction ends. Sqlite has no row locks,
+// its writers take the whole database when their transaction begins, so the query is left as it is.
+func ForUpdate(db *gorm.DB) *gorm.DB {
+	if db.Dialect().GetName() != "postgres" {
+		return db
+	}
+	return db.Set("gorm:query_option", "FOR UPDATE")
+}
+
+// first fetches the row with the given id, a missing row is ErrNotFound
+func first[M any](db *gorm.DB, id uint) (*M, error) {
+	var model M
+	//ids are unique, so we can use First
+	if result := db.First(&model, id); result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+
+		return nil, result.Error
+	}
+
+	return &model, nil
+}
+
+type gormAccounts struct {
+	db *gorm.DB
+}
+
+func (r gormAccounts) Create(account *Account) error {
+	return r.db.Create(account).Error
+}
+
+func (r gormAccounts) FetchById(id uint) (*Account, error) {
+	return first[Account](r.db, id)
+}
+
+func (r gormAccounts) FetchForUpdate(id uint) (*Account, error) {
+	return first[Account](ForUpdate(r.db), id)
+}
+
+func (r gormAccounts) List() (*[]Account, error) {
+	var accounts []Account
+	if result := r.db.Find(&accounts); result.Error != nil {
+		return nil, result.Error
+	}
+	return &accounts, nil
+}
+
+func (r gormAccounts) ListPage(filter AccountFilter, page PageRequest) (*Page[Account], error) {
+	db := r.db
+
+	if filter.OwnerID != nil {
+		db = db.Where("owner_id = ?", *filter.OwnerID)
+	}
+	if filter.AccountType != "" {
+		db = db.Where("account_type = ?", filter.AccountType)
+	}
+	if filter.Status != "" {
+		db = db.Where("status = ?", filter.Status)
+	}
+	if filter.Holder != "" {
+		db = db.Where(`LOWER(account_holder) LIKE ? ESCAPE '\'`, likePattern(filter.Holder))
+	}
+	if filter.CreatedFrom != nil {
+		db = db.Where("accounts.created_at >= ?", *filter.CreatedFrom)
+	}
+	if filter.CreatedTo != nil {
+		db = db.Where("accounts.created_at < ?", *filter.CreatedTo)
+	}
+	if filter.MinBalance != nil {
+		db = db.Where("balance_minor >= ?", *filter.MinBalance)
+	}
+	if filter.MaxBalance != nil {
+		db = db.Where("balance_minor <= ?", *filter.MaxBalance)
+	}
+
+	return paginate(db, "accounts", accountSortKeys, func(a *Account) uint { return a.ID }, page)
+}
+
+func (r gormAccounts) ListOpen(accountTypes []string, openedBefore time.Time) ([]Account, error) {
+	var accounts []Account
+	if resp := r.db.Where("status = ? AND account_type IN (?) AND created_at < ?", AccountOpen, accountTypes, openedBefore).Order("id").Find(&accounts); resp.Error != nil {
+		return nil, resp.Error
+	}
+	return accounts, nil
+}
+
+func (r gormAccounts) UpdateHolder(id uint, holder string, version uint) (bool, error) {
+	db := r.db.Model(&Account{}).Where("id = ?", id)
+	if version != 0 {
+		db = db.Where("version = ?", version)
+	}
+	resp := db.Updates(map[string]interface{}{
+		"account_holder": holder,
+		"version":        gorm.Expr("version + 1"),
+	})
+	return resp.RowsAffected > 0, resp.Error
+}
+
+func (r gormAccounts) UpdateBalance(account *Account) error {
+	resp := r.db.Model(&Account{}).Where("id = ? AND version = ?", account.ID, account.Version).Updates(map[string]interface{}{
+		"balance_minor":    account.Balance.Minor,
+		"balance_currency": account.Balance.Currency,
+		"version":          account.Version + 1,
+	})
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if resp.RowsAffected == 0 {
+		return ErrConflict
+	}
+
+	account.Version++
+	return nil
+}
+
+func (r gormAccounts) UpdateStatus(account *Account, status string) error {
+	//compare and swap on the version column
+	readVersion := account.Version
+	resp := r.db.Model(account).Where("version = ?", readVersion).Updates(map[string]interface{}{
+		"status":  status,
+		"version": readVersion + 1,
+	})
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if resp.RowsAffected == 0 {
+		return ErrConflict
+	}
+
+	account.Status = status
+	account.Version = readVersion + 1
+	return nil
+}
+
+func (r gormAccounts) MarkDormant(inactiveSince time.Time) (int64, error) {
+	resp := r.db.Model(&Account{}).
+		Where("status = ? AND updated_at < ?", AccountOpen, inactiveSince).
+		Updates(map[string]interface{}{"status": AccountDormant, "version": gorm.Expr("version + 1")})
+	return resp.RowsAffected, resp.Error
+}
+
+type gormTransactions struct {
+	db *gorm.DB
+}
+
+func (r gormTransactions) Create(transaction *Transaction) error {
+	return r.db.Create(transaction).Error
+}
+
+func (r gormTransactions) FetchById(id uint) (*Transaction, error) {
+	return first[Transaction](r.db, id)
+}
+
+func (r gormTransactions) List() (*[]Transaction, error) {
+	return r.list(nil)
+}
+
+func (r gormTransactions) ListByAccount(accountID uint) (*[]Transaction, error) {
+	criteria := Transaction{AccountID: accountID}
+	return r.list(&criteria)
+}
+
+func (r gormTransactions) list(criteria *Transaction) (*[]Transaction, error) {
+	db := r.db.Preload("Account") //preloads the account object
+
+	if criteria != nil {
+		db = db.Where(criteria)
+	}
+
+	var result []Transaction
+
+	if resp := db.Find(&result); resp.Error != nil {
+		return nil, resp.Error
+	}
+
+	return &result, nil
+}
+
+func (r gormTransactions) ListPage(filter TransactionFilter, page PageRequest) (*Page[Transaction], error) {
+	db := r.db.Preload("Account") //preloads the account object
+
+	if filter.OwnerID != nil {
+		owned := r.db.Model(&Account{}).Select("id").Where("owner_id = ?", *filter.OwnerID).SubQuery()
+		db = db.Where("account_id IN ?", owned)
+	}
+	if filter.AccountID != nil {
+		db = db.Where("account_id = ?", *filter.AccountID)
+	}
+	if filter.Type != "" {
+		db = db.Where("type = ?", filter.Type)
+	}
+	if filter.CreatedFrom != nil {
+		db = db.Where("transactions.created_at >= ?", *filter.CreatedFrom)
+	}
+	if filter.CreatedTo != nil {
+		db = db.Where("transactions.created_at < ?", *filter.CreatedTo)
+	}
+	if filter.MinAmount != nil {
+		db = db.Where("amount_minor >= ?", *filter.MinAmount)
+	}
+	if filter.MaxAmount != nil {
+		db = db.Where("amount_minor <= ?", *filter.MaxAmount)
+	}
+
+	return paginate(db, "transactions", transactionSortKeys, func(t *Transaction) uint { return t.ID }, page)
+}
+
+func (r gormTransactions) Since(accountID uint, from time.Time) ([]Transaction, error) {
+	var transactions []Transaction
+	if result := r.db.Where("account_id = ? AND created_at >= ?", accountID, from).Order("created_at, id").Find(&transactions); result.Error != nil {
+		return nil, result.Error
+	}
+	return transactions, nil
+}
+
+func (r gormTransactions) Fees(id uint) ([]Transaction, error) {
+	var fees []Transaction
+	if result := r.db.Where("fee_for_id = ?", id).Order("id").Find(&fees); result.Error != nil {
+		return nil, result.Error
+	}
+	return fees, nil
+}
+
+func (r gormTransactions) IsReversed(id uint) (bool, error) {
+	var reversals int
+	if result := r.db.Model(&Transaction{}).Where("reversal_of_id = ?", id).Count(&reversals); result.Error != nil {
+		return false, result.Error
+	}
+	return reversals > 0, nil
+}
+
+func (r gormTransactions) Save(transaction *Transaction) error {
+	return r.db.Save(transaction).Error
+}
+
+func (r gormTransactions) Delete(transaction *Transaction) error {
+	return r.db.Delete(transaction).Error
+}
+
+type gormTransfers struct {
+	db *gorm.DB
+}
+
+func (r gormTransfers) Create(transfer *Transfer) error {
+	return r.db.Create(transfer).Error
+}
+
+func (r gormTransfers) FetchById(id uint) (*Transfer, error) {
+	return first[Transfer](r.db.Preload("Transactions"), id)
+}
+
+type gormJournal struct {
+	db *gorm.DB
+}
+
+func (r gormJournal) Create(entry *JournalEntry) error {
+	//gorm creates the postings along with the entry
+	return r.db.Create(entry).Error
+}
+
+func (r gormJournal) Sum(accountID uint, currency string) (int64, error) {
+	var total struct {
+		Sum int64
+	}
+
+	result := r.db.Model(&Posting{}).
+		Select("COALESCE(SUM(amount_minor), 0) AS sum").
+		Where("account_id = ? AND amount_currency = ?", accountID, currency).
+		Scan(&total)
+	return total.Sum, result.Error
+}
+
+func (r gormJournal) Unbalanced() ([]EntryImbalance, error) {
+	var unbalanced []EntryImbalance
+
+	result := r.db.Model(&Posting{}).
+		Select("journal_entry_id, amount_currency, SUM(amount_minor) AS total").
+		Group("journal_entry_id, amount_currency").
+		Having("SUM(amount_minor) <> 0").
+		Scan(&unbalanced)
+	return unbalanced, result.Error
+}
+
+type gormPeriods[R PeriodRecord] struct {
+	db *gorm.DB
+}
+
+func (r gormPeriods[R]) Recorded(accountID uint, period string) (bool, error) {
+	var records int
+	if resp := r.db.Model(new(R)).Where("account_id = ? AND period = ?", accountID, period).Count(&records); resp.Error != nil {
+		return false, resp.Error
+	}
+	return records > 0, nil
+}
+
+// the record goes in before the work it records, a concurrent run for the same period fails on its unique index
+func (r gormPeriods[R]) Create(record *R) error {
+	return r.db.Create(record).Error
+}
+
+func (r gormPeriods[R]) Save(record *R) error {
+	return r.db.Save(record).Error
+}
+
+// paginate fetches one page of the query. Pages are keyed on the sort column and the id rather than an offset,
+// so rows that are added or removed while a client pages through the list don't shift the later pages.
+func paginate[M any](db *gorm.DB, table string, keys map[string]sortKey[M], id func(row *M) uint, page PageRequest) (*Page[M], error) {
+	key, err := sortKeyFor(keys, page)
+	if err != nil {
+		return nil, err
+	}
+	limit := pageLimit(page)
+
+	direction, after := "ASC", ">"
+	if page.Desc {
+		direction, after = "DESC", "<"
+	}
+
+	idColumn := table + ".id"
+	column := table + "." + key.column
+	order := column + " " + direction
+	if column != idColumn {
+		order += ", " + idColumn + " " + direction
+	}
+
+	if page.Cursor != "" {
+		c, err := decodeCursor(page.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		if column == idColumn {
+			db = db.Where(fmt.Sprintf("%s %s ?", idColumn, after), c.ID)
+		} else {
+			//sqlite compares times as text, so the value is passed as the type of the column
+			value, err := key.parse(c.Value)
+			if err != nil {
+				return nil, ErrInvalidCursor
+			}
+			db = db.Where(fmt.Sprintf("%s %s ? OR (%s = ? AND %s %s ?)", column, after, column, idColumn, after), value, value, c.ID)
+		}
+	}
+
+	//one extra row tells us whether there is another page
+	rows := make([]M, 0)
+	if result := db.Order(order).Limit(limit + 1).Find(&rows); result.Error != nil {
+		return nil, result.Error
+	}
+
+	return nextPage(rows, key, id, limit), nil
+}
+
+// likePattern matches values containing s, the LIKE wildcards in s are matched literally
+func likePattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(s))
+	return "%" + s + "%"
+}
@@ -0,0 +1,343 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	config "github.com/jobullo/go-api-example/config"
+	"github.com/jobullo/go-api-example/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// GormStoreSuite checks the SQL the gorm store sends, what the repositories do is tested through the services
+type GormStoreSuite struct {
+	suite.Suite
+	assert  *assert.Assertions
+	sqlmock sqlmock.Sqlmock
+	store   *GormStore
+}
+
+func TestGormStoreSuite(t *testing.T) {
+	suite.Run(t, new(GormStoreSuite))
+}
+
+func (gs *GormStoreSuite) SetupTest() {
+	t := gs.T()
+
+	db, sql, err := mock.DB()
+	require.NoError(t, err)
+
+	gs.assert = assert.New(t)
+	gs.sqlmock = sql
+	gs.store = NewGormStore(db)
+}
+
+func (gs *GormStoreSuite) TestAccounts_Create() {
+	account := &Account{AccountHolder: "Foo Bar", AccountType: "savings", Balance: NewMoney(10000, "USD"), Version: 1, Status: AccountOpen}
+
+	gs.sqlmock.ExpectBegin()
+	gs.sqlmock.ExpectQuery(`(?i)INSERT INTO "accounts" \("created_at","updated_at","deleted_at","account_holder","account_type","balance_minor","balance_currency","version","owner_id","status"\) VALUES \(\$1,\$2,\$3,\$4,\$5,\$6,\$7,\$8,\$9,\$10\) RETURNING "accounts"\."id"`).
+		WithArgs(mock.Any{}, mock.Any{}, mock.Any{}, "Foo Bar", "savings", int64(10000), "USD", 1, nil, "open").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	gs.sqlmock.ExpectCommit()
+
+	gs.assert.NoError(gs.store.Accounts().Create(account))
+	gs.assert.Equal(uint(1), account.ID)
+	gs.assert.NoError(gs.sqlmock.ExpectationsWereMet())
+}
+
+func (gs *GormStoreSuite) TestAccounts_FetchById() {
+	gs.sqlmock.ExpectQuery(`(?i)SELECT\s+\*\s+FROM\s+"accounts"\s+WHERE\s+"accounts"\."deleted_at"\s+IS\s+NULL\s+AND\s+\(\("accounts"\."id"\s+=\s+7\)\)\s+ORDER\s+BY\s+"accounts"\."id"\s+ASC\s+LIMIT\s+1$`).
+		WillReturnRows(accountRows().AddRow(7, time.Now(), time.Now(), nil, "Foo Bar", "savings", 10000, "USD", 1, AccountOpen))
+
+	account, err := gs.store.Accounts().FetchById(7)
+
+	if gs.assert.NoError(err) {
+		gs.assert.Equal(NewMoney(10000, "USD"), account.Balance)
+		gs.assert.NoError(gs.sqlmock.ExpectationsWereMet())
+	}
+}
+
+func (gs *GormStoreSuite) TestAccounts_FetchById_NotFound() {
+	gs.sqlmock.ExpectQuery(`^SELECT \* FROM "accounts"`).WillReturnRows(accountRows())
+
+	_, err := gs.store.Accounts().FetchById(7)
+
+	gs.assert.ErrorIs(err, ErrNotFound)
+	gs.assert.NoError(gs.sqlmock.ExpectationsWereMet())
+}
+
+func (gs *GormStoreSuite) TestAccounts_FetchForUpdate() {
+	// postgres locks the row until the database transaction ends
+	gs.sqlmock.ExpectQuery(`^SELECT (.+) FROM "accounts" (.+)"accounts"."id" = 7(.+) LIMIT 1 FOR UPDATE$`).
+		WillReturnRows(accountRows().AddRow(7, time.Now(), time.Now(), nil, "Foo Bar", "savings", 10000, "USD", 1, AccountOpen))
+
+	_, err := gs.store.Accounts().FetchForUpdate(7)
+
+	gs.assert.NoError(err)
+	gs.assert.NoError(gs.sqlmock.ExpectationsWereMet())
+}
+
+func (gs *GormStoreSuite) TestSnapshot() {
+	// a read-only transaction without row locks
+	gs.sqlmock.ExpectBegin()
+	gs.sqlmock.ExpectQuery(`^SELECT (.+) FROM "accounts" (.+)"accounts"."id" = 7(.+) LIMIT 1$`).
+		WillReturnRows(accountRows().AddRow(7, time.Now(), time.Now(), nil, "Foo Bar", "savings", 10000, "USD", 1, AccountOpen))
+	gs.sqlmock.ExpectRollback()
+
+	err := gs.store.Snapshot(func(store Store) error {
+		_, err := store.Accounts().FetchById(7)
+		return err
+	})
+
+	gs.assert.NoError(err)
+	gs.assert.NoError(gs.sqlmock.ExpectationsWereMet())
+}
+
+func (gs *GormStoreSuite) TestSnapshot_InAtomic() {
+	// the snapshot is read in the transaction that is already open
+	gs.sqlmock.ExpectBegin()
+	gs.sqlmock.ExpectQuery(`^SELECT (.+) FROM "accounts"`).
+		WillReturnRows(accountRows().AddRow(7, time.Now(), time.Now(), nil, "Foo Bar", "savings", 10000, "USD", 1, AccountOpen))
+	gs.sqlmock.ExpectCommit()
+
+	err := gs.store.Atomic(func(store Store) error {
+		return store.Snapshot(func(store Store) error {
+			_, err := store.Accounts().FetchById(7)
+			return err
+		})
+	})
+
+	gs.assert.NoError(err)
+	gs.assert.NoError(gs.sqlmock.ExpectationsWereMet())
+}
+
+func (gs *GormStoreSuite) TestAccounts_UpdateHolder() {
+	// without a version the latest version is updated
+	gs.sqlmock.ExpectBegin()
+	gs.sqlmock.ExpectExec(`^UPDATE "accounts" SET "account_holder" = \$1, "updated_at" = \$2, "version" = version \+ 1 +WHERE (.+) AND \(\(id = \$3\)\)$`).
+		WithArgs("Foo Bar", mock.Any{}, 7).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	gs.sqlmock.ExpectCommit()
+
+	// with one the write is a compare and swap
+	gs.sqlmock.ExpectBegin()
+	gs.sqlmock.ExpectExec(`^UPDATE "accounts" SET (.+) AND \(\(id = \$3\) AND \(version = \$4\)\)$`).
+		WithArgs("Foo Bar", mock.Any{}, 7, 2).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	gs.sqlmock.ExpectCommit()
+
+	updated, err := gs.store.Accounts().UpdateHolder(7, "Foo Bar", 0)
+	gs.assert.NoError(err)
+	gs.assert.True(updated)
+
+	updated, err = gs.store.Accounts().UpdateHolder(7, "Foo Bar", 2)
+	gs.assert.NoError(err)
+	gs.assert.False(updated)
+
+	gs.assert.NoError(gs.sqlmock.ExpectationsWereMet())
+}
+
+func (gs *GormStoreSuite) TestAccounts_UpdateBalance() {
+	account := &Account{Balance: NewMoney(7000, "USD"), Version: 1}
+	account.ID = 7
+
+	gs.sqlmock.ExpectBegin()
+	gs.sqlmock.ExpectExec(`^UPDATE "accounts" SET "balance_currency" = \$1, "balance_minor" = \$2, "updated_at" = \$3, "version" = \$4 +WHERE (.+) AND \(\(id = \$5 AND version = \$6\)\)$`).
+		WithArgs("USD", int64(7000), mock.Any{}, 2, 7, 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	gs.sqlmock.ExpectCommit()
+
+	// the account moved on, so the second write matches no rows
+	gs.sqlmock.ExpectBegin()
+	gs.sqlmock.ExpectExec(`^UPDATE "accounts"`).
+		WithArgs("USD", int64(7000), mock.Any{}, 3, 7, 2).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	gs.sqlmock.ExpectCommit()
+
+	gs.assert.NoError(gs.store.Accounts().UpdateBalance(account))
+	gs.assert.Equal(uint(2), account.Version)
+	gs.assert.ErrorIs(gs.store.Accounts().UpdateBalance(account), ErrConflict)
+	gs.assert.Equal(uint(2), account.Version)
+	gs.assert.NoError(gs.sqlmock.ExpectationsWereMet())
+}
+
+func (gs *GormStoreSuite) TestAccounts_ListPage_Filters() {
+	rows := accountRows().
+		AddRow(1, time.Now(), time.Now(), nil, "Foo Bar", "savings", 10000, "USD", 1, AccountOpen).
+		AddRow(2, time.Now(), time.Now(), nil, "Foo Baz", "savings", 20000, "USD", 1, AccountOpen)
+
+	// one more row than the limit is fetched to find out if there is a next page
+	gs.sqlmock.ExpectQuery(`^SELECT \* FROM "accounts" WHERE "accounts"."deleted_at" IS NULL AND \(\(owner_id = \$1\) AND \(account_type = \$2\) AND \(LOWER\(account_holder\) LIKE \$3 ESCAPE '\\'\) AND \(balance_minor >= \$4\)\) ORDER BY accounts.id ASC LIMIT 2$`).
+		WithArgs(7, "savings", `%foo\_%`, 5000).
+		WillReturnRows(rows)
+
+	ownerID, minBalance := uint(7), int64(5000)
+	page, err := gs.store.Accounts().ListPage(
+		AccountFilter{OwnerID: &ownerID, AccountType: "savings", Holder: "Foo_", MinBalance: &minBalance},
+		PageRequest{Limit: 1},
+	)
+
+	if gs.assert.NoError(err) {
+		gs.assert.NoError(gs.sqlmock.ExpectationsWereMet())
+		gs.assert.Len(page.Items, 1)
+		gs.assert.Equal(encodeCursor(cursor{Value: "1", ID: 1}), page.NextCursor)
+	}
+}
+
+func (gs *GormStoreSuite) TestAccounts_ListPage_Cursor() {
+	// continues after the balance and id in the cursor
+	gs.sqlmock.ExpectQuery(`^SELECT \* FROM "accounts" WHERE "accounts"."deleted_at" IS NULL AND \(\(accounts.balance_minor < \$1 OR \(accounts.balance_minor = \$2 AND accounts.id < \$3\)\)\) ORDER BY accounts.balance_minor DESC, accounts.id DESC LIMIT 51$`).
+		WithArgs(int64(2500), int64(2500), 3).
+		WillReturnRows(accountRows().AddRow(2, time.Now(), time.Now(), nil, "Foo Bar", "savings", 2000, "USD", 1, AccountOpen))
+
+	page, err := gs.store.Accounts().ListPage(AccountFilter{}, PageRequest{
+		Cursor: encodeCursor(cursor{Value: "2500", ID: 3}),
+		Sort:   "balance",
+		Desc:   true,
+	})
+
+	if gs.assert.NoError(err) {
+		gs.assert.NoError(gs.sqlmock.ExpectationsWereMet())
+		gs.assert.Len(page.Items, 1)
+		gs.assert.Empty(page.NextCursor)
+	}
+}
+
+func (gs *GormStoreSuite) TestAccounts_ListPage_InvalidRequest() {
+	for _, request := range []PageRequest{
+		{Sort: "password"},
+		{Cursor: "not a cursor"},
+		{Cursor: encodeCursor(cursor{Value: "lots", ID: 3}), Sort: "balance"},
+	} {
+		_, err := gs.store.Accounts().ListPage(AccountFilter{}, request)
+		gs.assert.Error(err)
+	}
+
+	// nothing is read
+	gs.assert.NoError(gs.sqlmock.ExpectationsWereMet())
+}
+
+func (gs *GormStoreSuite) TestTransactions_ListPage() {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ownerID := uint(7)
+
+	gs.sqlmock.ExpectQuery(`^SELECT \* FROM "transactions" WHERE "transactions"."deleted_at" IS NULL AND \(\(account_id IN \(SELECT id FROM "accounts" WHERE "accounts"."deleted_at" IS NULL AND \(\(owner_id = \$1\)\)\)\) AND \(type = \$2\) AND \(transactions.created_at >= \$3\)\) ORDER BY transactions.created_at DESC, transactions.id DESC LIMIT 11$`).
+		WithArgs(7, "deposit", from).
+		WillReturnRows(transactionRows().AddRow(4, time.Now(), time.Now(), nil, 1, "deposit", 2500, "USD", nil, nil, 1))
+	// the accounts of the page are preloaded
+	gs.sqlmock.ExpectQuery(`^SELECT \* FROM "accounts" WHERE (.+)\("id" IN \(\$1\)\)`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "account_holder"}).AddRow(1, "Foo Bar"))
+
+	page, err := gs.store.Transactions().ListPage(
+		TransactionFilter{OwnerID: &ownerID, Type: "deposit", CreatedFrom: &from},
+		PageRequest{Limit: 10, Sort: "created_at", Desc: true},
+	)
+
+	if gs.assert.NoError(err) {
+		gs.assert.NoError(gs.sqlmock.ExpectationsWereMet())
+		if gs.assert.Len(page.Items, 1) {
+			gs.assert.Equal(uint(1), page.Items[0].Account.ID)
+		}
+		gs.assert.Empty(page.NextCursor)
+	}
+}
+
+func (gs *GormStoreSuite) TestTransactions_Since() {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	gs.sqlmock.ExpectQuery(`^SELECT \* FROM "transactions" WHERE (.+)account_id = \$1 AND created_at >= \$2(.+) ORDER BY created_at, id$`).
+		WithArgs(1, from).
+		WillReturnRows(transactionRows())
+
+	_, err := gs.store.Transactions().Since(1, from)
+
+	gs.assert.NoError(err)
+	gs.assert.NoError(gs.sqlmock.ExpectationsWereMet())
+}
+
+func (gs *GormStoreSuite) TestTransactions_IsReversed() {
+	gs.sqlmock.ExpectQuery(`^SELECT count\(\*\) FROM "transactions" WHERE (.+)\(\(reversal_of_id = \$1\)\)$`).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	reversed, err := gs.store.Transactions().IsReversed(5)
+
+	if gs.assert.NoError(err) {
+		gs.assert.True(reversed)
+		gs.assert.NoError(gs.sqlmock.ExpectationsWereMet())
+	}
+}
+
+func (gs *GormStoreSuite) TestJournal_Unbalanced() {
+	gs.sqlmock.ExpectQuery(`^SELECT journal_entry_id, amount_currency, SUM\(amount_minor\) AS total FROM "postings" (.+) GROUP BY journal_entry_id, amount_currency HAVING \(SUM\(amount_minor\) <> 0\)$`).
+		WillReturnRows(sqlmock.NewRows([]string{"journal_entry_id", "amount_currency", "total"}).AddRow(4, "USD", 1))
+
+	unbalanced, err := gs.store.Journal().Unbalanced()
+
+	if gs.assert.NoError(err) {
+		gs.assert.Equal([]EntryImbalance{{JournalEntryID: 4, AmountCurrency: "USD", Total: 1}}, unbalanced)
+		gs.assert.NoError(gs.sqlmock.ExpectationsWereMet())
+	}
+}
+
+func TestGormStore_SnapshotOnSQLite(t *testing.T) {
+	db, err := New(&config.Database{Driver: DriverSQLite, Database: filepath.Join(t.TempDir(), "bank.db")})
+	require.NoError(t, err)
+	defer db.Close()
+	_, err = db.Migrate()
+	require.NoError(t, err)
+
+	store := db.Store()
+	account := &Account{AccountHolder: "Foo Bar", AccountType: "savings", Balance: NewMoney(100, "USD"), Version: 1, Status: AccountOpen}
+	require.NoError(t, store.Accounts().Create(account))
+
+	// a writer has changed the balance and not committed yet
+	written, release, done := make(chan struct{}), make(chan struct{}), make(chan error)
+	go func() {
+		done <- store.Atomic(func(store Store) error {
+			updated := *account
+			updated.Balance = NewMoney(200, "USD")
+			if err := store.Accounts().UpdateBalance(&updated); err != nil {
+				return err
+			}
+			close(written)
+			<-release
+			return nil
+		})
+	}()
+	<-written
+
+	// the snapshot doesn't wait for the writer, it reads the committed balance
+	err = store.Snapshot(func(store Store) error {
+		stored, err := store.Accounts().FetchById(account.ID)
+		if err == nil {
+			assert.Equal(t, NewMoney(100, "USD"), stored.Balance)
+		}
+		return err
+	})
+	assert.NoError(t, err)
+
+	// and the writer commits once the snapshot is over
+	close(release)
+	require.NoError(t, <-done)
+	stored, err := store.Accounts().FetchById(account.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, NewMoney(200, "USD"), stored.Balance)
+	}
+}
+
+// creates the rows object for the "accounts" table
+func accountRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "account_holder", "account_type", "balance_minor", "balance_currency", "version", "status"})
+}
+
+// creates the rows object for the "transactions" table
+func transactionRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "account_id", "type", "amount_minor", "amount_currency", "transfer_id", "reversal_of_id", "journal_entry_id"})
+}
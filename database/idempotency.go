@@ -0,0 +1,22 @@
+package database
+
+import (
+	"time"
+)
+
+// IdempotencyKey stores the response to a request made with an Idempotency-Key header so retries can be replayed.
+// A record with a zero StatusCode is a reservation for a request that is still being processed.
+// Keys are scoped to the user that sent them, so one user's response is never replayed to another.
+type IdempotencyKey struct {
+	ID           uint `gorm:"primary_key"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Key          string `gorm:"unique_index:idx_idempotency_keys_scope"`
+	Method       string `gorm:"unique_index:idx_idempotency_keys_scope"`
+	Path         string `gorm:"unique_index:idx_idempotency_keys_scope"`
+	UserID       uint   `gorm:"not null;default:0;unique_index:idx_idempotency_keys_scope"`
+	RequestHash  string
+	StatusCode   int
+	ContentType  string
+	ResponseBody []byte
+}
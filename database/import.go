@@ -0,0 +1,17 @@
+package database
+
+// ImportResult reports on a bulk import. Rows are only saved when every row is valid and it isn't a dry run,
+// so Imported is either zero or the number of rows.
+type ImportResult struct {
+	DryRun   bool          `json:"dryRun"`
+	Rows     int           `json:"rows"`
+	Imported int           `json:"imported"`
+	Errors   []ImportError `json:"errors"`
+}
+
+// ImportError is a problem with one row of an import, Line counts the header as line 1
+type ImportError struct {
+	Line    int    `json:"line"`
+	Column  string `json:"column,omitempty"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,24 @@
+package database
+
+import (
+	"time"
+)
+
+// InterestPosting records that interest for a period has been paid on an account, so running the posting
+// again for the same period doesn't pay it twice. TransactionID is nil when nothing was earned.
+type InterestPosting struct {
+	ID            uint `gorm:"primary_key"`
+	CreatedAt     time.Time
+	AccountID     uint   `json:"accountID" gorm:"unique_index:idx_interest_postings_period"`
+	Period        string `json:"period" gorm:"unique_index:idx_interest_postings_period"` //the month interest was earned in, e.g. 2024-01
+	Amount        Money  `json:"amount" gorm:"embedded;embedded_prefix:amount_"`
+	TransactionID *uint  `json:"transactionID,omitempty"`
+}
+
+// InterestRun reports on posting the interest of a period, accounts that were paid
+// by an earlier run for the same period are counted as skipped
+type InterestRun struct {
+	Period  string            `json:"period"`
+	Posted  []InterestPosting `json:"posted"`
+	Skipped int               `json:"skipped"`
+}
@@ -0,0 +1,29 @@
+package database
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// JournalEntry is one balanced business event in the double-entry ledger, its postings always sum to zero
+type JournalEntry struct {
+	gorm.Model            //leaving this ananymous field here so gorm:embedded tag isn't necessary
+	Description string    `json:"description"`
+	Postings    []Posting `json:"postings" gorm:"foreignKey:JournalEntryID"`
+}
+
+// Posting debits (positive amount) or credits (negative amount) one ledger account.
+// Customer accounts are referenced by AccountID, internal accounts such as cash by SystemAccount.
+type Posting struct {
+	gorm.Model            //leaving this ananymous field here so gorm:embedded tag isn't necessary
+	JournalEntryID uint   `json:"journalEntryID" gorm:"index"`
+	AccountID      *uint  `json:"accountID,omitempty" gorm:"index"`
+	SystemAccount  string `json:"systemAccount,omitempty"`
+	Amount         Money  `json:"amount" gorm:"embedded;embedded_prefix:amount_"`
+}
+
+// EntryImbalance is the total of the postings of a journal entry in one currency that doesn't sum to zero
+type EntryImbalance struct {
+	JournalEntryID uint
+	AmountCurrency string
+	Total          int64
+}
@@ -0,0 +1,751 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the banking data in maps, for tests and for running the api without a database.
+// Atomic calls run one at a time on a copy of the data that replaces it when they succeed, so a failed
+// call leaves nothing behind. Copying the data makes every Atomic call as slow as the store is big.
+type MemoryStore struct {
+	mu       *sync.RWMutex
+	data     *memoryData
+	atomic   bool //set on the store an Atomic or Snapshot call passes on, which already holds the lock
+	readOnly bool //set on the store a Snapshot call passes on, which only holds the lock for reading
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// create an empty memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{mu: &sync.RWMutex{}, data: &memoryData{}}
+}
+
+// memoryData holds the rows of every table by id
+type memoryData struct {
+	accounts         table[Account]
+	transactions     table[Transaction]
+	transfers        table[Transfer]
+	journalEntries   table[JournalEntry]
+	postings         table[Posting]
+	interestPostings table[InterestPosting]
+	maintenanceFees  table[MaintenanceFee]
+}
+
+func (d *memoryData) clone() *memoryData {
+	return &memoryData{
+		accounts:         d.accounts.clone(),
+		transactions:     d.transactions.clone(),
+		transfers:        d.transfers.clone(),
+		journalEntries:   d.journalEntries.clone(),
+		postings:         d.postings.clone(),
+		interestPostings: d.interestPostings.clone(),
+		maintenanceFees:  d.maintenanceFees.clone(),
+	}
+}
+
+// table holds the rows of one model. Rows are copied on the way in and out, so the pointers in them
+// are never shared with callers and a clone of the table can share them with the original.
+type table[M any] struct {
+	rows   map[uint]M
+	lastID uint
+}
+
+func (t table[M]) clone() table[M] {
+	rows := make(map[uint]M, len(t.rows))
+	for id, row := range t.rows {
+		rows[id] = row
+	}
+	return table[M]{rows: rows, lastID: t.lastID}
+}
+
+// insert stores a new row under the next id and returns the id
+func (t *table[M]) insert(row func(id uint) M) uint {
+	if t.rows == nil {
+		t.rows = map[uint]M{}
+	}
+	t.lastID++
+	t.rows[t.lastID] = row(t.lastID)
+	return t.lastID
+}
+
+// sorted returns the rows that match by id
+func (t table[M]) sorted(match func(row *M) bool) []M {
+	ids := make([]uint, 0, len(t.rows))
+	for id := range t.rows {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	rows := make([]M, 0)
+	for _, id := range ids {
+		row := t.rows[id]
+		if match(&row) {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// read runs fn on the data, the lock is shared with other readers
+func (s *MemoryStore) read(fn func(d *memoryData) error) error {
+	if !s.atomic {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	}
+	return fn(s.data)
+}
+
+// returned for a write to the store passed to a Snapshot call
+var errReadOnly = errors.New("the store of a snapshot is read-only")
+
+// write runs fn on the data, which must check everything before it changes anything
+func (s *MemoryStore) write(fn func(d *memoryData) error) error {
+	if s.readOnly {
+		return errReadOnly
+	}
+	if !s.atomic {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	return fn(s.data)
+}
+
+func (s *MemoryStore) Accounts() AccountRepository         { return memoryAccounts{s} }
+func (s *MemoryStore) Transactions() TransactionRepository { return memoryTransactions{s} }
+func (s *MemoryStore) Transfers() TransferRepository       { return memoryTransfers{s} }
+func (s *MemoryStore) Journal() JournalRepository          { return memoryJournal{s} }
+
+func (s *MemoryStore) InterestPostings() PeriodRepository[InterestPosting] {
+	return memoryPeriods[InterestPosting]{
+		store: s,
+		table: func(d *memoryData) *table[InterestPosting] { return &d.interestPostings },
+		key: func(p *InterestPosting) (*uint, uint, string, *time.Time) {
+			return &p.ID, p.AccountID, p.Period, &p.CreatedAt
+		},
+		copy: func(p InterestPosting) InterestPosting {
+			p.TransactionID = copyID(p.TransactionID)
+			return p
+		},
+	}
+}
+
+func (s *MemoryStore) MaintenanceFees() PeriodRepository[MaintenanceFee] {
+	return memoryPeriods[MaintenanceFee]{
+		store: s,
+		table: func(d *memoryData) *table[MaintenanceFee] { return &d.maintenanceFees },
+		key: func(f *MaintenanceFee) (*uint, uint, string, *time.Time) {
+			return &f.ID, f.AccountID, f.Period, &f.CreatedAt
+		},
+		copy: func(f MaintenanceFee) MaintenanceFee {
+			f.TransactionID = copyID(f.TransactionID)
+			return f
+		},
+	}
+}
+
+// Atomic holds the lock while fn runs, so Atomic calls behave as if they ran one after another
+func (s *MemoryStore) Atomic(fn func(store Store) error) error {
+	if s.atomic {
+		return fn(s)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &MemoryStore{mu: s.mu, data: s.data.clone(), atomic: true}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	s.data = tx.data
+	return nil
+}
+
+// Snapshot holds the lock for reading while fn runs, so no Atomic call changes the data in the meantime
+func (s *MemoryStore) Snapshot(fn func(store Store) error) error {
+	if s.atomic {
+		return fn(s)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return fn(&MemoryStore{mu: s.mu, data: s.data, atomic: true, readOnly: true})
+}
+
+func copyID(id *uint) *uint {
+	if id == nil {
+		return nil
+	}
+	copied := *id
+	return &copied
+}
+
+// the stored copy of a model leaves out the models loaded along with it
+func copyAccount(a Account) Account {
+	a.OwnerID = copyID(a.OwnerID)
+	a.Transactions = nil
+	return a
+}
+
+func copyTransaction(t Transaction) Transaction {
+	t.Account = nil
+	t.TransferID = copyID(t.TransferID)
+	t.ReversalOfID = copyID(t.ReversalOfID)
+	t.JournalEntryID = copyID(t.JournalEntryID)
+	t.FeeForID = copyID(t.FeeForID)
+	t.Fees = nil
+	return t
+}
+
+// timestamps sets the times of a new row like gorm does, a creation time that is already set is kept
+func timestamps(createdAt *time.Time, updatedAt *time.Time) {
+	now := time.Now()
+	if createdAt.IsZero() {
+		*createdAt = now
+	}
+	if updatedAt != nil && updatedAt.IsZero() {
+		*updatedAt = now
+	}
+}
+
+func fetch[M any](rows map[uint]M, id uint, deleted func(row *M) bool) (*M, error) {
+	row, ok := rows[id]
+	if !ok || deleted(&row) {
+		return nil, ErrNotFound
+	}
+	return &row, nil
+}
+
+// page sorts the rows that match a page request and cuts out the page, the same way paginate does in SQL
+func page[M any](rows []M, keys map[string]sortKey[M], id func(row *M) uint, request PageRequest) (*Page[M], error) {
+	key, err := sortKeyFor(keys, request)
+	if err != nil {
+		return nil, err
+	}
+	limit := pageLimit(request)
+
+	//rows are ordered on the sort key, then on the id
+	compare := func(a *M, value interface{}, aID uint, bID uint) int {
+		if c := compareValues(key.field(a), value); c != 0 {
+			return c
+		}
+		return compareValues(aID, bID)
+	}
+	direction := 1
+	if request.Desc {
+		direction = -1
+	}
+	slices.SortStableFunc(rows, func(a, b M) int {
+		return direction * compare(&a, key.field(&b), id(&a), id(&b))
+	})
+
+	if request.Cursor != "" {
+		c, err := decodeCursor(request.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		value := interface{}(c.ID)
+		if key.column != "id" {
+			if value, err = key.parse(c.Value); err != nil {
+				return nil, ErrInvalidCursor
+			}
+		}
+
+		start := len(rows)
+		for i := range rows {
+			if direction*compare(&rows[i], value, id(&rows[i]), c.ID) > 0 {
+				start = i
+				break
+			}
+		}
+		rows = rows[start:]
+	}
+
+	if len(rows) > limit+1 {
+		rows = rows[:limit+1]
+	}
+	return nextPage(rows, key, id, limit), nil
+}
+
+// between reports whether t is within an optional range that includes from and excludes to
+func between(t time.Time, from *time.Time, to *time.Time) bool {
+	return (from == nil || !t.Before(*from)) && (to == nil || t.Before(*to))
+}
+
+// within reports whether v is within an optional range that includes both ends
+func within(v int64, least *int64, most *int64) bool {
+	return (least == nil || v >= *least) && (most == nil || v <= *most)
+}
+
+type memoryAccounts struct {
+	store *MemoryStore
+}
+
+func (r memoryAccounts) Create(account *Account) error {
+	return r.store.write(func(d *memoryData) error {
+		timestamps(&account.CreatedAt, &account.UpdatedAt)
+		if account.Version == 0 {
+			account.Version = 1
+		}
+		if account.Status == "" {
+			account.Status = AccountOpen
+		}
+
+		account.ID = d.accounts.insert(func(id uint) Account {
+			stored := copyAccount(*account)
+			stored.ID = id
+			return stored
+		})
+		return nil
+	})
+}
+
+func (r memoryAccounts) FetchById(id uint) (*Account, error) {
+	var account *Account
+	err := r.store.read(func(d *memoryData) error {
+		var err error
+		account, err = r.fetch(d, id)
+		return err
+	})
+	return account, err
+}
+
+// Atomic calls already run one at a time, so an account fetched in one is locked until it returns
+func (r memoryAccounts) FetchForUpdate(id uint) (*Account, error) {
+	return r.FetchById(id)
+}
+
+func (r memoryAccounts) fetch(d *memoryData, id uint) (*Account, error) {
+	account, err := fetch(d.accounts.rows, id, func(a *Account) bool { return a.DeletedAt != nil })
+	if err != nil {
+		return nil, err
+	}
+	copied := copyAccount(*account)
+	return &copied, nil
+}
+
+func (r memoryAccounts) list(match func(a *Account) bool) []Account {
+	var accounts []Account
+	r.store.read(func(d *memoryData) error {
+		accounts = d.accounts.sorted(func(a *Account) bool { return a.DeletedAt == nil && match(a) })
+		return nil
+	})
+	for i := range accounts {
+		accounts[i] = copyAccount(accounts[i])
+	}
+	return accounts
+}
+
+func (r memoryAccounts) List() (*[]Account, error) {
+	accounts := r.list(func(a *Account) bool { return true })
+	return &accounts, nil
+}
+
+func (r memoryAccounts) ListPage(filter AccountFilter, request PageRequest) (*Page[Account], error) {
+	holder := strings.ToLower(filter.Holder)
+	accounts := r.list(func(a *Account) bool {
+		return (filter.OwnerID == nil || (a.OwnerID != nil && *a.OwnerID == *filter.OwnerID)) &&
+			(filter.AccountType == "" || a.AccountType == filter.AccountType) &&
+			(filter.Status == "" || a.Status == filter.Status) &&
+			strings.Contains(strings.ToLower(a.AccountHolder), holder) &&
+			between(a.CreatedAt, filter.CreatedFrom, filter.CreatedTo) &&
+			within(a.Balance.Minor, filter.MinBalance, filter.MaxBalance)
+	})
+	return page(accounts, accountSortKeys, func(a *Account) uint { return a.ID }, request)
+}
+
+func (r memoryAccounts) ListOpen(accountTypes []string, openedBefore time.Time) ([]Account, error) {
+	return r.list(func(a *Account) bool {
+		return a.Status == AccountOpen && slices.Contains(accountTypes, a.AccountType) && a.CreatedAt.Before(openedBefore)
+	}), nil
+}
+
+// update changes a stored account if it is at the given version, or at any version when it's zero
+func (r memoryAccounts) update(id uint, version uint, change func(a *Account)) (bool, error) {
+	updated := false
+	err := r.store.write(func(d *memoryData) error {
+		account, ok := d.accounts.rows[id]
+		if !ok || account.DeletedAt != nil || (version != 0 && account.Version != version) {
+			return nil
+		}
+
+		change(&account)
+		account.UpdatedAt = time.Now()
+		d.accounts.rows[id] = account
+		updated = true
+		return nil
+	})
+	return updated, err
+}
+
+func (r memoryAccounts) UpdateHolder(id uint, holder string, version uint) (bool, error) {
+	return r.update(id, version, func(a *Account) {
+		a.AccountHolder = holder
+		a.Version++
+	})
+}
+
+func (r memoryAccounts) UpdateBalance(account *Account) error {
+	updated, err := r.update(account.ID, account.Version, func(a *Account) {
+		a.Balance = account.Balance
+		a.Version++
+	})
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrConflict
+	}
+
+	account.Version++
+	return nil
+}
+
+func (r memoryAccounts) UpdateStatus(account *Account, status string) error {
+	updated, err := r.update(account.ID, account.Version, func(a *Account) {
+		a.Status = status
+		a.Version++
+	})
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrConflict
+	}
+
+	account.Status = status
+	account.Version++
+	return nil
+}
+
+func (r memoryAccounts) MarkDormant(inactiveSince time.Time) (int64, error) {
+	var marked int64
+	err := r.store.write(func(d *memoryData) error {
+		now := time.Now()
+		for id, account := range d.accounts.rows {
+			if account.DeletedAt == nil && account.Status == AccountOpen && account.UpdatedAt.Before(inactiveSince) {
+				account.Status = AccountDormant
+				account.Version++
+				account.UpdatedAt = now
+				d.accounts.rows[id] = account
+				marked++
+			}
+		}
+		return nil
+	})
+	return marked, err
+}
+
+type memoryTransactions struct {
+	store *MemoryStore
+}
+
+// checkReversal keeps reversal_of_id unique like its index does, deleted transactions included
+func checkReversal(d *memoryData, transaction *Transaction) error {
+	if transaction.ReversalOfID == nil {
+		return nil
+	}
+	for id, row := range d.transactions.rows {
+		if id != transaction.ID && row.ReversalOfID != nil && *row.ReversalOfID == *transaction.ReversalOfID {
+			return fmt.Errorf("%w: transaction %d", ErrAlreadyReversed, *transaction.ReversalOfID)
+		}
+	}
+	return nil
+}
+
+func (r memoryTransactions) Create(transaction *Transaction) error {
+	return r.store.write(func(d *memoryData) error {
+		if err := checkReversal(d, transaction); err != nil {
+			return err
+		}
+
+		timestamps(&transaction.CreatedAt, &transaction.UpdatedAt)
+		transaction.ID = d.transactions.insert(func(id uint) Transaction {
+			stored := copyTransaction(*transaction)
+			stored.ID = id
+			return stored
+		})
+		return nil
+	})
+}
+
+func (r memoryTransactions) FetchById(id uint) (*Transaction, error) {
+	var transaction *Transaction
+	err := r.store.read(func(d *memoryData) error {
+		stored, err := fetch(d.transactions.rows, id, func(t *Transaction) bool { return t.DeletedAt != nil })
+		if err != nil {
+			return err
+		}
+		copied := copyTransaction(*stored)
+		transaction = &copied
+		return nil
+	})
+	return transaction, err
+}
+
+// list returns the transactions that match by id, along with their accounts when withAccounts is set
+func (r memoryTransactions) list(withAccounts bool, match func(d *memoryData, t *Transaction) bool) []Transaction {
+	var transactions []Transaction
+	r.store.read(func(d *memoryData) error {
+		transactions = d.transactions.sorted(func(t *Transaction) bool { return t.DeletedAt == nil && match(d, t) })
+		for i := range transactions {
+			transactions[i] = copyTransaction(transactions[i])
+			if account, ok := d.accounts.rows[transactions[i].AccountID]; ok && withAccounts {
+				copied := copyAccount(account)
+				transactions[i].Account = &copied
+			}
+		}
+		return nil
+	})
+	return transactions
+}
+
+func (r memoryTransactions) List() (*[]Transaction, error) {
+	transactions := r.list(true, func(d *memoryData, t *Transaction) bool { return true })
+	return &transactions, nil
+}
+
+func (r memoryTransactions) ListByAccount(accountID uint) (*[]Transaction, error) {
+	transactions := r.list(true, func(d *memoryData, t *Transaction) bool { return t.AccountID == accountID })
+	return &transactions, nil
+}
+
+func (r memoryTransactions) ListPage(filter TransactionFilter, request PageRequest) (*Page[Transaction], error) {
+	transactions := r.list(true, func(d *memoryData, t *Transaction) bool {
+		if filter.OwnerID != nil {
+			account, ok := d.accounts.rows[t.AccountID]
+			if !ok || account.OwnerID == nil || *account.OwnerID != *filter.OwnerID {
+				return false
+			}
+		}
+		return (filter.AccountID == nil || t.AccountID == *filter.AccountID) &&
+			(filter.Type == "" || t.Type == filter.Type) &&
+			between(t.CreatedAt, filter.CreatedFrom, filter.CreatedTo) &&
+			within(t.Amount.Minor, filter.MinAmount, filter.MaxAmount)
+	})
+	return page(transactions, transactionSortKeys, func(t *Transaction) uint { return t.ID }, request)
+}
+
+func (r memoryTransactions) Since(accountID uint, from time.Time) ([]Transaction, error) {
+	transactions := r.list(false, func(d *memoryData, t *Transaction) bool {
+		return t.AccountID == accountID && !t.CreatedAt.Before(from)
+	})
+	slices.SortStableFunc(transactions, func(a, b Transaction) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return transactions, nil
+}
+
+func (r memoryTransactions) Fees(id uint) ([]Transaction, error) {
+	return r.list(false, func(d *memoryData, t *Transaction) bool {
+		return t.FeeForID != nil && *t.FeeForID == id
+	}), nil
+}
+
+func (r memoryTransactions) IsReversed(id uint) (bool, error) {
+	reversals := r.list(false, func(d *memoryData, t *Transaction) bool {
+		return t.ReversalOfID != nil && *t.ReversalOfID == id
+	})
+	return len(reversals) > 0, nil
+}
+
+// Save writes every field of a stored transaction
+func (r memoryTransactions) Save(transaction *Transaction) error {
+	return r.store.write(func(d *memoryData) error {
+		stored, ok := d.transactions.rows[transaction.ID]
+		if !ok || stored.DeletedAt != nil {
+			return ErrNotFound
+		}
+		if err := checkReversal(d, transaction); err != nil {
+			return err
+		}
+
+		transaction.UpdatedAt = time.Now()
+		d.transactions.rows[transaction.ID] = copyTransaction(*transaction)
+		return nil
+	})
+}
+
+// Delete marks a transaction deleted, like gorm it is kept but no longer found
+func (r memoryTransactions) Delete(transaction *Transaction) error {
+	return r.store.write(func(d *memoryData) error {
+		stored, ok := d.transactions.rows[transaction.ID]
+		if !ok || stored.DeletedAt != nil {
+			return nil
+		}
+
+		now := time.Now()
+		stored.DeletedAt = &now
+		d.transactions.rows[transaction.ID] = stored
+		transaction.DeletedAt = &now
+		return nil
+	})
+}
+
+type memoryTransfers struct {
+	store *MemoryStore
+}
+
+func (r memoryTransfers) Create(transfer *Transfer) error {
+	return r.store.write(func(d *memoryData) error {
+		timestamps(&transfer.CreatedAt, &transfer.UpdatedAt)
+		transfer.ID = d.transfers.insert(func(id uint) Transfer {
+			stored := *transfer
+			stored.ID = id
+			stored.Transactions = nil
+			return stored
+		})
+		return nil
+	})
+}
+
+func (r memoryTransfers) FetchById(id uint) (*Transfer, error) {
+	var transfer *Transfer
+	err := r.store.read(func(d *memoryData) error {
+		var err error
+		if transfer, err = fetch(d.transfers.rows, id, func(t *Transfer) bool { return t.DeletedAt != nil }); err != nil {
+			return err
+		}
+
+		//both legs, which is all the transactions linked to the transfer
+		transfer.Transactions = d.transactions.sorted(func(t *Transaction) bool {
+			return t.DeletedAt == nil && t.TransferID != nil && *t.TransferID == id
+		})
+		for i := range transfer.Transactions {
+			transfer.Transactions[i] = copyTransaction(transfer.Transactions[i])
+		}
+		return nil
+	})
+	return transfer, err
+}
+
+type memoryJournal struct {
+	store *MemoryStore
+}
+
+func (r memoryJournal) Create(entry *JournalEntry) error {
+	return r.store.write(func(d *memoryData) error {
+		timestamps(&entry.CreatedAt, &entry.UpdatedAt)
+		entry.ID = d.journalEntries.insert(func(id uint) JournalEntry {
+			stored := *entry
+			stored.ID = id
+			stored.Postings = nil
+			return stored
+		})
+
+		for i := range entry.Postings {
+			posting := &entry.Postings[i]
+			posting.JournalEntryID = entry.ID
+			timestamps(&posting.CreatedAt, &posting.UpdatedAt)
+			posting.ID = d.postings.insert(func(id uint) Posting {
+				stored := *posting
+				stored.ID = id
+				stored.AccountID = copyID(stored.AccountID)
+				return stored
+			})
+		}
+		return nil
+	})
+}
+
+func (r memoryJournal) Sum(accountID uint, currency string) (int64, error) {
+	var sum int64
+	err := r.store.read(func(d *memoryData) error {
+		for _, posting := range d.postings.rows {
+			if posting.DeletedAt == nil && posting.AccountID != nil && *posting.AccountID == accountID && posting.Amount.Currency == currency {
+				sum += posting.Amount.Minor
+			}
+		}
+		return nil
+	})
+	return sum, err
+}
+
+func (r memoryJournal) Unbalanced() ([]EntryImbalance, error) {
+	var unbalanced []EntryImbalance
+	err := r.store.read(func(d *memoryData) error {
+		totals := map[EntryImbalance]int64{}
+		for _, posting := range d.postings.rows {
+			if posting.DeletedAt == nil {
+				totals[EntryImbalance{JournalEntryID: posting.JournalEntryID, AmountCurrency: posting.Amount.Currency}] += posting.Amount.Minor
+			}
+		}
+
+		for entry, total := range totals {
+			if total != 0 {
+				entry.Total = total
+				unbalanced = append(unbalanced, entry)
+			}
+		}
+		return nil
+	})
+
+	slices.SortFunc(unbalanced, func(a, b EntryImbalance) int {
+		if a.JournalEntryID != b.JournalEntryID {
+			return compareValues(a.JournalEntryID, b.JournalEntryID)
+		}
+		return strings.Compare(a.AmountCurrency, b.AmountCurrency)
+	})
+	return unbalanced, err
+}
+
+// memoryPeriods stores period records, key returns the fields of a record that the store sets or looks up
+type memoryPeriods[R PeriodRecord] struct {
+	store *MemoryStore
+	table func(d *memoryData) *table[R]
+	key   func(record *R) (id *uint, accountID uint, period string, createdAt *time.Time)
+	copy  func(record R) R
+}
+
+func (r memoryPeriods[R]) recorded(d *memoryData, accountID uint, period string) bool {
+	for _, record := range r.table(d).rows {
+		if _, recordAccountID, recordPeriod, _ := r.key(&record); recordAccountID == accountID && recordPeriod == period {
+			return true
+		}
+	}
+	return false
+}
+
+func (r memoryPeriods[R]) Recorded(accountID uint, period string) (bool, error) {
+	var recorded bool
+	err := r.store.read(func(d *memoryData) error {
+		recorded = r.recorded(d, accountID, period)
+		return nil
+	})
+	return recorded, err
+}
+
+func (r memoryPeriods[R]) Create(record *R) error {
+	return r.store.write(func(d *memoryData) error {
+		id, accountID, period, createdAt := r.key(record)
+		if r.recorded(d, accountID, period) {
+			return fmt.Errorf("account %d already has a record for %s", accountID, period)
+		}
+
+		timestamps(createdAt, nil)
+		*id = r.table(d).insert(func(newID uint) R {
+			stored := r.copy(*record)
+			storedID, _, _, _ := r.key(&stored)
+			*storedID = newID
+			return stored
+		})
+		return nil
+	})
+}
+
+func (r memoryPeriods[R]) Save(record *R) error {
+	return r.store.write(func(d *memoryData) error {
+		id, _, _, _ := r.key(record)
+		if _, ok := r.table(d).rows[*id]; !ok {
+			return ErrNotFound
+		}
+
+		r.table(d).rows[*id] = r.copy(*record)
+		return nil
+	})
+}
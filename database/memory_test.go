@@ -0,0 +1,133 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_AtomicRollsBack(t *testing.T) {
+	store := NewMemoryStore()
+	failure := errors.New("failure")
+
+	err := store.Atomic(func(store Store) error {
+		require.NoError(t, store.Accounts().Create(&Account{AccountHolder: "Foo Bar", AccountType: "savings"}))
+
+		// a nested call is part of the outer one, so its changes go with it
+		require.NoError(t, store.Atomic(func(store Store) error {
+			return store.Transactions().Create(&Transaction{AccountID: 1, Type: "deposit", Amount: NewMoney(100, "USD")})
+		}))
+		return failure
+	})
+
+	assert.ErrorIs(t, err, failure)
+	accounts, err := store.Accounts().List()
+	if assert.NoError(t, err) {
+		assert.Empty(t, *accounts)
+	}
+	transactions, err := store.Transactions().List()
+	if assert.NoError(t, err) {
+		assert.Empty(t, *transactions)
+	}
+
+	// the ids of the dropped rows are handed out again
+	account := &Account{AccountHolder: "Foo Bar", AccountType: "savings"}
+	require.NoError(t, store.Accounts().Create(account))
+	assert.Equal(t, uint(1), account.ID)
+}
+
+func TestMemoryStore_UpdateBalance(t *testing.T) {
+	store := NewMemoryStore()
+	account := &Account{AccountHolder: "Foo Bar", AccountType: "savings", Balance: NewMoney(100, "USD"), Version: 1}
+	require.NoError(t, store.Accounts().Create(account))
+	stale := *account
+
+	account.Balance = NewMoney(200, "USD")
+	require.NoError(t, store.Accounts().UpdateBalance(account))
+	assert.Equal(t, uint(2), account.Version)
+
+	// the stale copy is still at version 1
+	stale.Balance = NewMoney(300, "USD")
+	assert.ErrorIs(t, store.Accounts().UpdateBalance(&stale), ErrConflict)
+
+	stored, err := store.Accounts().FetchById(account.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, NewMoney(200, "USD"), stored.Balance)
+		assert.Equal(t, uint(2), stored.Version)
+	}
+}
+
+func TestMemoryStore_ReturnsCopies(t *testing.T) {
+	store := NewMemoryStore()
+	ownerID := uint(7)
+	account := &Account{AccountHolder: "Foo Bar", AccountType: "savings", OwnerID: &ownerID}
+	require.NoError(t, store.Accounts().Create(account))
+
+	// changing what the store handed out doesn't change what it holds
+	fetched, err := store.Accounts().FetchById(account.ID)
+	require.NoError(t, err)
+	fetched.AccountHolder = "Baz Qux"
+	*fetched.OwnerID = 8
+	ownerID = 9
+
+	stored, err := store.Accounts().FetchById(account.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Foo Bar", stored.AccountHolder)
+		assert.Equal(t, uint(7), *stored.OwnerID)
+	}
+}
+
+func TestMemoryStore_OneReversalPerTransaction(t *testing.T) {
+	store := NewMemoryStore()
+	original := &Transaction{AccountID: 1, Type: "deposit", Amount: NewMoney(100, "USD")}
+	require.NoError(t, store.Transactions().Create(original))
+
+	reversal := &Transaction{AccountID: 1, Type: "withdrawal", Amount: NewMoney(100, "USD"), ReversalOfID: &original.ID}
+	require.NoError(t, store.Transactions().Create(reversal))
+
+	// like the unique index on reversal_of_id
+	again := &Transaction{AccountID: 1, Type: "withdrawal", Amount: NewMoney(100, "USD"), ReversalOfID: &original.ID}
+	assert.ErrorIs(t, store.Transactions().Create(again), ErrAlreadyReversed)
+
+	reversed, err := store.Transactions().IsReversed(original.ID)
+	if assert.NoError(t, err) {
+		assert.True(t, reversed)
+	}
+}
+
+func TestMemoryStore_Periods(t *testing.T) {
+	store := NewMemoryStore()
+
+	require.NoError(t, store.InterestPostings().Create(&InterestPosting{AccountID: 1, Period: "2024-01"}))
+	assert.Error(t, store.InterestPostings().Create(&InterestPosting{AccountID: 1, Period: "2024-01"}))
+
+	recorded, err := store.InterestPostings().Recorded(1, "2024-01")
+	if assert.NoError(t, err) {
+		assert.True(t, recorded)
+	}
+	recorded, err = store.InterestPostings().Recorded(1, "2024-02")
+	if assert.NoError(t, err) {
+		assert.False(t, recorded)
+	}
+}
+
+func TestMemoryStore_SnapshotIsReadOnly(t *testing.T) {
+	store := NewMemoryStore()
+	account := &Account{AccountHolder: "Foo Bar", AccountType: "savings"}
+	require.NoError(t, store.Accounts().Create(account))
+
+	err := store.Snapshot(func(store Store) error {
+		if _, err := store.Accounts().FetchById(account.ID); err != nil {
+			return err
+		}
+		return store.Accounts().Create(&Account{AccountHolder: "Baz Qux", AccountType: "savings"})
+	})
+
+	assert.ErrorIs(t, err, errReadOnly)
+	accounts, err := store.Accounts().List()
+	if assert.NoError(t, err) {
+		assert.Len(t, *accounts, 1)
+	}
+}
@@ -0,0 +1,243 @@
+package database
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	gorm "github.com/jinzhu/gorm"
+)
+
+// the schema is built from the sql files in migrations, numbered in the order they are applied
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migration files are named <version>_<name>.up.sql, with a matching .down.sql to roll them back
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// key of the postgres advisory lock held while a migration runs, so two instances starting
+// at the same time don't both apply it
+const migrationLock = 7265746
+
+// Migration is one versioned change to the schema
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration and when it was applied, AppliedAt is nil while it is pending
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// a row of the schema_migrations table
+type schemaMigration struct {
+	Version   int64
+	Name      string
+	AppliedAt time.Time
+}
+
+// LoadMigrations reads the migration files at the root of fsys, sorted by version
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, migration.Name, match[2])
+		}
+
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator applies and rolls back migrations, keeping track of them in the schema_migrations table
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// create a migrator for the migrations embedded in the binary
+func NewMigrator(db *gorm.DB) (*Migrator, error) {
+	files, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := LoadMigrations(files)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Up applies every pending migration in order and returns the ones it applied.
+// Each migration runs in its own transaction, so a failure leaves the ones before it in place.
+func (m *Migrator) Up() ([]Migration, error) {
+	status, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []Migration{}
+	for _, s := range status {
+		if s.AppliedAt != nil {
+			continue
+		}
+
+		ran := false
+		performUp := func(tx *gorm.DB) error {
+			done, err := isApplied(tx, s.Version)
+			if err != nil || done {
+				return err
+			}
+			ran = true
+
+			if err := tx.Exec(s.Up).Error; err != nil {
+				return err
+			}
+			return tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+				s.Version, s.Name, time.Now()).Error
+		}
+
+		if err := m.locked(performUp); err != nil {
+			return applied, fmt.Errorf("migration %d_%s: %w", s.Version, s.Name, err)
+		}
+		if ran {
+			applied = append(applied, s.Migration)
+		}
+	}
+	return applied, nil
+}
+
+// Down rolls back the most recently applied migration and returns it
+func (m *Migrator) Down() (*Migration, error) {
+	status, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *Migration
+	for i := len(status) - 1; i >= 0; i-- {
+		if status[i].AppliedAt != nil {
+			latest = &status[i].Migration
+			break
+		}
+	}
+	if latest == nil {
+		return nil, ErrNoMigration
+	}
+	if latest.Down == "" {
+		return nil, fmt.Errorf("migration %d_%s can't be rolled back, it has no down file", latest.Version, latest.Name)
+	}
+
+	performDown := func(tx *gorm.DB) error {
+		done, err := isApplied(tx, latest.Version)
+		if err != nil || !done {
+			return err
+		}
+
+		if err := tx.Exec(latest.Down).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, latest.Version).Error
+	}
+
+	if err := m.locked(performDown); err != nil {
+		return nil, fmt.Errorf("migration %d_%s: %w", latest.Version, latest.Name, err)
+	}
+	return latest, nil
+}
+
+// Status lists every known migration and when it was applied
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	if err := m.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    bigint PRIMARY KEY,
+		name       text NOT NULL,
+		applied_at timestamp with time zone NOT NULL
+	)`).Error; err != nil {
+		return nil, err
+	}
+
+	var rows []schemaMigration
+	if err := m.db.Raw(`SELECT version, name, applied_at FROM schema_migrations ORDER BY version`).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	appliedAt := make(map[int64]time.Time, len(rows))
+	for _, row := range rows {
+		appliedAt[row.Version] = row.AppliedAt
+	}
+
+	status := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		s := MigrationStatus{Migration: migration}
+		if at, ok := appliedAt[migration.Version]; ok {
+			s.AppliedAt = &at
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
+
+// locked runs fc in a transaction that holds the migration lock
+func (m *Migrator) locked(fc func(tx *gorm.DB) error) error {
+	return m.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`SELECT pg_advisory_xact_lock(?)`, migrationLock).Error; err != nil {
+			return err
+		}
+		return fc(tx)
+	})
+}
+
+// isApplied checks again once the lock is held, another instance may have applied the migration meanwhile
+func isApplied(tx *gorm.DB, version int64) (bool, error) {
+	var count int
+	if err := tx.Raw(`SELECT count(*) FROM schema_migrations WHERE version = ?`, version).Row().Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
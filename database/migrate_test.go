@@ -0,0 +1,335 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+	"github.com/jobullo/go-api-example/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations(fstest.MapFS{
+		"002_add_status.up.sql":       {Data: []byte("ALTER TABLE accounts ADD COLUMN status text")},
+		"002_add_status.down.sql":     {Data: []byte("ALTER TABLE accounts DROP COLUMN status")},
+		"001_initial_schema.up.sql":   {Data: []byte("CREATE TABLE accounts (id serial)")},
+		"001_initial_schema.down.sql": {Data: []byte("DROP TABLE accounts")},
+		"readme.md":                   {Data: []byte("not a migration")},
+		"003_backfill_status.up.sql":  {Data: []byte("UPDATE accounts SET status = 'active'")},
+	})
+
+	require.NoError(t, err)
+	if assert.Len(t, migrations, 3) {
+		assert.Equal(t, Migration{Version: 1, Name: "initial_schema", Up: "CREATE TABLE accounts (id serial)", Down: "DROP TABLE accounts"}, migrations[0])
+		assert.Equal(t, int64(2), migrations[1].Version)
+		// a migration without a down file can be applied but not rolled back
+		assert.Equal(t, "", migrations[2].Down)
+	}
+}
+
+func TestLoadMigrations_Invalid(t *testing.T) {
+	_, err := LoadMigrations(fstest.MapFS{"001_initial_schema.down.sql": {Data: []byte("DROP TABLE accounts")}})
+	assert.ErrorContains(t, err, "has no up file")
+
+	_, err = LoadMigrations(fstest.MapFS{
+		"001_initial_schema.up.sql": {Data: []byte("CREATE TABLE accounts (id serial)")},
+		"001_accounts.down.sql":     {Data: []byte("DROP TABLE accounts")},
+	})
+	assert.ErrorContains(t, err, "is named both")
+}
+
+func TestNewMigrator_EmbedsInitialSchema(t *testing.T) {
+	migrator, err := NewMigrator(nil)
+
+	require.NoError(t, err)
+	if assert.NotEmpty(t, migrator.migrations) {
+		initial := migrator.migrations[0]
+		assert.Equal(t, int64(1), initial.Version)
+		assert.Equal(t, "initial_schema", initial.Name)
+		assert.Contains(t, initial.Up, "CREATE TABLE IF NOT EXISTS accounts")
+		assert.Contains(t, initial.Down, "DROP TABLE IF EXISTS accounts")
+	}
+}
+
+func testMigrator(t *testing.T) (*Migrator, sqlmock.Sqlmock) {
+	db, sql, err := mock.DB()
+	require.NoError(t, err)
+
+	return &Migrator{db: db, migrations: []Migration{
+		{Version: 1, Name: "initial_schema", Up: "CREATE TABLE accounts (id serial)", Down: "DROP TABLE accounts"},
+		{Version: 2, Name: "add_status", Up: "ALTER TABLE accounts ADD COLUMN status text", Down: "ALTER TABLE accounts DROP COLUMN status"},
+	}}, sql
+}
+
+func expectAppliedMigrations(sql sqlmock.Sqlmock, versions ...int64) {
+	rows := sqlmock.NewRows([]string{"version", "name", "applied_at"})
+	for _, version := range versions {
+		rows.AddRow(version, "migration", time.Now())
+	}
+
+	sql.ExpectExec(`^CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	sql.ExpectQuery(`^SELECT version, name, applied_at FROM schema_migrations`).WillReturnRows(rows)
+}
+
+func TestMigrator_Up(t *testing.T) {
+	migrator, sql := testMigrator(t)
+
+	expectAppliedMigrations(sql, 1)
+	sql.ExpectBegin()
+	sql.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock($1)`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	sql.ExpectQuery(regexp.QuoteMeta(`SELECT count(*) FROM schema_migrations WHERE version = $1`)).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	sql.ExpectExec(regexp.QuoteMeta(`ALTER TABLE accounts ADD COLUMN status text`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	sql.ExpectExec(`^INSERT INTO schema_migrations`).
+		WithArgs(2, "add_status", mock.Any{}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sql.ExpectCommit()
+
+	applied, err := migrator.Up()
+
+	if assert.NoError(t, err) {
+		assert.NoError(t, sql.ExpectationsWereMet())
+		if assert.Len(t, applied, 1) {
+			assert.Equal(t, "add_status", applied[0].Name)
+		}
+	}
+}
+
+func TestMigrator_Up_AppliedByAnotherInstance(t *testing.T) {
+	migrator, sql := testMigrator(t)
+
+	expectAppliedMigrations(sql, 1)
+	sql.ExpectBegin()
+	sql.ExpectExec(`pg_advisory_xact_lock`).WillReturnResult(sqlmock.NewResult(0, 0))
+	// the migration was applied while this instance waited for the lock
+	sql.ExpectQuery(`SELECT count`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	sql.ExpectCommit()
+
+	applied, err := migrator.Up()
+
+	if assert.NoError(t, err) {
+		assert.NoError(t, sql.ExpectationsWereMet())
+		assert.Empty(t, applied)
+	}
+}
+
+func TestMigrator_Up_FailureRollsBack(t *testing.T) {
+	migrator, sql := testMigrator(t)
+
+	expectAppliedMigrations(sql)
+	sql.ExpectBegin()
+	sql.ExpectExec(`pg_advisory_xact_lock`).WillReturnResult(sqlmock.NewResult(0, 0))
+	sql.ExpectQuery(`SELECT count`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	sql.ExpectExec(`CREATE TABLE accounts`).WillReturnError(mock.Error())
+	sql.ExpectRollback()
+
+	applied, err := migrator.Up()
+
+	assert.ErrorContains(t, err, "migration 1_initial_schema")
+	assert.Empty(t, applied)
+	assert.NoError(t, sql.ExpectationsWereMet())
+}
+
+func TestMigrator_Down(t *testing.T) {
+	migrator, sql := testMigrator(t)
+
+	expectAppliedMigrations(sql, 1, 2)
+	sql.ExpectBegin()
+	sql.ExpectExec(`pg_advisory_xact_lock`).WillReturnResult(sqlmock.NewResult(0, 0))
+	sql.ExpectQuery(`SELECT count`).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	sql.ExpectExec(regexp.QuoteMeta(`ALTER TABLE accounts DROP COLUMN status`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	sql.ExpectExec(regexp.QuoteMeta(`DELETE FROM schema_migrations WHERE version = $1`)).
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	sql.ExpectCommit()
+
+	migration, err := migrator.Down()
+
+	if assert.NoError(t, err) {
+		assert.NoError(t, sql.ExpectationsWereMet())
+		assert.Equal(t, int64(2), migration.Version)
+	}
+}
+
+func TestMigrator_Down_NothingApplied(t *testing.T) {
+	migrator, sql := testMigrator(t)
+
+	expectAppliedMigrations(sql)
+
+	_, err := migrator.Down()
+
+	assert.ErrorIs(t, err, ErrNoMigration)
+	assert.NoError(t, sql.ExpectationsWereMet())
+}
+
+func TestMigrator_Status(t *testing.T) {
+	migrator, sql := testMigrator(t)
+
+	expectAppliedMigrations(sql, 1)
+
+	status, err := migrator.Status()
+
+	if assert.NoError(t, err) && assert.Len(t, status, 2) {
+		assert.NotNil(t, status[0].AppliedAt)
+		assert.Nil(t, status[1].AppliedAt)
+	}
+}
+
+// the columns accounts and transactions had when BuildDatabase created them, before migrations existed
+var baselineColumns = map[string][]string{
+	"accounts":     {"id", "created_at", "updated_at", "deleted_at", "account_holder", "account_type"},
+	"transactions": {"id", "created_at", "updated_at", "deleted_at", "account_id", "type"},
+}
+
+// the baseline schema as BuildDatabase created it, amounts were still floats
+const baselineSchema = `
+CREATE TABLE accounts (id serial PRIMARY KEY, created_at timestamp with time zone, updated_at timestamp with time zone,
+    deleted_at timestamp with time zone, account_holder text, account_type text, balance numeric);
+CREATE TABLE transactions (id serial PRIMARY KEY, created_at timestamp with time zone, updated_at timestamp with time zone,
+    deleted_at timestamp with time zone, account_id integer, type text, amount numeric);
+INSERT INTO accounts (account_holder, account_type, balance) VALUES ('Foo Bar', 'savings', 12.34);
+INSERT INTO transactions (account_id, type, amount) VALUES (1, 'deposit', 0.1);
+`
+
+// every column added to accounts and transactions since the baseline has to be added to existing tables,
+// not only created with new ones, and its indexes built after it
+func TestMigrations_AdoptBaselineSchema(t *testing.T) {
+	migrator, err := NewMigrator(nil)
+	require.NoError(t, err)
+
+	var up strings.Builder
+	for _, migration := range migrator.migrations {
+		up.WriteString(migration.Up)
+	}
+	sql := up.String()
+
+	db, _, err := mock.DB()
+	require.NoError(t, err)
+
+	for _, model := range []interface{}{&Account{}, &Transaction{}} {
+		scope := db.NewScope(model)
+		table := scope.TableName()
+
+		for _, field := range scope.GetModelStruct().StructFields {
+			if !field.IsNormal || field.IsIgnored || slices.Contains(baselineColumns[table], field.DBName) {
+				continue
+			}
+
+			added := strings.Index(sql, "ADD COLUMN IF NOT EXISTS "+field.DBName+" ")
+			if !assert.GreaterOrEqual(t, added, 0, "%s.%s is never added to an existing table", table, field.DBName) {
+				continue
+			}
+
+			for _, tag := range []string{"INDEX", "UNIQUE_INDEX"} {
+				name, ok := field.TagSettingsGet(tag)
+				if !ok {
+					continue
+				}
+				if name == tag {
+					name = fmt.Sprintf("idx_%s_%s", table, field.DBName)
+				}
+				assert.Greater(t, strings.Index(sql, "INDEX IF NOT EXISTS "+name+" "), added, "%s isn't built after %s.%s is added", name, table, field.DBName)
+			}
+		}
+	}
+}
+
+// migrates a database with the baseline schema on a real postgres server, set MIGRATION_TEST_DSN to run it.
+// Everything happens in a schema of its own that is dropped afterwards.
+func TestMigrator_Up_BaselineDatabase(t *testing.T) {
+	db := migrationTestDB(t)
+	require.NoError(t, db.Exec(baselineSchema).Error)
+
+	migrator, err := NewMigrator(db)
+	require.NoError(t, err)
+	applied, err := migrator.Up()
+	require.NoError(t, err)
+	assert.Len(t, applied, len(migrator.migrations))
+
+	//the old rows are readable through the models
+	var account Account
+	if assert.NoError(t, db.First(&account, 1).Error) {
+		assert.Equal(t, NewMoney(1234, "USD"), account.Balance)
+		assert.Equal(t, uint(1), account.Version)
+		assert.Equal(t, AccountOpen, account.Status)
+	}
+	var transaction Transaction
+	if assert.NoError(t, db.First(&transaction, 1).Error) {
+		assert.Equal(t, NewMoney(10, "USD"), transaction.Amount)
+		assert.Nil(t, transaction.ReversalOfID)
+	}
+
+	//and every column of every model exists
+	for _, model := range models {
+		scope := db.NewScope(model)
+		for _, field := range scope.GetModelStruct().StructFields {
+			if field.IsNormal && !field.IsIgnored {
+				assert.True(t, scope.Dialect().HasColumn(scope.TableName(), field.DBName), "%s.%s is missing", scope.TableName(), field.DBName)
+			}
+		}
+	}
+}
+
+// a transaction reversed twice before reversals were unique keeps its first reversal, the second one stays
+// a plain transaction so the balance it moved still adds up
+func TestMigrator_Up_DuplicateReversals(t *testing.T) {
+	db := migrationTestDB(t)
+
+	migrator, err := NewMigrator(db)
+	require.NoError(t, err)
+	all := migrator.migrations
+	migrator.migrations = slices.DeleteFunc(slices.Clone(all), func(m Migration) bool { return m.Version >= 5 })
+	_, err = migrator.Up()
+	require.NoError(t, err)
+
+	require.NoError(t, db.Exec(`INSERT INTO transactions (account_id, type, amount_minor, amount_currency, reversal_of_id) VALUES
+		(1, 'deposit', 1000, 'USD', NULL), (1, 'withdrawal', 1000, 'USD', 1), (1, 'withdrawal', 1000, 'USD', 1)`).Error)
+
+	migrator.migrations = all
+	_, err = migrator.Up()
+	require.NoError(t, err)
+
+	var reversals []Transaction
+	require.NoError(t, db.Order("id").Find(&reversals, "type = ?", "withdrawal").Error)
+	if assert.Len(t, reversals, 2) {
+		assert.Equal(t, uint(1), *reversals[0].ReversalOfID)
+		assert.Nil(t, reversals[1].ReversalOfID)
+	}
+
+	//and the index now stops a third reversal
+	assert.Error(t, db.Exec(`INSERT INTO transactions (account_id, type, amount_minor, amount_currency, reversal_of_id)
+		VALUES (1, 'withdrawal', 1000, 'USD', 1)`).Error)
+}
+
+// migrationTestDB connects to the postgres server at MIGRATION_TEST_DSN with a schema of its own, which is
+// dropped when the test ends, and skips the test when it isn't set
+func migrationTestDB(t *testing.T) *gorm.DB {
+	dsn := os.Getenv("MIGRATION_TEST_DSN")
+	if dsn == "" {
+		t.Skip("MIGRATION_TEST_DSN is not set")
+	}
+
+	db, err := gorm.Open("postgres", dsn)
+	require.NoError(t, err)
+
+	//one connection, so the search path applies to every statement
+	db.DB().SetMaxOpenConns(1)
+	schema := fmt.Sprintf("migration_test_%d", time.Now().UnixNano())
+	require.NoError(t, db.Exec("CREATE SCHEMA "+schema).Error)
+	t.Cleanup(func() {
+		db.Exec("DROP SCHEMA " + schema + " CASCADE")
+		db.Close()
+	})
+	require.NoError(t, db.Exec("SET search_path TO "+schema).Error)
+	return db
+}
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS revoked_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS idempotency_keys;
+DROP TABLE IF EXISTS postings;
+DROP TABLE IF EXISTS journal_entries;
+DROP TABLE IF EXISTS transfers;
+DROP TABLE IF EXISTS transactions;
+DROP TABLE IF EXISTS accounts;
@@ -0,0 +1,146 @@
+-- The schema as it was built by BuildDatabase. Tables are only created if they are missing,
+-- so databases set up before migrations existed are adopted as they are.
+
+CREATE TABLE IF NOT EXISTS accounts (
+    id               serial PRIMARY KEY,
+    created_at       timestamp with time zone,
+    updated_at       timestamp with time zone,
+    deleted_at       timestamp with time zone,
+    account_holder   text,
+    account_type     text,
+    balance_minor    bigint,
+    balance_currency varchar(3),
+    version          integer NOT NULL DEFAULT 1,
+    owner_id         integer
+);
+CREATE INDEX IF NOT EXISTS idx_accounts_deleted_at ON accounts (deleted_at);
+
+CREATE TABLE IF NOT EXISTS transactions (
+    id               serial PRIMARY KEY,
+    created_at       timestamp with time zone,
+    updated_at       timestamp with time zone,
+    deleted_at       timestamp with time zone,
+    account_id       integer,
+    type             text,
+    amount_minor     bigint,
+    amount_currency  varchar(3),
+    transfer_id      integer,
+    reversal_of_id   integer,
+    journal_entry_id integer
+);
+CREATE INDEX IF NOT EXISTS idx_transactions_deleted_at ON transactions (deleted_at);
+
+CREATE TABLE IF NOT EXISTS transfers (
+    id              serial PRIMARY KEY,
+    created_at      timestamp with time zone,
+    updated_at      timestamp with time zone,
+    deleted_at      timestamp with time zone,
+    from_account_id integer,
+    to_account_id   integer,
+    amount_minor    bigint,
+    amount_currency varchar(3)
+);
+CREATE INDEX IF NOT EXISTS idx_transfers_deleted_at ON transfers (deleted_at);
+
+CREATE TABLE IF NOT EXISTS journal_entries (
+    id          serial PRIMARY KEY,
+    created_at  timestamp with time zone,
+    updated_at  timestamp with time zone,
+    deleted_at  timestamp with time zone,
+    description text
+);
+CREATE INDEX IF NOT EXISTS idx_journal_entries_deleted_at ON journal_entries (deleted_at);
+
+CREATE TABLE IF NOT EXISTS postings (
+    id               serial PRIMARY KEY,
+    created_at       timestamp with time zone,
+    updated_at       timestamp with time zone,
+    deleted_at       timestamp with time zone,
+    journal_entry_id integer,
+    account_id       integer,
+    system_account   text,
+    amount_minor     bigint,
+    amount_currency  varchar(3)
+);
+CREATE INDEX IF NOT EXISTS idx_postings_deleted_at ON postings (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_postings_journal_entry_id ON postings (journal_entry_id);
+CREATE INDEX IF NOT EXISTS idx_postings_account_id ON postings (account_id);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    id            serial PRIMARY KEY,
+    created_at    timestamp with time zone,
+    updated_at    timestamp with time zone,
+    key           text,
+    method        text,
+    path          text,
+    request_hash  text,
+    status_code   integer,
+    content_type  text,
+    response_body bytea
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_idempotency_keys_scope ON idempotency_keys (key, method, path);
+
+CREATE TABLE IF NOT EXISTS users (
+    id            serial PRIMARY KEY,
+    created_at    timestamp with time zone,
+    updated_at    timestamp with time zone,
+    deleted_at    timestamp with time zone,
+    username      text NOT NULL,
+    password_hash text NOT NULL,
+    role          text NOT NULL DEFAULT 'customer'
+);
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_users_username ON users (username);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id         serial PRIMARY KEY,
+    created_at timestamp with time zone,
+    updated_at timestamp with time zone,
+    deleted_at timestamp with time zone,
+    user_id    integer NOT NULL,
+    family_id  text NOT NULL,
+    token_hash text NOT NULL,
+    expires_at timestamp with time zone,
+    used_at    timestamp with time zone,
+    revoked_at timestamp with time zone
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_deleted_at ON refresh_tokens (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_refresh_tokens_token_hash ON refresh_tokens (token_hash);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    id         serial PRIMARY KEY,
+    created_at timestamp with time zone,
+    jti        text NOT NULL,
+    expires_at timestamp with time zone
+);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_revoked_tokens_jti ON revoked_tokens (jti);
+
+-- Databases from before amounts were stored in minor units have float balance and amount columns.
+-- The float is cast to numeric first, which rounds it to 15 significant digits and removes binary
+-- drift such as 0.30000000000000004, so no cents are lost.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS balance_minor bigint, ADD COLUMN IF NOT EXISTS balance_currency varchar(3);
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS amount_minor bigint, ADD COLUMN IF NOT EXISTS amount_currency varchar(3);
+
+-- Databases built by BuildDatabase only have the columns accounts and transactions started with,
+-- the ones added since are added here, with the indexes on them.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS version integer NOT NULL DEFAULT 1, ADD COLUMN IF NOT EXISTS owner_id integer;
+CREATE INDEX IF NOT EXISTS idx_accounts_owner_id ON accounts (owner_id);
+ALTER TABLE transactions
+    ADD COLUMN IF NOT EXISTS transfer_id integer,
+    ADD COLUMN IF NOT EXISTS reversal_of_id integer,
+    ADD COLUMN IF NOT EXISTS journal_entry_id integer;
+
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'accounts' AND column_name = 'balance') THEN
+        UPDATE accounts SET balance_minor = ROUND(balance::numeric * 100)::bigint, balance_currency = 'USD';
+        ALTER TABLE accounts DROP COLUMN balance;
+    END IF;
+
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'transactions' AND column_name = 'amount') THEN
+        UPDATE transactions SET amount_minor = ROUND(amount::numeric * 100)::bigint, amount_currency = 'USD';
+        ALTER TABLE transactions DROP COLUMN amount;
+    END IF;
+END $$;
@@ -0,0 +1,3 @@
+-- Accounts have a lifecycle status, every existing account is open.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'open';
+CREATE INDEX IF NOT EXISTS idx_accounts_status ON accounts (status);
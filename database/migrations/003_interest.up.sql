@@ -0,0 +1,11 @@
+-- Interest paid on an account for a period, one row per account and period so a run can't pay twice.
+CREATE TABLE IF NOT EXISTS interest_postings (
+    id              serial PRIMARY KEY,
+    created_at      timestamp with time zone,
+    account_id      integer,
+    period          text,
+    amount_minor    bigint,
+    amount_currency varchar(3),
+    transaction_id  integer
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_interest_postings_period ON interest_postings (account_id, period);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS maintenance_fees;
+DROP INDEX IF EXISTS idx_transactions_fee_for_id;
+ALTER TABLE transactions DROP COLUMN IF EXISTS fee_for_id;
@@ -0,0 +1,16 @@
+-- Fees are transactions of their own, linked to the transaction that triggered them.
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS fee_for_id integer;
+CREATE INDEX IF NOT EXISTS idx_transactions_fee_for_id ON transactions (fee_for_id);
+
+-- The maintenance fee of an account for a period, one row per account and period so a run can't charge twice.
+CREATE TABLE IF NOT EXISTS maintenance_fees (
+    id              serial PRIMARY KEY,
+    created_at      timestamp with time zone,
+    account_id      integer,
+    period          text,
+    amount_minor    bigint,
+    amount_currency varchar(3),
+    waived          boolean,
+    transaction_id  integer
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_maintenance_fees_period ON maintenance_fees (account_id, period);
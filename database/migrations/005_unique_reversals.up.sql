@@ -0,0 +1,12 @@
+-- A transaction can only be reversed once, the reversal check in the service is backed by the database.
+-- NULLs are distinct, so transactions that aren't reversals are unaffected.
+--
+-- Concurrent requests could reverse a transaction twice before this index existed, and the duplicates would stop it
+-- from being built. The earliest reversal stays the reversal, later ones are kept as plain deposits and withdrawals:
+-- they already moved the balance and were posted to the ledger, so removing them would leave both wrong.
+UPDATE transactions AS duplicate SET reversal_of_id = NULL
+WHERE duplicate.reversal_of_id IS NOT NULL AND EXISTS (
+    SELECT 1 FROM transactions AS earlier
+    WHERE earlier.reversal_of_id = duplicate.reversal_of_id AND earlier.id < duplicate.id
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_reversal_of_id ON transactions (reversal_of_id);
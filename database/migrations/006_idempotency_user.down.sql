@@ -0,0 +1,5 @@
+-- Stored responses are only kept for a day, they are dropped rather than merged so the narrower index can be built.
+DELETE FROM idempotency_keys;
+DROP INDEX IF EXISTS idx_idempotency_keys_scope;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_idempotency_keys_scope ON idempotency_keys (key, method, path);
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS user_id;
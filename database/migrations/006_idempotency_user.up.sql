@@ -0,0 +1,4 @@
+-- Idempotency keys are scoped to the user that sent them, so a stored response is never replayed to another user.
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS user_id integer NOT NULL DEFAULT 0;
+DROP INDEX IF EXISTS idx_idempotency_keys_scope;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_idempotency_keys_scope ON idempotency_keys (key, method, path, user_id);
@@ -0,0 +1,261 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is used when an amount is provided without a currency
+const DefaultCurrency = "USD"
+
+// currencies are the ISO 4217 currencies accounts can be held in, with the number of decimal places of their
+// minor unit, e.g. cents for USD. Yen have no minor unit and dinars have three.
+var currencies = map[string]int{
+	"AUD": 2, "BHD": 3, "BRL": 2, "CAD": 2, "CHF": 2, "CLP": 0, "CNY": 2, "CZK": 2, "DKK": 2, "EUR": 2,
+	"GBP": 2, "HKD": 2, "HUF": 2, "INR": 2, "ISK": 0, "JOD": 3, "JPY": 0, "KRW": 0, "KWD": 3, "MXN": 2,
+	"NOK": 2, "NZD": 2, "OMR": 3, "PLN": 2, "SEK": 2, "SGD": 2, "TND": 3, "USD": 2, "VND": 0, "ZAR": 2,
+}
+
+// ValidCurrency reports whether accounts can be held in a currency, the code is case insensitive
+func ValidCurrency(currency string) bool {
+	_, ok := currencies[strings.ToUpper(currency)]
+	return ok
+}
+
+// Money is an exact amount of money held as integer minor units (cents) plus an ISO 4217 currency code.
+// Models embed it with a column prefix, e.g. `gorm:"embedded;embedded_prefix:balance_"`
+type Money struct {
+	Minor    int64
+	Currency string `gorm:"type:varchar(3)"`
+}
+
+// NewMoney creates a money value from minor units and a currency code
+func NewMoney(minor int64, currency string) Money {
+	return Money{Minor: minor, Currency: normalizeCurrency(currency)}
+}
+
+// Normalized returns the amount with an upper case currency code, filling in the default currency if missing
+func (m Money) Normalized() Money {
+	return NewMoney(m.Minor, m.Currency)
+}
+
+// ParseMoney parses a decimal string such as "12.34" or "-5" into an exact money value, with no more decimal
+// places than the currency has. An empty currency is left empty so the caller can decide which currency
+// applies, see In, until then the amount has the decimal places of the default currency.
+func ParseMoney(value string, currency string) (Money, error) {
+	if currency != "" && !ValidCurrency(currency) {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidCurrency, currency)
+	}
+
+	minor, err := parseMinor(value, decimals(currency))
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Minor: minor, Currency: strings.ToUpper(currency)}, nil
+}
+
+// parseMinor parses a decimal string into minor units with the given number of decimal places
+func parseMinor(value string, places int) (int64, error) {
+	s := strings.TrimSpace(value)
+	if s == "" {
+		return 0, fmt.Errorf("%w: empty amount", ErrInvalidAmount)
+	}
+
+	negative := false
+	if s[0] == '-' || s[0] == '+' {
+		negative = s[0] == '-'
+		s = s[1:]
+	}
+
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	if whole == "" && frac == "" || hasFrac && frac == "" {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+	}
+	if len(frac) > places {
+		return 0, fmt.Errorf("%w: %q has more than %d decimal places", ErrInvalidAmount, value, places)
+	}
+	frac += strings.Repeat("0", places-len(frac))
+	if whole == "" {
+		whole = "0"
+	}
+
+	major, err := strconv.ParseUint(whole, 10, 63)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+	}
+	var minor uint64
+	if frac != "" {
+		if minor, err = strconv.ParseUint(frac, 10, 63); err != nil {
+			return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+		}
+	}
+	perMajor := uint64(pow10(places))
+	if major > (1<<63-1-minor)/perMajor {
+		return 0, fmt.Errorf("%w: %q is out of range", ErrInvalidAmount, value)
+	}
+
+	total := int64(major*perMajor + minor)
+	if negative {
+		total = -total
+	}
+	return total, nil
+}
+
+// In returns an amount that was given without a currency in the given currency, e.g. the currency of the account
+// it is posted to. The amount has the decimal places of the default currency until then, so it is rescaled to
+// the currency's, an amount with more decimal places than the currency has fails with ErrInvalidAmount.
+// An amount that has a currency is returned as it is.
+func (m Money) In(currency string) (Money, error) {
+	if m.Currency != "" {
+		return m, nil
+	}
+
+	from, to := decimals(""), decimals(currency)
+	minor := m.Minor
+	if to > from {
+		minor *= pow10(to - from)
+	} else if factor := pow10(from - to); minor%factor != 0 {
+		return Money{}, fmt.Errorf("%w: %s has more than %d decimal places", ErrInvalidAmount, m.Decimal(), to)
+	} else {
+		minor /= factor
+	}
+	return NewMoney(minor, currency), nil
+}
+
+// Add returns the sum of two amounts in the same currency
+func (m Money) Add(other Money) (Money, error) {
+	if err := m.checkCurrency(other); err != nil {
+		return Money{}, err
+	}
+	return NewMoney(m.Minor+other.Minor, m.currency()), nil
+}
+
+// Sub returns the difference of two amounts in the same currency
+func (m Money) Sub(other Money) (Money, error) {
+	if err := m.checkCurrency(other); err != nil {
+		return Money{}, err
+	}
+	return NewMoney(m.Minor-other.Minor, m.currency()), nil
+}
+
+// Neg returns the amount with its sign flipped
+func (m Money) Neg() Money {
+	return NewMoney(-m.Minor, m.currency())
+}
+
+// IsZero reports whether the amount is zero
+func (m Money) IsZero() bool {
+	return m.Minor == 0
+}
+
+// IsNegative reports whether the amount is below zero
+func (m Money) IsNegative() bool {
+	return m.Minor < 0
+}
+
+// IsPositive reports whether the amount is above zero
+func (m Money) IsPositive() bool {
+	return m.Minor > 0
+}
+
+// Decimal formats the amount as a decimal string with the decimal places of its currency, e.g. "-12.30"
+func (m Money) Decimal() string {
+	sign := ""
+	minor := m.Minor
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+
+	places := decimals(m.Currency)
+	if places == 0 {
+		return fmt.Sprintf("%s%d", sign, minor)
+	}
+	perMajor := pow10(places)
+	return fmt.Sprintf("%s%d.%0*d", sign, minor/perMajor, places, minor%perMajor)
+}
+
+// String formats the amount with its currency, e.g. "12.30 USD"
+func (m Money) String() string {
+	return m.Decimal() + " " + m.currency()
+}
+
+// MarshalJSON encodes the amount as {"amount": "12.30", "currency": "USD"}.
+// The amount is a string so clients never round-trip it through a float.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}{Amount: m.Decimal(), Currency: m.currency()})
+}
+
+// UnmarshalJSON accepts either the object form produced by MarshalJSON or a bare
+// number or string such as 12.3 or "12.30", which leaves the currency empty.
+// Numbers are parsed from their literal text, never through float64. A currency that isn't supported
+// is kept, so request validation can report it on its field, see ValidCurrency.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	var raw moneyJSON
+	if len(data) > 0 && data[0] == '{' {
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+	} else if err := json.Unmarshal(data, &raw.Amount); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidAmount, data)
+	}
+
+	minor, err := parseMinor(raw.Amount.String(), decimals(raw.Currency))
+	if err != nil {
+		return err
+	}
+
+	*m = Money{Minor: minor, Currency: strings.ToUpper(raw.Currency)}
+	return nil
+}
+
+// accepted wire format of a money value, the amount may be a JSON number or a numeric string
+type moneyJSON struct {
+	Amount   json.Number `json:"amount"`
+	Currency string      `json:"currency,omitempty"`
+}
+
+func (m Money) currency() string {
+	return normalizeCurrency(m.Currency)
+}
+
+func (m Money) checkCurrency(other Money) error {
+	if m.currency() != other.currency() {
+		return fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.currency(), other.currency())
+	}
+	return nil
+}
+
+func normalizeCurrency(currency string) string {
+	if currency == "" {
+		return DefaultCurrency
+	}
+	return strings.ToUpper(currency)
+}
+
+// decimals returns the number of decimal places of a currency, an empty or unsupported one has the default currency's
+func decimals(currency string) int {
+	if places, ok := currencies[normalizeCurrency(currency)]; ok {
+		return places
+	}
+	return currencies[DefaultCurrency]
+}
+
+func pow10(n int) int64 {
+	result := int64(1)
+	for i := 0; i < n; i++ {
+		result *= 10
+	}
+	return result
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMoney(t *testing.T) {
+	cases := []struct {
+		input string
+		minor int64
+	}{
+		{"0", 0},
+		{"12", 1200},
+		{"12.3", 1230},
+		{"12.34", 1234},
+		{"-0.05", -5},
+		{".5", 50},
+		{"+7.10", 710},
+	}
+
+	for _, c := range cases {
+		money, err := ParseMoney(c.input, "usd")
+		if assert.NoError(t, err, c.input) {
+			assert.Equal(t, c.minor, money.Minor, c.input)
+			assert.Equal(t, "USD", money.Currency, c.input)
+		}
+	}
+}
+
+func TestParseMoney_Invalid(t *testing.T) {
+	for _, input := range []string{"", "-", ".", "1.", "1.234", "abc", "1e3", "1,00", "92233720368547758.08"} {
+		_, err := ParseMoney(input, "")
+		assert.ErrorIs(t, err, ErrInvalidAmount, input)
+	}
+}
+
+func TestParseMoney_CurrencyDecimals(t *testing.T) {
+	// yen have no minor unit and dinars have three decimal places
+	yen, err := ParseMoney("1500", "JPY")
+	if assert.NoError(t, err) {
+		assert.Equal(t, NewMoney(1500, "JPY"), yen)
+		assert.Equal(t, "1500", yen.Decimal())
+	}
+	dinars, err := ParseMoney("1.234", "kwd")
+	if assert.NoError(t, err) {
+		assert.Equal(t, NewMoney(1234, "KWD"), dinars)
+		assert.Equal(t, "1.234", dinars.Decimal())
+	}
+
+	_, err = ParseMoney("1.5", "JPY")
+	assert.ErrorIs(t, err, ErrInvalidAmount)
+	_, err = ParseMoney("1.2345", "KWD")
+	assert.ErrorIs(t, err, ErrInvalidAmount)
+}
+
+func TestParseMoney_InvalidCurrency(t *testing.T) {
+	for _, currency := range []string{"XYZ", "US", "dollars", "usd "} {
+		_, err := ParseMoney("1.00", currency)
+		assert.ErrorIs(t, err, ErrInvalidCurrency, currency)
+	}
+	assert.True(t, ValidCurrency("eur"))
+	assert.False(t, ValidCurrency(""))
+}
+
+func TestMoney_In(t *testing.T) {
+	// an amount without a currency takes the currency's decimal places
+	amount, err := ParseMoney("12.50", "")
+	if assert.NoError(t, err) {
+		dinars, err := amount.In("KWD")
+		assert.NoError(t, err)
+		assert.Equal(t, NewMoney(12500, "KWD"), dinars)
+
+		_, err = amount.In("JPY")
+		assert.ErrorIs(t, err, ErrInvalidAmount)
+	}
+
+	yen, err := Money{Minor: 1200}.In("JPY")
+	assert.NoError(t, err)
+	assert.Equal(t, NewMoney(12, "JPY"), yen)
+
+	// an amount with a currency is left alone
+	euros, err := NewMoney(1250, "EUR").In("JPY")
+	assert.NoError(t, err)
+	assert.Equal(t, NewMoney(1250, "EUR"), euros)
+}
+
+func TestMoney_AddDoesNotDrift(t *testing.T) {
+	// 0.1 added ten times is exactly 1.00, unlike float64
+	total := NewMoney(0, "USD")
+	for i := 0; i < 10; i++ {
+		var err error
+		total, err = total.Add(NewMoney(10, "USD"))
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, "1.00", total.Decimal())
+}
+
+func TestMoney_CurrencyMismatch(t *testing.T) {
+	_, err := NewMoney(100, "USD").Sub(NewMoney(100, "EUR"))
+	assert.ErrorIs(t, err, ErrCurrencyMismatch)
+}
+
+func TestMoney_JSON(t *testing.T) {
+	data, err := json.Marshal(NewMoney(-1205, "usd"))
+	if assert.NoError(t, err) {
+		assert.JSONEq(t, `{"amount":"-12.05","currency":"USD"}`, string(data))
+	}
+
+	for input, expected := range map[string]Money{
+		`{"amount":"12.05","currency":"EUR"}`: {Minor: 1205, Currency: "EUR"},
+		`{"amount":12.05}`:                    {Minor: 1205},
+		`12.05`:                               {Minor: 1205},
+		`"0.10"`:                              {Minor: 10},
+		`{"amount":"1500","currency":"jpy"}`:  {Minor: 1500, Currency: "JPY"},
+		`{"amount":"5","currency":"XYZ"}`:     {Minor: 500, Currency: "XYZ"},
+	} {
+		var money Money
+		if assert.NoError(t, json.Unmarshal([]byte(input), &money), input) {
+			assert.Equal(t, expected, money, input)
+		}
+	}
+
+	var money Money
+	assert.ErrorIs(t, json.Unmarshal([]byte(`"12.345"`), &money), ErrInvalidAmount)
+}
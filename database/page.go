@@ -0,0 +1,177 @@
+package database
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// number of records returned by a page when no limit is given, and the most a page can return
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 200
+)
+
+// PageRequest asks for one page of a list. Cursor is the NextCursor of the previous page,
+// Sort names the field to sort by and each list decides which fields it can be sorted by.
+type PageRequest struct {
+	Cursor string
+	Limit  int
+	Sort   string
+	Desc   bool
+}
+
+// Page is one page of a list, NextCursor is empty on the last page
+type Page[M any] struct {
+	Items      []M    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// AccountFilter narrows down a list of accounts, zero values don't filter.
+// Date ranges include From and exclude To, balance ranges are in minor units and include both ends.
+type AccountFilter struct {
+	OwnerID     *uint
+	AccountType string
+	Status      string
+	Holder      string //case insensitive substring of the account holder
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	MinBalance  *int64
+	MaxBalance  *int64
+}
+
+// TransactionFilter narrows down a list of transactions, zero values don't filter.
+// Date ranges include From and exclude To, amount ranges are in minor units and include both ends.
+type TransactionFilter struct {
+	OwnerID     *uint //only transactions on accounts that belong to this user
+	AccountID   *uint
+	Type        string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	MinAmount   *int64
+	MaxAmount   *int64
+}
+
+// sortKey is a field a list can be sorted by. field reads it from a row as a uint, int64, string or time.Time,
+// which is what cursors are written from and what the memory store compares.
+type sortKey[M any] struct {
+	column string
+	field  func(row *M) interface{}
+}
+
+// the fields a list of accounts can be sorted by
+var accountSortKeys = map[string]sortKey[Account]{
+	"id":         {column: "id", field: func(a *Account) interface{} { return a.ID }},
+	"created_at": {column: "created_at", field: func(a *Account) interface{} { return a.CreatedAt }},
+	"holder":     {column: "account_holder", field: func(a *Account) interface{} { return a.AccountHolder }},
+	"balance":    {column: "balance_minor", field: func(a *Account) interface{} { return a.Balance.Minor }},
+}
+
+// the fields a list of transactions can be sorted by
+var transactionSortKeys = map[string]sortKey[Transaction]{
+	"id":         {column: "id", field: func(t *Transaction) interface{} { return t.ID }},
+	"created_at": {column: "created_at", field: func(t *Transaction) interface{} { return t.CreatedAt }},
+	"amount":     {column: "amount_minor", field: func(t *Transaction) interface{} { return t.Amount.Minor }},
+}
+
+// value writes the field of a row to a cursor
+func (k sortKey[M]) value(row *M) string {
+	switch v := k.field(row).(type) {
+	case uint:
+		return strconv.FormatUint(uint64(v), 10)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// parse reads back a value written to a cursor as the type of the field
+func (k sortKey[M]) parse(value string) (interface{}, error) {
+	var zero M
+	switch k.field(&zero).(type) {
+	case uint:
+		id, err := strconv.ParseUint(value, 10, 0)
+		return uint(id), err
+	case int64:
+		return strconv.ParseInt(value, 10, 64)
+	case time.Time:
+		return time.Parse(time.RFC3339Nano, value)
+	default:
+		return value, nil
+	}
+}
+
+// compareValues orders two values of the same field
+func compareValues(a interface{}, b interface{}) int {
+	switch a := a.(type) {
+	case uint:
+		return cmp.Compare(a, b.(uint))
+	case int64:
+		return cmp.Compare(a, b.(int64))
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	default:
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	}
+}
+
+// sortKeyFor looks up the field a page is sorted by, lists are sorted by id unless the page says otherwise
+func sortKeyFor[M any](keys map[string]sortKey[M], page PageRequest) (sortKey[M], error) {
+	if page.Sort == "" {
+		page.Sort = "id"
+	}
+	key, ok := keys[page.Sort]
+	if !ok {
+		return key, fmt.Errorf("%w: %s", ErrInvalidSort, page.Sort)
+	}
+	return key, nil
+}
+
+// pageLimit is the number of rows a page returns
+func pageLimit(page PageRequest) int {
+	if page.Limit <= 0 {
+		return DefaultPageLimit
+	}
+	return min(page.Limit, MaxPageLimit)
+}
+
+// cursor points just past the last row of a page, ties on the sort column are broken by id
+type cursor struct {
+	Value string `json:"v,omitempty"`
+	ID    uint   `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// nextPage cuts the rows of a page, fetched with one extra row to tell whether there is another page, down to the limit
+func nextPage[M any](rows []M, key sortKey[M], id func(row *M) uint, limit int) *Page[M] {
+	page := &Page[M]{Items: rows}
+	if len(rows) > limit {
+		page.Items = rows[:limit]
+		last := &page.Items[limit-1]
+		page.NextCursor = encodeCursor(cursor{Value: key.value(last), ID: id(last)})
+	}
+	return page
+}
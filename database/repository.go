@@ -0,0 +1,101 @@
+package database
+
+import (
+	"time"
+)
+
+// Store gives access to the repositories of the banking data. NewGormStore keeps them in whichever database
+// gorm is connected to and NewMemoryStore keeps them in maps, so the services can run without a database.
+type Store interface {
+	Accounts() AccountRepository
+	Transactions() TransactionRepository
+	Transfers() TransferRepository
+	Journal() JournalRepository
+	InterestPostings() PeriodRepository[InterestPosting]
+	MaintenanceFees() PeriodRepository[MaintenanceFee]
+
+	// Atomic runs fn with a store whose changes are all kept when fn returns nil and all dropped otherwise.
+	// Calling Atomic on the store passed to fn runs the inner function as part of the outer one.
+	Atomic(fn func(store Store) error) error
+
+	// Snapshot runs fn with a store that only reads, every read sees the data as it was at one moment and nothing
+	// is locked for update. Calling Snapshot on the store passed to Atomic runs fn as part of that call.
+	Snapshot(fn func(store Store) error) error
+}
+
+// Repository stores one kind of model, FetchById returns ErrNotFound for a missing id
+type Repository[M any] interface {
+	Create(model *M) error
+	FetchById(id uint) (*M, error)
+}
+
+// AccountRepository stores accounts. Writes to an account the caller has read are a compare and swap on its
+// version, they return ErrConflict when the account has moved on and bump the version of the caller's copy.
+type AccountRepository interface {
+	Repository[Account]
+	List() (*[]Account, error)
+	ListPage(filter AccountFilter, page PageRequest) (*Page[Account], error)
+
+	// ListOpen lists, by id, the open accounts of the given types that were opened before the given time
+	ListOpen(accountTypes []string, openedBefore time.Time) ([]Account, error)
+
+	// FetchForUpdate fetches an account and locks it until the surrounding Atomic call returns
+	FetchForUpdate(id uint) (*Account, error)
+
+	// UpdateHolder writes the holder and bumps the version, when version isn't zero only if it matches.
+	// It reports whether an account was updated.
+	UpdateHolder(id uint, holder string, version uint) (bool, error)
+	UpdateBalance(account *Account) error
+	UpdateStatus(account *Account, status string) error
+
+	// MarkDormant makes open accounts that haven't been updated since the given time dormant and returns how many
+	MarkDormant(inactiveSince time.Time) (int64, error)
+}
+
+// TransactionRepository stores transactions, the lists preload the account of each transaction
+type TransactionRepository interface {
+	Repository[Transaction]
+	List() (*[]Transaction, error)
+	ListByAccount(accountID uint) (*[]Transaction, error)
+	ListPage(filter TransactionFilter, page PageRequest) (*Page[Transaction], error)
+
+	// Since lists the transactions on an account from the given time on, in the order they were posted
+	Since(accountID uint, from time.Time) ([]Transaction, error)
+
+	// Fees lists, by id, the fees a transaction triggered
+	Fees(id uint) ([]Transaction, error)
+
+	// IsReversed reports whether a reversal of the transaction has been posted, a transaction can only have one
+	IsReversed(id uint) (bool, error)
+	Save(transaction *Transaction) error
+	Delete(transaction *Transaction) error
+}
+
+// TransferRepository stores transfers, FetchById loads both legs along with the transfer
+type TransferRepository interface {
+	Repository[Transfer]
+}
+
+// JournalRepository stores the entries of the double-entry ledger, Create writes the postings along with the entry
+type JournalRepository interface {
+	Create(entry *JournalEntry) error
+
+	// Sum adds up the postings to a customer account in one currency, in minor units
+	Sum(accountID uint, currency string) (int64, error)
+
+	// Unbalanced lists the entries whose postings don't sum to zero in a currency
+	Unbalanced() ([]EntryImbalance, error)
+}
+
+// PeriodRecord is a record that something has been done for an account once in a period,
+// such as an InterestPosting or a MaintenanceFee
+type PeriodRecord interface {
+	InterestPosting | MaintenanceFee
+}
+
+// PeriodRepository stores the records of a period, there is at most one per account and period
+type PeriodRepository[R PeriodRecord] interface {
+	Recorded(accountID uint, period string) (bool, error)
+	Create(record *R) error
+	Save(record *R) error
+}
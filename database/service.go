@@ -1,9 +1,11 @@
 package database
 
+// Service manages one kind of model, the services for accounts and transactions implement it
+// on top of the repositories of a Store
 type Service[M any] interface {
 	Create(model *M) error
-	Delete(id int) error
-	FetchById(id int) (*M, error)
-	List() ([]*M, error)
-	Update(mode *M) error
+	Delete(id uint) error
+	FetchById(id uint) (*M, error)
+	List() (*[]M, error)
+	Update(model *M) error
 }
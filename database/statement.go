@@ -0,0 +1,23 @@
+package database
+
+import (
+	"time"
+)
+
+// Statement lists the transactions on an account over a period, From is included and To is not.
+// The closing balance is the opening balance plus every transaction on the statement.
+type Statement struct {
+	AccountID      uint            `json:"accountID"`
+	Account        *Account        `json:"account,omitempty"`
+	From           time.Time       `json:"from"`
+	To             time.Time       `json:"to"`
+	OpeningBalance Money           `json:"openingBalance"`
+	Lines          []StatementLine `json:"transactions"`
+	ClosingBalance Money           `json:"closingBalance"`
+}
+
+// StatementLine is a transaction on a statement along with the account balance right after it
+type StatementLine struct {
+	Transaction    Transaction `json:"transaction"`
+	RunningBalance Money       `json:"runningBalance"`
+}
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// RefreshToken lets a client get a new access token without logging in again. Only a hash of the token is stored.
+// Every refresh replaces the token with a new one in the same family, so a token that is used twice has leaked
+// and the whole family is revoked.
+type RefreshToken struct {
+	gorm.Model
+	UserID    uint   `gorm:"index;not null"`
+	FamilyID  string `gorm:"index;not null"`
+	TokenHash string `gorm:"unique_index;not null"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+// RevokedToken is an access token that was revoked before it expired, identified by its jti claim.
+// Rows can be removed once the token would have expired anyway.
+type RevokedToken struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	JTI       string `gorm:"unique_index;not null"`
+	ExpiresAt time.Time
+}
+
+type TokenService interface {
+	Issue(userID uint) (string, error)
+	Rotate(refreshToken string) (uint, string, error)
+	Revoke(refreshToken string) error
+	RevokeAccessToken(jti string, expiresAt time.Time) error
+	IsRevoked(jti string) (bool, error)
+}
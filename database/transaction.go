@@ -1,18 +1,32 @@
 package database
 
 import (
+	"time"
+
 	"github.com/jinzhu/gorm"
 )
 
 type Transaction struct {
-	gorm.Model          //leaving this ananymous field here so gorm:embedded tag isn't necessary
-	AccountID  uint     `json:"accountID" binding:"required"`
-	Account    *Account `json:"account"`
-	Type       string   `json:"transactionType" binding:"required"`
-	Amount     float64  `json:"transactionAmount" binding:"required"`
+	gorm.Model                   //leaving this ananymous field here so gorm:embedded tag isn't necessary
+	AccountID      uint          `json:"accountID" binding:"required"`
+	Account        *Account      `json:"account"`
+	Type           string        `json:"transactionType" binding:"required"`
+	Amount         Money         `json:"transactionAmount" gorm:"embedded;embedded_prefix:amount_"`
+	TransferID     *uint         `json:"transferID,omitempty"`
+	ReversalOfID   *uint         `json:"reversalOfID,omitempty" gorm:"unique_index:idx_transactions_reversal_of_id"` //set on a reversal, the transaction it cancels out
+	JournalEntryID *uint         `json:"journalEntryID,omitempty"`
+	FeeForID       *uint         `json:"feeForID,omitempty" gorm:"index"` //set on a fee, the transaction that triggered it
+	Fees           []Transaction `json:"fees,omitempty" gorm:"-"`         //the fees charged for a transaction, or refunded with a reversal
 }
 
+// TransactionTypes are the types a transaction can be created with, interest and fee transactions are only posted
+// by the services
+var TransactionTypes = []string{"deposit", "withdrawal"}
+
 type TransactionService interface {
 	Service[Transaction]
 	ListByAccount(accountID uint) (*[]Transaction, error)
+	ListPage(filter TransactionFilter, page PageRequest) (*Page[Transaction], error)
+	Reverse(id uint) (*Transaction, error)
+	Statement(accountID uint, from time.Time, to time.Time) (*Statement, error)
 }
@@ -0,0 +1,19 @@
+package database
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Transfer moves money between two accounts, it is recorded as a withdrawal and a deposit linked by the transfer ID
+type Transfer struct {
+	gorm.Model                  //leaving this ananymous field here so gorm:embedded tag isn't necessary
+	FromAccountID uint          `json:"fromAccountID" binding:"required"`
+	ToAccountID   uint          `json:"toAccountID" binding:"required"`
+	Amount        Money         `json:"amount" gorm:"embedded;embedded_prefix:amount_"`
+	Transactions  []Transaction `json:"transactions,omitempty" gorm:"foreignKey:TransferID"`
+}
+
+type TransferService interface {
+	Create(transfer *Transfer) error
+	FetchById(id uint) (*Transfer, error)
+}
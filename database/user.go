@@ -0,0 +1,31 @@
+package database
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// Roles a user can have, customers only see their own accounts while staff see every account
+const (
+	RoleCustomer = "customer"
+	RoleTeller   = "teller"
+	RoleAdmin    = "admin"
+)
+
+// User is someone who can log in to the API, only a bcrypt hash of the password is stored
+type User struct {
+	gorm.Model          //leaving this ananymous field here so gorm:embedded tag isn't necessary
+	Username     string `json:"username" gorm:"unique_index;not null"`
+	PasswordHash string `json:"-" gorm:"not null"`
+	Role         string `json:"role" gorm:"not null;default:'customer'"`
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	return role == RoleCustomer || role == RoleTeller || role == RoleAdmin
+}
+
+type UserService interface {
+	Register(username string, password string, role string) (*User, error)
+	Authenticate(username string, password string) (*User, error)
+	FetchById(id uint) (*User, error)
+}
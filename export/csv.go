@@ -0,0 +1,41 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/jobullo/go-api-example/database"
+)
+
+// WriteCSV writes one row per transaction with a header row, amounts are signed decimals
+// such as -12.50 so spreadsheets can sum them
+func WriteCSV(w io.Writer, statement *database.Statement) error {
+	rows, err := lines(statement)
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"date", "transaction_id", "type", "amount", "currency", "balance"}); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		record := []string{
+			row.Transaction.CreatedAt.UTC().Format(time.RFC3339),
+			strconv.FormatUint(uint64(row.Transaction.ID), 10),
+			row.Transaction.Type,
+			row.Amount.Decimal(),
+			row.Amount.Currency,
+			row.RunningBalance.Decimal(),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,98 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jobullo/go-api-example/database"
+)
+
+// Formats a statement can be exported in
+const (
+	CSV  = "csv"
+	OFX  = "ofx"
+	Text = "text"
+)
+
+// Formats lists every export format, in the order they are offered to clients
+var Formats = []string{CSV, OFX, Text}
+
+var ErrUnknownFormat = errors.New("unknown export format")
+
+var contentTypes = map[string]string{
+	CSV:  "text/csv",
+	OFX:  "application/x-ofx",
+	Text: "text/plain",
+}
+
+var extensions = map[string]string{
+	CSV:  "csv",
+	OFX:  "ofx",
+	Text: "txt",
+}
+
+// ContentType returns the MIME type of a format
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+// FormatFor returns the format with the given MIME type, or an empty string if there is none
+func FormatFor(contentType string) string {
+	for format, ct := range contentTypes {
+		if ct == contentType {
+			return format
+		}
+	}
+	return ""
+}
+
+// FileName suggests a file name for an exported statement
+func FileName(format string, statement *database.Statement) string {
+	return fmt.Sprintf("statement-%d-%s.%s", statement.AccountID, statement.From.Format(dateLayout), extensions[format])
+}
+
+// Write writes the statement to w in the given format
+func Write(w io.Writer, format string, statement *database.Statement) error {
+	switch format {
+	case CSV:
+		return WriteCSV(w, statement)
+	case OFX:
+		return WriteOFX(w, statement)
+	case Text:
+		return WriteText(w, statement)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+	}
+}
+
+const dateLayout = "2006-01-02"
+
+// line is a statement line with the signed amount it added to the balance
+type line struct {
+	database.StatementLine
+	Amount database.Money
+}
+
+// lines works out the signed amount of every statement line from the running balances,
+// so withdrawals come out negative without knowing about transaction types
+func lines(statement *database.Statement) ([]line, error) {
+	result := make([]line, 0, len(statement.Lines))
+	previous := statement.OpeningBalance
+	for _, l := range statement.Lines {
+		amount, err := l.RunningBalance.Sub(previous)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, line{StatementLine: l, Amount: amount})
+		previous = l.RunningBalance
+	}
+	return result, nil
+}
+
+// lastDay is the last day of the statement period, the end of a period is not part of it
+func lastDay(statement *database.Statement) time.Time {
+	return statement.To.Add(-time.Nanosecond)
+}
@@ -0,0 +1,107 @@
+package export
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jobullo/go-api-example/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// a January statement that opens at 80.00, takes a deposit of 50.00 and a withdrawal of 20.00
+func testStatement() *database.Statement {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	transaction := func(id uint, day int, transactionType string, amount int64) database.Transaction {
+		return database.Transaction{
+			Model:  gorm.Model{ID: id, CreatedAt: from.AddDate(0, 0, day-1).Add(9 * time.Hour)},
+			Type:   transactionType,
+			Amount: database.NewMoney(amount, "USD"),
+		}
+	}
+
+	return &database.Statement{
+		AccountID:      4,
+		Account:        &database.Account{AccountHolder: "Foo & Bar", AccountType: "savings"},
+		From:           from,
+		To:             from.AddDate(0, 1, 0),
+		OpeningBalance: database.NewMoney(8000, "USD"),
+		Lines: []database.StatementLine{
+			{Transaction: transaction(1, 2, "deposit", 5000), RunningBalance: database.NewMoney(13000, "USD")},
+			{Transaction: transaction(2, 15, "withdrawal", 2000), RunningBalance: database.NewMoney(11000, "USD")},
+		},
+		ClosingBalance: database.NewMoney(11000, "USD"),
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var b strings.Builder
+
+	require.NoError(t, WriteCSV(&b, testStatement()))
+
+	assert.Equal(t, "date,transaction_id,type,amount,currency,balance\n"+
+		"2024-01-02T09:00:00Z,1,deposit,50.00,USD,130.00\n"+
+		"2024-01-15T09:00:00Z,2,withdrawal,-20.00,USD,110.00\n", b.String())
+}
+
+func TestWriteOFX(t *testing.T) {
+	var b strings.Builder
+
+	require.NoError(t, WriteOFX(&b, testStatement()))
+
+	// the body after the headers is well formed xml
+	out := b.String()
+	assert.True(t, strings.HasPrefix(out, `<?xml version="1.0"`))
+	assert.Contains(t, out, `<?OFX OFXHEADER="200" VERSION="220"`)
+
+	body := out[strings.Index(out, "<OFX>"):]
+	var doc ofxDocument
+	require.NoError(t, xml.Unmarshal([]byte(body), &doc))
+
+	response := doc.Statement.Response
+	assert.Equal(t, "USD", response.Currency)
+	assert.Equal(t, "4", response.Account.ID)
+	assert.Equal(t, "SAVINGS", response.Account.Type)
+	assert.Equal(t, "20240101000000", response.Transactions.Start)
+	assert.Equal(t, "110.00", response.Balance.Amount)
+	if assert.Len(t, response.Transactions.Transactions, 2) {
+		assert.Equal(t, ofxTransaction{Type: "CREDIT", Posted: "20240102090000", Amount: "50.00", ID: "1", Name: "deposit"}, response.Transactions.Transactions[0])
+		assert.Equal(t, ofxTransaction{Type: "DEBIT", Posted: "20240115090000", Amount: "-20.00", ID: "2", Name: "withdrawal"}, response.Transactions.Transactions[1])
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	var b strings.Builder
+
+	require.NoError(t, WriteText(&b, testStatement()))
+
+	out := b.String()
+	assert.Contains(t, out, "Statement for account 4 (savings), Foo & Bar\n")
+	assert.Contains(t, out, "Period 2024-01-01 to 2024-01-31, amounts in USD\n")
+
+	// every row is the same width so the columns line up
+	rows := strings.Split(strings.TrimSuffix(out, "\n"), "\n")[3:]
+	if assert.Len(t, rows, 6) {
+		for _, row := range rows {
+			assert.Len(t, row, len(rows[0]), row)
+		}
+		assert.Equal(t, "2024-01-15         2  withdrawal                -20.00          110.00", rows[4])
+		assert.True(t, strings.HasSuffix(rows[5], "110.00"))
+	}
+}
+
+func TestWrite_UnknownFormat(t *testing.T) {
+	var b strings.Builder
+
+	assert.ErrorIs(t, Write(&b, "xls", testStatement()), ErrUnknownFormat)
+}
+
+func TestFormatFor(t *testing.T) {
+	for _, format := range Formats {
+		assert.Equal(t, format, FormatFor(ContentType(format)))
+	}
+	assert.Equal(t, "", FormatFor("application/json"))
+}
@@ -0,0 +1,136 @@
+package export
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jobullo/go-api-example/database"
+)
+
+// BankID identifies the bank in OFX files, accounting tools use it together with the account id
+// to match imports to the right account
+var BankID = "000000000"
+
+// OFX 2 files are XML with a processing instruction in place of the SGML headers of OFX 1
+const ofxHeader = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>` + "\n" +
+	`<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>` + "\n"
+
+// dates in OFX are written without separators
+const ofxDateLayout = "20060102150405"
+
+type ofxStatus struct {
+	Code     int    `xml:"CODE"`
+	Severity string `xml:"SEVERITY"`
+}
+
+type ofxTransaction struct {
+	Type   string `xml:"TRNTYPE"`
+	Posted string `xml:"DTPOSTED"`
+	Amount string `xml:"TRNAMT"`
+	ID     string `xml:"FITID"`
+	Name   string `xml:"NAME"`
+}
+
+type ofxDocument struct {
+	XMLName xml.Name `xml:"OFX"`
+	SignOn  struct {
+		Status   ofxStatus `xml:"STATUS"`
+		Server   string    `xml:"DTSERVER"`
+		Language string    `xml:"LANGUAGE"`
+	} `xml:"SIGNONMSGSRSV1>SONRS"`
+	Statement struct {
+		TransactionUID string    `xml:"TRNUID"`
+		Status         ofxStatus `xml:"STATUS"`
+		Response       struct {
+			Currency string `xml:"CURDEF"`
+			Account  struct {
+				BankID string `xml:"BANKID"`
+				ID     string `xml:"ACCTID"`
+				Type   string `xml:"ACCTTYPE"`
+			} `xml:"BANKACCTFROM"`
+			Transactions struct {
+				Start        string           `xml:"DTSTART"`
+				End          string           `xml:"DTEND"`
+				Transactions []ofxTransaction `xml:"STMTTRN"`
+			} `xml:"BANKTRANLIST"`
+			Balance struct {
+				Amount string `xml:"BALAMT"`
+				AsOf   string `xml:"DTASOF"`
+			} `xml:"LEDGERBAL"`
+		} `xml:"STMTRS"`
+	} `xml:"BANKMSGSRSV1>STMTTRNRS"`
+}
+
+// WriteOFX writes the statement as an OFX 2.2 bank statement download
+func WriteOFX(w io.Writer, statement *database.Statement) error {
+	rows, err := lines(statement)
+	if err != nil {
+		return err
+	}
+
+	var doc ofxDocument
+	doc.SignOn.Status = ofxStatus{Code: 0, Severity: "INFO"}
+	doc.SignOn.Server = ofxDate(time.Now())
+	doc.SignOn.Language = "ENG"
+
+	doc.Statement.TransactionUID = "0"
+	doc.Statement.Status = ofxStatus{Code: 0, Severity: "INFO"}
+
+	response := &doc.Statement.Response
+	response.Currency = statement.ClosingBalance.Normalized().Currency
+	response.Account.BankID = BankID
+	response.Account.ID = strconv.FormatUint(uint64(statement.AccountID), 10)
+	response.Account.Type = ofxAccountType(statement.Account)
+	response.Transactions.Start = ofxDate(statement.From)
+	response.Transactions.End = ofxDate(statement.To)
+	response.Balance.Amount = statement.ClosingBalance.Decimal()
+	response.Balance.AsOf = ofxDate(statement.To)
+
+	for _, row := range rows {
+		transactionType := "CREDIT"
+		switch {
+		case row.Transaction.Type == "interest":
+			transactionType = "INT"
+		case row.Transaction.Type == "fee":
+			transactionType = "FEE"
+		case row.Amount.IsNegative():
+			transactionType = "DEBIT"
+		}
+
+		response.Transactions.Transactions = append(response.Transactions.Transactions, ofxTransaction{
+			Type:   transactionType,
+			Posted: ofxDate(row.Transaction.CreatedAt),
+			Amount: row.Amount.Decimal(),
+			ID:     strconv.FormatUint(uint64(row.Transaction.ID), 10),
+			Name:   row.Transaction.Type,
+		})
+	}
+
+	if _, err := io.WriteString(w, ofxHeader); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+func ofxDate(t time.Time) string {
+	return t.UTC().Format(ofxDateLayout)
+}
+
+// ofxAccountType maps our account types to the OFX ones, anything OFX doesn't know is a checking account
+func ofxAccountType(account *database.Account) string {
+	if account != nil && strings.EqualFold(account.AccountType, "savings") {
+		return "SAVINGS"
+	}
+	return "CHECKING"
+}
@@ -0,0 +1,48 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jobullo/go-api-example/database"
+)
+
+// layout of a row of the text statement: date, transaction id, description, amount and balance
+const textRow = "%-10s  %8s  %-16s  %14s  %14s\n"
+
+// WriteText writes the statement as fixed-width plain text that lines up in a monospaced font
+func WriteText(w io.Writer, statement *database.Statement) error {
+	rows, err := lines(statement)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Statement for account %d", statement.AccountID)
+	if account := statement.Account; account != nil {
+		fmt.Fprintf(&b, " (%s), %s", account.AccountType, account.AccountHolder)
+	}
+	fmt.Fprintf(&b, "\nPeriod %s to %s, amounts in %s\n\n",
+		statement.From.Format(dateLayout), lastDay(statement).Format(dateLayout), statement.ClosingBalance.Normalized().Currency)
+
+	fmt.Fprintf(&b, textRow, "Date", "ID", "Description", "Amount", "Balance")
+	fmt.Fprintf(&b, textRow, strings.Repeat("-", 10), strings.Repeat("-", 8), strings.Repeat("-", 16), strings.Repeat("-", 14), strings.Repeat("-", 14))
+	fmt.Fprintf(&b, textRow, statement.From.Format(dateLayout), "", "Opening balance", "", statement.OpeningBalance.Decimal())
+
+	for _, row := range rows {
+		fmt.Fprintf(&b, textRow,
+			row.Transaction.CreatedAt.Format(dateLayout),
+			fmt.Sprint(row.Transaction.ID),
+			row.Transaction.Type,
+			row.Amount.Decimal(),
+			row.RunningBalance.Decimal(),
+		)
+	}
+
+	fmt.Fprintf(&b, textRow, lastDay(statement).Format(dateLayout), "", "Closing balance", "", statement.ClosingBalance.Decimal())
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,92 @@
+package ledger
+
+import (
+	"fmt"
+
+	"github.com/jobullo/go-api-example/database"
+)
+
+// Debit posts an amount to the debit side of a customer account
+func Debit(accountID uint, amount database.Money) database.Posting {
+	return database.Posting{AccountID: &accountID, Amount: amount}
+}
+
+// Credit posts an amount to the credit side of a customer account
+func Credit(accountID uint, amount database.Money) database.Posting {
+	return database.Posting{AccountID: &accountID, Amount: amount.Neg()}
+}
+
+// DebitSystem posts an amount to the debit side of an internal account
+func DebitSystem(name string, amount database.Money) database.Posting {
+	return database.Posting{SystemAccount: name, Amount: amount}
+}
+
+// CreditSystem posts an amount to the credit side of an internal account
+func CreditSystem(name string, amount database.Money) database.Posting {
+	return database.Posting{SystemAccount: name, Amount: amount.Neg()}
+}
+
+// EntryFor builds the journal entry for a customer transaction. The customer account is credited for
+// money coming in and debited for money going out, the other side goes to cash or, for the legs
+// of a transfer, to the transfer clearing account. Interest is paid out of the interest expense account and fees are paid into the fee income account.
+func EntryFor(transaction *database.Transaction) (database.JournalEntry, error) {
+	counterparty := Cash
+	if transaction.TransferID != nil {
+		counterparty = TransferClearing
+	}
+
+	entry := database.JournalEntry{
+		Description: fmt.Sprintf("%s on account %d", transaction.Type, transaction.AccountID),
+	}
+
+	switch transaction.Type {
+	case "deposit":
+		entry.Postings = []database.Posting{
+			DebitSystem(counterparty, transaction.Amount),
+			Credit(transaction.AccountID, transaction.Amount),
+		}
+	case "withdrawal":
+		entry.Postings = []database.Posting{
+			Debit(transaction.AccountID, transaction.Amount),
+			CreditSystem(counterparty, transaction.Amount),
+		}
+	case "interest":
+		entry.Postings = []database.Posting{
+			DebitSystem(InterestExpense, transaction.Amount),
+			Credit(transaction.AccountID, transaction.Amount),
+		}
+	case "fee":
+		entry.Postings = []database.Posting{
+			Debit(transaction.AccountID, transaction.Amount),
+			CreditSystem(FeeIncome, transaction.Amount),
+		}
+	default:
+		return database.JournalEntry{}, database.ErrInvalidType
+	}
+
+	return entry, nil
+}
+
+// OpeningEntry builds the journal entry for the balance an account was opened with
+func OpeningEntry(account *database.Account) database.JournalEntry {
+	return database.JournalEntry{
+		Description: fmt.Sprintf("opening balance of account %d", account.ID),
+		Postings: []database.Posting{
+			DebitSystem(OpeningBalance, account.Balance),
+			Credit(account.ID, account.Balance),
+		},
+	}
+}
+
+// Reversed returns an entry with every posting of the given entry flipped to the other side
+func Reversed(entry database.JournalEntry, description string) database.JournalEntry {
+	reversed := database.JournalEntry{Description: description}
+	for _, posting := range entry.Postings {
+		reversed.Postings = append(reversed.Postings, database.Posting{
+			AccountID:     posting.AccountID,
+			SystemAccount: posting.SystemAccount,
+			Amount:        posting.Amount.Neg(),
+		})
+	}
+	return reversed
+}
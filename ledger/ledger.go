@@ -0,0 +1,90 @@
+package ledger
+
+import (
+	"fmt"
+
+	"github.com/jobullo/go-api-example/database"
+)
+
+// Internal system accounts that customer accounts are posted against.
+// Customer deposit accounts are liabilities of the bank, so money a customer holds is a credit.
+const (
+	Cash             = "cash"              //money physically received or paid out
+	TransferClearing = "transfer_clearing" //holds a transfer between its withdrawal and deposit legs
+	OpeningBalance   = "opening_balance"   //balances that accounts were opened with
+	InterestExpense  = "interest_expense"  //interest the bank has paid on customer accounts
+	FeeIncome        = "fee_income"        //fees the bank has charged to customer accounts
+)
+
+type Ledger struct {
+	journal database.JournalRepository
+}
+
+// create a new ledger that keeps its entries in the given journal
+func New(journal database.JournalRepository) *Ledger {
+	return &Ledger{journal: journal}
+}
+
+// Post validates that the entry balances and writes it along with its postings
+func (l *Ledger) Post(entry *database.JournalEntry) error {
+	if err := Validate(entry); err != nil {
+		return err
+	}
+
+	return l.journal.Create(entry)
+}
+
+// Balance returns what the bank owes the holder of a customer account, i.e. credits less debits
+func (l *Ledger) Balance(accountID uint, currency string) (database.Money, error) {
+	sum, err := l.journal.Sum(accountID, currency)
+	if err != nil {
+		return database.Money{}, err
+	}
+
+	return database.NewMoney(-sum, currency), nil
+}
+
+// Verify checks the ledger invariant: the postings of every journal entry sum to zero in each currency,
+// which also means all postings in the ledger sum to zero
+func (l *Ledger) Verify() error {
+	unbalanced, err := l.journal.Unbalanced()
+	if err != nil {
+		return err
+	}
+
+	if len(unbalanced) > 0 {
+		first := unbalanced[0]
+		return fmt.Errorf("%w: %d entries, e.g. entry %d is off by %s",
+			database.ErrUnbalancedEntry, len(unbalanced), first.JournalEntryID, database.NewMoney(first.Total, first.AmountCurrency))
+	}
+
+	return nil
+}
+
+// Validate checks that an entry has at least two non-zero postings in a single currency that sum to zero
+func Validate(entry *database.JournalEntry) error {
+	if len(entry.Postings) < 2 {
+		return fmt.Errorf("%w: an entry needs at least two postings", database.ErrUnbalancedEntry)
+	}
+
+	total := database.NewMoney(0, entry.Postings[0].Amount.Currency)
+	for _, posting := range entry.Postings {
+		if posting.Amount.IsZero() {
+			return fmt.Errorf("%w: zero amount posting", database.ErrUnbalancedEntry)
+		}
+		if (posting.AccountID == nil) == (posting.SystemAccount == "") {
+			return fmt.Errorf("%w: a posting needs either a customer or a system account", database.ErrUnbalancedEntry)
+		}
+
+		var err error
+		if total, err = total.Add(posting.Amount); err != nil {
+			return err
+		}
+	}
+
+	if !total.IsZero() {
+		return fmt.Errorf("%w: postings sum to %s", database.ErrUnbalancedEntry, total)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package ledger
+
+import (
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jobullo/go-api-example/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntryFor_Deposit(t *testing.T) {
+	transaction := &database.Transaction{AccountID: 3, Type: "deposit", Amount: database.NewMoney(1250, "USD")}
+
+	entry, err := EntryFor(transaction)
+
+	if assert.NoError(t, err) && assert.NoError(t, Validate(&entry)) {
+		assert.Equal(t, Cash, entry.Postings[0].SystemAccount)
+		assert.Equal(t, int64(1250), entry.Postings[0].Amount.Minor)
+		assert.Equal(t, uint(3), *entry.Postings[1].AccountID)
+		assert.Equal(t, int64(-1250), entry.Postings[1].Amount.Minor)
+	}
+}
+
+func TestEntryFor_Fee(t *testing.T) {
+	transaction := &database.Transaction{AccountID: 3, Type: "fee", Amount: database.NewMoney(25, "USD")}
+
+	entry, err := EntryFor(transaction)
+
+	if assert.NoError(t, err) && assert.NoError(t, Validate(&entry)) {
+		assert.Equal(t, uint(3), *entry.Postings[0].AccountID)
+		assert.Equal(t, int64(25), entry.Postings[0].Amount.Minor)
+		assert.Equal(t, FeeIncome, entry.Postings[1].SystemAccount)
+		assert.Equal(t, int64(-25), entry.Postings[1].Amount.Minor)
+	}
+}
+
+func TestEntryFor_TransferLegUsesClearing(t *testing.T) {
+	transferID := uint(9)
+	transaction := &database.Transaction{AccountID: 3, Type: "withdrawal", Amount: database.NewMoney(500, "USD"), TransferID: &transferID}
+
+	entry, err := EntryFor(transaction)
+
+	if assert.NoError(t, err) && assert.NoError(t, Validate(&entry)) {
+		assert.Equal(t, int64(500), entry.Postings[0].Amount.Minor)
+		assert.Equal(t, TransferClearing, entry.Postings[1].SystemAccount)
+	}
+}
+
+func TestEntryFor_InvalidType(t *testing.T) {
+	_, err := EntryFor(&database.Transaction{AccountID: 3, Type: "gift", Amount: database.NewMoney(500, "USD")})
+
+	assert.ErrorIs(t, err, database.ErrInvalidType)
+}
+
+func TestReversed(t *testing.T) {
+	entry := OpeningEntry(&database.Account{Balance: database.NewMoney(700, "USD")})
+
+	reversed := Reversed(entry, "undo")
+
+	if assert.NoError(t, Validate(&reversed)) {
+		for i := range entry.Postings {
+			assert.Equal(t, entry.Postings[i].Amount.Neg(), reversed.Postings[i].Amount)
+		}
+	}
+}
+
+func TestValidate_Unbalanced(t *testing.T) {
+	for name, postings := range map[string][]database.Posting{
+		"single posting":  {Credit(1, database.NewMoney(100, "USD"))},
+		"off by a cent":   {Credit(1, database.NewMoney(100, "USD")), DebitSystem(Cash, database.NewMoney(99, "USD"))},
+		"zero postings":   {Credit(1, database.NewMoney(0, "USD")), DebitSystem(Cash, database.NewMoney(0, "USD"))},
+		"missing account": {{Amount: database.NewMoney(-100, "USD")}, DebitSystem(Cash, database.NewMoney(100, "USD"))},
+	} {
+		err := Validate(&database.JournalEntry{Postings: postings})
+		assert.ErrorIs(t, err, database.ErrUnbalancedEntry, name)
+	}
+
+	mixed := &database.JournalEntry{Postings: []database.Posting{
+		Credit(1, database.NewMoney(100, "USD")),
+		DebitSystem(Cash, database.NewMoney(100, "EUR")),
+	}}
+	assert.ErrorIs(t, Validate(mixed), database.ErrCurrencyMismatch)
+}
+
+func TestPost_RejectsUnbalancedEntry(t *testing.T) {
+	store := database.NewMemoryStore()
+
+	entry := &database.JournalEntry{Postings: []database.Posting{
+		Credit(1, database.NewMoney(100, "USD")),
+		DebitSystem(Cash, database.NewMoney(50, "USD")),
+	}}
+
+	// nothing is written
+	assert.ErrorIs(t, New(store.Journal()).Post(entry), database.ErrUnbalancedEntry)
+	assert.NoError(t, New(store.Journal()).Verify())
+	assert.Zero(t, entry.ID)
+}
+
+func TestBalance(t *testing.T) {
+	ledger := New(database.NewMemoryStore().Journal())
+
+	opening := OpeningEntry(&database.Account{Model: gorm.Model{ID: 1}, Balance: database.NewMoney(700, "USD")})
+	require.NoError(t, ledger.Post(&opening))
+	fee, err := EntryFor(&database.Transaction{AccountID: 1, Type: "fee", Amount: database.NewMoney(25, "USD")})
+	require.NoError(t, err)
+	require.NoError(t, ledger.Post(&fee))
+
+	balance, err := ledger.Balance(1, "USD")
+	if assert.NoError(t, err) {
+		assert.Equal(t, database.NewMoney(675, "USD"), balance)
+	}
+	assert.NoError(t, ledger.Verify())
+}
+
+func TestVerify_Unbalanced(t *testing.T) {
+	store := database.NewMemoryStore()
+
+	// an entry that went around Post
+	require.NoError(t, store.Journal().Create(&database.JournalEntry{Postings: []database.Posting{
+		Credit(1, database.NewMoney(100, "USD")),
+		DebitSystem(Cash, database.NewMoney(99, "USD")),
+	}}))
+
+	assert.ErrorIs(t, New(store.Journal()).Verify(), database.ErrUnbalancedEntry)
+}
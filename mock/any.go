@@ -29,3 +29,14 @@ func (a AnyTimeAfter) Match(v driver.Value) bool {
 	}
 	return actual.After(a.Value)
 }
+
+// CaptureString matches any string argument and stores it in Value, e.g. to check a generated token
+type CaptureString struct {
+	Value *string
+}
+
+func (c CaptureString) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	*c.Value = s
+	return ok
+}
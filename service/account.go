@@ -1,127 +1,218 @@
 package service
 
 import (
-	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jobullo/go-api-example/database"
-
-	gorm "github.com/jinzhu/gorm"
+	"github.com/jobullo/go-api-example/ledger"
 )
 
 type AccountService struct {
-	db *gorm.DB
+	store database.Store
 }
 
-// create a new account service
-func NewAccountService(db *gorm.DB) *AccountService {
-	return &AccountService{db: db}
+var _ database.AccountService = (*AccountService)(nil)
+
+// create a new account service, accounts are kept in the store's account repository
+func NewAccountService(store database.Store) *AccountService {
+	return &AccountService{store: store}
 }
 
 // implement the create a new account method of the account service interface
 func (as *AccountService) Create(account *database.Account) error {
 
-	//TODO - add validation
-	// - balance is not negative
-	// - account name is not empty
-	// - account type is not empty and is a valid type
+	//whether the type is one of the configured types is checked by the callers, which have the rules
+	if strings.TrimSpace(account.AccountHolder) == "" {
+		return database.ErrInvalidHolder
+	}
+	if strings.TrimSpace(account.AccountType) == "" {
+		return database.ErrInvalidAccountType
+	}
+	if account.Balance.IsNegative() {
+		return fmt.Errorf("%w: an account can't be opened with a negative balance", database.ErrInvalidAmount)
+	}
 
-	//create from provided account struct object
-	if result := as.db.Create(account); result.Error != nil {
-		return result.Error
+	//fill in the default currency if the balance was given without one
+	account.Balance = account.Balance.Normalized()
+	if !database.ValidCurrency(account.Balance.Currency) {
+		return fmt.Errorf("%w: %q", database.ErrInvalidCurrency, account.Balance.Currency)
 	}
-	return nil
-}
 
-// implement the FetchByID method of the account service interface
-func (as *AccountService) FetchById(id uint) (*database.Account, error) {
-	var account database.Account
-	//account ids are unique, so we can use First
-	if result := as.db.First(&account, id); result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, database.ErrNotFound
+	//versions start at one so zero can mean "no version given" on update
+	account.Version = 1
+	account.Status = database.AccountOpen
+
+	//inline function to pass to store.Atomic
+	performCreate := func(store database.Store) error {
+		//create from provided account struct object
+		if err := store.Accounts().Create(account); err != nil {
+			return err
+		}
+
+		//record the opening balance in the double-entry ledger
+		if !account.Balance.IsZero() {
+			entry := ledger.OpeningEntry(account)
+			if err := ledger.New(store.Journal()).Post(&entry); err != nil {
+				return err
+			}
 		}
 
-		return nil, result.Error
+		return nil
 	}
 
-	return &account, nil
+	//will roll back the account if the opening balance can't be posted
+	return as.store.Atomic(performCreate)
+}
+
+// implement the FetchByID method of the account service interface
+func (as *AccountService) FetchById(id uint) (*database.Account, error) {
+	return as.store.Accounts().FetchById(id)
+}
+
+// FetchForUpdate fetches an account and locks it until the surrounding store.Atomic call returns,
+// so concurrent balance changes to the same account are applied one after another
+func (as *AccountService) FetchForUpdate(id uint) (*database.Account, error) {
+	return as.store.Accounts().FetchForUpdate(id)
 }
 
 // implement the List method of the account service interface
 func (as *AccountService) List() (*[]database.Account, error) {
-	var account []database.Account
-	result := as.db.Find(&account)
-	if result.Error != nil {
-		return nil, result.Error
-	}
-	return &account, nil
+	return as.store.Accounts().List()
+}
+
+// ListPage lists one page of the accounts that match the filter
+func (as *AccountService) ListPage(filter database.AccountFilter, page database.PageRequest) (*database.Page[database.Account], error) {
+	return as.store.Accounts().ListPage(filter, page)
 }
 
-// implement the Update method of the account service interface
+// implement the Update method of the account service interface, only the holder is written, see UpdateHolder.
+// When account.Version is set it must match the stored version, otherwise database.ErrConflict is returned.
 func (as *AccountService) Update(account *database.Account) error {
-	var acc database.Account
-	// fetch the account by id
-	if resp := as.db.First(&acc, account.Model.ID); resp.Error != nil {
-		if errors.Is(resp.Error, gorm.ErrRecordNotFound) {
-			return database.ErrNotFound
-		}
-		return resp.Error
+	updated, err := as.UpdateHolder(account.Model.ID, account.AccountHolder, account.Version)
+	if err != nil {
+		return err
 	}
 
-	//TODO: Need to check that the account holder is not empty or if it is different from the current account holder
+	*account = *updated
+	return nil
+}
 
-	// update the account holder
-	acc.AccountHolder = account.AccountHolder
-	acc.Balance = account.Balance //-- should updates to balance only be done through transactions?
+// UpdateHolder changes the holder of an account and bumps its version, nothing else is written so a balance
+// the caller read earlier can't overwrite a concurrent posting. With a version the write is a compare and swap
+// and database.ErrConflict is returned if the account has moved on, without one the latest version is updated.
+func (as *AccountService) UpdateHolder(id uint, holder string, version uint) (*database.Account, error) {
+	if strings.TrimSpace(holder) == "" {
+		return nil, database.ErrInvalidHolder
+	}
 
-	if resp := as.db.Save(&acc); resp.Error != nil {
-		return resp.Error
+	updated, err := as.store.Accounts().UpdateHolder(id, holder, version)
+	if err != nil {
+		return nil, err
 	}
 
-	//update the account timestamp
-	account.Model.CreatedAt = acc.Model.CreatedAt
-	account.Model.UpdatedAt = acc.Model.UpdatedAt
+	//read back the stored account, which also tells a missing account from a stale version
+	account, err := as.FetchById(id)
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		return nil, database.ErrConflict
+	}
 
-	return nil
+	return account, nil
+}
+
+// updateBalance writes the balance of an account the caller has locked, it's how the transaction
+// services move money. The write is a compare and swap on account.Version.
+func (as *AccountService) updateBalance(account *database.Account) error {
+	return as.store.Accounts().UpdateBalance(account)
 }
 
-// implement the Delete method of the account service interface
+// implement the Delete method of the account service interface. Accounts are never removed, so their
+// transactions and statements stay available, deleting an account closes it.
 func (as *AccountService) Delete(id uint) error {
-	var account database.Account
+	_, err := as.Close(id, 0)
+	return err
+}
 
-	//first check that the account exisas
-	if resp := as.db.First(&account, id); errors.Is(resp.Error, gorm.ErrRecordNotFound) {
-		return database.ErrNotFound
-	}
+// the statuses an account can move to from each status
+var accountTransitions = map[string][]string{
+	database.AccountOpen:    {database.AccountFrozen, database.AccountDormant, database.AccountClosed},
+	database.AccountFrozen:  {database.AccountOpen, database.AccountClosed},
+	database.AccountDormant: {database.AccountOpen, database.AccountFrozen, database.AccountClosed},
+}
 
-	//fetch associated transactions
-	var transactions []database.Transaction
-	if err := as.db.Model(&account).Association("Transactions").Find(&transactions).Error; err != nil {
-		return err
-	}
+// Freeze stops all postings to an open or dormant account.
+// Like Update, a non-zero version must match the stored version.
+func (as *AccountService) Freeze(id uint, version uint) (*database.Account, error) {
+	return as.transition(id, version, database.AccountFrozen, nil)
+}
 
-	//inline function to handle the deletion of the account and its associated transactions
-	deleteAllAccountData := func(db *gorm.DB) error {
+// Unfreeze reopens a frozen or dormant account
+func (as *AccountService) Unfreeze(id uint, version uint) (*database.Account, error) {
+	return as.transition(id, version, database.AccountOpen, nil)
+}
 
-		// Delete each transaction
-		for _, transaction := range transactions {
-			if err := as.db.Delete(&transaction).Error; err != nil {
-				return err
-			}
+// Close closes an account for good, its balance has to be zero
+func (as *AccountService) Close(id uint, version uint) (*database.Account, error) {
+	return as.transition(id, version, database.AccountClosed, func(account *database.Account) error {
+		if !account.Balance.IsZero() {
+			return fmt.Errorf("%w: account %d has a balance of %s", database.ErrBalanceNotZero, account.ID, account.Balance)
 		}
+		return nil
+	})
+}
+
+// MarkDormant makes open accounts that haven't changed since the given time dormant,
+// every posting updates the account so its updated_at is the time of its last activity
+func (as *AccountService) MarkDormant(inactiveSince time.Time) (int64, error) {
+	return as.store.Accounts().MarkDormant(inactiveSince)
+}
 
-		// Delete the account
-		if err := as.db.Delete(&account).Error; err != nil {
+// transition moves an account to a new status. The account is locked so a posting can't slip in
+// between the check and the write, e.g. a deposit to an account that is being closed.
+func (as *AccountService) transition(id uint, version uint, status string, check func(*database.Account) error) (*database.Account, error) {
+	var account *database.Account
+
+	//inline function to pass to store.Atomic
+	performTransition := func(store database.Store) error {
+		var err error
+		if account, err = store.Accounts().FetchForUpdate(id); err != nil {
 			return err
 		}
 
-		return nil
+		//the caller's copy is stale
+		if version != 0 && version != account.Version {
+			return database.ErrConflict
+		}
+
+		if !allowsTransition(account.Status, status) {
+			return fmt.Errorf("%w: account %d is %s and can't be %s", database.ErrInvalidTransition, account.ID, account.Status, status)
+		}
+		if check != nil {
+			if err := check(account); err != nil {
+				return err
+			}
+		}
+
+		//compare and swap on the version
+		return store.Accounts().UpdateStatus(account, status)
 	}
 
-	//will roll back the transaction if an error is returned by deleteAllAccountData
-	if err := as.db.Transaction(deleteAllAccountData); err != nil {
-		return err
+	if err := as.store.Atomic(performTransition); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return account, nil
+}
+
+func allowsTransition(from string, to string) bool {
+	for _, status := range accountTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
 }
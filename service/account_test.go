@@ -2,14 +2,12 @@ package service
 
 import (
 	"testing"
-	"time"
 
-	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/go-faker/faker/v4"
 	"github.com/jobullo/go-api-example/database"
+	"github.com/jobullo/go-api-example/ledger"
 	"github.com/jobullo/go-api-example/mock"
 	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -18,7 +16,7 @@ import (
 type AccountServiceSuite struct {
 	suite.Suite
 	assert  *assert.Assertions
-	sqlmock sqlmock.Sqlmock
+	store   database.Store
 	service *AccountService
 }
 
@@ -30,12 +28,9 @@ func TestAccountServiceSuite(t *testing.T) {
 func (as *AccountServiceSuite) SetupTest() {
 	t := as.T() //gets the current *testing.T context, used by testing framework to manage test state
 
-	db, sql, err := mock.DB() //mock.DB() returns a gorm.DB and sqlmock.Sqlmock
-	require.NoError(t, err)   //use require from testify to stop test if error is not nil
-
 	as.assert = assert.New(t) //create a new assert.Assertions object for use in tests
-	as.sqlmock = sql          // sql mock used to create expectations
-	as.service = NewAccountService(db)
+	as.store = database.NewMemoryStore()
+	as.service = NewAccountService(as.store)
 }
 
 func (as *AccountServiceSuite) TestAccountService_Create() {
@@ -43,219 +38,241 @@ func (as *AccountServiceSuite) TestAccountService_Create() {
 	account := &database.Account{
 		AccountHolder: faker.Name(),
 		AccountType:   "savings",
-		Balance:       100,
+		Balance:       database.NewMoney(10000, "USD"),
 	}
 
-	queryPattern := `(?i)INSERT INTO "accounts" \("created_at","updated_at","deleted_at","account_holder","account_type","balance"\) VALUES \(\$1,\$2,\$3,\$4,\$5,\$6\) RETURNING "accounts"\."id"`
-
-	// Set expectations on the mock for an INSERT query on the transactions table
-	as.sqlmock.ExpectBegin()
-	as.sqlmock.ExpectQuery(queryPattern).
-		WithArgs(mock.Any{}, mock.Any{}, mock.Any{}, account.AccountHolder, account.AccountType, account.Balance).
-		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-	as.sqlmock.ExpectCommit()
-
 	// Call the method under test
 	err := as.service.Create(account)
 
 	// Check the result
-	as.assert.NoError(err)
-	as.assert.NoError(as.sqlmock.ExpectationsWereMet())
+	if as.assert.NoError(err) {
+		as.assert.NotZero(account.ID)
+		as.assert.Equal(uint(1), account.Version)
+		as.assert.Equal(database.AccountOpen, account.Status)
+
+		// the opening balance is posted to the ledger in the same transaction
+		balance, err := ledger.New(as.store.Journal()).Balance(account.ID, "USD")
+		as.assert.NoError(err)
+		as.assert.Equal(database.NewMoney(10000, "USD"), balance)
+	}
 }
 
-func (as *AccountServiceSuite) TestAccountService_FetchById() {
-	id := mock.ID()
-	rows := as.newRows()
-	as.addRow(rows, id, time.Now(), time.Now(), nil, faker.Name(), "savings", 100)
+func (as *AccountServiceSuite) TestAccountService_Create_Invalid() {
+	for expected, account := range map[error]database.Account{
+		database.ErrInvalidHolder:      {AccountHolder: " ", AccountType: "savings"},
+		database.ErrInvalidAccountType: {AccountHolder: faker.Name()},
+		database.ErrInvalidAmount:      {AccountHolder: faker.Name(), AccountType: "savings", Balance: database.NewMoney(-1, "USD")},
+		database.ErrInvalidCurrency:    {AccountHolder: faker.Name(), AccountType: "savings", Balance: database.NewMoney(1, "XXX")},
+	} {
+		as.assert.ErrorIs(as.service.Create(&account), expected)
+	}
 
-	// Formulating the regex pattern
-	queryPattern := `(?i)SELECT\s+\*\s+FROM\s+"accounts"\s+WHERE\s+"accounts"\."deleted_at"\s+IS\s+NULL\s+AND\s+\(\("accounts"\."id"\s+=\s+\d+\)\)\s+ORDER\s+BY\s+"accounts"\."id"\s+ASC\s+LIMIT\s+1`
+	// nothing is written for an invalid account
+	accounts, err := as.service.List()
+	as.assert.NoError(err)
+	as.assert.Empty(*accounts)
+}
 
-	// Using sqlmock to expect the query
-	as.sqlmock.ExpectQuery(queryPattern).WillReturnRows(rows)
+func (as *AccountServiceSuite) TestAccountService_FetchById() {
+	created := as.newAccount(faker.Name(), "savings", database.NewMoney(10000, "USD"))
 
-	_, err := as.service.FetchById(id)
+	account, err := as.service.FetchById(created.ID)
 
 	if as.assert.NoError(err) {
-		as.assert.NoError(as.sqlmock.ExpectationsWereMet())
+		as.assert.Equal(created.AccountHolder, account.AccountHolder)
+		as.assert.Equal(database.NewMoney(10000, "USD"), account.Balance)
 	}
 }
 
 func (as *AccountServiceSuite) TestAccountService_FetchById_NotFound() {
-	id := mock.ID()
-	rows := as.newRows()
+	_, err := as.service.FetchById(mock.ID())
 
-	// Formulating the regex pattern
-	queryPattern := `(?i)SELECT\s+\*\s+FROM\s+"accounts"\s+WHERE\s+"accounts"\."deleted_at"\s+IS\s+NULL\s+AND\s+\(\("accounts"\."id"\s+=\s+\d+\)\)\s+ORDER\s+BY\s+"accounts"\."id"\s+ASC\s+LIMIT\s+1`
+	as.assert.ErrorIs(err, database.ErrNotFound)
+}
 
-	// Using sqlmock to expect the query
-	as.sqlmock.ExpectQuery(queryPattern).WillReturnRows(rows)
+func (as *AccountServiceSuite) TestAccountService_Update() {
+	created := as.newAccount(faker.Name(), "savings", database.NewMoney(10000, "USD"))
+	newAccountHolder := faker.Name()
+	account := &database.Account{
+		AccountHolder: newAccountHolder,
+		AccountType:   "checking",
+		// a balance on the caller's copy is ignored, the stored one is kept
+		Balance: database.NewMoney(1, "USD"),
+	}
+	account.ID = created.ID
 
-	_, err := as.service.FetchById(id)
+	// without a version the latest version is updated
+	err := as.service.Update(account)
 
-	if as.assert.Error(err) {
-		as.assert.NoError(as.sqlmock.ExpectationsWereMet())
+	as.assert.NoError(err)
+	as.assert.Equal(newAccountHolder, account.AccountHolder)
+	as.assert.Equal("savings", account.AccountType)
+	as.assert.Equal(database.NewMoney(10000, "USD"), account.Balance)
+	as.assert.Equal(uint(2), account.Version)
+}
+
+// test that the Update method returns an error when the account is not found
+func (as *AccountServiceSuite) TestAccountService_Update_NotFound() {
+	account := &database.Account{
+		AccountHolder: faker.Name(),
+		AccountType:   "savings",
+		Balance:       database.NewMoney(10000, "USD"),
 	}
+	account.ID = mock.ID()
+
+	err := as.service.Update(account)
+
+	as.assert.ErrorIs(err, database.ErrNotFound)
 }
 
-func (as *AccountServiceSuite) TestAccountService_Update() {
-	id := mock.ID()
-	originalAccountHolder := faker.Name()
+// test that an update made with a stale version is rejected
+func (as *AccountServiceSuite) TestAccountService_Update_StaleVersion() {
+	created := as.newAccount(faker.Name(), "savings", database.NewMoney(10000, "USD"))
 	account := &database.Account{
-		AccountHolder: originalAccountHolder,
+		AccountHolder: faker.Name(),
 		AccountType:   "savings",
-		Balance:       100,
+		Version:       2,
 	}
+	account.ID = created.ID
 
-	// set the schema for the account table in the mock database
-	rows := as.newRows()
-	// add a row to the account table
-	as.addRow(rows, id, time.Now(), time.Now(), nil, account.AccountHolder, account.AccountType, account.Balance)
+	// the stored account is still at version 1
+	err := as.service.Update(account)
 
-	// generate a new account holder name and set it on the account object
-	newAccountHolder := faker.Name()
-	account.AccountHolder = newAccountHolder
+	as.assert.ErrorIs(err, database.ErrConflict)
+	as.assert.Equal(uint(2), account.Version)
 
-	// set expectations on the mock for a SELECT query on the accounts table
-	as.sqlmock.ExpectQuery("^SELECT .* FROM \"accounts\".*").WillReturnRows(rows)
+	stored, err := as.service.FetchById(created.ID)
+	if as.assert.NoError(err) {
+		as.assert.Equal(created.AccountHolder, stored.AccountHolder)
+		as.assert.Equal(uint(1), stored.Version)
+	}
+}
 
-	// account exists, so we expect a transaction to update the account holder
-	as.sqlmock.ExpectBegin()
-	as.sqlmock.ExpectExec("UPDATE .*").WillReturnResult(sqlmock.NewResult(1, 1))
-	as.sqlmock.ExpectCommit()
+// test that an empty holder is rejected without writing anything
+func (as *AccountServiceSuite) TestAccountService_UpdateHolder_Empty() {
+	created := as.newAccount(faker.Name(), "savings", database.NewMoney(10000, "USD"))
 
-	// update with new account holder
-	as.service.Update(account)
+	_, err := as.service.UpdateHolder(created.ID, " ", 0)
 
-	// assert that all of data was updated correctly
-	as.assert.NoError(as.sqlmock.ExpectationsWereMet())
-	as.assert.Equal(account.AccountHolder, newAccountHolder)
-	as.assert.Equal("savings", account.AccountType)
-	as.assert.Equal(float64(100), account.Balance)
+	as.assert.ErrorIs(err, database.ErrInvalidHolder)
+	stored, err := as.service.FetchById(created.ID)
+	if as.assert.NoError(err) {
+		as.assert.Equal(uint(1), stored.Version)
+	}
 }
 
-// test that the Update method returns an error when the account is not found
-func (as *AccountServiceSuite) TestAccountService_Update_NotFound() {
-	originalAccountHolder := faker.Name()
-	account := &database.Account{
-		AccountHolder: originalAccountHolder,
-		AccountType:   "savings",
-		Balance:       100,
+func (as *AccountServiceSuite) TestAccountService_List() {
+	as.newAccount(faker.Name(), "savings", database.NewMoney(10000, "USD"))
+	as.newAccount(faker.Name(), "checking", database.NewMoney(20000, "USD"))
+
+	accounts, err := as.service.List()
+
+	if as.assert.NoError(err) {
+		as.assert.Len(*accounts, 2)
 	}
+}
 
-	//row not added so account won't be found
-	rows := as.newRows()
+func (as *AccountServiceSuite) TestAccountService_ListPage_Filters() {
+	ownerID, otherID, minBalance := uint(7), uint(8), int64(5000)
+	first := as.newOwnedAccount(ownerID, "Foo Bar", "savings", database.NewMoney(10000, "USD"))
+	as.newOwnedAccount(ownerID, "Foo_Baz", "savings", database.NewMoney(20000, "USD"))
+	second := as.newOwnedAccount(ownerID, "foo_qux", "savings", database.NewMoney(30000, "USD"))
+	// each of these fails one of the filters
+	as.newOwnedAccount(otherID, "Foo_Bar", "savings", database.NewMoney(10000, "USD"))
+	as.newOwnedAccount(ownerID, "Foo_Bar", "checking", database.NewMoney(10000, "USD"))
+	as.newOwnedAccount(ownerID, "Foo_Bar", "savings", database.NewMoney(1000, "USD"))
+	filter := database.AccountFilter{OwnerID: &ownerID, AccountType: "savings", Holder: "Foo_", MinBalance: &minBalance}
+
+	// the underscore in the holder is matched literally and case-insensitively, so "Foo Bar" is left out
+	page, err := as.service.ListPage(filter, database.PageRequest{Limit: 1})
+
+	if as.assert.NoError(err) && as.assert.Len(page.Items, 1) {
+		as.assert.NotEqual(first.ID, page.Items[0].ID)
+		as.assert.NotEmpty(page.NextCursor)
+	}
 
-	// select query will return no rows and no update query will be executed
-	as.sqlmock.ExpectQuery("^SELECT .* FROM \"accounts\".*").WillReturnRows(rows)
+	page, err = as.service.ListPage(filter, database.PageRequest{Limit: 1, Cursor: page.NextCursor})
 
-	err := as.service.Update(account)
+	if as.assert.NoError(err) && as.assert.Len(page.Items, 1) {
+		as.assert.Equal(second.ID, page.Items[0].ID)
+		as.assert.Empty(page.NextCursor)
+	}
+}
+
+func (as *AccountServiceSuite) TestAccountService_ListPage_Cursor() {
+	low := as.newAccount("Foo Bar", "savings", database.NewMoney(2000, "USD"))
+	high := as.newAccount("Foo Baz", "savings", database.NewMoney(2500, "USD"))
+	tied := as.newAccount("Foo Qux", "savings", database.NewMoney(2500, "USD"))
+	request := database.PageRequest{Limit: 2, Sort: "balance", Desc: true}
+
+	// the tie on the balance is broken by the id
+	page, err := as.service.ListPage(database.AccountFilter{}, request)
 
-	if as.assert.Error(err) {
-		as.assert.NoError(as.sqlmock.ExpectationsWereMet())
+	if as.assert.NoError(err) && as.assert.Len(page.Items, 2) {
+		as.assert.Equal(tied.ID, page.Items[0].ID)
+		as.assert.Equal(high.ID, page.Items[1].ID)
+	}
+
+	// continues after the balance and id in the cursor
+	request.Cursor = page.NextCursor
+	page, err = as.service.ListPage(database.AccountFilter{}, request)
+
+	if as.assert.NoError(err) && as.assert.Len(page.Items, 1) {
+		as.assert.Equal(low.ID, page.Items[0].ID)
+		as.assert.Empty(page.NextCursor)
 	}
 }
 
-func (as *AccountServiceSuite) TestAccountService_List() {
-	// set the schema for the account table in the mock database
-	rows := as.newRows()
-	// add two rows to the account table
-	as.addRow(rows, mock.ID(), time.Now(), time.Now(), nil, faker.Name(), "savings", 100)
-	as.addRow(rows, mock.ID(), time.Now(), time.Now(), nil, faker.Name(), "checking", 200)
+func (as *AccountServiceSuite) TestAccountService_ListPage_InvalidRequest() {
+	_, err := as.service.ListPage(database.AccountFilter{}, database.PageRequest{Sort: "password"})
+	as.assert.ErrorIs(err, database.ErrInvalidSort)
 
-	// Formulating the regex pattern
-	queryPattern := `^SELECT\s+\*\s+FROM\s+"accounts"\s+WHERE\s+"accounts"\."deleted_at"\s+IS\s+NULL`
+	_, err = as.service.ListPage(database.AccountFilter{}, database.PageRequest{Cursor: "not a cursor"})
+	as.assert.ErrorIs(err, database.ErrInvalidCursor)
+}
 
-	// Using sqlmock to expect the query
-	as.sqlmock.ExpectQuery(queryPattern).WillReturnRows(rows)
+func (as *AccountServiceSuite) TestAccountService_Close_NonZeroBalance() {
+	created := as.newAccount("Foo Bar", "savings", database.NewMoney(10000, "USD"))
 
-	_, err := as.service.List()
+	_, err := as.service.Close(created.ID, 0)
 
+	as.assert.ErrorIs(err, database.ErrBalanceNotZero)
+	stored, err := as.service.FetchById(created.ID)
 	if as.assert.NoError(err) {
-		as.assert.NoError(as.sqlmock.ExpectationsWereMet())
+		as.assert.Equal(database.AccountOpen, stored.Status)
 	}
 }
 
-//TODO: Fix the Delete test
-//func (as *AccountServiceSuite) TestAccountService_Delete() {
-//	id := mock.ID()
-//	originalAccountHolder := faker.Name()
-//	account := &database.Account{
-//		AccountHolder: originalAccountHolder,
-//		AccountType:   "savings",
-//		Balance:       100,
-//	}
-//
-//	// set the schema for the account table in the mock database
-//	accountRows := as.newRows()
-//	// add a row to the account table
-//	as.addRow(accountRows, id, time.Now(), time.Now(), nil, account.AccountHolder, account.AccountType, account.Balance)
-//
-//	// set the schema for the transactions table in the mock database
-//	transactionRows := as.newTransactionRows()
-//	// add a row to the transactions table
-//	as.addTransactionRow(transactionRows, mock.ID(), time.Now(), time.Now(), nil, id, 100, "deposit")
-//
-//	// set expectations on the mock for a SELECT query on the accounts table
-//	as.sqlmock.ExpectQuery("^SELECT .* FROM \"accounts\".*").WillReturnRows(accountRows)
-//
-//	// expect a SELECT query on the transactions table to find associated transactions
-//	as.sqlmock.ExpectQuery("^SELECT .* FROM \"transactions\".*").WillReturnRows(sqlmock.NewRows([]string{"id"}))
-//
-//	// expect a transaction to begin
-//	as.sqlmock.ExpectBegin()
-//
-//	// expect transactions to be deleted
-//	as.sqlmock.ExpectExec("DELETE .*").WillReturnResult(sqlmock.NewResult(1, 1))
-//
-//	// expect account to be deleted
-//	as.sqlmock.ExpectExec("DELETE .*").WillReturnResult(sqlmock.NewResult(1, 1))
-//
-//	// expect the transaction to commit
-//	as.sqlmock.ExpectCommit()
-//
-//	// delete the account
-//	err := as.service.Delete(id)
-//
-//	// assert that all of data was updated correctly
-//	as.assert.NoError(err)
-//	as.assert.NoError(as.sqlmock.ExpectationsWereMet())
-//}
-
-// creates the rows object for use in tests
-func (s *AccountServiceSuite) newRows() *sqlmock.Rows {
-	return sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "account_holder", "account_type", "balance"})
-}
+func (as *AccountServiceSuite) TestAccountService_Close() {
+	created := as.newAccount("Foo Bar", "savings", database.NewMoney(0, "USD"))
+
+	// a stale version is rejected
+	_, err := as.service.Close(created.ID, 2)
+	as.assert.ErrorIs(err, database.ErrConflict)
+
+	account, err := as.service.Close(created.ID, 1)
 
-// creates the rows object for use in tests for the "transactions" table
-func (s *AccountServiceSuite) newTransactionRows() *sqlmock.Rows {
-	return sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "account_id", "amount", "transaction_type"})
+	if as.assert.NoError(err) {
+		as.assert.Equal(database.AccountClosed, account.Status)
+		as.assert.Equal(uint(2), account.Version)
+	}
+
+	// a closed account stays closed
+	_, err = as.service.Unfreeze(created.ID, 0)
+	as.assert.ErrorIs(err, database.ErrInvalidTransition)
 }
 
-// populates the transaction rows object with a row of data
-func (s *AccountServiceSuite) addTransactionRow(
-	rows *sqlmock.Rows,
-	id uint,
-	createdAt time.Time,
-	updatedAt time.Time,
-	deletedAt *time.Time,
-	accountID uint,
-	amount float64,
-	transactionType string,
-) {
-	rows.AddRow(id, createdAt, updatedAt, deletedAt, accountID, amount, transactionType)
+// creates an account through the service
+func (as *AccountServiceSuite) newAccount(holder string, accountType string, balance database.Money) *database.Account {
+	return as.newOwnedAccount(0, holder, accountType, balance)
 }
 
-// populates the rows object with a row of data
-func (s *AccountServiceSuite) addRow(
-	rows *sqlmock.Rows,
-	id uint,
-	createdAt time.Time,
-	updatedAt time.Time,
-	deletedAt *time.Time,
-	accountHolder string,
-	accountType string,
-	balance float64,
-) {
-	rows.AddRow(id, createdAt, updatedAt, deletedAt, accountHolder, accountType, balance)
+// creates an account of the given owner through the service, an owner of zero leaves the account without one
+func (as *AccountServiceSuite) newOwnedAccount(ownerID uint, holder string, accountType string, balance database.Money) *database.Account {
+	account := &database.Account{AccountHolder: holder, AccountType: accountType, Balance: balance}
+	if ownerID != 0 {
+		account.OwnerID = &ownerID
+	}
+	as.Require().NoError(as.service.Create(account))
+	return account
 }
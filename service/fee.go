@@ -0,0 +1,224 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jobullo/go-api-example/database"
+	"github.com/jobullo/go-api-example/ledger"
+)
+
+// FeeService charges the monthly maintenance fees, the fees triggered by a transaction are charged by
+// TransactionService.Create along with it
+type FeeService struct {
+	store database.Store
+	rules Rules //fee schedules by account type
+}
+
+// create a new fee service, accounts are charged the fees of the given rules
+func NewFeeService(store database.Store, rules Rules) *FeeService {
+	return &FeeService{store: store, rules: rules}
+}
+
+// transactionFees returns the fees a transaction triggers given the account balance right after it:
+// the withdrawal fee unless the balance is high enough to waive it, and the overdraft fee when the
+// withdrawal leaves the account overdrawn
+func (f FeeSchedule) transactionFees(transaction *database.Transaction, balance database.Money) ([]database.Money, error) {
+	if transaction.Type != "withdrawal" {
+		return nil, nil
+	}
+
+	var fees []database.Money
+	if f.Withdrawal.IsPositive() {
+		waived, err := f.waives(balance)
+		if err != nil {
+			return nil, err
+		}
+		if !waived {
+			fees = append(fees, f.Withdrawal)
+		}
+	}
+	if f.Overdraft.IsPositive() && balance.IsNegative() {
+		fees = append(fees, f.Overdraft)
+	}
+	return fees, nil
+}
+
+// waives reports whether a balance is high enough for the maintenance and withdrawal fees to be waived,
+// a balance in another currency than the waiver balance fails with database.ErrCurrencyMismatch
+func (f FeeSchedule) waives(balance database.Money) (bool, error) {
+	if !f.WaiverBalance.IsPositive() {
+		return false, nil
+	}
+
+	difference, err := balance.Sub(f.WaiverBalance)
+	if err != nil {
+		return false, err
+	}
+	return !difference.IsNegative(), nil
+}
+
+// postFee charges a fee to an account that is locked by the atomic store, linked to the transaction
+// that triggered it if there is one. Fees are charged even when they take the balance past the overdraft limit.
+// The new balance is only set on account, the caller writes it.
+func postFee(store database.Store, account *database.Account, amount database.Money, feeFor *uint) (*database.Transaction, error) {
+	//fees are configured in the currency of the account type, an account in another one can't be charged them
+	fee := database.Transaction{
+		AccountID: account.ID,
+		Type:      "fee",
+		Amount:    amount.Normalized(),
+		FeeForID:  feeFor,
+	}
+
+	balance, err := account.Balance.Add(fee.Amount.Neg())
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := ledger.EntryFor(&fee)
+	if err != nil {
+		return nil, err
+	}
+	if err := ledger.New(store.Journal()).Post(&entry); err != nil {
+		return nil, err
+	}
+	fee.JournalEntryID = &entry.ID
+
+	if err := store.Transactions().Create(&fee); err != nil {
+		return nil, err
+	}
+
+	account.Balance = balance
+	return &fee, nil
+}
+
+// ChargeMaintenance charges the maintenance fee of a month, such as 2024-01, to every open account whose type has one.
+// The fee is waived when the balance stayed at or above the waiver balance at the end of every day of the month
+// the account was open. Each account is charged in a store.Atomic call of its own along with a record of the
+// period, accounts that already have a record for the period are skipped, so a run that failed halfway can be run again.
+// Accounts held in another currency than the fee is in are skipped too.
+func (fs *FeeService) ChargeMaintenance(period string) (*database.FeeRun, error) {
+	from, to, err := pastPeriod(period)
+	if err != nil {
+		return nil, err
+	}
+
+	var accountTypes []string
+	for accountType, typeRules := range fs.rules.AccountTypes {
+		if typeRules.Fees.Maintenance.IsPositive() {
+			accountTypes = append(accountTypes, accountType)
+		}
+	}
+
+	run := &database.FeeRun{Period: period, Charged: []database.MaintenanceFee{}}
+	if len(accountTypes) == 0 {
+		return run, nil
+	}
+
+	//accounts opened after the period don't owe anything for it
+	accounts, err := fs.store.Accounts().ListOpen(accountTypes, to)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range accounts {
+		fee, err := fs.chargeAccount(accounts[i].ID, period, from, to)
+		//the account may have been closed or frozen since it was listed, and an account held in another currency
+		//than its type's, e.g. opened before the type's currency was changed, can't be charged the fee
+		if errors.Is(err, errAlreadyPosted) || errors.Is(err, database.ErrAccountNotOpen) || errors.Is(err, database.ErrCurrencyMismatch) {
+			run.Skipped++
+			continue
+		}
+		if err != nil {
+			return run, fmt.Errorf("account %d: %w", accounts[i].ID, err)
+		}
+		run.Charged = append(run.Charged, *fee)
+	}
+
+	return run, nil
+}
+
+// chargeAccount charges one account the maintenance fee of a period, or waives it, and records that it has been dealt with
+func (fs *FeeService) chargeAccount(accountID uint, period string, from time.Time, to time.Time) (*database.MaintenanceFee, error) {
+	record := database.MaintenanceFee{AccountID: accountID, Period: period}
+
+	//inline function to pass to store.Atomic
+	performCharge := func(store database.Store) error {
+		charged, err := store.MaintenanceFees().Recorded(accountID, period)
+		if err != nil {
+			return err
+		}
+		if charged {
+			return errAlreadyPosted
+		}
+
+		//the record goes in first, a concurrent run for the same period fails on its unique index and rolls back
+		if err := store.MaintenanceFees().Create(&record); err != nil {
+			return err
+		}
+
+		accountService := NewAccountService(store)
+		account, err := accountService.FetchForUpdate(accountID)
+		if err != nil {
+			return err
+		}
+		if !account.IsOpen() {
+			return fmt.Errorf("%w: account %d is %s", database.ErrAccountNotOpen, account.ID, account.Status)
+		}
+
+		fees := fs.rules.AccountTypes[account.AccountType].Fees
+		waived, err := fs.waivesPeriod(store, account, fees, from, to)
+		if err != nil {
+			return err
+		}
+		if waived {
+			record.Amount = database.NewMoney(0, account.Balance.Currency)
+			record.Waived = true
+			return store.MaintenanceFees().Save(&record)
+		}
+
+		fee, err := postFee(store, account, fees.Maintenance, nil)
+		if err != nil {
+			return err
+		}
+		if err := accountService.updateBalance(account); err != nil {
+			return err
+		}
+
+		record.Amount = fee.Amount
+		record.TransactionID = &fee.ID
+		return store.MaintenanceFees().Save(&record)
+	}
+
+	//will roll back the record of the period if the fee can't be charged
+	if err := fs.store.Atomic(performCharge); err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// waivesPeriod reports whether the lowest end-of-day balance of the period is high enough to waive the maintenance
+// fee, only the days since the account was opened count
+func (fs *FeeService) waivesPeriod(store database.Store, account *database.Account, fees FeeSchedule, from time.Time, to time.Time) (bool, error) {
+	if !fees.WaiverBalance.IsPositive() {
+		return false, nil
+	}
+
+	if opened := account.CreatedAt.UTC().Truncate(24 * time.Hour); opened.After(from) {
+		from = opened
+	}
+
+	statement, err := NewTransactionService(store, fs.rules).Statement(account.ID, from, to)
+	if err != nil {
+		return false, err
+	}
+
+	for _, balance := range dailyBalances(statement) {
+		if waived, err := fees.waives(balance); err != nil || !waived {
+			return false, err
+		}
+	}
+	return true, nil
+}
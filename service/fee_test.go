@@ -0,0 +1,257 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jobullo/go-api-example/database"
+	"github.com/jobullo/go-api-example/ledger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+// FeeServiceSuite charges fees against a memory store so the balances and the ledger can be checked afterwards
+type FeeServiceSuite struct {
+	suite.Suite
+	assert       *assert.Assertions
+	store        database.Store
+	rules        Rules
+	service      *FeeService
+	transactions *TransactionService
+}
+
+func TestFeeServiceSuite(t *testing.T) {
+	suite.Run(t, new(FeeServiceSuite))
+}
+
+func (fs *FeeServiceSuite) SetupTest() {
+	t := fs.T()
+
+	fs.assert = assert.New(t)
+	fs.store = database.NewMemoryStore()
+	fs.rules = Rules{AccountTypes: map[string]AccountTypeRules{
+		"checking": {
+			Currency:       "USD",
+			OverdraftLimit: database.NewMoney(50000, "USD"),
+			Fees: FeeSchedule{
+				Maintenance:   database.NewMoney(500, "USD"),
+				Withdrawal:    database.NewMoney(25, "USD"),
+				Overdraft:     database.NewMoney(2500, "USD"),
+				WaiverBalance: database.NewMoney(150000, "USD"),
+			},
+		},
+		// no overdraft, so only the fees are in a currency
+		"basic": {
+			Currency: "USD",
+			Fees:     FeeSchedule{Withdrawal: database.NewMoney(25, "USD"), WaiverBalance: database.NewMoney(150000, "USD")},
+		},
+	}}
+	fs.service = NewFeeService(fs.store, fs.rules)
+	fs.transactions = NewTransactionService(fs.store, fs.rules)
+}
+
+func (fs *FeeServiceSuite) TestCreate_ChargesWithdrawalFee() {
+	account := fs.openAccount(10000)
+
+	withdrawal := fs.withdraw(account, 1000)
+
+	if fs.assert.Len(withdrawal.Fees, 1) {
+		fs.assert.Equal("fee", withdrawal.Fees[0].Type)
+		fs.assert.Equal(int64(25), withdrawal.Fees[0].Amount.Minor)
+		fs.assert.Equal(withdrawal.ID, *withdrawal.Fees[0].FeeForID)
+	}
+	fs.assertBalance(account, "89.75")
+}
+
+func (fs *FeeServiceSuite) TestCreate_WaivesWithdrawalFee() {
+	account := fs.openAccount(200000)
+
+	withdrawal := fs.withdraw(account, 1000)
+
+	fs.assert.Empty(withdrawal.Fees)
+	fs.assertBalance(account, "1990.00")
+}
+
+func (fs *FeeServiceSuite) TestCreate_ChargesOverdraftFee() {
+	account := fs.openAccount(10000)
+
+	withdrawal := fs.withdraw(account, 15000)
+
+	// the withdrawal fee and the overdraft fee
+	fs.assert.Len(withdrawal.Fees, 2)
+	fs.assertBalance(account, "-75.25")
+}
+
+func (fs *FeeServiceSuite) TestChargeMaintenance_OncePerPeriod() {
+	low := fs.openAccount(10000)
+	high := fs.openAccount(200000)
+
+	run, err := fs.service.ChargeMaintenance("2024-01")
+	if fs.assert.NoError(err) && fs.assert.Len(run.Charged, 2) {
+		fs.assert.Equal(int64(500), run.Charged[0].Amount.Minor)
+		fs.assert.NotNil(run.Charged[0].TransactionID)
+		fs.assert.True(run.Charged[1].Waived)
+		fs.assert.Nil(run.Charged[1].TransactionID)
+	}
+
+	// running the period again charges nothing
+	again, err := fs.service.ChargeMaintenance("2024-01")
+	if fs.assert.NoError(err) {
+		fs.assert.Empty(again.Charged)
+		fs.assert.Equal(2, again.Skipped)
+	}
+
+	fs.assertBalance(low, "95.00")
+	fs.assertBalance(high, "2000.00")
+}
+
+func (fs *FeeServiceSuite) TestFees_AccountInAnotherCurrency() {
+	// an account opened in yen before its type was configured in dollars
+	account := database.Account{AccountHolder: "Foo Bar", AccountType: "basic", Balance: database.NewMoney(200000, "JPY")}
+	fs.Require().NoError(NewAccountService(fs.store).Create(&account))
+
+	// neither 0.25 dollars nor 25 yen is charged
+	withdrawal := database.Transaction{AccountID: account.ID, Type: "withdrawal", Amount: database.NewMoney(1000, "JPY")}
+	fs.assert.ErrorIs(fs.transactions.Create(&withdrawal), database.ErrCurrencyMismatch)
+
+	saved, err := NewAccountService(fs.store).FetchById(account.ID)
+	if fs.assert.NoError(err) {
+		fs.assert.Equal(database.NewMoney(200000, "JPY"), saved.Balance)
+	}
+}
+
+func (fs *FeeServiceSuite) TestChargeMaintenance_SkipsAccountsInAnotherCurrency() {
+	dollars := fs.openAccount(10000)
+	yen := database.Account{AccountHolder: "Foo Bar", AccountType: "checking", Balance: database.NewMoney(10000, "JPY")}
+	yen.CreatedAt = time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)
+	fs.Require().NoError(NewAccountService(fs.store).Create(&yen))
+
+	run, err := fs.service.ChargeMaintenance("2024-01")
+	if fs.assert.NoError(err) && fs.assert.Len(run.Charged, 1) {
+		fs.assert.Equal(dollars, run.Charged[0].AccountID)
+		fs.assert.Equal(1, run.Skipped)
+	}
+	fs.assertBalance(dollars, "95.00")
+}
+
+func (fs *FeeServiceSuite) TestImportTransactions_ChargesNoFees() {
+	// imported history already has the other system's fees in it, and keeps its dates
+	account := fs.openAccount(10000)
+
+	result, err := NewImportService(fs.store, fs.rules).ImportTransactions(strings.NewReader(
+		"account_id,type,amount,created_at\n"+
+			fmt.Sprintf("%d,withdrawal,150.00,2024-01-15\n", account)), false)
+
+	if fs.assert.NoError(err) && fs.assert.Empty(result.Errors) {
+		fs.assertBalance(account, "-50.00")
+
+		// the withdrawal is the only transaction, so no fee was charged
+		transactions, err := fs.transactions.ListByAccount(account)
+		if fs.Require().NoError(err); fs.assert.Len(*transactions, 1) {
+			withdrawal := (*transactions)[0]
+			fs.assert.Equal("withdrawal", withdrawal.Type)
+			fs.assert.True(withdrawal.CreatedAt.Equal(time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)))
+		}
+	}
+}
+
+func (fs *FeeServiceSuite) TestReverse_RefundsFees() {
+	account := fs.openAccount(10000)
+	withdrawal := fs.withdraw(account, 15000)
+
+	reversal, err := fs.transactions.Reverse(withdrawal.ID)
+
+	if fs.assert.NoError(err) && fs.assert.Len(reversal.Fees, 2) {
+		fs.assert.Equal(withdrawal.Fees[0].ID, *reversal.Fees[0].ReversalOfID)
+		fs.assert.Equal(withdrawal.Fees[1].ID, *reversal.Fees[1].ReversalOfID)
+	}
+	fs.assertBalance(account, "100.00")
+}
+
+func (fs *FeeServiceSuite) TestReverse_SkipsFeesReversedOnTheirOwn() {
+	account := fs.openAccount(10000)
+	withdrawal := fs.withdraw(account, 1000)
+	_, err := fs.transactions.Reverse(withdrawal.Fees[0].ID)
+	fs.Require().NoError(err)
+
+	reversal, err := fs.transactions.Reverse(withdrawal.ID)
+
+	if fs.assert.NoError(err) {
+		fs.assert.Empty(reversal.Fees)
+	}
+	fs.assertBalance(account, "100.00")
+}
+
+func (fs *FeeServiceSuite) TestUpdate_ChargesFeesAgain() {
+	// the overdraft fee goes away with the overdraft, the withdrawal fee is charged again
+	account := fs.openAccount(10000)
+	withdrawal := fs.withdraw(account, 15000)
+
+	correction := database.Transaction{Amount: database.NewMoney(5000, "USD")}
+	correction.ID = withdrawal.ID
+	err := fs.transactions.Update(&correction)
+
+	if fs.assert.NoError(err) && fs.assert.Len(correction.Fees, 1) {
+		fs.assert.Equal(int64(25), correction.Fees[0].Amount.Minor)
+	}
+	fs.assertBalance(account, "49.75")
+	fs.assertFees(withdrawal.ID, 1)
+}
+
+func (fs *FeeServiceSuite) TestDelete_RemovesFees() {
+	account := fs.openAccount(10000)
+	withdrawal := fs.withdraw(account, 15000)
+
+	fs.Require().NoError(fs.transactions.Delete(withdrawal.ID))
+
+	fs.assertBalance(account, "100.00")
+	fs.assertFees(withdrawal.ID, 0)
+}
+
+func (fs *FeeServiceSuite) TestDelete_ReversedFee() {
+	// a reversed fee is final, so the withdrawal that triggered it is too
+	account := fs.openAccount(10000)
+	withdrawal := fs.withdraw(account, 1000)
+	_, err := fs.transactions.Reverse(withdrawal.Fees[0].ID)
+	fs.Require().NoError(err)
+
+	fs.assert.ErrorIs(fs.transactions.Delete(withdrawal.ID), database.ErrReversalFinal)
+
+	fs.assertBalance(account, "90.00")
+}
+
+// openAccount opens a checking account before January 2024 with the given balance in cents
+func (fs *FeeServiceSuite) openAccount(balance int64) uint {
+	account := database.Account{AccountHolder: "Foo Bar", AccountType: "checking", Balance: database.NewMoney(balance, "USD")}
+	account.CreatedAt = time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)
+	fs.Require().NoError(NewAccountService(fs.store).Create(&account))
+	return account.ID
+}
+
+func (fs *FeeServiceSuite) withdraw(accountID uint, amount int64) *database.Transaction {
+	withdrawal := database.Transaction{AccountID: accountID, Type: "withdrawal", Amount: database.NewMoney(amount, "USD")}
+	fs.Require().NoError(fs.transactions.Create(&withdrawal))
+	return &withdrawal
+}
+
+// assertBalance checks the account balance, and that the ledger agrees with it
+func (fs *FeeServiceSuite) assertBalance(accountID uint, expected string) {
+	account, err := NewAccountService(fs.store).FetchById(accountID)
+	fs.Require().NoError(err)
+	fs.assert.Equal(expected, account.Balance.Decimal())
+
+	balance, err := ledger.New(fs.store.Journal()).Balance(accountID, "USD")
+	if fs.assert.NoError(err) {
+		fs.assert.Equal(expected, balance.Decimal())
+	}
+	fs.assert.NoError(ledger.New(fs.store.Journal()).Verify())
+}
+
+// assertFees checks how many fees a transaction has
+func (fs *FeeServiceSuite) assertFees(transactionID uint, expected int) {
+	fees, err := fs.store.Transactions().Fees(transactionID)
+	fs.Require().NoError(err)
+	fs.assert.Len(fees, expected)
+}
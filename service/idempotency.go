@@ -0,0 +1,79 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jobullo/go-api-example/database"
+)
+
+// how long a stored response is replayed for before its key can be used again
+const IdempotencyKeyTTL = 24 * time.Hour
+
+type IdempotencyService struct {
+	db *gorm.DB
+}
+
+// create a new idempotency service
+func NewIdempotencyService(db *gorm.DB) *IdempotencyService {
+	return &IdempotencyService{db: db}
+}
+
+// Reserve claims the key for a request. If the key is already in use the stored record is returned
+// instead so the caller can replay it, or reject the request if it doesn't match.
+func (is *IdempotencyService) Reserve(record *database.IdempotencyKey) (*database.IdempotencyKey, error) {
+	existing, err := is.fetch(record)
+	if err == nil {
+		return existing, nil
+	}
+	if !errors.Is(err, database.ErrNotFound) {
+		return nil, err
+	}
+
+	//expired records would block the unique index
+	expired := is.db.Unscoped().
+		Where("key = ? AND method = ? AND path = ? AND user_id = ? AND created_at <= ?", record.Key, record.Method, record.Path, record.UserID, time.Now().Add(-IdempotencyKeyTTL)).
+		Delete(&database.IdempotencyKey{})
+	if expired.Error != nil {
+		return nil, expired.Error
+	}
+
+	record.StatusCode = 0
+	if result := is.db.Create(record); result.Error != nil {
+		//a concurrent request with the same key won the race to the unique index
+		if existing, err := is.fetch(record); err == nil {
+			return existing, nil
+		}
+		return nil, result.Error
+	}
+
+	return nil, nil
+}
+
+// Complete stores the response for a reserved key
+func (is *IdempotencyService) Complete(record *database.IdempotencyKey) error {
+	return is.db.Save(record).Error
+}
+
+// Release removes a reservation so the request can be retried with the same key
+func (is *IdempotencyService) Release(record *database.IdempotencyKey) error {
+	return is.db.Unscoped().Delete(record).Error
+}
+
+// fetch looks up an unexpired record for the same key, method, path and user
+func (is *IdempotencyService) fetch(record *database.IdempotencyKey) (*database.IdempotencyKey, error) {
+	var existing database.IdempotencyKey
+
+	result := is.db.
+		Where("key = ? AND method = ? AND path = ? AND user_id = ? AND created_at > ?", record.Key, record.Method, record.Path, record.UserID, time.Now().Add(-IdempotencyKeyTTL)).
+		First(&existing)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, database.ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &existing, nil
+}
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jobullo/go-api-example/database"
+)
+
+// errRollback backs out an import that is a dry run or has invalid rows, it never reaches the caller
+var errRollback = errors.New("rollback import")
+
+// the errors that make a row invalid, anything else is a database failure that stops the import
+var rowErrors = []error{
+	database.ErrInvalidAmount,
+	database.ErrCurrencyMismatch,
+	database.ErrInvalidCurrency,
+	database.ErrInvalidType,
+	database.ErrInvalidAccountType,
+	database.ErrInvalidHolder,
+	database.ErrInsufficientFunds,
+	database.ErrParentNotFound,
+	database.ErrAccountNotOpen,
+	database.ErrUnbalancedEntry,
+}
+
+// ImportService loads accounts and transactions from CSV files, e.g. when migrating from another system
+type ImportService struct {
+	store database.Store
+	rules Rules
+}
+
+// create a new import service, transactions are checked against the given rules
+func NewImportService(store database.Store, rules Rules) *ImportService {
+	return &ImportService{store: store, rules: rules}
+}
+
+// ImportAccounts creates an account for every row of a CSV file with the columns
+// account_holder, account_type and optionally balance, currency and owner_id
+func (is *ImportService) ImportAccounts(r io.Reader, dryRun bool) (*database.ImportResult, error) {
+	return is.run(r, []string{"account_holder", "account_type"}, dryRun, func(store database.Store, row csvRow) error {
+		account := database.Account{
+			AccountHolder: row.get("account_holder"),
+			AccountType:   row.get("account_type"),
+		}
+
+		//accounts are held in the currency of their type, which is used when the row has none
+		currency := row.get("currency")
+		if err := is.rules.CheckCurrency(account.AccountType, currency); err != nil {
+			return columnError{"currency", err}
+		}
+		if currency == "" {
+			currency = is.rules.Currency(account.AccountType)
+		}
+
+		if balance := row.get("balance"); balance != "" {
+			money, err := database.ParseMoney(balance, currency)
+			if err != nil {
+				return columnError{"balance", err}
+			}
+			account.Balance = money
+		} else {
+			account.Balance = database.NewMoney(0, currency)
+		}
+
+		if owner := row.get("owner_id"); owner != "" {
+			id, err := strconv.ParseUint(owner, 10, 32)
+			if err != nil {
+				return columnError{"owner_id", fmt.Errorf("invalid user id %q", owner)}
+			}
+			ownerID := uint(id)
+			account.OwnerID = &ownerID
+		}
+
+		if !is.rules.AllowsAccountType(account.AccountType) {
+			return columnError{"account_type", fmt.Errorf("%w %q", database.ErrInvalidAccountType, account.AccountType)}
+		}
+
+		return NewAccountService(store).Create(&account)
+	})
+}
+
+// ImportTransactions posts a transaction for every row of a CSV file with the columns
+// account_id, type and amount and optionally currency and created_at. Rows are posted in order, so a row
+// can rely on the deposits above it and on accounts imported earlier in the same database.
+// The fees of the other system are imported as rows of their own, so no fees are charged.
+func (is *ImportService) ImportTransactions(r io.Reader, dryRun bool) (*database.ImportResult, error) {
+	return is.run(r, []string{"account_id", "type", "amount"}, dryRun, func(store database.Store, row csvRow) error {
+		accountID, err := strconv.ParseUint(row.get("account_id"), 10, 32)
+		if err != nil {
+			return columnError{"account_id", fmt.Errorf("invalid account id %q", row.get("account_id"))}
+		}
+
+		amount, err := database.ParseMoney(row.get("amount"), row.get("currency"))
+		if err != nil {
+			return columnError{"amount", err}
+		}
+
+		transaction := database.Transaction{AccountID: uint(accountID), Type: row.get("type"), Amount: amount}
+
+		//keep the date the transaction was made in the other system, so statements and interest see it then
+		if value := row.get("created_at"); value != "" {
+			if transaction.CreatedAt, err = parseImportTime(value); err != nil {
+				return columnError{"created_at", err}
+			}
+		}
+
+		return NewTransactionService(store, is.rules).create(&transaction, false)
+	})
+}
+
+// parseImportTime reads an RFC 3339 timestamp or a day, which is taken as midnight UTC.
+// Transactions can't be imported into the future.
+func parseImportTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		if t, err = time.Parse("2006-01-02", value); err != nil {
+			return time.Time{}, fmt.Errorf("must be a date such as 2024-01-31 or an RFC 3339 timestamp, got %q", value)
+		}
+	}
+	if t.After(time.Now()) {
+		return time.Time{}, fmt.Errorf("%q is in the future", value)
+	}
+	return t.UTC(), nil
+}
+
+// csvRow is one line of an import file, values are looked up by the column names in the header
+type csvRow struct {
+	columns map[string]int
+	values  []string
+}
+
+func (r csvRow) get(column string) string {
+	if i, ok := r.columns[column]; ok && i < len(r.values) {
+		return strings.TrimSpace(r.values[i])
+	}
+	return ""
+}
+
+// columnError is a row error caused by the value in one column
+type columnError struct {
+	column string
+	err    error
+}
+
+func (e columnError) Error() string { return e.err.Error() }
+func (e columnError) Unwrap() error { return e.err }
+
+// run reads the header, then imports every row in a single store.Atomic call.
+// Every row is tried so the result lists all the invalid ones, but nothing is saved unless all of them are valid.
+func (is *ImportService) run(r io.Reader, required []string, dryRun bool, importRow func(store database.Store, row csvRow) error) (*database.ImportResult, error) {
+	result := &database.ImportResult{DryRun: dryRun, Errors: []database.ImportError{}}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		result.Errors = append(result.Errors, database.ImportError{Line: 1, Message: "the file is empty"})
+		return result, nil
+	} else if err != nil {
+		result.Errors = append(result.Errors, database.ImportError{Line: 1, Message: err.Error()})
+		return result, nil
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range required {
+		if _, ok := columns[name]; !ok {
+			result.Errors = append(result.Errors, database.ImportError{Line: 1, Column: name, Message: "missing column"})
+		}
+	}
+	if len(result.Errors) > 0 {
+		return result, nil
+	}
+
+	performImport := func(store database.Store) error {
+		for {
+			values, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				//a malformed line can't be read past
+				var parseErr *csv.ParseError
+				if errors.As(err, &parseErr) {
+					result.Errors = append(result.Errors, database.ImportError{Line: parseErr.Line, Message: parseErr.Err.Error()})
+					break
+				}
+				return err
+			}
+			line, _ := reader.FieldPos(0)
+
+			result.Rows++
+			row := csvRow{columns: columns, values: values}
+			if err := checkRequired(row, required); err != nil {
+				result.Errors = append(result.Errors, rowError(line, err))
+				continue
+			}
+
+			if err := importRow(store, row); err != nil {
+				if !isRowError(err) {
+					return fmt.Errorf("line %d: %w", line, err)
+				}
+				result.Errors = append(result.Errors, rowError(line, err))
+			}
+		}
+
+		if dryRun || len(result.Errors) > 0 {
+			return errRollback
+		}
+		return nil
+	}
+
+	if err := is.store.Atomic(performImport); err != nil && !errors.Is(err, errRollback) {
+		return nil, err
+	}
+
+	if !dryRun && len(result.Errors) == 0 {
+		result.Imported = result.Rows
+	}
+	return result, nil
+}
+
+func checkRequired(row csvRow, required []string) error {
+	for _, column := range required {
+		if row.get(column) == "" {
+			return columnError{column, errors.New("value is required")}
+		}
+	}
+	return nil
+}
+
+func isRowError(err error) bool {
+	var ce columnError
+	if errors.As(err, &ce) {
+		return true
+	}
+	for _, rowErr := range rowErrors {
+		if errors.Is(err, rowErr) {
+			return true
+		}
+	}
+	return false
+}
+
+func rowError(line int, err error) database.ImportError {
+	importError := database.ImportError{Line: line, Message: err.Error()}
+	var ce columnError
+	if errors.As(err, &ce) {
+		importError.Column = ce.column
+	}
+	return importError
+}
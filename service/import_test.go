@@ -0,0 +1,177 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jobullo/go-api-example/database"
+	"github.com/jobullo/go-api-example/ledger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+type ImportServiceSuite struct {
+	suite.Suite
+	assert  *assert.Assertions
+	store   database.Store
+	service *ImportService
+}
+
+func TestImportServiceSuite(t *testing.T) {
+	suite.Run(t, new(ImportServiceSuite))
+}
+
+func (is *ImportServiceSuite) SetupTest() {
+	t := is.T()
+
+	is.assert = assert.New(t)
+	is.store = database.NewMemoryStore()
+	is.service = NewImportService(is.store, DefaultRules())
+}
+
+func (is *ImportServiceSuite) TestImportAccounts() {
+	owner := uint(7)
+	// savings accounts are held in euros
+	rules := DefaultRules()
+	rules.AccountTypes["savings"] = AccountTypeRules{Currency: "EUR"}
+	is.service.rules = rules
+
+	result, err := is.service.ImportAccounts(strings.NewReader(
+		"account_holder,account_type,balance,currency,owner_id\n"+
+			"Foo Bar,checking,,,\n"+
+			"Baz Qux,savings,1250.50,eur,7\n"), false)
+
+	if is.assert.NoError(err) {
+		is.assert.Equal(&database.ImportResult{Rows: 2, Imported: 2, Errors: []database.ImportError{}}, result)
+	}
+
+	accounts := is.accounts(2)
+	is.assert.Equal("Foo Bar", accounts[0].AccountHolder)
+	is.assert.Equal(database.NewMoney(0, "USD"), accounts[0].Balance)
+	is.assert.Nil(accounts[0].OwnerID)
+	is.assert.Equal("Baz Qux", accounts[1].AccountHolder)
+	is.assert.Equal(database.NewMoney(125050, "EUR"), accounts[1].Balance)
+	is.assert.Equal(&owner, accounts[1].OwnerID)
+
+	// the opening balance of the second account is posted to the ledger
+	balance, err := ledger.New(is.store.Journal()).Balance(accounts[1].ID, "EUR")
+	is.assert.NoError(err)
+	is.assert.Equal(database.NewMoney(125050, "EUR"), balance)
+}
+
+func (is *ImportServiceSuite) TestImportAccounts_DryRun() {
+	result, err := is.service.ImportAccounts(strings.NewReader("account_holder,account_type\nFoo Bar,checking\n"), true)
+
+	if is.assert.NoError(err) {
+		is.assert.Equal(&database.ImportResult{DryRun: true, Rows: 1, Imported: 0, Errors: []database.ImportError{}}, result)
+	}
+
+	// every row is checked, then nothing is kept
+	is.accounts(0)
+}
+
+func (is *ImportServiceSuite) TestImportAccounts_MissingColumn() {
+	// nothing is imported when the header is wrong
+	result, err := is.service.ImportAccounts(strings.NewReader("holder,account_type\nFoo Bar,checking\n"), false)
+
+	if is.assert.NoError(err) {
+		is.assert.Equal([]database.ImportError{{Line: 1, Column: "account_holder", Message: "missing column"}}, result.Errors)
+		is.assert.Equal(0, result.Imported)
+	}
+}
+
+func (is *ImportServiceSuite) TestImportAccounts_InvalidRows() {
+	// the valid row isn't kept either
+	result, err := is.service.ImportAccounts(strings.NewReader(
+		"account_holder,account_type,balance,currency\n"+
+			"Foo Bar,brokerage,,\n"+
+			",savings,,\n"+
+			"Baz Qux,savings,-1.00,\n"+
+			"Quux,checking,10.00,EUR\n"+
+			"Corge,checking,10.00,\n"), false)
+
+	if is.assert.NoError(err) {
+		is.assert.Equal([]database.ImportError{
+			{Line: 2, Column: "account_type", Message: `invalid account type "brokerage"`},
+			{Line: 3, Column: "account_holder", Message: "value is required"},
+			{Line: 4, Message: "invalid amount: an account can't be opened with a negative balance"},
+			{Line: 5, Column: "currency", Message: "currency mismatch: checking accounts are held in USD, not EUR"},
+		}, result.Errors)
+		is.assert.Equal(0, result.Imported)
+	}
+	is.accounts(0)
+}
+
+func (is *ImportServiceSuite) TestImportTransactions() {
+	_, err := is.service.ImportAccounts(strings.NewReader("account_holder,account_type\nFoo Bar,savings\n"), false)
+	is.Require().NoError(err)
+
+	// a row can rely on the deposit above it, and the date of the other system is kept
+	result, err := is.service.ImportTransactions(strings.NewReader(
+		"account_id,type,amount,created_at\n"+
+			"1,deposit,10.00,2024-01-02\n"+
+			"1,withdrawal,2.50,2024-01-03T10:00:00Z\n"), false)
+
+	if is.assert.NoError(err) {
+		is.assert.Equal(&database.ImportResult{Rows: 2, Imported: 2, Errors: []database.ImportError{}}, result)
+	}
+	is.assert.Equal(database.NewMoney(750, "USD"), is.accounts(1)[0].Balance)
+	transactions, err := is.store.Transactions().ListByAccount(1)
+	if is.assert.NoError(err) && is.assert.Len(*transactions, 2) {
+		is.assert.Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), (*transactions)[0].CreatedAt)
+	}
+}
+
+func (is *ImportServiceSuite) TestImportTransactions_ReportsEveryInvalidRow() {
+	_, err := is.service.ImportAccounts(strings.NewReader("account_holder,account_type\nFoo Bar,savings\n"), false)
+	is.Require().NoError(err)
+
+	// line 3 refers to an account that doesn't exist
+	result, err := is.service.ImportTransactions(strings.NewReader(
+		"account_id,type,amount\n"+
+			"1,deposit,ten\n"+
+			"99,deposit,10.00\n"+
+			"1,,10.00\n"+
+			"1,deposit,10.00\n"), false)
+
+	if is.assert.NoError(err) {
+		is.assert.Equal(4, result.Rows)
+		is.assert.Equal(0, result.Imported)
+		if is.assert.Len(result.Errors, 3) {
+			is.assert.Equal(2, result.Errors[0].Line)
+			is.assert.Equal("amount", result.Errors[0].Column)
+			is.assert.Equal(3, result.Errors[1].Line)
+			is.assert.Equal(database.ErrParentNotFound.Error(), result.Errors[1].Message)
+			is.assert.Equal(database.ImportError{Line: 4, Column: "type", Message: "value is required"}, result.Errors[2])
+		}
+	}
+
+	// the valid row isn't kept either
+	is.assert.Equal(database.NewMoney(0, "USD"), is.accounts(1)[0].Balance)
+}
+
+func (is *ImportServiceSuite) TestImportTransactions_InvalidCreatedAt() {
+	// the dates are checked before the account is looked up
+	result, err := is.service.ImportTransactions(strings.NewReader(
+		"account_id,type,amount,created_at\n"+
+			"1,deposit,10.00,yesterday\n"+
+			"1,deposit,10.00,"+time.Now().AddDate(0, 0, 2).Format("2006-01-02")+"\n"), false)
+
+	if is.assert.NoError(err) {
+		if is.assert.Len(result.Errors, 2) {
+			is.assert.Equal("created_at", result.Errors[0].Column)
+			is.assert.Contains(result.Errors[0].Message, "must be a date")
+			is.assert.Equal("created_at", result.Errors[1].Column)
+			is.assert.Contains(result.Errors[1].Message, "in the future")
+		}
+	}
+}
+
+// asserts how many accounts are stored and returns them by id
+func (is *ImportServiceSuite) accounts(count int) []database.Account {
+	accounts, err := is.store.Accounts().List()
+	is.Require().NoError(err)
+	is.Require().Len(*accounts, count)
+	return *accounts
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/jobullo/go-api-example/database"
+)
+
+// interest is paid monthly, periods are named by their month
+const periodLayout = "2006-01"
+
+// interest accrues daily at 1/365th of the yearly rate, leap years included
+const daysPerYear = 365
+
+// InterestService works out the interest earned on accounts and pays it once per period
+type InterestService struct {
+	store database.Store
+	rules Rules //interest rates by account type
+}
+
+// create a new interest service, accounts earn the rates of the given rules
+func NewInterestService(store database.Store, rules Rules) *InterestService {
+	return &InterestService{store: store, rules: rules}
+}
+
+// ParsePeriod returns the first day of a month such as 2024-01 and the first day of the next month, in UTC
+func ParsePeriod(period string) (time.Time, time.Time, error) {
+	from, err := time.Parse(periodLayout, period)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: %q is not a month such as 2024-01", database.ErrInvalidPeriod, period)
+	}
+	return from, from.AddDate(0, 1, 0), nil
+}
+
+// pastPeriod parses a month that is over, interest and fees are only worked out for whole months
+func pastPeriod(period string) (time.Time, time.Time, error) {
+	from, to, err := ParsePeriod(period)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if to.After(time.Now()) {
+		return time.Time{}, time.Time{}, fmt.Errorf("%w: %s isn't over yet", database.ErrInvalidPeriod, period)
+	}
+	return from, to, nil
+}
+
+// Accrue works out the interest an account earned from the start of the period, or the day it was opened,
+// up to, but not including, the end of the period. Every day earns the day's share of the yearly rate on the balance at the end of that day, worked out from the
+// transaction history, overdrawn days earn nothing. The days are added up exactly and the total is rounded
+// half up to a minor unit once.
+func (is *InterestService) Accrue(account *database.Account, from time.Time, to time.Time) (database.Money, error) {
+	rate := is.rules.AccountTypes[account.AccountType].InterestRate
+	if rate == 0 {
+		return database.NewMoney(0, account.Balance.Currency), nil
+	}
+
+	//the days before the account was opened earn nothing
+	if opened := account.CreatedAt.UTC().Truncate(24 * time.Hour); opened.After(from) {
+		from = opened
+	}
+	if !from.Before(to) {
+		return database.NewMoney(0, account.Balance.Currency), nil
+	}
+
+	statement, err := NewTransactionService(is.store, is.rules).Statement(account.ID, from, to)
+	if err != nil {
+		return database.Money{}, err
+	}
+
+	//add up the end-of-day balances of the period
+	total := new(big.Int)
+	for _, balance := range dailyBalances(statement) {
+		if balance.Minor > 0 {
+			total.Add(total, big.NewInt(balance.Minor))
+		}
+	}
+
+	//balance * rate in millionths / 365, rounded half up
+	interest := total.Mul(total, big.NewInt(rate))
+	divisor := big.NewInt(1_000_000 * daysPerYear)
+	interest.Add(interest, new(big.Int).Rsh(divisor, 1))
+	interest.Quo(interest, divisor)
+
+	return database.NewMoney(interest.Int64(), statement.OpeningBalance.Currency), nil
+}
+
+// Post pays the interest earned in a month, such as 2024-01, to every open account whose type earns interest.
+// Each account is paid in a store.Atomic call of its own along with a record of the period, accounts
+// that already have a record for the period are skipped, so a run that failed halfway can be run again.
+// Frozen, dormant and closed accounts don't take postings and earn nothing.
+func (is *InterestService) Post(period string) (*database.InterestRun, error) {
+	from, to, err := pastPeriod(period)
+	if err != nil {
+		return nil, err
+	}
+
+	var accountTypes []string
+	for accountType, typeRules := range is.rules.AccountTypes {
+		if typeRules.InterestRate > 0 {
+			accountTypes = append(accountTypes, accountType)
+		}
+	}
+
+	run := &database.InterestRun{Period: period, Posted: []database.InterestPosting{}}
+	if len(accountTypes) == 0 {
+		return run, nil
+	}
+
+	//accounts opened after the period can't have earned anything in it
+	accounts, err := is.store.Accounts().ListOpen(accountTypes, to)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range accounts {
+		posting, err := is.postAccount(accounts[i].ID, period, from, to)
+		//the account may have been closed or frozen since it was listed
+		if errors.Is(err, errAlreadyPosted) || errors.Is(err, database.ErrAccountNotOpen) {
+			run.Skipped++
+			continue
+		}
+		if err != nil {
+			return run, fmt.Errorf("account %d: %w", accounts[i].ID, err)
+		}
+		run.Posted = append(run.Posted, *posting)
+	}
+
+	return run, nil
+}
+
+// dailyBalances returns the balance at the end of every day of a statement's period
+func dailyBalances(statement *database.Statement) []database.Money {
+	var balances []database.Money
+
+	//the lines are in the order they were posted
+	balance := statement.OpeningBalance
+	line := 0
+	for day := statement.From; day.Before(statement.To); day = day.AddDate(0, 0, 1) {
+		endOfDay := day.AddDate(0, 0, 1)
+		for line < len(statement.Lines) && statement.Lines[line].Transaction.CreatedAt.Before(endOfDay) {
+			balance = statement.Lines[line].RunningBalance
+			line++
+		}
+		balances = append(balances, balance)
+	}
+
+	return balances
+}
+
+// returned for an account that has already been paid interest, or charged a fee, for the period
+var errAlreadyPosted = errors.New("already posted for the period")
+
+// postAccount pays one account the interest of a period and records that it has been paid
+func (is *InterestService) postAccount(accountID uint, period string, from time.Time, to time.Time) (*database.InterestPosting, error) {
+	posting := database.InterestPosting{AccountID: accountID, Period: period}
+
+	//inline function to pass to store.Atomic
+	performPosting := func(store database.Store) error {
+		posted, err := store.InterestPostings().Recorded(accountID, period)
+		if err != nil {
+			return err
+		}
+		if posted {
+			return errAlreadyPosted
+		}
+
+		//the record goes in first, a concurrent run for the same period fails on its unique index and rolls back
+		if err := store.InterestPostings().Create(&posting); err != nil {
+			return err
+		}
+
+		account, err := NewAccountService(store).FetchForUpdate(accountID)
+		if err != nil {
+			return err
+		}
+		if !account.IsOpen() {
+			return fmt.Errorf("%w: account %d is %s", database.ErrAccountNotOpen, account.ID, account.Status)
+		}
+
+		interest, err := NewInterestService(store, is.rules).Accrue(account, from, to)
+		if err != nil {
+			return err
+		}
+		posting.Amount = interest
+		if !interest.IsPositive() {
+			return store.InterestPostings().Save(&posting)
+		}
+
+		transaction := database.Transaction{AccountID: account.ID, Type: "interest", Amount: interest}
+		if err := NewTransactionService(store, is.rules).Create(&transaction); err != nil {
+			return err
+		}
+		posting.TransactionID = &transaction.ID
+		return store.InterestPostings().Save(&posting)
+	}
+
+	//will roll back the record of the period if the interest can't be paid
+	if err := is.store.Atomic(performPosting); err != nil {
+		return nil, err
+	}
+
+	return &posting, nil
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jobullo/go-api-example/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// InterestServiceSuite works out interest against a memory store, the balance history
+// comes from real transactions rather than expected SQL
+type InterestServiceSuite struct {
+	suite.Suite
+	assert  *assert.Assertions
+	store   database.Store
+	service *InterestService
+	account *database.Account
+}
+
+func TestInterestServiceSuite(t *testing.T) {
+	suite.Run(t, new(InterestServiceSuite))
+}
+
+func (is *InterestServiceSuite) SetupTest() {
+	t := is.T()
+
+	is.assert = assert.New(t)
+	is.store = database.NewMemoryStore()
+
+	//3.65% a year is 0.01% a day
+	rules := Rules{AccountTypes: map[string]AccountTypeRules{"savings": {InterestRate: 36500}, "checking": {}}}
+	is.service = NewInterestService(is.store, rules)
+
+	//opened with 1000.00 before January 2024, another 1000.00 is deposited on the 11th
+	is.account = &database.Account{AccountHolder: "Foo Bar", AccountType: "savings", Balance: database.NewMoney(100000, "USD")}
+	is.account.CreatedAt = time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, NewAccountService(is.store).Create(is.account))
+
+	deposit := database.Transaction{AccountID: is.account.ID, Type: "deposit", Amount: database.NewMoney(100000, "USD")}
+	deposit.CreatedAt = time.Date(2024, 1, 11, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, NewTransactionService(is.store, rules).Create(&deposit))
+}
+
+func (is *InterestServiceSuite) TestAccrue() {
+	from, to, err := ParsePeriod("2024-01")
+	is.Require().NoError(err)
+
+	interest, err := is.service.Accrue(is.account, from, to)
+
+	// 10 days at 1000.00 and 21 days at 2000.00, 0.01% a day
+	if is.assert.NoError(err) {
+		is.assert.Equal(database.NewMoney(520, "USD"), interest)
+	}
+}
+
+func (is *InterestServiceSuite) TestAccrue_AccountOpenedBeforeTheLedger() {
+	// accounts from before the ledger have a balance but no postings, the history comes from the balance
+	account := &database.Account{AccountHolder: "Foo Bar", AccountType: "savings", Balance: database.NewMoney(200000, "USD"), Version: 1, Status: database.AccountOpen}
+	account.CreatedAt = time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)
+	is.Require().NoError(is.store.Accounts().Create(account))
+	deposit := database.Transaction{AccountID: account.ID, Type: "deposit", Amount: database.NewMoney(100000, "USD")}
+	deposit.CreatedAt = time.Date(2024, 1, 11, 12, 0, 0, 0, time.UTC)
+	is.Require().NoError(is.store.Transactions().Create(&deposit))
+
+	from, to, err := ParsePeriod("2024-01")
+	is.Require().NoError(err)
+
+	interest, err := is.service.Accrue(account, from, to)
+
+	if is.assert.NoError(err) {
+		is.assert.Equal(database.NewMoney(520, "USD"), interest)
+	}
+}
+
+func (is *InterestServiceSuite) TestAccrue_AccountOpenedInThePeriod() {
+	// only the last day of January counts for an account opened on the 31st
+	account := &database.Account{AccountHolder: "Foo Bar", AccountType: "savings", Balance: database.NewMoney(100000, "USD")}
+	account.CreatedAt = time.Date(2024, 1, 31, 9, 0, 0, 0, time.UTC)
+	is.Require().NoError(NewAccountService(is.store).Create(account))
+
+	from, to, err := ParsePeriod("2024-01")
+	is.Require().NoError(err)
+
+	interest, err := is.service.Accrue(account, from, to)
+
+	if is.assert.NoError(err) {
+		is.assert.Equal(database.NewMoney(10, "USD"), interest)
+	}
+}
+
+func (is *InterestServiceSuite) TestAccrue_NoRate() {
+	checking := &database.Account{Model: is.account.Model, AccountType: "checking", Balance: is.account.Balance}
+
+	interest, err := is.service.Accrue(checking, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC))
+
+	if is.assert.NoError(err) {
+		is.assert.True(interest.IsZero())
+	}
+}
+
+func (is *InterestServiceSuite) TestPost_OncePerPeriod() {
+	run, err := is.service.Post("2024-01")
+	if is.assert.NoError(err) && is.assert.Len(run.Posted, 1) {
+		is.assert.Equal(database.NewMoney(520, "USD"), run.Posted[0].Amount)
+		is.assert.NotNil(run.Posted[0].TransactionID)
+	}
+
+	// running the period again pays nothing
+	again, err := is.service.Post("2024-01")
+	if is.assert.NoError(err) {
+		is.assert.Empty(again.Posted)
+		is.assert.Equal(1, again.Skipped)
+	}
+
+	account, err := NewAccountService(is.store).FetchById(is.account.ID)
+	if is.assert.NoError(err) {
+		is.assert.Equal("2005.20", account.Balance.Decimal())
+	}
+}
+
+func (is *InterestServiceSuite) TestPost_AccountFrozenSinceListed() {
+	// the status is checked again under the account lock, so the account is skipped instead of failing the run
+	_, err := NewAccountService(is.store).Freeze(is.account.ID, 0)
+	is.Require().NoError(err)
+	from, to, err := ParsePeriod("2024-01")
+	is.Require().NoError(err)
+
+	_, err = is.service.postAccount(is.account.ID, "2024-01", from, to)
+
+	is.assert.ErrorIs(err, database.ErrAccountNotOpen)
+	posted, err := is.store.InterestPostings().Recorded(is.account.ID, "2024-01")
+	is.Require().NoError(err)
+	is.assert.False(posted)
+}
+
+func (is *InterestServiceSuite) TestPost_PeriodNotOver() {
+	_, err := is.service.Post(time.Now().Format(periodLayout))
+	is.assert.ErrorIs(err, database.ErrInvalidPeriod)
+
+	_, err = is.service.Post("January")
+	is.assert.ErrorIs(err, database.ErrInvalidPeriod)
+}
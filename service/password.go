@@ -0,0 +1,57 @@
+package service
+
+import (
+	"unicode"
+)
+
+// DefaultMinPasswordStrength is used where no configuration is loaded, such as the console
+const DefaultMinPasswordStrength = 3
+
+// MaxPasswordLength is the longest password in bytes bcrypt can hash
+const MaxPasswordLength = 72
+
+// PasswordStrength scores a password from 0 (very weak) to 4 (strong), on the same scale as
+// config.Application.MinPasswordStr. Length counts the most, mixing character classes adds the rest.
+func PasswordStrength(password string) int {
+	length := len([]rune(password))
+	if length < 8 {
+		return 0
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			classes++
+		}
+	}
+
+	score := 1
+	if length >= 12 {
+		score++
+	}
+	if classes >= 3 {
+		score++
+	}
+	if classes == 4 || length >= 16 && classes >= 2 {
+		score++
+	}
+	if score > 4 {
+		score = 4
+	}
+
+	return score
+}
@@ -0,0 +1,25 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordStrength(t *testing.T) {
+	cases := map[string]int{
+		"":                     0,
+		"Ab1!":                 0, //too short whatever it contains
+		"password":             1,
+		"passwordpassword":     2,
+		"Password1":            2,
+		"Password1234":         3,
+		"Passw0rd!":            3,
+		"correcthorse battery": 3,
+		"Correct horse 1":      4,
+	}
+
+	for password, want := range cases {
+		assert.Equal(t, want, PasswordStrength(password), "password %q", password)
+	}
+}
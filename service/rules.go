@@ -0,0 +1,204 @@
+package service
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/jobullo/go-api-example/config"
+	"github.com/jobullo/go-api-example/database"
+)
+
+// AccountTypeRules holds the business rules for accounts of one account type
+type AccountTypeRules struct {
+	// the currency accounts of this type are held in, the limit and fees are amounts in it
+	Currency string
+	// how far below zero a withdrawal may take the balance
+	OverdraftLimit database.Money
+	// yearly interest rate in millionths, 25000 is 2.5%. Zero pays no interest.
+	InterestRate int64
+	Fees         FeeSchedule
+}
+
+// FeeSchedule holds the fees charged to accounts of one account type, zero fees aren't charged
+type FeeSchedule struct {
+	Maintenance database.Money //charged every month by the maintenance fee run
+	Withdrawal  database.Money //charged on every withdrawal
+	Overdraft   database.Money //charged on every withdrawal that leaves the balance below zero
+	// the maintenance and withdrawal fees are waived while the balance is at least this, zero waives nothing
+	WaiverBalance database.Money
+}
+
+// Rules holds the business rules enforced by the services, keyed by account type.
+// Account types without rules can't go negative.
+type Rules struct {
+	AccountTypes map[string]AccountTypeRules
+	// when set, transactions are append-only and corrections are made with reversals
+	Immutable bool
+}
+
+// DefaultRules are used when no rules are configured: savings accounts can't go negative and earn 2.5%
+// a year, checking accounts may be overdrawn by up to 500.00
+func DefaultRules() Rules {
+	return Rules{
+		AccountTypes: map[string]AccountTypeRules{
+			"savings":  {Currency: database.DefaultCurrency, InterestRate: 25000},
+			"checking": {Currency: database.DefaultCurrency, OverdraftLimit: database.NewMoney(50000, database.DefaultCurrency)},
+		},
+	}
+}
+
+// RulesFromConfig builds the rules from the account_types and application sections of the configuration
+func RulesFromConfig(cfg config.Configuration) (Rules, error) {
+	rules := DefaultRules()
+	if cfg.App != nil {
+		rules.Immutable = cfg.App.ImmutableLedger
+	}
+
+	if cfg.AccountTypes == nil {
+		return rules, nil
+	}
+
+	rules.AccountTypes = make(map[string]AccountTypeRules, len(cfg.AccountTypes))
+	for accountType, accountTypeCfg := range cfg.AccountTypes {
+		typeRules := AccountTypeRules{Currency: database.DefaultCurrency}
+
+		if accountTypeCfg != nil && accountTypeCfg.Currency != "" {
+			if !database.ValidCurrency(accountTypeCfg.Currency) {
+				return Rules{}, fmt.Errorf("account type %s: %w: %q", accountType, database.ErrInvalidCurrency, accountTypeCfg.Currency)
+			}
+			typeRules.Currency = strings.ToUpper(accountTypeCfg.Currency)
+		}
+
+		if accountTypeCfg != nil && accountTypeCfg.OverdraftLimit != "" {
+			limit, err := database.ParseMoney(accountTypeCfg.OverdraftLimit, typeRules.Currency)
+			if err != nil {
+				return Rules{}, fmt.Errorf("account type %s: overdraft limit: %w", accountType, err)
+			}
+			if limit.IsNegative() {
+				return Rules{}, fmt.Errorf("account type %s: overdraft limit can't be negative", accountType)
+			}
+			typeRules.OverdraftLimit = limit
+		}
+
+		if accountTypeCfg != nil {
+			fees, err := feeScheduleFromConfig(accountTypeCfg, typeRules.Currency)
+			if err != nil {
+				return Rules{}, fmt.Errorf("account type %s: %w", accountType, err)
+			}
+			typeRules.Fees = fees
+		}
+
+		if accountTypeCfg != nil && accountTypeCfg.InterestRate != "" {
+			rate, err := parseInterestRate(accountTypeCfg.InterestRate)
+			if err != nil {
+				return Rules{}, fmt.Errorf("account type %s: interest rate: %w", accountType, err)
+			}
+			typeRules.InterestRate = rate
+		}
+
+		rules.AccountTypes[accountType] = typeRules
+	}
+
+	return rules, nil
+}
+
+// feeScheduleFromConfig reads the fees of an account type in its currency, every fee is optional
+func feeScheduleFromConfig(cfg *config.AccountType, currency string) (FeeSchedule, error) {
+	var fees FeeSchedule
+	for _, fee := range []struct {
+		name   string
+		value  string
+		target *database.Money
+	}{
+		{"maintenance fee", cfg.MaintenanceFee, &fees.Maintenance},
+		{"withdrawal fee", cfg.WithdrawalFee, &fees.Withdrawal},
+		{"overdraft fee", cfg.OverdraftFee, &fees.Overdraft},
+		{"fee waiver balance", cfg.FeeWaiverBalance, &fees.WaiverBalance},
+	} {
+		if fee.value == "" {
+			continue
+		}
+
+		amount, err := database.ParseMoney(fee.value, currency)
+		if err != nil {
+			return FeeSchedule{}, fmt.Errorf("%s: %w", fee.name, err)
+		}
+		if amount.IsNegative() {
+			return FeeSchedule{}, fmt.Errorf("%s can't be negative", fee.name)
+		}
+		*fee.target = amount
+	}
+	return fees, nil
+}
+
+// parseInterestRate reads a percentage with up to four decimals, e.g. "2.5" or "0.0125", as millionths
+func parseInterestRate(value string) (int64, error) {
+	percent, ok := new(big.Rat).SetString(strings.TrimSuffix(strings.TrimSpace(value), "%"))
+	if !ok {
+		return 0, fmt.Errorf("%q is not a percentage", value)
+	}
+	if percent.Sign() < 0 {
+		return 0, fmt.Errorf("%q is negative", value)
+	}
+
+	rate := percent.Mul(percent, big.NewRat(10000, 1))
+	if !rate.IsInt() || !rate.Num().IsInt64() {
+		return 0, fmt.Errorf("%q has more than four decimals", value)
+	}
+	return rate.Num().Int64(), nil
+}
+
+// CheckWithdrawal returns database.ErrInsufficientFunds when the new balance is
+// further below zero than the account type's overdraft limit allows, and database.ErrCurrencyMismatch
+// when the account isn't held in the currency the limit is in
+func (r Rules) CheckWithdrawal(account *database.Account, balance database.Money) error {
+	limit := r.AccountTypes[account.AccountType].OverdraftLimit
+
+	//no limit means no overdraft in any currency
+	if limit.IsPositive() && limit.Normalized().Currency != balance.Normalized().Currency {
+		return fmt.Errorf("%w: account %d is in %s, the overdraft limit of %s accounts is in %s",
+			database.ErrCurrencyMismatch, account.ID, balance.Normalized().Currency, account.AccountType, limit.Currency)
+	}
+	if balance.Minor+limit.Minor < 0 {
+		return fmt.Errorf("%w: account %d would be %s with an overdraft limit of %s",
+			database.ErrInsufficientFunds, account.ID, balance, limit.Decimal())
+	}
+
+	return nil
+}
+
+// Currency returns the currency accounts of the given type are held in
+func (r Rules) Currency(accountType string) string {
+	if typeRules, ok := r.AccountTypes[accountType]; ok && typeRules.Currency != "" {
+		return typeRules.Currency
+	}
+	return database.DefaultCurrency
+}
+
+// CheckCurrency returns database.ErrCurrencyMismatch when an account of the given type would be held in
+// another currency than the type's, an empty currency is the type's
+func (r Rules) CheckCurrency(accountType string, currency string) error {
+	if currency != "" && !strings.EqualFold(currency, r.Currency(accountType)) {
+		return fmt.Errorf("%w: %s accounts are held in %s, not %s",
+			database.ErrCurrencyMismatch, accountType, r.Currency(accountType), strings.ToUpper(currency))
+	}
+	return nil
+}
+
+// AllowsAccountType reports whether accounts of the given type can be opened, only configured types can
+func (r Rules) AllowsAccountType(accountType string) bool {
+	_, ok := r.AccountTypes[accountType]
+	return ok
+}
+
+// AccountTypeNames lists the configured account types in alphabetical order
+func (r Rules) AccountTypeNames() []string {
+	names := make([]string, 0, len(r.AccountTypes))
+	for accountType := range r.AccountTypes {
+		names = append(names, accountType)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/jobullo/go-api-example/config"
+	"github.com/jobullo/go-api-example/database"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestRulesFromConfig(t *testing.T) {
+	var cfg config.Configuration
+	err := yaml.Unmarshal([]byte(`
+account_types:
+  savings:
+    overdraft_limit: 0
+    interest_rate: 2.5
+  checking:
+    overdraft_limit: 250.50
+    maintenance_fee: 5.00
+    withdrawal_fee: 0.25
+    fee_waiver_balance: 1500
+  business:
+`), &cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	rules, err := RulesFromConfig(cfg)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(0), rules.AccountTypes["savings"].OverdraftLimit.Minor)
+		assert.Equal(t, int64(25050), rules.AccountTypes["checking"].OverdraftLimit.Minor)
+		assert.Equal(t, int64(0), rules.AccountTypes["business"].OverdraftLimit.Minor)
+		assert.Equal(t, int64(25000), rules.AccountTypes["savings"].InterestRate)
+		assert.Equal(t, int64(0), rules.AccountTypes["checking"].InterestRate)
+		assert.Equal(t, int64(500), rules.AccountTypes["checking"].Fees.Maintenance.Minor)
+		assert.Equal(t, int64(25), rules.AccountTypes["checking"].Fees.Withdrawal.Minor)
+		assert.Equal(t, int64(0), rules.AccountTypes["checking"].Fees.Overdraft.Minor)
+		assert.Equal(t, int64(150000), rules.AccountTypes["checking"].Fees.WaiverBalance.Minor)
+		assert.Equal(t, "USD", rules.AccountTypes["checking"].Fees.Maintenance.Currency)
+	}
+}
+
+func TestRulesFromConfig_Invalid(t *testing.T) {
+	for _, limit := range []string{"abc", "-10"} {
+		cfg := config.Configuration{AccountTypes: map[string]*config.AccountType{
+			"checking": {OverdraftLimit: limit},
+		}}
+
+		_, err := RulesFromConfig(cfg)
+		assert.Error(t, err, limit)
+	}
+}
+
+func TestRulesFromConfig_InvalidInterestRate(t *testing.T) {
+	for _, rate := range []string{"abc", "-1", "2.00001"} {
+		cfg := config.Configuration{AccountTypes: map[string]*config.AccountType{
+			"savings": {InterestRate: rate},
+		}}
+
+		_, err := RulesFromConfig(cfg)
+		assert.Error(t, err, rate)
+	}
+}
+
+func TestRulesFromConfig_InvalidFee(t *testing.T) {
+	for _, accountType := range []*config.AccountType{
+		{MaintenanceFee: "abc"},
+		{WithdrawalFee: "-0.25"},
+		{FeeWaiverBalance: "-1"},
+	} {
+		cfg := config.Configuration{AccountTypes: map[string]*config.AccountType{"checking": accountType}}
+
+		_, err := RulesFromConfig(cfg)
+		assert.Error(t, err)
+	}
+}
+
+func TestRules_CheckWithdrawal(t *testing.T) {
+	rules := DefaultRules()
+	checking := &database.Account{AccountType: "checking"}
+	unknown := &database.Account{AccountType: "brokerage"}
+
+	assert.NoError(t, rules.CheckWithdrawal(checking, database.NewMoney(-50000, "USD")))
+	assert.ErrorIs(t, rules.CheckWithdrawal(checking, database.NewMoney(-50001, "USD")), database.ErrInsufficientFunds)
+	assert.NoError(t, rules.CheckWithdrawal(unknown, database.NewMoney(0, "USD")))
+	assert.ErrorIs(t, rules.CheckWithdrawal(unknown, database.NewMoney(-1, "USD")), database.ErrInsufficientFunds)
+
+	// a limit in dollars says nothing about an account in yen
+	assert.ErrorIs(t, rules.CheckWithdrawal(checking, database.NewMoney(-100, "JPY")), database.ErrCurrencyMismatch)
+	assert.NoError(t, rules.CheckWithdrawal(unknown, database.NewMoney(0, "JPY")))
+}
+
+func TestRules_Currency(t *testing.T) {
+	cfg := config.Configuration{AccountTypes: map[string]*config.AccountType{
+		"checking": {},
+		"yen":      {Currency: "jpy", OverdraftLimit: "50000"},
+	}}
+
+	rules, err := RulesFromConfig(cfg)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "USD", rules.Currency("checking"))
+		assert.Equal(t, "JPY", rules.Currency("yen"))
+		assert.Equal(t, database.NewMoney(50000, "JPY"), rules.AccountTypes["yen"].OverdraftLimit)
+
+		assert.NoError(t, rules.CheckCurrency("yen", ""))
+		assert.NoError(t, rules.CheckCurrency("yen", "JPY"))
+		assert.ErrorIs(t, rules.CheckCurrency("yen", "USD"), database.ErrCurrencyMismatch)
+	}
+
+	// fees are in the account type's currency too
+	cfg.AccountTypes["yen"].MaintenanceFee = "500"
+	rules, err = RulesFromConfig(cfg)
+	if assert.NoError(t, err) {
+		assert.Equal(t, database.NewMoney(500, "JPY"), rules.AccountTypes["yen"].Fees.Maintenance)
+	}
+	cfg.AccountTypes["yen"].MaintenanceFee = "5.50"
+	_, err = RulesFromConfig(cfg)
+	assert.ErrorIs(t, err, database.ErrInvalidAmount)
+	cfg.AccountTypes["yen"].MaintenanceFee = ""
+
+	// amounts have the decimal places of the account type's currency
+	cfg.AccountTypes["yen"].OverdraftLimit = "0.50"
+	_, err = RulesFromConfig(cfg)
+	assert.ErrorIs(t, err, database.ErrInvalidAmount)
+
+	cfg.AccountTypes["yen"] = &config.AccountType{Currency: "XYZ"}
+	_, err = RulesFromConfig(cfg)
+	assert.ErrorIs(t, err, database.ErrInvalidCurrency)
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jobullo/go-api-example/database"
+)
+
+// DefaultRefreshTokenLifetime is how long a refresh token can be used when the configuration doesn't say
+const DefaultRefreshTokenLifetime = 7 * 24 * time.Hour
+
+type TokenService struct {
+	db       *gorm.DB
+	lifetime time.Duration
+}
+
+// create a new token service, refresh tokens it issues expire after lifetime
+func NewTokenService(db *gorm.DB, lifetime time.Duration) *TokenService {
+	if lifetime <= 0 {
+		lifetime = DefaultRefreshTokenLifetime
+	}
+	return &TokenService{db: db, lifetime: lifetime}
+}
+
+// Issue creates a refresh token for a new login and returns it, it is only ever stored hashed
+func (ts *TokenService) Issue(userID uint) (string, error) {
+	familyID, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	return ts.issue(ts.db, userID, familyID)
+}
+
+// Rotate exchanges a refresh token for a new one and returns the user it belongs to.
+// Presenting a token that was already exchanged or revoked revokes every token issued since the login
+// and returns database.ErrTokenReused, since either the client or an attacker holds a stolen copy.
+func (ts *TokenService) Rotate(refreshToken string) (uint, string, error) {
+	var userID uint
+	var next string
+	reused := false
+
+	//inline function to pass to db.Transaction
+	performRotate := func(db *gorm.DB) error {
+		//lock the token so two concurrent refreshes can't both succeed
+		var token database.RefreshToken
+		result := database.ForUpdate(db).
+			Where("token_hash = ?", hashToken(refreshToken)).
+			First(&token)
+		if result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				return database.ErrInvalidToken
+			}
+			return result.Error
+		}
+
+		//the revocation has to be committed, so this isn't returned as an error
+		if token.UsedAt != nil || token.RevokedAt != nil {
+			reused = true
+			return revokeFamily(db, token.FamilyID)
+		}
+
+		now := time.Now()
+		if !token.ExpiresAt.After(now) {
+			return database.ErrInvalidToken
+		}
+
+		if err := db.Model(&token).Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		var err error
+		userID = token.UserID
+		next, err = ts.issue(db, token.UserID, token.FamilyID)
+		return err
+	}
+
+	if err := ts.db.Transaction(performRotate); err != nil {
+		return 0, "", err
+	}
+	if reused {
+		return 0, "", database.ErrTokenReused
+	}
+
+	return userID, next, nil
+}
+
+// Revoke revokes a refresh token along with every other token issued since the same login
+func (ts *TokenService) Revoke(refreshToken string) error {
+	var token database.RefreshToken
+	if result := ts.db.Where("token_hash = ?", hashToken(refreshToken)).First(&token); result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return database.ErrInvalidToken
+		}
+		return result.Error
+	}
+
+	return revokeFamily(ts.db, token.FamilyID)
+}
+
+// RevokeAccessToken adds an access token to the revocation list until it expires
+func (ts *TokenService) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	//entries for tokens that have expired anyway are no longer needed
+	if err := ts.db.Where("expires_at <= ?", time.Now()).Delete(&database.RevokedToken{}).Error; err != nil {
+		return err
+	}
+
+	var revoked database.RevokedToken
+	return ts.db.Where(database.RevokedToken{JTI: jti}).
+		Attrs(database.RevokedToken{ExpiresAt: expiresAt}).
+		FirstOrCreate(&revoked).Error
+}
+
+// IsRevoked reports whether the access token with the given jti is on the revocation list
+func (ts *TokenService) IsRevoked(jti string) (bool, error) {
+	var count int
+	if err := ts.db.Model(&database.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (ts *TokenService) issue(db *gorm.DB, userID uint, familyID string) (string, error) {
+	raw, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	token := database.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(raw),
+		ExpiresAt: time.Now().Add(ts.lifetime),
+	}
+	if result := db.Create(&token); result.Error != nil {
+		return "", result.Error
+	}
+
+	return raw, nil
+}
+
+func revokeFamily(db *gorm.DB, familyID string) error {
+	return db.Model(&database.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// tokens are looked up by hash so a leaked table can't be used to refresh
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func randomToken() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jobullo/go-api-example/database"
+	"github.com/jobullo/go-api-example/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// A new test suite is created by embedding
+// the suite.Suite struct.
+type TokenServiceSuite struct {
+	suite.Suite
+	assert  *assert.Assertions
+	sqlmock sqlmock.Sqlmock
+	service *TokenService
+}
+
+// Invoke this function to run the test suite with "go test" at the CLI
+func TestTokenServiceSuite(t *testing.T) {
+	suite.Run(t, new(TokenServiceSuite))
+}
+
+func (ts *TokenServiceSuite) SetupTest() {
+	t := ts.T()
+
+	db, sql, err := mock.DB()
+	require.NoError(t, err)
+
+	ts.assert = assert.New(t)
+	ts.sqlmock = sql
+	ts.service = NewTokenService(db, time.Hour)
+}
+
+func (ts *TokenServiceSuite) TestIssue_StoresHash() {
+	var stored string
+	ts.sqlmock.ExpectBegin()
+	ts.sqlmock.ExpectQuery(`^INSERT INTO "refresh_tokens"`).
+		WithArgs(mock.Any{}, mock.Any{}, mock.Any{}, 7, mock.Any{}, mock.CaptureString{Value: &stored}, mock.Any{}, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	ts.sqlmock.ExpectCommit()
+
+	token, err := ts.service.Issue(7)
+
+	if ts.assert.NoError(err) {
+		ts.assert.NoError(ts.sqlmock.ExpectationsWereMet())
+		ts.assert.NotEmpty(token)
+		ts.assert.Equal(hashToken(token), stored)
+	}
+}
+
+func (ts *TokenServiceSuite) TestRotate() {
+	ts.sqlmock.ExpectBegin()
+	ts.expectTokenSelect("old", time.Now().Add(time.Hour), nil, nil)
+	ts.sqlmock.ExpectExec(`^UPDATE "refresh_tokens" SET "updated_at" = \$1, "used_at" = \$2`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	ts.sqlmock.ExpectQuery(`^INSERT INTO "refresh_tokens"`).
+		WithArgs(mock.Any{}, mock.Any{}, mock.Any{}, 7, "family", mock.Any{}, mock.Any{}, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	ts.sqlmock.ExpectCommit()
+
+	userID, next, err := ts.service.Rotate("old")
+
+	if ts.assert.NoError(err) {
+		ts.assert.NoError(ts.sqlmock.ExpectationsWereMet())
+		ts.assert.Equal(uint(7), userID)
+		ts.assert.NotEqual("old", next)
+	}
+}
+
+func (ts *TokenServiceSuite) TestRotate_ReuseRevokesFamily() {
+	// the token was exchanged before, so the revocation of the family is committed and the caller is rejected
+	used := time.Now().Add(-time.Minute)
+	ts.sqlmock.ExpectBegin()
+	ts.expectTokenSelect("old", time.Now().Add(time.Hour), &used, nil)
+	ts.sqlmock.ExpectExec(`^UPDATE "refresh_tokens" SET "revoked_at" = \$1, "updated_at" = \$2 WHERE (.+)family_id = \$3 AND revoked_at IS NULL`).
+		WithArgs(mock.Any{}, mock.Any{}, "family").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	ts.sqlmock.ExpectCommit()
+
+	_, _, err := ts.service.Rotate("old")
+
+	ts.assert.ErrorIs(err, database.ErrTokenReused)
+	ts.assert.NoError(ts.sqlmock.ExpectationsWereMet())
+}
+
+func (ts *TokenServiceSuite) TestRotate_Expired() {
+	ts.sqlmock.ExpectBegin()
+	ts.expectTokenSelect("old", time.Now().Add(-time.Minute), nil, nil)
+	ts.sqlmock.ExpectRollback()
+
+	_, _, err := ts.service.Rotate("old")
+
+	ts.assert.ErrorIs(err, database.ErrInvalidToken)
+	ts.assert.NoError(ts.sqlmock.ExpectationsWereMet())
+}
+
+func (ts *TokenServiceSuite) TestRotate_Unknown() {
+	ts.sqlmock.ExpectBegin()
+	ts.sqlmock.ExpectQuery(`^SELECT (.+) FROM "refresh_tokens"`).
+		WillReturnRows(ts.newRows())
+	ts.sqlmock.ExpectRollback()
+
+	_, _, err := ts.service.Rotate("nope")
+
+	ts.assert.ErrorIs(err, database.ErrInvalidToken)
+	ts.assert.NoError(ts.sqlmock.ExpectationsWereMet())
+}
+
+func (ts *TokenServiceSuite) TestIsRevoked() {
+	ts.sqlmock.ExpectQuery(`^SELECT count\(\*\) FROM "revoked_tokens"`).
+		WithArgs("abc").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	revoked, err := ts.service.IsRevoked("abc")
+
+	if ts.assert.NoError(err) {
+		ts.assert.True(revoked)
+		ts.assert.NoError(ts.sqlmock.ExpectationsWereMet())
+	}
+}
+
+// sets the expectation that the refresh token is looked up by its hash and locked
+func (ts *TokenServiceSuite) expectTokenSelect(token string, expiresAt time.Time, usedAt *time.Time, revokedAt *time.Time) {
+	ts.sqlmock.ExpectQuery(`^SELECT (.+) FROM "refresh_tokens" (.+) FOR UPDATE$`).
+		WithArgs(hashToken(token)).
+		WillReturnRows(ts.newRows().
+			AddRow(1, time.Now(), time.Now(), nil, 7, "family", hashToken(token), expiresAt, usedAt, revokedAt))
+}
+
+// creates the rows object for the "refresh_tokens" table
+func (ts *TokenServiceSuite) newRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "user_id", "family_id", "token_hash", "expires_at", "used_at", "revoked_at"})
+}
@@ -2,149 +2,629 @@ package service
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
-	"github.com/jinzhu/gorm"
 	"github.com/jobullo/go-api-example/database"
+	"github.com/jobullo/go-api-example/ledger"
 )
 
 type TransactionService struct {
-	db             *gorm.DB
-	accountService AccountService //dependency injection - keep balance in sync
+	store database.Store //transactions are posted along with the balance of their account and the ledger
+	rules Rules          //overdraft limits by account type
 }
 
-func NewTransactionService(db *gorm.DB, accountService AccountService) *TransactionService {
+var _ database.TransactionService = (*TransactionService)(nil)
+
+func NewTransactionService(store database.Store, rules Rules) *TransactionService {
 	return &TransactionService{
-		db:             db,
-		accountService: accountService,
+		store: store,
+		rules: rules,
 	}
 }
 
 // implements the create a new transaction method of the transaction service interface
 func (ts *TransactionService) Create(transaction *database.Transaction) error {
-	//check that the account exists
-	account, err := ts.accountService.FetchById(transaction.AccountID)
-	if err != nil {
-		if errors.Is(err, database.ErrNotFound) {
-			return database.ErrParentNotFound
-		}
-		return err
+	return ts.create(transaction, true)
+}
+
+// create posts a transaction and, when chargeFees is set, the fees it triggers. Imports replay history
+// from another system, which already charged whatever fees applied.
+func (ts *TransactionService) create(transaction *database.Transaction, chargeFees bool) error {
+	if !transaction.Amount.IsPositive() {
+		return database.ErrInvalidAmount
 	}
 
-	//populate account before insertion into DB
-	transaction.Account = account
+	var account *database.Account
 
-	//inline function to handle to pass to db.Transaction
-	performTransaction := func(db *gorm.DB) error {
-		//create from provided transaction struct object
-		if result := ts.db.Create(&transaction); result.Error != nil {
-			return result.Error
+	//inline function to handle to pass to store.Atomic
+	performTransaction := func(store database.Store) error {
+		accountService := NewAccountService(store)
+
+		//check that the account exists and lock it so concurrent postings are applied one at a time
+		var err error
+		if account, err = accountService.FetchForUpdate(transaction.AccountID); err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				return database.ErrParentNotFound
+			}
+			return err
+		}
+		if !account.IsOpen() {
+			return fmt.Errorf("%w: account %d is %s", database.ErrAccountNotOpen, account.ID, account.Status)
 		}
 
+		//an amount without a currency is in the account's currency
+		given, err := transaction.Amount.In(account.Balance.Currency)
+		if err != nil {
+			return err
+		}
+		transaction.Amount = given.Normalized()
+
 		//compute the new account balance
-		if transaction.Type == "deposit" {
-			account.Balance += transaction.Amount
-		} else if transaction.Type == "withdrawal" {
-			account.Balance -= transaction.Amount
-		} else {
-			return database.ErrInvalidType
+		amount, err := signedAmount(transaction)
+		if err != nil {
+			return err
 		}
+		balance, err := ts.adjustedBalance(account, amount)
+		if err != nil {
+			return err
+		}
+
+		//write through the double-entry ledger first so the transaction can link to its journal entry
+		entry, err := ledger.EntryFor(transaction)
+		if err != nil {
+			return err
+		}
+		if err := ledger.New(store.Journal()).Post(&entry); err != nil {
+			return err
+		}
+		transaction.JournalEntryID = &entry.ID
 
-		//now update the account
-		if err := ts.accountService.Update(account); err != nil {
+		//create from provided transaction struct object
+		if err := store.Transactions().Create(transaction); err != nil {
 			return err
 		}
 
-		return nil
+		//now update the account within the same database transaction
+		account.Balance = balance
+
+		//the fees the transaction triggers are transactions of their own, linked back to it
+		var fees []database.Money
+		if chargeFees {
+			if fees, err = ts.rules.AccountTypes[account.AccountType].Fees.transactionFees(transaction, balance); err != nil {
+				return err
+			}
+		}
+		for _, amount := range fees {
+			fee, err := postFee(store, account, amount, &transaction.ID)
+			if err != nil {
+				return err
+			}
+			transaction.Fees = append(transaction.Fees, *fee)
+		}
+
+		return accountService.updateBalance(account)
 	}
 
-	//will roll back the transaction if an error is returned by accountTransaction
-	if err := ts.accountService.db.Transaction(performTransaction); err != nil {
+	//will roll back the transaction if an error is returned by performTransaction
+	if err := ts.store.Atomic(performTransaction); err != nil {
 		return err
 	}
 
+	//populate account for the caller
+	transaction.Account = account
+
 	return nil
 }
 
 // implements the FetchByID method of the transaction service interface
-func (ts *TransactionService) FetchById(id int) (*database.Transaction, error) {
-	var transaction database.Transaction
-	if result := ts.db.First(&transaction, id); result.Error != nil {
-
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, database.ErrNotFound
-		}
-
-		return nil, result.Error
-	}
-
-	return &transaction, nil
+func (ts *TransactionService) FetchById(id uint) (*database.Transaction, error) {
+	return ts.store.Transactions().FetchById(id)
 }
 
 // implements the List method of the transaction service interface
 func (ts *TransactionService) List() (*[]database.Transaction, error) {
-	return ts.list(nil)
+	return ts.store.Transactions().List()
 }
 
-// private of the transaction service
-func (ts *TransactionService) list(criteria *database.Transaction) (*[]database.Transaction, error) {
+// ListPage lists one page of the transactions that match the filter
+func (ts *TransactionService) ListPage(filter database.TransactionFilter, page database.PageRequest) (*database.Page[database.Transaction], error) {
+	return ts.store.Transactions().ListPage(filter, page)
+}
 
-	db := ts.db.Preload("Account") //preloads the account object
+func (ts *TransactionService) ListByAccount(accountID uint) (*[]database.Transaction, error) {
+	return ts.store.Transactions().ListByAccount(accountID)
+}
 
-	if criteria != nil {
-		db = db.Where(criteria)
+// Statement lists the transactions on an account from the start of the period up to, but not including, its end,
+// with the balance after each one. Balances are worked back from the account's balance, so the amount an account
+// was opened with counts toward the opening balance of the period it was opened in.
+func (ts *TransactionService) Statement(accountID uint, from time.Time, to time.Time) (*database.Statement, error) {
+	if !to.After(from) {
+		return nil, database.ErrInvalidPeriod
 	}
 
-	var result []database.Transaction
+	var account *database.Account
+	var transactions []database.Transaction
 
-	if resp := db.Find(&result); resp.Error != nil {
-		return nil, resp.Error
+	//the balance and the transactions are read from one snapshot, so no posting lands between the two reads
+	readStatement := func(store database.Store) error {
+		var err error
+		if account, err = store.Accounts().FetchById(accountID); err != nil {
+			return err
+		}
+
+		//everything from the start of the period until now, the later transactions are backed out of the current balance
+		transactions, err = store.Transactions().Since(accountID, from)
+		return err
 	}
 
-	return &result, nil
-}
+	if err := ts.store.Snapshot(readStatement); err != nil {
+		return nil, err
+	}
 
-func (ts *TransactionService) ListByAccount(accountID uint) (*[]database.Transaction, error) {
-	criteria := database.Transaction{AccountID: accountID}
-	return ts.list(&criteria)
+	var err error
+	current := account.Balance
+
+	//back the transactions after the period out of the current balance to get the closing balance
+	closing := current
+	var lines []database.StatementLine
+	for i := range transactions {
+		amount, err := signedAmount(&transactions[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if transactions[i].CreatedAt.Before(to) {
+			lines = append(lines, database.StatementLine{Transaction: transactions[i], RunningBalance: amount})
+		} else if closing, err = closing.Sub(amount); err != nil {
+			return nil, err
+		}
+	}
+
+	//then back the period out of the closing balance to get the opening balance
+	opening := closing
+	for i := len(lines) - 1; i >= 0; i-- {
+		if opening, err = opening.Sub(lines[i].RunningBalance); err != nil {
+			return nil, err
+		}
+	}
+
+	//and replace each amount with the balance after it
+	running := opening
+	for i := range lines {
+		if running, err = running.Add(lines[i].RunningBalance); err != nil {
+			return nil, err
+		}
+		lines[i].RunningBalance = running
+	}
+
+	statement := &database.Statement{
+		AccountID:      accountID,
+		Account:        account,
+		From:           from,
+		To:             to,
+		OpeningBalance: opening,
+		Lines:          lines,
+		ClosingBalance: closing,
+	}
+	if statement.Lines == nil {
+		statement.Lines = []database.StatementLine{}
+	}
+
+	return statement, nil
 }
 
-// implements the Update method of the transaction service interface
+// implements the Update method of the transaction service interface,
+// the account balance is adjusted by the difference in the same database transaction
 func (ts *TransactionService) Update(transaction *database.Transaction) error {
+	if ts.rules.Immutable {
+		return database.ErrImmutable
+	}
+
+	if !transaction.Amount.IsPositive() {
+		return database.ErrInvalidAmount
+	}
+
 	var t database.Transaction
 
-	//TODO: validate transaction amount
-	// - can't be <= 0 (transaction type determines if it subtracts or adds to the account balance)
-	// - for a withdrawal, the amount can't be greater than the account balance
+	//inline function to pass to store.Atomic
+	performUpdate := func(store database.Store) error {
+		stored, err := store.Transactions().FetchById(transaction.Model.ID)
+		if err != nil {
+			return err
+		}
+		t = *stored
+		if err := checkNotTransferLeg(&t); err != nil {
+			return err
+		}
+
+		//an amount without a currency is in the currency it was posted in
+		given, err := transaction.Amount.In(t.Amount.Currency)
+		if err != nil {
+			return err
+		}
+		transaction.Amount = given
+		if transaction.Amount.Normalized().Currency != t.Amount.Normalized().Currency {
+			return database.ErrCurrencyMismatch
+		}
+
+		accountService := NewAccountService(store)
+		account, err := accountService.FetchForUpdate(t.AccountID)
+		if err != nil {
+			return err
+		}
+		if err := checkNotClosed(account); err != nil {
+			return err
+		}
+		if err := checkNotReversal(store, &t); err != nil {
+			return err
+		}
+
+		//back out the old amount and apply the new one
+		oldAmount, err := signedAmount(&t)
+		if err != nil {
+			return err
+		}
+		oldEntry, err := ledger.EntryFor(&t)
+		if err != nil {
+			return err
+		}
+		t.Amount = transaction.Amount.Normalized()
+		newAmount, err := signedAmount(&t)
+		if err != nil {
+			return err
+		}
+		newEntry, err := ledger.EntryFor(&t)
+		if err != nil {
+			return err
+		}
+		difference, err := newAmount.Sub(oldAmount)
+		if err != nil {
+			return err
+		}
+		balance, err := ts.adjustedBalance(account, difference)
+		if err != nil {
+			return err
+		}
+
+		//the ledger is append-only, the correction is posted as a new entry
+		correction := ledger.Reversed(oldEntry, fmt.Sprintf("correction of transaction %d", t.ID))
+		correction.Postings = append(correction.Postings, newEntry.Postings...)
+		if err := ledger.New(store.Journal()).Post(&correction); err != nil {
+			return err
+		}
 
-	if resp := ts.db.First(&t, transaction.Model.ID); resp.Error != nil {
+		if err := store.Transactions().Save(&t); err != nil {
+			return err
+		}
+		account.Balance = balance
 
-		if errors.Is(resp.Error, gorm.ErrRecordNotFound) {
-			return database.ErrNotFound
+		//the fees are charged again for the corrected transaction, as if it had been posted like this
+		if err := removeFees(store, account, t.ID, fmt.Sprintf("correction of transaction %d", t.ID)); err != nil {
+			return err
+		}
+		fees, err := ts.rules.AccountTypes[account.AccountType].Fees.transactionFees(&t, account.Balance)
+		if err != nil {
+			return err
+		}
+		for _, amount := range fees {
+			fee, err := postFee(store, account, amount, &t.ID)
+			if err != nil {
+				return err
+			}
+			t.Fees = append(t.Fees, *fee)
 		}
 
-		return resp.Error
+		return accountService.updateBalance(account)
 	}
 
-	t.Amount = transaction.Amount
-	if resp := ts.db.Save(&t); resp.Error != nil {
-		return resp.Error
+	//will roll back the transaction if an error is returned by performUpdate
+	if err := ts.store.Atomic(performUpdate); err != nil {
+		return err
 	}
 
 	transaction.Type = t.Type
+	transaction.Amount = t.Amount
 	transaction.AccountID = t.AccountID
+	transaction.Fees = t.Fees
 	transaction.Model.CreatedAt = t.Model.CreatedAt
 	transaction.Model.UpdatedAt = t.Model.UpdatedAt
 
 	return nil
 }
 
-// implements the delete method of the transaction service interface
+// implements the delete method of the transaction service interface,
+// the transaction's amount is backed out of the account balance in the same database transaction
 func (ts *TransactionService) Delete(id uint) error {
-	var transaction database.Transaction
+	if ts.rules.Immutable {
+		return database.ErrImmutable
+	}
+
+	//inline function to pass to store.Atomic
+	performDelete := func(store database.Store) error {
+		transaction, err := store.Transactions().FetchById(id)
+		if err != nil {
+			return err
+		}
+		if err := checkNotTransferLeg(transaction); err != nil {
+			return err
+		}
+
+		accountService := NewAccountService(store)
+		account, err := accountService.FetchForUpdate(transaction.AccountID)
+		if err != nil {
+			return err
+		}
+		if err := checkNotClosed(account); err != nil {
+			return err
+		}
+		if err := checkNotReversal(store, transaction); err != nil {
+			return err
+		}
+
+		amount, err := signedAmount(transaction)
+		if err != nil {
+			return err
+		}
+		balance, err := ts.adjustedBalance(account, amount.Neg())
+		if err != nil {
+			return err
+		}
+
+		//the ledger is append-only, the deleted transaction's entry is reversed
+		entry, err := ledger.EntryFor(transaction)
+		if err != nil {
+			return err
+		}
+		reversal := ledger.Reversed(entry, fmt.Sprintf("deletion of transaction %d", transaction.ID))
+		if err := ledger.New(store.Journal()).Post(&reversal); err != nil {
+			return err
+		}
+
+		if err := store.Transactions().Delete(transaction); err != nil {
+			return err
+		}
+		account.Balance = balance
+
+		//the fees it triggered go with it
+		if err := removeFees(store, account, transaction.ID, fmt.Sprintf("deletion of transaction %d", transaction.ID)); err != nil {
+			return err
+		}
+
+		return accountService.updateBalance(account)
+	}
 
-	if result := ts.db.First(&transaction, id); errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		return database.ErrNotFound
+	//will roll back the transaction if an error is returned by performDelete
+	return ts.store.Atomic(performDelete)
+}
+
+// Reverse posts a transaction that cancels out the given one and links it back to the original.
+// Reversals are corrections so they always post, even when they take the balance past the overdraft limit.
+func (ts *TransactionService) Reverse(id uint) (*database.Transaction, error) {
+	var reversal database.Transaction
+	var account *database.Account
+
+	//inline function to pass to store.Atomic
+	performReversal := func(store database.Store) error {
+		original, err := store.Transactions().FetchById(id)
+		if err != nil {
+			return err
+		}
+
+		if original.ReversalOfID != nil {
+			return database.ErrReversal
+		}
+
+		if reversal, err = reversalOf(original); err != nil {
+			return err
+		}
+
+		//the account lock is taken before checking for an earlier reversal, so two reversals of the same
+		//transaction can't both find none
+		accountService := NewAccountService(store)
+		if account, err = accountService.FetchForUpdate(original.AccountID); err != nil {
+			return err
+		}
+		if err := checkNotClosed(account); err != nil {
+			return err
+		}
+		if err := checkNotReversed(store, original.ID); err != nil {
+			return err
+		}
+
+		if err := postReversal(store, account, original, &reversal); err != nil {
+			return err
+		}
+
+		//the fees the original triggered are refunded with it, unless they were already reversed on their own
+		fees, err := store.Transactions().Fees(original.ID)
+		if err != nil {
+			return err
+		}
+		for i := range fees {
+			if err := checkNotReversed(store, fees[i].ID); errors.Is(err, database.ErrAlreadyReversed) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			refund, err := reversalOf(&fees[i])
+			if err != nil {
+				return err
+			}
+			if err := postReversal(store, account, &fees[i], &refund); err != nil {
+				return err
+			}
+			reversal.Fees = append(reversal.Fees, refund)
+		}
+
+		return accountService.updateBalance(account)
+	}
+
+	//will roll back the transaction if an error is returned by performReversal
+	if err := ts.store.Atomic(performReversal); err != nil {
+		return nil, err
+	}
+
+	//populate account for the caller
+	reversal.Account = account
+
+	return &reversal, nil
+}
+
+// reversalOf returns the transaction that cancels out original, it isn't posted yet
+func reversalOf(original *database.Transaction) (database.Transaction, error) {
+	reversal := database.Transaction{
+		AccountID:    original.AccountID,
+		Amount:       original.Amount,
+		ReversalOfID: &original.ID,
+	}
+	switch original.Type {
+	case "deposit", "interest":
+		reversal.Type = "withdrawal"
+	case "withdrawal", "fee":
+		reversal.Type = "deposit"
+	default:
+		return database.Transaction{}, database.ErrInvalidType
+	}
+	return reversal, nil
+}
+
+// postReversal posts the reversal of original to an account that is locked by the atomic store.
+// The new balance is only set on account, the caller writes it.
+func postReversal(store database.Store, account *database.Account, original *database.Transaction, reversal *database.Transaction) error {
+	amount, err := signedAmount(reversal)
+	if err != nil {
+		return err
+	}
+	balance, err := account.Balance.Add(amount)
+	if err != nil {
+		return err
+	}
+
+	//flip the original journal entry so even transfer legs are reversed against the same accounts
+	originalEntry, err := ledger.EntryFor(original)
+	if err != nil {
+		return err
+	}
+	entry := ledger.Reversed(originalEntry, fmt.Sprintf("reversal of transaction %d", original.ID))
+	if err := ledger.New(store.Journal()).Post(&entry); err != nil {
+		return err
+	}
+	reversal.JournalEntryID = &entry.ID
+
+	if err := store.Transactions().Create(reversal); err != nil {
+		return err
+	}
+
+	account.Balance = balance
+	return nil
+}
+
+// removeFees backs the fees a transaction triggered out of an account that is locked by the atomic store,
+// when the transaction is changed or deleted. A fee that was reversed is final, so then the transaction is too.
+// The new balance is only set on account, the caller writes it.
+func removeFees(store database.Store, account *database.Account, id uint, description string) error {
+	fees, err := store.Transactions().Fees(id)
+	if err != nil {
+		return err
+	}
+
+	for i := range fees {
+		if err := checkNotReversed(store, fees[i].ID); errors.Is(err, database.ErrAlreadyReversed) {
+			return fmt.Errorf("%w: fee %d of transaction %d was reversed", database.ErrReversalFinal, fees[i].ID, id)
+		} else if err != nil {
+			return err
+		}
+
+		balance, err := account.Balance.Add(fees[i].Amount)
+		if err != nil {
+			return err
+		}
+
+		//the ledger is append-only, the fee's entry is reversed
+		entry, err := ledger.EntryFor(&fees[i])
+		if err != nil {
+			return err
+		}
+		reversal := ledger.Reversed(entry, description)
+		if err := ledger.New(store.Journal()).Post(&reversal); err != nil {
+			return err
+		}
+
+		if err := store.Transactions().Delete(&fees[i]); err != nil {
+			return err
+		}
+		account.Balance = balance
+	}
+
+	return nil
+}
+
+// checkNotClosed lets corrections through to frozen and dormant accounts, but a closed account's
+// balance has to stay at the zero it was closed with
+func checkNotClosed(account *database.Account) error {
+	if account.Status == database.AccountClosed {
+		return fmt.Errorf("%w: account %d is closed", database.ErrAccountNotOpen, account.ID)
+	}
+	return nil
+}
+
+// checkNotReversed makes sure a transaction can only be reversed once, the caller holds the account lock
+func checkNotReversed(store database.Store, id uint) error {
+	reversed, err := store.Transactions().IsReversed(id)
+	if err != nil {
+		return err
+	}
+	if reversed {
+		return database.ErrAlreadyReversed
+	}
+	return nil
+}
+
+// checkNotReversal keeps a reversal and the transaction it cancels out mirror images of each other,
+// neither can be changed or deleted once the reversal is posted
+func checkNotReversal(store database.Store, transaction *database.Transaction) error {
+	if transaction.ReversalOfID != nil {
+		return database.ErrReversalFinal
+	}
+	return checkNotReversed(store, transaction.ID)
+}
+
+// checkNotTransferLeg keeps the two legs of a transfer and its amount in agreement, and the transfer clearing
+// account balanced, by not letting one leg change without the other
+func checkNotTransferLeg(transaction *database.Transaction) error {
+	if transaction.TransferID != nil {
+		return fmt.Errorf("%w: transaction %d is part of transfer %d", database.ErrTransferLeg, transaction.ID, *transaction.TransferID)
+	}
+	return nil
+}
+
+// signedAmount returns what a transaction adds to its account's balance, withdrawals are negative
+func signedAmount(transaction *database.Transaction) (database.Money, error) {
+	switch transaction.Type {
+	case "deposit", "interest":
+		return transaction.Amount, nil
+	case "withdrawal", "fee":
+		return transaction.Amount.Neg(), nil
+	default:
+		return database.Money{}, database.ErrInvalidType
+	}
+}
+
+// adjustedBalance returns the account balance after adding amount,
+// the overdraft rules are enforced whenever the balance goes down
+func (ts *TransactionService) adjustedBalance(account *database.Account, amount database.Money) (database.Money, error) {
+	balance, err := account.Balance.Add(amount)
+	if err != nil {
+		return database.Money{}, err
+	}
+
+	if amount.IsNegative() {
+		if err := ts.rules.CheckWithdrawal(account, balance); err != nil {
+			return database.Money{}, err
+		}
 	}
 
-	return ts.db.Delete(&transaction, id).Error
+	return balance, nil
 }
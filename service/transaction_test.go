@@ -4,11 +4,10 @@ import (
 	"testing"
 	"time"
 
-	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/jobullo/go-api-example/database"
+	"github.com/jobullo/go-api-example/ledger"
 	"github.com/jobullo/go-api-example/mock"
 	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -17,10 +16,9 @@ import (
 type TransactionServiceSuite struct {
 	suite.Suite
 	assert       *assert.Assertions
-	sqlmock      sqlmock.Sqlmock
+	store        database.Store
 	transService *TransactionService
 	acctService  *AccountService
-	account      database.Account
 }
 
 // Invoke this function to run the test suite with "go test" at the CLI
@@ -31,72 +29,492 @@ func TestTransactionServiceSuite(t *testing.T) {
 func (ts *TransactionServiceSuite) SetupTest() {
 	t := ts.T() //gets the current *testing.T context, used by testing framework to manage test state
 
-	db, sql, err := mock.DB() //mock.DB() returns a gorm.DB and sqlmock.Sqlmock
-	require.NoError(t, err)   //use require from testify to stop test if error is not nil
-
 	ts.assert = assert.New(t) //create a new assert.Assertions object for use in tests
-	ts.sqlmock = sql          // sql mock used to create expectations
-	ts.acctService = NewAccountService(db)
-	ts.transService = NewTransactionService(db, *ts.acctService)
-
-	ts.account = database.Account{
-		AccountHolder: "Foo Bar",
-		AccountType:   "checking",
-		Balance:       100,
-	}
-
-	// Set expectations on the mock to insert a new row into the accounts table
-	ts.sqlmock.ExpectBegin()
-	ts.sqlmock.ExpectExec("^INSERT INTO `accounts`").
-		WithArgs(ts.account.AccountHolder, ts.account.AccountType, ts.account.Balance).
-		WillReturnResult(sqlmock.NewResult(1, 1))
-	ts.sqlmock.ExpectCommit()
+	ts.store = database.NewMemoryStore()
+	ts.acctService = NewAccountService(ts.store)
+	ts.transService = NewTransactionService(ts.store, DefaultRules())
 }
 
-func (ts *TransactionServiceSuite) TestCreate_ExecutesInsert() {
-	// Set expectations on the mock for a SELECT query on the accounts table
-	ts.sqlmock.ExpectBegin()
-	ts.sqlmock.ExpectQuery("^SELECT (.+) FROM `accounts` WHERE `accounts`.`deleted_at` IS NULL AND \\(\\(`accounts`.`id` = \\?\\)\\) ORDER BY `accounts`.`id` ASC LIMIT 1").
-		WithArgs(1).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "account_holder", "account_type", "balance"}).
-			AddRow(1, time.Now(), time.Now(), nil, ts.account.AccountHolder, ts.account.AccountType, ts.account.Balance))
-	ts.sqlmock.ExpectCommit()
+func (ts *TransactionServiceSuite) TearDownTest() {
+	// whatever a test did, the ledger has to stay balanced
+	ts.assert.NoError(ledger.New(ts.store.Journal()).Verify())
+}
 
+func (ts *TransactionServiceSuite) TestCreate() {
+	account := ts.newAccount("checking", 10000)
 	// Create a new transaction object pointer
 	transaction := &database.Transaction{
-		AccountID: 1,
+		AccountID: account.ID,
 		Type:      "deposit",
-		Amount:    100,
-		Account:   &ts.account,
+		Amount:    database.NewMoney(10000, "USD"),
 	}
 
-	// Set expectations on the mock for an INSERT query on the transactions table
-	ts.sqlmock.ExpectBegin()
-	ts.sqlmock.ExpectExec("^INSERT INTO `transactions`").
-		WithArgs(mock.Any{}, mock.Any{}, mock.Any{}, mock.Any{}, 1, mock.Any{}, "deposit", 100).
-		WillReturnResult(sqlmock.NewResult(1, 1))
-	ts.sqlmock.ExpectCommit()
-
 	// Call the method under test
 	err := ts.transService.Create(transaction)
 
 	// Check the result
+	if ts.assert.NoError(err) {
+		ts.assert.NotZero(transaction.ID)
+		ts.assert.NotNil(transaction.JournalEntryID)
+		ts.assert.Equal(database.NewMoney(20000, "USD"), transaction.Account.Balance)
+		ts.assertBalance(account.ID, 20000)
+		ts.assert.Equal(uint(2), ts.version(account.ID))
+	}
+}
+
+func (ts *TransactionServiceSuite) TestCreate_Conflict() {
+	// the account changed between the read and the write, so the balance update is rejected
+	account := ts.newAccount("checking", 10000)
+	ts.transService.store = failingStore{Store: ts.store, err: database.ErrConflict}
+
+	err := ts.transService.Create(&database.Transaction{
+		AccountID: account.ID,
+		Type:      "deposit",
+		Amount:    database.NewMoney(10000, "USD"),
+	})
+
+	// the transaction and its journal entry are rolled back with the balance
+	ts.assert.ErrorIs(err, database.ErrConflict)
+	ts.assertBalance(account.ID, 10000)
+	ts.assertTransactions(account.ID, 0)
+}
+
+func (ts *TransactionServiceSuite) TestCreate_InvalidAmount() {
+	account := ts.newAccount("checking", 10000)
+
+	// a zero amount is rejected before touching the store
+	err := ts.transService.Create(&database.Transaction{AccountID: account.ID, Type: "deposit"})
+
+	ts.assert.ErrorIs(err, database.ErrInvalidAmount)
+	ts.assertTransactions(account.ID, 0)
+}
+
+func (ts *TransactionServiceSuite) TestCreate_AccountNotFound() {
+	err := ts.transService.Create(&database.Transaction{
+		AccountID: mock.ID(),
+		Type:      "deposit",
+		Amount:    database.NewMoney(10000, "USD"),
+	})
+
+	ts.assert.ErrorIs(err, database.ErrParentNotFound)
+}
+
+func (ts *TransactionServiceSuite) TestCreate_CurrencyMismatch() {
+	account := ts.newAccount("checking", 10000)
+
+	err := ts.transService.Create(&database.Transaction{
+		AccountID: account.ID,
+		Type:      "deposit",
+		Amount:    database.NewMoney(10000, "EUR"),
+	})
+
+	ts.assert.ErrorIs(err, database.ErrCurrencyMismatch)
+	ts.assertBalance(account.ID, 10000)
+}
+
+func (ts *TransactionServiceSuite) TestCreate_WithdrawalWithinOverdraftLimit() {
+	// checking accounts may go 500.00 below zero
+	account := ts.newAccount("checking", 10000)
+
+	err := ts.transService.Create(&database.Transaction{
+		AccountID: account.ID,
+		Type:      "withdrawal",
+		Amount:    database.NewMoney(60000, "USD"),
+	})
+
 	ts.assert.NoError(err)
-	ts.assert.NoError(ts.sqlmock.ExpectationsWereMet())
-}
-
-//func (ts *TransactionServiceSuite) TestTransactionService_List() {
-//	//TODO - implement
-//}
-//
-//func (ts *TransactionServiceSuite) TestTransactionService_FetchById() {
-//	//TODO - implement
-//}
-//
-//func (ts *TransactionServiceSuite) TestTransactionService_Update() {
-//	//TODO - implement
-//}
-//
-//func (ts *TransactionServiceSuite) TestTransactionService_Delete() {
-//	//TODO - implement
-//}
+	ts.assertBalance(account.ID, -50000)
+}
+
+func (ts *TransactionServiceSuite) TestCreate_WithdrawalBeyondOverdraftLimit() {
+	account := ts.newAccount("checking", 10000)
+
+	// nothing is written when the withdrawal is rejected
+	err := ts.transService.Create(&database.Transaction{
+		AccountID: account.ID,
+		Type:      "withdrawal",
+		Amount:    database.NewMoney(60001, "USD"),
+	})
+
+	ts.assert.ErrorIs(err, database.ErrInsufficientFunds)
+	ts.assertBalance(account.ID, 10000)
+	ts.assertTransactions(account.ID, 0)
+}
+
+func (ts *TransactionServiceSuite) TestCreate_SavingsCannotGoNegative() {
+	account := ts.newAccount("savings", 10000)
+
+	err := ts.transService.Create(&database.Transaction{
+		AccountID: account.ID,
+		Type:      "withdrawal",
+		Amount:    database.NewMoney(10001, "USD"),
+	})
+
+	ts.assert.ErrorIs(err, database.ErrInsufficientFunds)
+	ts.assertBalance(account.ID, 10000)
+}
+
+func (ts *TransactionServiceSuite) TestCreate_AccountNotOpen() {
+	account := ts.newAccount("checking", 10000)
+	_, err := ts.acctService.Freeze(account.ID, 0)
+	ts.Require().NoError(err)
+
+	err = ts.transService.Create(&database.Transaction{
+		AccountID: account.ID,
+		Type:      "deposit",
+		Amount:    database.NewMoney(10000, "USD"),
+	})
+
+	ts.assert.ErrorIs(err, database.ErrAccountNotOpen)
+	ts.assertBalance(account.ID, 10000)
+}
+
+func (ts *TransactionServiceSuite) TestTransactionService_Update() {
+	// a 100.00 deposit is corrected to 150.00, so the balance goes from 200.00 to 250.00
+	account := ts.newAccount("checking", 10000)
+	id := ts.post(account.ID, "deposit", 10000)
+
+	transaction := &database.Transaction{Amount: database.NewMoney(15000, "")}
+	transaction.ID = id
+
+	err := ts.transService.Update(transaction)
+
+	if ts.assert.NoError(err) {
+		ts.assert.Equal("deposit", transaction.Type)
+		ts.assert.Equal(database.NewMoney(15000, "USD"), transaction.Amount)
+		ts.assertBalance(account.ID, 25000)
+	}
+}
+
+func (ts *TransactionServiceSuite) TestTransactionService_Update_Withdrawal() {
+	// a 20.00 withdrawal is corrected to 50.00, so the balance goes from 80.00 to 50.00
+	account := ts.newAccount("checking", 10000)
+	id := ts.post(account.ID, "withdrawal", 2000)
+
+	transaction := &database.Transaction{Amount: database.NewMoney(5000, "USD")}
+	transaction.ID = id
+
+	err := ts.transService.Update(transaction)
+
+	ts.assert.NoError(err)
+	ts.assertBalance(account.ID, 5000)
+}
+
+func (ts *TransactionServiceSuite) TestTransactionService_Update_RollsBackOverdraft() {
+	// raising a withdrawal on a savings account past its balance is rejected and nothing is written
+	account := ts.newAccount("savings", 10000)
+	id := ts.post(account.ID, "withdrawal", 2000)
+
+	transaction := &database.Transaction{Amount: database.NewMoney(10001, "USD")}
+	transaction.ID = id
+
+	err := ts.transService.Update(transaction)
+
+	ts.assert.ErrorIs(err, database.ErrInsufficientFunds)
+	ts.assertBalance(account.ID, 8000)
+	stored, err := ts.transService.FetchById(id)
+	if ts.assert.NoError(err) {
+		ts.assert.Equal(database.NewMoney(2000, "USD"), stored.Amount)
+	}
+}
+
+func (ts *TransactionServiceSuite) TestTransactionService_Update_NotFound() {
+	transaction := &database.Transaction{Amount: database.NewMoney(15000, "USD")}
+	transaction.ID = mock.ID()
+
+	err := ts.transService.Update(transaction)
+
+	ts.assert.ErrorIs(err, database.ErrNotFound)
+}
+
+func (ts *TransactionServiceSuite) TestTransactionService_Delete() {
+	// deleting a 30.00 deposit takes the balance from 130.00 to 100.00
+	account := ts.newAccount("checking", 10000)
+	id := ts.post(account.ID, "deposit", 3000)
+
+	err := ts.transService.Delete(id)
+
+	ts.assert.NoError(err)
+	ts.assertBalance(account.ID, 10000)
+	ts.assertTransactions(account.ID, 0)
+}
+
+func (ts *TransactionServiceSuite) TestTransactionService_Delete_Withdrawal() {
+	// deleting a 30.00 withdrawal gives the money back, 70.00 becomes 100.00
+	account := ts.newAccount("checking", 10000)
+	id := ts.post(account.ID, "withdrawal", 3000)
+
+	err := ts.transService.Delete(id)
+
+	ts.assert.NoError(err)
+	ts.assertBalance(account.ID, 10000)
+}
+
+func (ts *TransactionServiceSuite) TestTransactionService_Delete_RollsBackOnFailure() {
+	// the balance update fails, so the delete is rolled back with it
+	account := ts.newAccount("checking", 10000)
+	id := ts.post(account.ID, "deposit", 3000)
+	ts.transService.store = failingStore{Store: ts.store, err: mock.Error()}
+
+	err := ts.transService.Delete(id)
+
+	ts.assert.Error(err)
+	ts.assertBalance(account.ID, 13000)
+	ts.assertTransactions(account.ID, 1)
+}
+
+func (ts *TransactionServiceSuite) TestTransactionService_Delete_NotFound() {
+	err := ts.transService.Delete(mock.ID())
+
+	ts.assert.ErrorIs(err, database.ErrNotFound)
+}
+
+func (ts *TransactionServiceSuite) TestTransactionService_Immutable() {
+	// in immutable mode nothing is read or written, callers are pointed at reversals
+	account := ts.newAccount("checking", 10000)
+	id := ts.post(account.ID, "deposit", 3000)
+	rules := DefaultRules()
+	rules.Immutable = true
+	ts.transService.rules = rules
+
+	transaction := &database.Transaction{Amount: database.NewMoney(15000, "USD")}
+	transaction.ID = id
+
+	ts.assert.ErrorIs(ts.transService.Update(transaction), database.ErrImmutable)
+	ts.assert.ErrorIs(ts.transService.Delete(id), database.ErrImmutable)
+	ts.assertBalance(account.ID, 13000)
+}
+
+func (ts *TransactionServiceSuite) TestTransactionService_Reverse() {
+	// reversing a 30.00 deposit posts a linked 30.00 withdrawal, 130.00 becomes 100.00
+	account := ts.newAccount("checking", 10000)
+	id := ts.post(account.ID, "deposit", 3000)
+
+	reversal, err := ts.transService.Reverse(id)
+
+	if ts.assert.NoError(err) {
+		ts.assert.NotZero(reversal.ID)
+		ts.assert.Equal("withdrawal", reversal.Type)
+		ts.assert.Equal(id, *reversal.ReversalOfID)
+		ts.assertBalance(account.ID, 10000)
+		ts.assertTransactions(account.ID, 2)
+	}
+}
+
+func (ts *TransactionServiceSuite) TestTransactionService_Reverse_IgnoresOverdraftLimit() {
+	// a reversal is a correction, so it posts even when it overdraws a savings account
+	account := ts.newAccount("savings", 10000)
+	id := ts.post(account.ID, "deposit", 50000)
+	ts.post(account.ID, "withdrawal", 50000)
+
+	_, err := ts.transService.Reverse(id)
+
+	ts.assert.NoError(err)
+	ts.assertBalance(account.ID, -40000)
+}
+
+func (ts *TransactionServiceSuite) TestTransactionService_Reverse_AlreadyReversed() {
+	account := ts.newAccount("checking", 10000)
+	id := ts.post(account.ID, "deposit", 3000)
+	_, err := ts.transService.Reverse(id)
+	ts.Require().NoError(err)
+
+	_, err = ts.transService.Reverse(id)
+
+	ts.assert.ErrorIs(err, database.ErrAlreadyReversed)
+	ts.assertBalance(account.ID, 10000)
+}
+
+func (ts *TransactionServiceSuite) TestTransactionService_Reverse_Reversal() {
+	account := ts.newAccount("checking", 10000)
+	reversal, err := ts.transService.Reverse(ts.post(account.ID, "deposit", 3000))
+	ts.Require().NoError(err)
+
+	_, err = ts.transService.Reverse(reversal.ID)
+
+	ts.assert.ErrorIs(err, database.ErrReversal)
+}
+
+func (ts *TransactionServiceSuite) TestTransactionService_Update_Reversed() {
+	// a reversed transaction keeps the amount its reversal cancels out
+	account := ts.newAccount("checking", 10000)
+	id := ts.post(account.ID, "deposit", 3000)
+	_, err := ts.transService.Reverse(id)
+	ts.Require().NoError(err)
+
+	transaction := &database.Transaction{Amount: database.NewMoney(5000, "USD")}
+	transaction.ID = id
+
+	err = ts.transService.Update(transaction)
+
+	ts.assert.ErrorIs(err, database.ErrAlreadyReversed)
+	ts.assertBalance(account.ID, 10000)
+}
+
+func (ts *TransactionServiceSuite) TestTransactionService_Delete_Reversal() {
+	account := ts.newAccount("checking", 10000)
+	reversal, err := ts.transService.Reverse(ts.post(account.ID, "deposit", 3000))
+	ts.Require().NoError(err)
+
+	err = ts.transService.Delete(reversal.ID)
+
+	ts.assert.ErrorIs(err, database.ErrReversalFinal)
+	ts.assertBalance(account.ID, 10000)
+}
+
+func (ts *TransactionServiceSuite) TestTransactionService_TransferLeg() {
+	// one leg of a transfer can't change without the other, nothing is written
+	account := ts.newAccount("checking", 10000)
+	transferID := uint(3)
+	leg := &database.Transaction{AccountID: account.ID, Type: "withdrawal", Amount: database.NewMoney(3000, "USD"), TransferID: &transferID}
+	ts.Require().NoError(ts.store.Transactions().Create(leg))
+
+	for _, change := range []func() error{
+		func() error {
+			transaction := &database.Transaction{Amount: database.NewMoney(5000, "USD")}
+			transaction.ID = leg.ID
+			return ts.transService.Update(transaction)
+		},
+		func() error { return ts.transService.Delete(leg.ID) },
+	} {
+		ts.assert.ErrorIs(change(), database.ErrTransferLeg)
+		ts.assertTransactions(account.ID, 1)
+	}
+}
+
+func (ts *TransactionServiceSuite) TestTransactionService_ListPage() {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ownerID := uint(7)
+	other := ts.newAccount("checking", 10000)
+	account := &database.Account{AccountHolder: "Foo Baz", AccountType: "checking", Balance: database.NewMoney(0, "USD"), OwnerID: &ownerID}
+	ts.Require().NoError(ts.acctService.Create(account))
+
+	// only the deposit on the owner's account since the start of the year matches
+	ts.postAt(other.ID, "deposit", 2500, from.Add(time.Hour))
+	ts.postAt(account.ID, "withdrawal", 2500, from.Add(time.Hour))
+	ts.postAt(account.ID, "deposit", 2500, from.Add(-time.Hour))
+	id := ts.postAt(account.ID, "deposit", 2500, from.Add(time.Hour))
+
+	page, err := ts.transService.ListPage(
+		database.TransactionFilter{OwnerID: &ownerID, Type: "deposit", CreatedFrom: &from},
+		database.PageRequest{Limit: 10, Sort: "created_at", Desc: true},
+	)
+
+	if ts.assert.NoError(err) {
+		if ts.assert.Len(page.Items, 1) {
+			ts.assert.Equal(id, page.Items[0].ID)
+			// the accounts of the page are preloaded
+			ts.assert.Equal("Foo Baz", page.Items[0].Account.AccountHolder)
+		}
+		ts.assert.Empty(page.NextCursor)
+	}
+}
+
+func (ts *TransactionServiceSuite) TestTransactionService_Statement() {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	// the account was opened with 30.00 before the period and is at 70.00 now
+	account := &database.Account{AccountHolder: "Foo Bar", AccountType: "checking", Balance: database.NewMoney(3000, "USD")}
+	account.CreatedAt = from.Add(-time.Hour)
+	ts.Require().NoError(ts.acctService.Create(account))
+	first := ts.postAt(account.ID, "deposit", 5000, from.Add(time.Hour))
+	second := ts.postAt(account.ID, "withdrawal", 2000, from.Add(48*time.Hour))
+	ts.postAt(account.ID, "deposit", 1000, to.Add(time.Hour))
+
+	statement, err := ts.transService.Statement(account.ID, from, to)
+
+	if ts.assert.NoError(err) {
+		// the deposit after the period is backed out of the closing balance
+		ts.assert.Equal(database.NewMoney(6000, "USD"), statement.ClosingBalance)
+		ts.assert.Equal(database.NewMoney(3000, "USD"), statement.OpeningBalance)
+		if ts.assert.Len(statement.Lines, 2) {
+			ts.assert.Equal(first, statement.Lines[0].Transaction.ID)
+			ts.assert.Equal(database.NewMoney(8000, "USD"), statement.Lines[0].RunningBalance)
+			ts.assert.Equal(second, statement.Lines[1].Transaction.ID)
+			ts.assert.Equal(database.NewMoney(6000, "USD"), statement.Lines[1].RunningBalance)
+		}
+	}
+}
+
+func (ts *TransactionServiceSuite) TestTransactionService_Statement_InvalidPeriod() {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err := ts.transService.Statement(1, from, from)
+
+	ts.assert.ErrorIs(err, database.ErrInvalidPeriod)
+}
+
+// opens an account of the given type with an opening balance in USD cents
+func (ts *TransactionServiceSuite) newAccount(accountType string, balance int64) *database.Account {
+	account := &database.Account{AccountHolder: "Foo Bar", AccountType: accountType, Balance: database.NewMoney(balance, "USD")}
+	ts.Require().NoError(ts.acctService.Create(account))
+	return account
+}
+
+// posts a transaction in USD cents to an account and returns its id
+func (ts *TransactionServiceSuite) post(accountID uint, transactionType string, amount int64) uint {
+	return ts.postAt(accountID, transactionType, amount, time.Time{})
+}
+
+// posts a transaction in USD cents to an account as if it had been posted at the given time, a zero time is now
+func (ts *TransactionServiceSuite) postAt(accountID uint, transactionType string, amount int64, at time.Time) uint {
+	transaction := &database.Transaction{AccountID: accountID, Type: transactionType, Amount: database.NewMoney(amount, "USD")}
+	transaction.CreatedAt = at
+	ts.Require().NoError(ts.transService.Create(transaction))
+	return transaction.ID
+}
+
+// asserts the stored balance of an account in USD cents
+func (ts *TransactionServiceSuite) assertBalance(accountID uint, balance int64) {
+	account, err := ts.acctService.FetchById(accountID)
+	if ts.assert.NoError(err) {
+		ts.assert.Equal(database.NewMoney(balance, "USD"), account.Balance)
+	}
+}
+
+// asserts how many transactions are stored on an account
+func (ts *TransactionServiceSuite) assertTransactions(accountID uint, count int) {
+	transactions, err := ts.transService.ListByAccount(accountID)
+	if ts.assert.NoError(err) {
+		ts.assert.Len(*transactions, count)
+	}
+}
+
+// returns the stored version of an account
+func (ts *TransactionServiceSuite) version(accountID uint) uint {
+	account, err := ts.acctService.FetchById(accountID)
+	ts.Require().NoError(err)
+	return account.Version
+}
+
+// failingStore is a store whose balance updates fail with err, like a write that lost a race
+// or a database that went away in the middle of a transaction
+type failingStore struct {
+	database.Store
+	err       error
+	accountID uint //when set only the updates of this account fail
+}
+
+func (fs failingStore) Accounts() database.AccountRepository {
+	return failingAccounts{AccountRepository: fs.Store.Accounts(), store: fs}
+}
+
+func (fs failingStore) Atomic(fn func(store database.Store) error) error {
+	return fs.Store.Atomic(func(store database.Store) error {
+		fs.Store = store
+		return fn(fs)
+	})
+}
+
+type failingAccounts struct {
+	database.AccountRepository
+	store failingStore
+}
+
+func (fa failingAccounts) UpdateBalance(account *database.Account) error {
+	if fa.store.accountID != 0 && fa.store.accountID != account.ID {
+		return fa.AccountRepository.UpdateBalance(account)
+	}
+	return fa.store.err
+}
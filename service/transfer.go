@@ -0,0 +1,108 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/jobullo/go-api-example/database"
+)
+
+type TransferService struct {
+	store database.Store
+	rules Rules //overdraft limits applied to the withdrawal leg
+}
+
+// create a new transfer service
+func NewTransferService(store database.Store, rules Rules) *TransferService {
+	return &TransferService{store: store, rules: rules}
+}
+
+// Create debits the source account and credits the destination account inside a single store.Atomic call,
+// both legs are recorded as transactions linked by the transfer ID
+func (ts *TransferService) Create(transfer *database.Transfer) error {
+	if transfer.FromAccountID == transfer.ToAccountID {
+		return database.ErrSameAccount
+	}
+
+	if !transfer.Amount.IsPositive() {
+		return database.ErrInvalidAmount
+	}
+
+	var legs []database.Transaction
+
+	//inline function to pass to store.Atomic
+	performTransfer := func(store database.Store) error {
+		//services bound to the atomic store so both legs commit or roll back together
+		accountService := NewAccountService(store)
+		transactionService := NewTransactionService(store, ts.rules)
+
+		//lock both accounts in id order so two opposing transfers can't deadlock
+		locked := map[uint]*database.Account{}
+		for _, id := range lockOrder(transfer.FromAccountID, transfer.ToAccountID) {
+			account, err := accountService.FetchForUpdate(id)
+			if err != nil {
+				if errors.Is(err, database.ErrNotFound) {
+					return database.ErrParentNotFound
+				}
+				return err
+			}
+			locked[id] = account
+		}
+
+		//an amount without a currency is in the source account's currency
+		from := locked[transfer.FromAccountID]
+		amount, err := transfer.Amount.In(from.Balance.Currency)
+		if err != nil {
+			return err
+		}
+		transfer.Amount = amount.Normalized()
+
+		if err := store.Transfers().Create(transfer); err != nil {
+			return err
+		}
+
+		withdrawal := database.Transaction{
+			AccountID:  transfer.FromAccountID,
+			Type:       "withdrawal",
+			Amount:     transfer.Amount,
+			TransferID: &transfer.ID,
+		}
+		if err := transactionService.Create(&withdrawal); err != nil {
+			return err
+		}
+
+		deposit := database.Transaction{
+			AccountID:  transfer.ToAccountID,
+			Type:       "deposit",
+			Amount:     transfer.Amount,
+			TransferID: &transfer.ID,
+		}
+		if err := transactionService.Create(&deposit); err != nil {
+			return err
+		}
+
+		legs = []database.Transaction{withdrawal, deposit}
+		return nil
+	}
+
+	//will roll back both legs if an error is returned by performTransfer
+	if err := ts.store.Atomic(performTransfer); err != nil {
+		return err
+	}
+
+	transfer.Transactions = legs
+
+	return nil
+}
+
+// FetchById fetches a transfer along with both of its legs
+func (ts *TransferService) FetchById(id uint) (*database.Transfer, error) {
+	return ts.store.Transfers().FetchById(id)
+}
+
+// lockOrder returns two account ids lowest first
+func lockOrder(a uint, b uint) []uint {
+	if a > b {
+		return []uint{b, a}
+	}
+	return []uint{a, b}
+}
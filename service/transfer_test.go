@@ -0,0 +1,176 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/jobullo/go-api-example/database"
+	"github.com/jobullo/go-api-example/ledger"
+	"github.com/jobullo/go-api-example/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+// A new test suite is created by embedding
+// the suite.Suite struct.
+type TransferServiceSuite struct {
+	suite.Suite
+	assert  *assert.Assertions
+	store   database.Store
+	service *TransferService
+}
+
+// Invoke this function to run the test suite with "go test" at the CLI
+func TestTransferServiceSuite(t *testing.T) {
+	suite.Run(t, new(TransferServiceSuite))
+}
+
+func (ts *TransferServiceSuite) SetupTest() {
+	t := ts.T()
+
+	ts.assert = assert.New(t)
+	ts.store = database.NewMemoryStore()
+	ts.service = NewTransferService(ts.store, DefaultRules())
+}
+
+func (ts *TransferServiceSuite) TearDownTest() {
+	// the transfer clearing account nets out to zero along with the rest of the ledger
+	ts.assert.NoError(ledger.New(ts.store.Journal()).Verify())
+}
+
+func (ts *TransferServiceSuite) TestCreate_PostsBothLegs() {
+	from := ts.newAccount(10000)
+	to := ts.newAccount(500)
+	transfer := &database.Transfer{
+		FromAccountID: from.ID,
+		ToAccountID:   to.ID,
+		Amount:        database.NewMoney(2500, ""),
+	}
+
+	err := ts.service.Create(transfer)
+
+	if ts.assert.NoError(err) {
+		ts.assert.Equal(database.NewMoney(2500, "USD"), transfer.Amount)
+		ts.assertBalance(from.ID, 7500)
+		ts.assertBalance(to.ID, 3000)
+	}
+
+	// the legs are linked to the transfer they belong to
+	stored, err := ts.service.FetchById(transfer.ID)
+	if ts.assert.NoError(err) && ts.assert.Len(stored.Transactions, 2) {
+		for _, leg := range stored.Transactions {
+			ts.assert.Equal(transfer.ID, *leg.TransferID)
+		}
+		ts.assert.Equal("withdrawal", stored.Transactions[0].Type)
+		ts.assert.Equal(from.ID, stored.Transactions[0].AccountID)
+		ts.assert.Equal("deposit", stored.Transactions[1].Type)
+		ts.assert.Equal(to.ID, stored.Transactions[1].AccountID)
+	}
+}
+
+func (ts *TransferServiceSuite) TestCreate_RollsBackWhenDepositFails() {
+	from := ts.newAccount(10000)
+	to := ts.newAccount(500)
+
+	// the destination account was changed by someone else, so the deposit leg fails after the withdrawal posted
+	ts.service.store = failingStore{Store: ts.store, err: database.ErrConflict, accountID: to.ID}
+
+	err := ts.service.Create(&database.Transfer{
+		FromAccountID: from.ID,
+		ToAccountID:   to.ID,
+		Amount:        database.NewMoney(2500, "USD"),
+	})
+
+	ts.assert.ErrorIs(err, database.ErrConflict)
+	ts.assertBalance(from.ID, 10000)
+	ts.assertBalance(to.ID, 500)
+	transactions, err := ts.store.Transactions().List()
+	if ts.assert.NoError(err) {
+		ts.assert.Empty(*transactions)
+	}
+}
+
+func (ts *TransferServiceSuite) TestCreate_LocksAccountsInIdOrder() {
+	first := ts.newAccount(10000)
+	second := ts.newAccount(10000)
+	var locked []uint
+	ts.service.store = lockingStore{Store: ts.store, locked: &locked}
+
+	// money moves from the second account to the first, but the first is locked first
+	err := ts.service.Create(&database.Transfer{
+		FromAccountID: second.ID,
+		ToAccountID:   first.ID,
+		Amount:        database.NewMoney(2500, "USD"),
+	})
+
+	if ts.assert.NoError(err) && ts.assert.GreaterOrEqual(len(locked), 2) {
+		ts.assert.Equal([]uint{first.ID, second.ID}, locked[:2])
+	}
+}
+
+func (ts *TransferServiceSuite) TestCreate_AccountNotFound() {
+	account := ts.newAccount(10000)
+
+	err := ts.service.Create(&database.Transfer{
+		FromAccountID: mock.ID(),
+		ToAccountID:   account.ID,
+		Amount:        database.NewMoney(2500, "USD"),
+	})
+
+	ts.assert.ErrorIs(err, database.ErrParentNotFound)
+	ts.assertBalance(account.ID, 10000)
+}
+
+func (ts *TransferServiceSuite) TestCreate_SameAccount() {
+	account := ts.newAccount(10000)
+
+	err := ts.service.Create(&database.Transfer{
+		FromAccountID: account.ID,
+		ToAccountID:   account.ID,
+		Amount:        database.NewMoney(2500, "USD"),
+	})
+
+	ts.assert.ErrorIs(err, database.ErrSameAccount)
+	ts.assertBalance(account.ID, 10000)
+}
+
+// opens a checking account with a balance in USD cents
+func (ts *TransferServiceSuite) newAccount(balance int64) *database.Account {
+	account := &database.Account{AccountHolder: "Foo Bar", AccountType: "checking", Balance: database.NewMoney(balance, "USD")}
+	ts.Require().NoError(NewAccountService(ts.store).Create(account))
+	return account
+}
+
+// asserts the stored balance of an account in USD cents
+func (ts *TransferServiceSuite) assertBalance(accountID uint, balance int64) {
+	account, err := ts.store.Accounts().FetchById(accountID)
+	if ts.assert.NoError(err) {
+		ts.assert.Equal(database.NewMoney(balance, "USD"), account.Balance)
+	}
+}
+
+// lockingStore records the order in which accounts are locked
+type lockingStore struct {
+	database.Store
+	locked *[]uint
+}
+
+func (ls lockingStore) Accounts() database.AccountRepository {
+	return lockingAccounts{AccountRepository: ls.Store.Accounts(), locked: ls.locked}
+}
+
+func (ls lockingStore) Atomic(fn func(store database.Store) error) error {
+	return ls.Store.Atomic(func(store database.Store) error {
+		ls.Store = store
+		return fn(ls)
+	})
+}
+
+type lockingAccounts struct {
+	database.AccountRepository
+	locked *[]uint
+}
+
+func (la lockingAccounts) FetchForUpdate(id uint) (*database.Account, error) {
+	*la.locked = append(*la.locked, id)
+	return la.AccountRepository.FetchForUpdate(id)
+}
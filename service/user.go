@@ -0,0 +1,118 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/jobullo/go-api-example/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// compared against when the username doesn't exist so a failed login takes as long either way
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+type UserService struct {
+	db                  *gorm.DB
+	minPasswordStrength int
+}
+
+// create a new user service, passwords scoring below minPasswordStrength on PasswordStrength are rejected
+func NewUserService(db *gorm.DB, minPasswordStrength int) *UserService {
+	return &UserService{db: db, minPasswordStrength: minPasswordStrength}
+}
+
+// Register creates a user with a bcrypt hash of the password, an empty role makes a customer
+func (us *UserService) Register(username string, password string, role string) (*database.User, error) {
+	username = normalizeUsername(username)
+	if username == "" {
+		return nil, database.ErrInvalidUsername
+	}
+
+	if role == "" {
+		role = database.RoleCustomer
+	}
+	if !database.ValidRole(role) {
+		return nil, database.ErrInvalidRole
+	}
+
+	if PasswordStrength(password) < us.minPasswordStrength {
+		return nil, database.ErrWeakPassword
+	}
+	if len(password) > MaxPasswordLength {
+		return nil, fmt.Errorf("%w: a password can be at most %d bytes long", database.ErrWeakPassword, MaxPasswordLength)
+	}
+
+	//check for an existing user first so the common case gets a clear error
+	if _, err := us.fetchByUsername(username); err == nil {
+		return nil, database.ErrUsernameTaken
+	} else if !errors.Is(err, database.ErrNotFound) {
+		return nil, err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &database.User{Username: username, PasswordHash: string(hash), Role: role}
+	if result := us.db.Create(user); result.Error != nil {
+		//lost a race with another registration for the same username
+		if _, err := us.fetchByUsername(username); err == nil {
+			return nil, database.ErrUsernameTaken
+		}
+		return nil, result.Error
+	}
+
+	return user, nil
+}
+
+// Authenticate returns the user when the password matches, otherwise database.ErrInvalidCredentials.
+// An unknown username and a wrong password are indistinguishable to the caller.
+func (us *UserService) Authenticate(username string, password string) (*database.User, error) {
+	user, err := us.fetchByUsername(normalizeUsername(username))
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+			return nil, database.ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, database.ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
+// implements the FetchByID method of the user service interface
+func (us *UserService) FetchById(id uint) (*database.User, error) {
+	var user database.User
+	if result := us.db.First(&user, id); result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, database.ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &user, nil
+}
+
+func (us *UserService) fetchByUsername(username string) (*database.User, error) {
+	var user database.User
+	if result := us.db.Where("username = ?", username).First(&user); result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, database.ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return &user, nil
+}
+
+// usernames are case insensitive
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jobullo/go-api-example/database"
+	"github.com/jobullo/go-api-example/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// A new test suite is created by embedding
+// the suite.Suite struct.
+type UserServiceSuite struct {
+	suite.Suite
+	assert  *assert.Assertions
+	sqlmock sqlmock.Sqlmock
+	service *UserService
+}
+
+// Invoke this function to run the test suite with "go test" at the CLI
+func TestUserServiceSuite(t *testing.T) {
+	suite.Run(t, new(UserServiceSuite))
+}
+
+func (us *UserServiceSuite) SetupTest() {
+	t := us.T()
+
+	db, sql, err := mock.DB()
+	require.NoError(t, err)
+
+	us.assert = assert.New(t)
+	us.sqlmock = sql
+	us.service = NewUserService(db, 3)
+}
+
+func (us *UserServiceSuite) TestRegister_HashesPassword() {
+	us.sqlmock.ExpectQuery(`^SELECT (.+) FROM "users"`).
+		WithArgs("jdoe").
+		WillReturnRows(us.newRows())
+	us.sqlmock.ExpectBegin()
+	us.sqlmock.ExpectQuery(`^INSERT INTO "users"`).
+		WithArgs(mock.Any{}, mock.Any{}, mock.Any{}, "jdoe", mock.Any{}, "customer").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	us.sqlmock.ExpectCommit()
+
+	// usernames are stored trimmed and in lower case
+	user, err := us.service.Register(" JDoe ", "Password1234", "")
+
+	if us.assert.NoError(err) {
+		us.assert.NoError(us.sqlmock.ExpectationsWereMet())
+		us.assert.Equal("jdoe", user.Username)
+		us.assert.Equal(database.RoleCustomer, user.Role)
+		us.assert.NotEqual("Password1234", user.PasswordHash)
+		us.assert.NoError(bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte("Password1234")))
+	}
+}
+
+func (us *UserServiceSuite) TestRegister_WeakPassword() {
+	// nothing is read or written for a weak password
+	_, err := us.service.Register("jdoe", "password", "")
+
+	us.assert.ErrorIs(err, database.ErrWeakPassword)
+	us.assert.NoError(us.sqlmock.ExpectationsWereMet())
+}
+
+func (us *UserServiceSuite) TestRegister_PasswordTooLong() {
+	// bcrypt can't hash more than 72 bytes, so a longer password is rejected up front
+	_, err := us.service.Register("jdoe", "Password1234"+strings.Repeat("x", MaxPasswordLength), "")
+
+	us.assert.ErrorIs(err, database.ErrWeakPassword)
+	us.assert.NoError(us.sqlmock.ExpectationsWereMet())
+}
+
+func (us *UserServiceSuite) TestRegister_InvalidRole() {
+	_, err := us.service.Register("jdoe", "Password1234", "superuser")
+
+	us.assert.ErrorIs(err, database.ErrInvalidRole)
+	us.assert.NoError(us.sqlmock.ExpectationsWereMet())
+}
+
+func (us *UserServiceSuite) TestRegister_UsernameTaken() {
+	us.sqlmock.ExpectQuery(`^SELECT (.+) FROM "users"`).
+		WillReturnRows(us.newRows().AddRow(1, time.Now(), time.Now(), nil, "jdoe", "hash", "customer"))
+
+	_, err := us.service.Register("jdoe", "Password1234", "")
+
+	us.assert.ErrorIs(err, database.ErrUsernameTaken)
+	us.assert.NoError(us.sqlmock.ExpectationsWereMet())
+}
+
+func (us *UserServiceSuite) TestAuthenticate() {
+	hash, err := bcrypt.GenerateFromPassword([]byte("Password1234"), bcrypt.MinCost)
+	us.Require().NoError(err)
+
+	us.sqlmock.ExpectQuery(`^SELECT (.+) FROM "users"`).
+		WithArgs("jdoe").
+		WillReturnRows(us.newRows().AddRow(1, time.Now(), time.Now(), nil, "jdoe", string(hash), "customer"))
+
+	user, err := us.service.Authenticate("jdoe", "Password1234")
+
+	if us.assert.NoError(err) {
+		us.assert.Equal(uint(1), user.ID)
+		us.assert.NoError(us.sqlmock.ExpectationsWereMet())
+	}
+}
+
+func (us *UserServiceSuite) TestAuthenticate_WrongPassword() {
+	hash, err := bcrypt.GenerateFromPassword([]byte("Password1234"), bcrypt.MinCost)
+	us.Require().NoError(err)
+
+	us.sqlmock.ExpectQuery(`^SELECT (.+) FROM "users"`).
+		WillReturnRows(us.newRows().AddRow(1, time.Now(), time.Now(), nil, "jdoe", string(hash), "customer"))
+
+	_, err = us.service.Authenticate("jdoe", "Password12345")
+
+	us.assert.ErrorIs(err, database.ErrInvalidCredentials)
+	us.assert.NoError(us.sqlmock.ExpectationsWereMet())
+}
+
+func (us *UserServiceSuite) TestAuthenticate_UnknownUser() {
+	us.sqlmock.ExpectQuery(`^SELECT (.+) FROM "users"`).
+		WillReturnRows(us.newRows())
+
+	_, err := us.service.Authenticate("nobody", "Password1234")
+
+	us.assert.ErrorIs(err, database.ErrInvalidCredentials)
+	us.assert.NoError(us.sqlmock.ExpectationsWereMet())
+}
+
+// creates the rows object for the "users" table
+func (us *UserServiceSuite) newRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "username", "password_hash", "role"})
+}
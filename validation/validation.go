@@ -0,0 +1,120 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// FieldError describes what is wrong with one field of a request, Field is empty
+// when the problem is with the request as a whole, e.g. a body that isn't json
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// Errors lists every problem found in a request, so a client can fix them all at once
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fieldError := range e {
+		if fieldError.Field == "" {
+			messages = append(messages, fieldError.Message)
+		} else {
+			messages = append(messages, fieldError.Field+": "+fieldError.Message)
+		}
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validator collects the errors of a request as its fields are checked
+type Validator struct {
+	errors Errors
+}
+
+// Add records a problem with a field
+func (v *Validator) Add(field string, message string) {
+	v.errors = append(v.errors, FieldError{Field: field, Message: message})
+}
+
+// Check records the message for the field unless ok holds
+func (v *Validator) Check(ok bool, field string, message string) {
+	if !ok {
+		v.Add(field, message)
+	}
+}
+
+// Required checks that a text field isn't blank and returns whether it passed,
+// so checks that only make sense on a value can be skipped
+func (v *Validator) Required(field string, value string) bool {
+	ok := strings.TrimSpace(value) != ""
+	v.Check(ok, field, "is required")
+	return ok
+}
+
+// MaxLength checks that a text field has at most n characters
+func (v *Validator) MaxLength(field string, value string, n int) {
+	v.Check(utf8.RuneCountInString(value) <= n, field, fmt.Sprintf("must be at most %d characters", n))
+}
+
+// OneOf checks that a field has one of the allowed values
+func (v *Validator) OneOf(field string, value string, allowed []string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.Add(field, "must be one of "+strings.Join(allowed, ", "))
+}
+
+// Valid reports whether no problems were found so far
+func (v *Validator) Valid() bool {
+	return len(v.errors) == 0
+}
+
+// Err returns the problems found as Errors, or nil when the request is valid
+func (v *Validator) Err() error {
+	if v.Valid() {
+		return nil
+	}
+	return v.errors
+}
+
+// DecodeJSON reads a json request body into dst. Fields dst doesn't have are ignored, so a client can send
+// back a record it read, and values of the wrong type are reported as errors on their field.
+func DecodeJSON(r io.Reader, dst interface{}) error {
+	err := json.NewDecoder(r).Decode(dst)
+	if err == nil {
+		return nil
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return Errors{{Message: "the request body is empty"}}
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return Errors{{Field: typeErr.Field, Message: "must be a " + jsonType(typeErr.Type.Kind().String())}}
+	default:
+		return Errors{{Message: err.Error()}}
+	}
+}
+
+// jsonType names a go kind the way a client sending json thinks of it
+func jsonType(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "number"
+	case kind == "bool":
+		return "boolean"
+	case kind == "slice", kind == "array":
+		return "list"
+	case kind == "struct", kind == "map":
+		return "object"
+	default:
+		return kind
+	}
+}
@@ -0,0 +1,51 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidator(t *testing.T) {
+	var v Validator
+
+	v.Required("accountHolder", "  ")
+	v.MaxLength("accountHolder", "Zoë", 3)
+	v.OneOf("accountType", "loan", []string{"checking", "savings"})
+	v.Check(false, "balance", "must not be negative")
+
+	assert.False(t, v.Valid())
+	assert.Equal(t, Errors{
+		{Field: "accountHolder", Message: "is required"},
+		{Field: "accountType", Message: "must be one of checking, savings"},
+		{Field: "balance", Message: "must not be negative"},
+	}, v.Err())
+	assert.Equal(t, "accountHolder: is required; accountType: must be one of checking, savings; balance: must not be negative", v.Err().Error())
+}
+
+func TestValidator_Valid(t *testing.T) {
+	var v Validator
+
+	assert.True(t, v.Required("accountHolder", "Foo Bar"))
+	v.OneOf("accountType", "savings", []string{"checking", "savings"})
+
+	assert.NoError(t, v.Err())
+}
+
+func TestDecodeJSON(t *testing.T) {
+	var request struct {
+		AccountID uint   `json:"accountID"`
+		Type      string `json:"transactionType"`
+	}
+
+	// fields the request doesn't have are ignored
+	assert.NoError(t, DecodeJSON(strings.NewReader(`{"accountID":1,"transactionType":"deposit","ID":5}`), &request))
+	assert.Equal(t, uint(1), request.AccountID)
+
+	assert.Equal(t, Errors{{Field: "accountID", Message: "must be a number"}},
+		DecodeJSON(strings.NewReader(`{"accountID":"one"}`), &request))
+	assert.Equal(t, Errors{{Message: "the request body is empty"}},
+		DecodeJSON(strings.NewReader(""), &request))
+	assert.Error(t, DecodeJSON(strings.NewReader(`{"accountID":`), &request))
+}